@@ -0,0 +1,633 @@
+package bft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// https://arxiv.org/abs/1803.05069, the blocks being agreed one at a time
+
+const (
+	phasePrepare = iota
+	phaseCommit
+)
+
+const (
+	// checksPerTimeout is the number of times the timeout is checked during a view
+	checksPerTimeout = 4
+	// maxFuture is the number of messages kept for the next block, per participant
+	maxFuture = 16
+)
+
+type voteKey struct {
+	phase int
+	view  int
+	hash  string
+}
+
+// Node agrees on the blocks of the chain with a Byzantine fault tolerant
+// protocol in the spirit of HotStuff. Out of the numParticipant participants,
+// f = (numParticipant-1)/3 may be faulty, a quorum being (numParticipant+f)/2+1
+// of them, that is 2f+1 of 3f+1 participants.
+//
+// For each block, the leader of the view proposes a block which the
+// participants vote for in two phases. A quorum of prepare votes makes a
+// prepare certificate, on which the participants lock before voting to
+// commit, and a quorum of commit votes commits the block. A locked
+// participant only votes for another block justified by a certificate of a
+// higher view, so that a committed block is proposed again by the next
+// leaders. The participants which do not see a block committed in time move
+// to the next view, sending their highest certificate to its leader.
+//
+// Every message is signed and the votes are gossiped to all the nodes, which
+// gather the certificates themselves. The nodes which do not take part in the
+// consensus learn the blocks from the proposals and the commit votes. As with
+// the other consensus, a node proposes a single block at a time and the
+// proposals not chosen are dropped once a block is committed. The blocks
+// preceding a snapshot block are pruned once it is committed.
+type Node struct {
+	numParticipant int
+	nodeIndex      int
+	f              int
+	timeout        time.Duration
+	blockFactory   blk.BlockFactory
+	keys           *Keys
+
+	mutex   sync.Mutex
+	started bool
+	chanEnd chan struct{}
+
+	// Chain, the blocks by number from base, the number of the first one, the
+	// height being the number of the next block
+	chain   []*blk.BlockContainer
+	base    int
+	blocks  map[string]*blk.BlockContainer
+	applied []*blk.BlockContainer
+
+	// State of the current height
+	view      int
+	deadline  time.Time
+	ready     bool
+	proposed  bool
+	expecting bool
+	voted     map[int]bool
+	locked    *extramessage.BFTCertificate
+	values    map[string]*blk.BlockContainer
+	votes     map[voteKey]map[int]*extramessage.BFTVote
+	newViews  map[int]map[int]*extramessage.BFTNewView
+	future    []*extramessage.ExtraMessage
+
+	pending blk.BlockContent
+}
+
+// NewNode creates a node with the given keys, node 0 being the leader of the
+// first view. A view lasts paxosRetry seconds.
+func NewNode(numParticipant int, nodeIndex int, paxosRetry int, blockFactory blk.BlockFactory, keys *Keys) *Node {
+	n := &Node{
+		numParticipant: numParticipant,
+		nodeIndex:      nodeIndex,
+		f:              (numParticipant - 1) / 3,
+		timeout:        time.Duration(paxosRetry) * time.Second,
+		blockFactory:   blockFactory,
+		keys:           keys,
+
+		chanEnd: make(chan struct{}),
+
+		chain:  make([]*blk.BlockContainer, 0),
+		blocks: make(map[string]*blk.BlockContainer),
+	}
+	n.reset()
+	return n
+}
+
+func (n *Node) participant() bool {
+	return n.nodeIndex < n.numParticipant && n.keys.Private != nil
+}
+
+func (n *Node) quorum() int {
+	return (n.numParticipant+n.f)/2 + 1
+}
+
+func (n *Node) leaderOf(view int) int {
+	return view % n.numParticipant
+}
+
+func (n *Node) height() int {
+	return n.base + len(n.chain)
+}
+
+// reset clears the state of the height, keeping the view
+func (n *Node) reset() {
+	n.ready = true
+	n.proposed = false
+	n.expecting = false
+	n.voted = make(map[int]bool)
+	n.locked = nil
+	n.values = make(map[string]*blk.BlockContainer)
+	n.votes = make(map[voteKey]map[int]*extramessage.BFTVote)
+	n.newViews = make(map[int]map[int]*extramessage.BFTNewView)
+}
+
+// start launches the timer of a participant
+func (n *Node) start(g *gossip.Gossiper) {
+	if n.started || !n.participant() {
+		return
+	}
+	n.started = true
+	n.deadline = time.Now().Add(n.timeout)
+	go n.run(g)
+}
+
+func (n *Node) run(g *gossip.Gossiper) {
+	ticker := time.NewTicker(n.timeout / checksPerTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.chanEnd:
+			return
+		case <-ticker.C:
+		}
+
+		var out []*extramessage.ExtraMessage
+		n.mutex.Lock()
+		if n.waiting() && time.Now().After(n.deadline) {
+			log.Printf("%s Move to view %d", g.GetIdentifier(), n.view+1)
+			out = n.enterView(g, n.view+1)
+		}
+		n.mutex.Unlock()
+		send(g, out)
+	}
+}
+
+// waiting returns whether a block is expected to be committed
+func (n *Node) waiting() bool {
+	return n.pending != nil || n.expecting || len(n.values) > 0
+}
+
+// wait makes the timer run from now if no block was expected so far
+func (n *Node) wait() {
+	if !n.waiting() {
+		n.deadline = time.Now().Add(n.timeout)
+	}
+}
+
+// Stop stops the timer
+func (n *Node) Stop() {
+	defer func() {
+		recover()
+	}()
+	close(n.chanEnd)
+}
+
+// Propose asks the leader to propose a block with the given content
+func (n *Node) Propose(g *gossip.Gossiper, blockContent blk.BlockContent) {
+	n.mutex.Lock()
+	n.start(g)
+	out := n.propose(g, blockContent)
+	n.mutex.Unlock()
+	send(g, out)
+}
+
+func (n *Node) propose(g *gossip.Gossiper, blockContent blk.BlockContent) []*extramessage.ExtraMessage {
+	if !n.participant() {
+		return nil
+	}
+	if n.pending == nil {
+		n.wait()
+		n.pending = blockContent
+	}
+	if n.leaderOf(n.view) == n.nodeIndex {
+		return n.tryPropose(g)
+	}
+	return []*extramessage.ExtraMessage{n.forward()}
+}
+
+// GetBlocks returns the committed blocks, the first return is the
+// hexadecimal hash of the last block
+func (n *Node) GetBlocks() (string, map[string]*blk.BlockContainer) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	blocks := make(map[string]*blk.BlockContainer, len(n.blocks))
+	for hash, block := range n.blocks {
+		blocks[hash] = block
+	}
+	return hex.EncodeToString(n.tailHash()), blocks
+}
+
+// HandleExtraMessage handles a message of the protocol and returns the next
+// committed block, if any
+func (n *Node) HandleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+	n.mutex.Lock()
+	n.start(g)
+	block, out := n.handle(g, msg)
+	n.mutex.Unlock()
+
+	send(g, out)
+	return block
+}
+
+// handle returns the next committed block, if any, and the messages to send.
+// The PaxosTLC messages are ignored as they are not signed.
+func (n *Node) handle(g *gossip.Gossiper, msg *extramessage.ExtraMessage) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	out := n.dispatch(g, msg)
+	if len(n.applied) == 0 {
+		return nil, out
+	}
+	block := n.applied[0]
+	n.applied = n.applied[1:]
+	return block, out
+}
+
+func (n *Node) dispatch(g *gossip.Gossiper, msg *extramessage.ExtraMessage) []*extramessage.ExtraMessage {
+	if msg.BFTProposal != nil {
+		return n.uponProposal(g, msg, msg.BFTProposal)
+	} else if msg.BFTVote != nil {
+		return n.uponVote(g, msg, msg.BFTVote)
+	} else if msg.BFTNewView != nil && n.participant() {
+		return n.uponNewView(g, msg, msg.BFTNewView)
+	} else if msg.BFTForward != nil && n.participant() {
+		return n.uponForward(g, msg.BFTForward)
+	}
+	return nil
+}
+
+// current returns whether the message is for the current height, keeping
+// the messages for the next one until it is reached
+func (n *Node) current(msg *extramessage.ExtraMessage, height int) bool {
+	if height == n.height()+1 && len(n.future) < maxFuture*n.numParticipant {
+		n.future = append(n.future, msg)
+	}
+	return height == n.height()
+}
+
+// --- Blocks ---
+
+func (n *Node) tailHash() []byte {
+	if len(n.chain) == 0 {
+		return make([]byte, 32)
+	}
+	return n.chain[len(n.chain)-1].Hash()
+}
+
+// newBlock returns the block following the tail with the given content
+func (n *Node) newBlock(content blk.BlockContent) *blk.BlockContainer {
+	if n.height() == 0 {
+		return n.blockFactory.NewGenesisBlock(content.BlockType(), 0, content)
+	}
+	return n.blockFactory.NewBlock(content.BlockType(), n.height(), n.tailHash(), content)
+}
+
+// extends returns whether the block is a valid next block of the chain
+func (n *Node) extends(block *blk.BlockContainer) bool {
+	return block != nil && !block.IsContentNil() && block.BlockNumber() == n.height() &&
+		bytes.Equal(block.PreviousHash(), n.tailHash())
+}
+
+// --- Certificates ---
+
+func (n *Node) lock(certificate *extramessage.BFTCertificate) {
+	if certificate != nil && (n.locked == nil || certificate.View > n.locked.View) {
+		n.locked = certificate
+	}
+}
+
+func (n *Node) certificate(key voteKey) *extramessage.BFTCertificate {
+	votes := make([]extramessage.BFTVote, 0, len(n.votes[key]))
+	for _, vote := range n.votes[key] {
+		votes = append(votes, *vote)
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return votes[i].Voter < votes[j].Voter
+	})
+
+	first := votes[0]
+	return &extramessage.BFTCertificate{
+		Phase:  first.Phase,
+		Height: first.Height,
+		View:   first.View,
+		Hash:   first.Hash,
+		Votes:  votes,
+	}
+}
+
+// valid returns whether the certificate holds the signed votes of a quorum of
+// distinct participants for the current height
+func (n *Node) valid(certificate *extramessage.BFTCertificate, phase int) bool {
+	if certificate.Phase != phase || certificate.Height != n.height() {
+		return false
+	}
+	voters := make(map[int]bool)
+	for _, vote := range certificate.Votes {
+		if vote.Phase != certificate.Phase || vote.Height != certificate.Height || vote.View != certificate.View ||
+			!bytes.Equal(vote.Hash, certificate.Hash) || voters[vote.Voter] ||
+			!n.keys.verify(vote.Voter, voteDigest(vote.Phase, vote.Height, vote.View, vote.Hash), vote.Signature) {
+			continue
+		}
+		voters[vote.Voter] = true
+	}
+	return len(voters) >= n.quorum()
+}
+
+// --- Normal case ---
+
+// forward sends the pending proposal to the leader
+func (n *Node) forward() *extramessage.ExtraMessage {
+	block := n.newBlock(n.pending)
+	return &extramessage.ExtraMessage{
+		BFTForward: &extramessage.BFTForward{
+			Node:      n.nodeIndex,
+			Value:     block,
+			Signature: n.keys.sign(forwardDigest(block.Hash())),
+		},
+	}
+}
+
+func (n *Node) uponForward(g *gossip.Gossiper, msg *extramessage.BFTForward) []*extramessage.ExtraMessage {
+	if !n.extends(msg.Value) || !n.keys.verify(msg.Node, forwardDigest(msg.Value.Hash()), msg.Signature) {
+		return nil
+	}
+	// The participants expect a block from the leader from now on
+	n.wait()
+	n.expecting = true
+	if n.leaderOf(n.view) != n.nodeIndex {
+		return nil
+	}
+	if n.pending == nil {
+		n.pending = msg.Value.GetContent()
+	}
+	return n.tryPropose(g)
+}
+
+// tryPropose proposes the block of the highest certificate, or the pending
+// one if there is none, once the leader knows the certificates of a quorum
+func (n *Node) tryPropose(g *gossip.Gossiper) []*extramessage.ExtraMessage {
+	if n.leaderOf(n.view) != n.nodeIndex || !n.ready || n.proposed {
+		return nil
+	}
+
+	var block *blk.BlockContainer
+	if n.locked != nil {
+		block = n.values[hex.EncodeToString(n.locked.Hash)]
+	} else if n.pending != nil {
+		block = n.newBlock(n.pending)
+	}
+	if block == nil {
+		return nil
+	}
+
+	n.proposed = true
+	proposal := &extramessage.BFTProposal{
+		Height:    n.height(),
+		View:      n.view,
+		Leader:    n.nodeIndex,
+		Value:     block,
+		Justify:   n.locked,
+		Signature: n.keys.sign(proposalDigest(n.height(), n.view, block.Hash())),
+	}
+	msg := &extramessage.ExtraMessage{
+		BFTProposal: proposal,
+	}
+	// The gossiper does not deliver our own messages
+	return append([]*extramessage.ExtraMessage{msg}, n.uponProposal(g, msg, proposal)...)
+}
+
+func (n *Node) uponProposal(g *gossip.Gossiper, msg *extramessage.ExtraMessage, proposal *extramessage.BFTProposal) []*extramessage.ExtraMessage {
+	if !n.current(msg, proposal.Height) || proposal.Leader != n.leaderOf(proposal.View) || !n.extends(proposal.Value) {
+		return nil
+	}
+	hash := proposal.Value.Hash()
+	if !n.keys.verify(proposal.Leader, proposalDigest(proposal.Height, proposal.View, hash), proposal.Signature) {
+		return nil
+	}
+	justified := proposal.Justify != nil && proposal.Justify.View < proposal.View &&
+		bytes.Equal(proposal.Justify.Hash, hash) && n.valid(proposal.Justify, phasePrepare)
+	if proposal.Justify != nil && !justified {
+		return nil
+	}
+
+	n.wait()
+	n.values[hex.EncodeToString(hash)] = proposal.Value
+	height := n.height()
+	out := n.tryCommit(g, hash)
+	if n.height() != height || !n.participant() || proposal.View != n.view || n.voted[phasePrepare] {
+		return out
+	}
+
+	// A locked participant only votes for another block with a certificate
+	// of a higher view
+	if n.locked != nil && !bytes.Equal(n.locked.Hash, hash) &&
+		(!justified || proposal.Justify.View <= n.locked.View) {
+		return out
+	}
+	if justified {
+		n.lock(proposal.Justify)
+	}
+	return append(out, n.vote(g, phasePrepare, hash)...)
+}
+
+func (n *Node) vote(g *gossip.Gossiper, phase int, hash []byte) []*extramessage.ExtraMessage {
+	n.voted[phase] = true
+	vote := &extramessage.BFTVote{
+		Phase:     phase,
+		Height:    n.height(),
+		View:      n.view,
+		Hash:      hash,
+		Voter:     n.nodeIndex,
+		Signature: n.keys.sign(voteDigest(phase, n.height(), n.view, hash)),
+	}
+	msg := &extramessage.ExtraMessage{
+		BFTVote: vote,
+	}
+	return append([]*extramessage.ExtraMessage{msg}, n.uponVote(g, msg, vote)...)
+}
+
+func (n *Node) uponVote(g *gossip.Gossiper, msg *extramessage.ExtraMessage, vote *extramessage.BFTVote) []*extramessage.ExtraMessage {
+	if !n.current(msg, vote.Height) || (vote.Phase != phasePrepare && vote.Phase != phaseCommit) {
+		return nil
+	}
+	key := voteKey{
+		phase: vote.Phase,
+		view:  vote.View,
+		hash:  hex.EncodeToString(vote.Hash),
+	}
+	voters, ok := n.votes[key]
+	if ok && voters[vote.Voter] != nil {
+		return nil
+	}
+	if !n.keys.verify(vote.Voter, voteDigest(vote.Phase, vote.Height, vote.View, vote.Hash), vote.Signature) {
+		return nil
+	}
+	if !ok {
+		voters = make(map[int]*extramessage.BFTVote)
+		n.votes[key] = voters
+	}
+	voters[vote.Voter] = vote
+	if len(voters) < n.quorum() {
+		return nil
+	}
+
+	if vote.Phase == phaseCommit {
+		return n.tryCommit(g, vote.Hash)
+	}
+
+	// Lock on the prepare certificate before voting to commit
+	n.lock(n.certificate(key))
+	if !n.participant() || vote.View != n.view || n.voted[phaseCommit] || !bytes.Equal(n.locked.Hash, vote.Hash) {
+		return nil
+	}
+	return n.vote(g, phaseCommit, vote.Hash)
+}
+
+// tryCommit commits the block once a quorum voted to commit it, in any view
+func (n *Node) tryCommit(g *gossip.Gossiper, hash []byte) []*extramessage.ExtraMessage {
+	block := n.values[hex.EncodeToString(hash)]
+	if block == nil {
+		return nil
+	}
+	for key, voters := range n.votes {
+		if key.phase == phaseCommit && key.hash == hex.EncodeToString(hash) && len(voters) >= n.quorum() {
+			return n.commit(g, block, key.view)
+		}
+	}
+	return nil
+}
+
+// commit appends the block and moves to the next height in the view of the
+// commit, handling the messages received in advance
+func (n *Node) commit(g *gossip.Gossiper, block *blk.BlockContainer, view int) []*extramessage.ExtraMessage {
+	log.Printf("%s Commit block %d in view %d", g.GetIdentifier(), block.BlockNumber(), view)
+	n.chain = append(n.chain, block)
+	n.blocks[hex.EncodeToString(block.Hash())] = block
+	n.applied = append(n.applied, block)
+	if block.Type == blk.BlockSnapshotStr {
+		n.prune()
+	}
+
+	if view > n.view {
+		n.view = view
+	}
+	n.reset()
+	// As with a new Paxos instance, the proposals are dropped once a block is committed
+	n.pending = nil
+
+	future := n.future
+	n.future = nil
+	var out []*extramessage.ExtraMessage
+	for _, msg := range future {
+		out = append(out, n.dispatch(g, msg)...)
+	}
+	return out
+}
+
+// prune drops the blocks preceding the snapshot at the tail
+func (n *Node) prune() {
+	for _, block := range n.chain[:len(n.chain)-1] {
+		delete(n.blocks, hex.EncodeToString(block.Hash()))
+	}
+	n.chain = n.chain[len(n.chain)-1:]
+	n.base = n.chain[0].BlockNumber()
+	log.Printf("Pruned the blocks before snapshot %d", n.base)
+}
+
+// --- View change ---
+
+// enterView moves to the view, sending our highest certificate to its leader
+// and forwarding the pending proposal
+func (n *Node) enterView(g *gossip.Gossiper, view int) []*extramessage.ExtraMessage {
+	n.view = view
+	n.deadline = time.Now().Add(n.timeout)
+	n.ready = false
+	n.proposed = false
+	n.voted = make(map[int]bool)
+
+	newView := &extramessage.BFTNewView{
+		Height: n.height(),
+		View:   view,
+		Node:   n.nodeIndex,
+		HighQC: n.locked,
+	}
+	highView, highHash := -1, []byte(nil)
+	if n.locked != nil {
+		highView, highHash = n.locked.View, n.locked.Hash
+		newView.Value = n.values[hex.EncodeToString(n.locked.Hash)]
+	}
+	newView.Signature = n.keys.sign(newViewDigest(n.height(), view, highView, highHash))
+	n.addNewView(newView)
+
+	out := []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			BFTNewView: newView,
+		},
+	}
+	if n.pending != nil && n.leaderOf(view) != n.nodeIndex {
+		out = append(out, n.forward())
+	}
+	return append(out, n.tryReady(g)...)
+}
+
+func (n *Node) addNewView(newView *extramessage.BFTNewView) {
+	nodes, ok := n.newViews[newView.View]
+	if !ok {
+		nodes = make(map[int]*extramessage.BFTNewView)
+		n.newViews[newView.View] = nodes
+	}
+	nodes[newView.Node] = newView
+}
+
+func (n *Node) uponNewView(g *gossip.Gossiper, msg *extramessage.ExtraMessage, newView *extramessage.BFTNewView) []*extramessage.ExtraMessage {
+	if !n.current(msg, newView.Height) || newView.View < n.view {
+		return nil
+	}
+	highView, highHash := -1, []byte(nil)
+	if newView.HighQC != nil {
+		highView, highHash = newView.HighQC.View, newView.HighQC.Hash
+		if !n.extends(newView.Value) || !bytes.Equal(newView.Value.Hash(), highHash) ||
+			!n.valid(newView.HighQC, phasePrepare) {
+			return nil
+		}
+	}
+	if !n.keys.verify(newView.Node, newViewDigest(newView.Height, newView.View, highView, highHash), newView.Signature) {
+		return nil
+	}
+
+	n.addNewView(newView)
+	if newView.HighQC != nil {
+		n.values[hex.EncodeToString(highHash)] = newView.Value
+		n.lock(newView.HighQC)
+	}
+
+	// f+1 participants moved to the view, at least one of them being correct
+	if newView.View > n.view && len(n.newViews[newView.View]) > n.f {
+		log.Printf("%s Join view %d", g.GetIdentifier(), newView.View)
+		return n.enterView(g, newView.View)
+	}
+	return n.tryReady(g)
+}
+
+// tryReady lets the leader propose once it knows the highest certificates of
+// a quorum
+func (n *Node) tryReady(g *gossip.Gossiper) []*extramessage.ExtraMessage {
+	if n.leaderOf(n.view) != n.nodeIndex || n.ready || len(n.newViews[n.view]) < n.quorum() {
+		return nil
+	}
+	n.ready = true
+	return n.tryPropose(g)
+}
+
+// send broadcasts the messages, it must be called without holding the mutex
+// as the gossiper may be blocked on the messages being handled
+func send(g *gossip.Gossiper, out []*extramessage.ExtraMessage) {
+	for _, msg := range out {
+		g.AddExtraMessage(msg)
+	}
+}
@@ -0,0 +1,312 @@
+package bft
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+type message struct {
+	from int
+	// to are the recipients, all the other nodes if nil
+	to  []int
+	msg *extramessage.ExtraMessage
+}
+
+// network delivers the messages to the nodes which are up, in order
+type network struct {
+	gossipers []*gossip.Gossiper
+	nodes     []*Node
+	down      map[int]bool
+	queue     []message
+}
+
+func newNetwork(t *testing.T, numParticipant int, numNodes int, firstPort int) *network {
+	keys, err := GenerateKeys(numParticipant)
+	require.NoError(t, err)
+
+	net := &network{
+		gossipers: make([]*gossip.Gossiper, numNodes),
+		nodes:     make([]*Node, numNodes),
+		down:      make(map[int]bool),
+	}
+	for i := range net.nodes {
+		// The gossipers are not run, they only name the nodes
+		g, err := gossip.GetFactory().New(fmt.Sprintf("127.0.0.1:%d", firstPort+i), fmt.Sprintf("node%d", i), 10, 0, numNodes)
+		require.NoError(t, err)
+		net.gossipers[i] = g
+
+		nodeKeys := keys[0].Public()
+		if i < numParticipant {
+			nodeKeys = keys[i]
+		}
+		net.nodes[i] = NewNode(numParticipant, i, 1, blk.NewGenericBlockFactory(), nodeKeys)
+	}
+	return net
+}
+
+func (net *network) send(from int, out []*extramessage.ExtraMessage) {
+	for _, msg := range out {
+		net.queue = append(net.queue, message{from: from, msg: msg})
+	}
+}
+
+func (net *network) propose(from int, patternID string) {
+	net.send(from, net.nodes[from].propose(net.gossipers[from], content(patternID)))
+}
+
+// timeout makes the participants waiting for a block move to the next view
+func (net *network) timeout() {
+	for i, n := range net.nodes {
+		if !net.down[i] && n.participant() && n.waiting() {
+			net.send(i, n.enterView(net.gossipers[i], n.view+1))
+		}
+	}
+}
+
+// run delivers the messages until there is none left
+func (net *network) run() {
+	for len(net.queue) > 0 {
+		m := net.queue[0]
+		net.queue = net.queue[1:]
+		to := m.to
+		if to == nil {
+			for i := range net.nodes {
+				if i != m.from {
+					to = append(to, i)
+				}
+			}
+		}
+		for _, i := range to {
+			if !net.down[i] && !net.down[m.from] {
+				_, out := net.nodes[i].handle(net.gossipers[i], m.msg)
+				net.send(i, out)
+			}
+		}
+	}
+}
+
+func (net *network) requireSameChain(t *testing.T, length int, nodes ...int) {
+	chain := net.nodes[nodes[0]].chain
+	require.Len(t, chain, length)
+	for i, block := range chain {
+		if i > 0 {
+			require.Equal(t, chain[i-1].Hash(), block.PreviousHash())
+		}
+	}
+	for _, i := range nodes {
+		require.Equal(t, chain, net.nodes[i].chain, "node%d", i)
+	}
+}
+
+func content(patternID string) *blk.MappingBlockContent {
+	return &blk.MappingBlockContent{
+		PatternID: patternID,
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 10, Z: 0}},
+	}
+}
+
+func patternID(block *blk.BlockContainer) string {
+	return block.GetContent().(*blk.MappingBlockContent).PatternID
+}
+
+func TestNode_replication(t *testing.T) {
+	net := newNetwork(t, 4, 5, 7400)
+
+	// Proposals of followers are forwarded to the leader
+	net.propose(1, "pattern0")
+	net.run()
+	net.propose(0, "pattern1")
+	net.propose(2, "pattern1")
+	net.run()
+
+	net.requireSameChain(t, 2, 0, 1, 2, 3, 4)
+	require.Equal(t, "pattern0", patternID(net.nodes[4].chain[0]))
+}
+
+func TestNode_leaderCrash(t *testing.T) {
+	net := newNetwork(t, 4, 4, 7500)
+
+	net.propose(0, "pattern0")
+	net.run()
+
+	// The leader is down, the others move to the view of node 1
+	net.down[0] = true
+	net.propose(2, "pattern1")
+	net.run()
+	net.requireSameChain(t, 1, 1, 2, 3)
+
+	net.timeout()
+	net.run()
+	net.requireSameChain(t, 2, 1, 2, 3)
+	require.Equal(t, "pattern1", patternID(net.nodes[1].chain[1]))
+	require.Equal(t, 1, net.nodes[3].view)
+}
+
+func TestNode_forgedMessages(t *testing.T) {
+	net := newNetwork(t, 4, 5, 7600)
+	byzantine := net.nodes[3]
+	forged := byzantine.newBlock(content("forged"))
+	hash := forged.Hash()
+
+	net.send(3, []*extramessage.ExtraMessage{
+		// The TLC messages are not signed
+		&extramessage.ExtraMessage{
+			PaxosTLC: &extramessage.PaxosTLC{Value: forged, Node: 0},
+		},
+		// Node 3 is not the leader of view 0
+		&extramessage.ExtraMessage{
+			BFTProposal: &extramessage.BFTProposal{
+				Height:    0,
+				View:      0,
+				Leader:    3,
+				Value:     forged,
+				Signature: byzantine.keys.sign(proposalDigest(0, 0, hash)),
+			},
+		},
+		// Node 3 signs in the name of the leader
+		&extramessage.ExtraMessage{
+			BFTProposal: &extramessage.BFTProposal{
+				Height:    0,
+				View:      0,
+				Leader:    0,
+				Value:     forged,
+				Signature: byzantine.keys.sign(proposalDigest(0, 0, hash)),
+			},
+		},
+	})
+	for voter := 0; voter < 4; voter++ {
+		for _, phase := range []int{phasePrepare, phaseCommit} {
+			net.send(3, []*extramessage.ExtraMessage{
+				&extramessage.ExtraMessage{
+					BFTVote: &extramessage.BFTVote{
+						Phase:     phase,
+						Height:    0,
+						View:      0,
+						Hash:      hash,
+						Voter:     voter,
+						Signature: byzantine.keys.sign(voteDigest(phase, 0, 0, hash)),
+					},
+				},
+			})
+		}
+	}
+	net.run()
+	for _, n := range net.nodes {
+		require.Empty(t, n.chain)
+	}
+
+	net.propose(1, "pattern0")
+	net.run()
+	net.requireSameChain(t, 1, 0, 1, 2, 4)
+	require.Equal(t, "pattern0", patternID(net.nodes[4].chain[0]))
+}
+
+func TestNode_equivocatingLeader(t *testing.T) {
+	net := newNetwork(t, 4, 4, 7700)
+	leader := net.nodes[0]
+
+	// The leader signs and votes for two blocks in the same view, each
+	// participant votes for the first one it receives
+	var proposals, votes []*extramessage.ExtraMessage
+	for _, id := range []string{"patternA", "patternB"} {
+		block := leader.newBlock(content(id))
+		hash := block.Hash()
+		proposals = append(proposals, &extramessage.ExtraMessage{
+			BFTProposal: &extramessage.BFTProposal{
+				Height:    0,
+				View:      0,
+				Leader:    0,
+				Value:     block,
+				Signature: leader.keys.sign(proposalDigest(0, 0, hash)),
+			},
+		})
+		votes = append(votes, &extramessage.ExtraMessage{
+			BFTVote: &extramessage.BFTVote{
+				Phase:     phasePrepare,
+				Height:    0,
+				View:      0,
+				Hash:      hash,
+				Voter:     0,
+				Signature: leader.keys.sign(voteDigest(phasePrepare, 0, 0, hash)),
+			},
+		})
+	}
+	// The leader only takes part through the messages it signed
+	net.down[0] = true
+	net.queue = append(net.queue,
+		message{from: 3, to: []int{1}, msg: proposals[0]},
+		message{from: 3, to: []int{2, 3}, msg: proposals[1]},
+		message{from: 3, to: []int{2, 3}, msg: proposals[0]},
+		message{from: 3, to: []int{1}, msg: proposals[1]},
+		message{from: 3, to: []int{1, 2, 3}, msg: votes[0]},
+		message{from: 3, to: []int{1, 2, 3}, msg: votes[1]},
+	)
+	net.run()
+
+	// Only the block voted by the correct participants gets a quorum
+	net.requireSameChain(t, 1, 1, 2, 3)
+	require.Equal(t, "patternB", patternID(net.nodes[1].chain[0]))
+}
+
+func TestNode_lockedAfterViewChange(t *testing.T) {
+	net := newNetwork(t, 4, 4, 7800)
+
+	// Only node 3 gets the prepare certificate and locks on the block
+	net.propose(0, "pattern0")
+	proposal := net.queue[0]
+	net.queue = nil
+	var votes []*extramessage.ExtraMessage
+	for i := 1; i < 4; i++ {
+		_, out := net.nodes[i].handle(net.gossipers[i], proposal.msg)
+		votes = append(votes, out[0])
+	}
+	for _, vote := range votes[:2] {
+		net.nodes[3].handle(net.gossipers[3], vote)
+	}
+	require.NotNil(t, net.nodes[3].locked)
+	require.Nil(t, net.nodes[1].locked)
+	require.Empty(t, net.nodes[3].chain)
+
+	// Node 1 leads the next view and proposes the block again with the
+	// certificate of node 3
+	net.down[0] = true
+	net.propose(2, "pattern1")
+	net.run()
+	net.timeout()
+	net.run()
+
+	net.requireSameChain(t, 1, 1, 2, 3)
+	require.Equal(t, "pattern0", patternID(net.nodes[2].chain[0]))
+}
+
+func TestNode_snapshot(t *testing.T) {
+	net := newNetwork(t, 4, 5, 8000)
+
+	for i := 0; i < 2; i++ {
+		net.propose(0, fmt.Sprintf("pattern%d", i))
+		net.run()
+	}
+	net.send(0, net.nodes[0].propose(net.gossipers[0], &blk.SnapshotBlockContent{
+		PatternID: "pattern1",
+		Positions: []r3.Vec{r3.Vec{X: 1, Y: 10, Z: 0}},
+	}))
+	net.run()
+	net.propose(1, "pattern2")
+	net.run()
+
+	// The blocks preceding the snapshot are pruned
+	net.requireSameChain(t, 2, 0, 1, 2, 3, 4)
+	for i, n := range net.nodes {
+		require.Equal(t, 2, n.base, "node%d", i)
+		require.Equal(t, 4, n.height(), "node%d", i)
+		require.Len(t, n.blocks, 2, "node%d", i)
+		tail, blocks := n.GetBlocks()
+		require.NoError(t, blk.Verify(tail, blocks))
+	}
+}
@@ -0,0 +1,74 @@
+package bft
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+)
+
+// Keys holds the public keys of the participants, indexed by node, and the
+// private key of the node, which is nil for the nodes only reading the chain.
+type Keys struct {
+	Roster  []ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+// GenerateKeys generates the keys of numParticipant participants sharing the
+// same roster
+func GenerateKeys(numParticipant int) ([]*Keys, error) {
+	roster := make([]ed25519.PublicKey, numParticipant)
+	privates := make([]ed25519.PrivateKey, numParticipant)
+	for i := range roster {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate the key of node %d: %v", i, err)
+		}
+		roster[i] = public
+		privates[i] = private
+	}
+
+	keys := make([]*Keys, numParticipant)
+	for i := range keys {
+		keys[i] = &Keys{
+			Roster:  roster,
+			Private: privates[i],
+		}
+	}
+	return keys, nil
+}
+
+// Public returns the keys without the private key, for a reader
+func (k *Keys) Public() *Keys {
+	return &Keys{
+		Roster: k.Roster,
+	}
+}
+
+func (k *Keys) sign(message []byte) []byte {
+	return ed25519.Sign(k.Private, message)
+}
+
+// verify checks the signature of the node, which must be a participant
+func (k *Keys) verify(node int, message []byte, signature []byte) bool {
+	if node < 0 || node >= len(k.Roster) || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(k.Roster[node], message, signature)
+}
+
+func proposalDigest(height, view int, hash []byte) []byte {
+	return []byte(fmt.Sprintf("proposal/%d/%d/%x", height, view, hash))
+}
+
+func voteDigest(phase, height, view int, hash []byte) []byte {
+	return []byte(fmt.Sprintf("vote/%d/%d/%d/%x", phase, height, view, hash))
+}
+
+// newViewDigest covers the certificate the node sent, -1 standing for none
+func newViewDigest(height, view, highView int, highHash []byte) []byte {
+	return []byte(fmt.Sprintf("new-view/%d/%d/%d/%x", height, view, highView, highHash))
+}
+
+func forwardDigest(hash []byte) []byte {
+	return []byte(fmt.Sprintf("forward/%x", hash))
+}
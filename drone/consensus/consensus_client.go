@@ -1,18 +1,109 @@
 package consensus
 
 import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"go.dedis.ch/cs438/orbitalswarm/bft"
 	"go.dedis.ch/cs438/orbitalswarm/extramessage"
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/paxos"
 	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/cs438/orbitalswarm/raft"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
+const (
+	// PaxosProtocol agrees on each block with a new Paxos instance followed by a TLC round
+	PaxosProtocol = "paxos"
+	// MultiPaxosProtocol agrees on the blocks with a stable leader skipping the prepare phase
+	MultiPaxosProtocol = "multi-paxos"
+	// RaftProtocol replicates the blocks in the log of a Raft leader
+	RaftProtocol = "raft"
+	// BFTProtocol agrees on the blocks with signed votes, tolerating (numParticipant-1)/3 Byzantine participants
+	BFTProtocol = "bft"
+)
+
+// chain agrees on the blocks proposed by the participants
+type chain interface {
+	Propose(g *gossip.Gossiper, blockContent blk.BlockContent)
+	HandleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer
+	GetBlocks() (string, map[string]*blk.BlockContainer)
+	Stop()
+}
+
 type ConsensusClient interface {
 	ProposeTargets(g *gossip.Gossiper, patternID string, targets []r3.Vec) []r3.Vec
-	ProposePaths(g *gossip.Gossiper, patternID string, paths [][]r3.Vec) [][]r3.Vec
+	ProposePaths(g *gossip.Gossiper, patternID string, paths [][]r3.Vec, trajectories []trajectory.Trajectory) *blk.PathBlockContent
 
 	GetBlocks() (string, map[string]*blk.BlockContainer)
-	HandleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer
+	// HandleExtraMessage handles a message of the consensus gossiped by origin
+	HandleExtraMessage(g *gossip.Gossiper, origin string, msg *extramessage.ExtraMessage) *blk.BlockContainer
+
+	// CommitLatency returns the time taken to commit the blocks proposed by the node
+	CommitLatency() LatencyStats
 
 	IsProposer() bool
+	Stop()
+}
+
+// NewConsensusClient creates the client of the node nodeIndex for the given
+// protocol. The first numParticipant nodes take part in the consensus, the
+// others only read the blocks. The keys are only used by the BFT protocol, the
+// participants requiring their private key. With a storage directory, the
+// Paxos protocol keeps the state of the node in a log named after it, from
+// which it is restored when the node restarts. The other protocols ignore it
+// and keep their state in memory only.
+func NewConsensusClient(protocol string, numParticipant, nodeIndex, paxosRetry int, keys *bft.Keys, storageDir string) (ConsensusClient, error) {
+	if storageDir != "" && protocol != PaxosProtocol {
+		log.Printf("The %s consensus of node %d is kept in memory only", protocol, nodeIndex)
+	}
+
+	var blockChain chain
+	switch protocol {
+	case PaxosProtocol:
+		var storage *paxos.Storage
+		if storageDir != "" {
+			var err error
+			storage, err = paxos.OpenStorage(filepath.Join(storageDir, fmt.Sprintf("node%d.wal", nodeIndex)))
+			if err != nil {
+				return nil, err
+			}
+		}
+		blockChain = paxos.NewBlockchain(numParticipant, nodeIndex, paxosRetry, blk.NewGenericBlockFactory(), storage)
+	case MultiPaxosProtocol:
+		blockChain = paxos.NewMultiPaxosBlockchain(numParticipant, nodeIndex, paxosRetry, blk.NewGenericBlockFactory())
+	case RaftProtocol:
+		blockChain = raft.NewNode(numParticipant, nodeIndex, paxosRetry, blk.NewGenericBlockFactory())
+	case BFTProtocol:
+		if keys == nil || len(keys.Roster) != numParticipant {
+			return nil, fmt.Errorf("the %s consensus requires the public keys of the %d participants", protocol, numParticipant)
+		}
+		if nodeIndex < numParticipant && keys.Private == nil {
+			return nil, fmt.Errorf("the private key of participant %d is missing", nodeIndex)
+		}
+		blockChain = bft.NewNode(numParticipant, nodeIndex, paxosRetry, blk.NewGenericBlockFactory(), keys)
+	default:
+		return nil, fmt.Errorf("unknown consensus protocol %s", protocol)
+	}
+
+	if nodeIndex < numParticipant {
+		return newConsensusParticipant(blockChain, numParticipant), nil
+	}
+	return newConsensusReader(blockChain, numParticipant), nil
+}
+
+// patternOf returns the pattern of the block, if it has one
+func patternOf(blockContainer *blk.BlockContainer) string {
+	switch content := blockContainer.GetContent().(type) {
+	case *blk.MappingBlockContent:
+		return content.PatternID
+	case *blk.PathBlockContent:
+		return content.PatternID
+	case *blk.SnapshotBlockContent:
+		return content.PatternID
+	}
+	return ""
 }
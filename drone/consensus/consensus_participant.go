@@ -8,9 +8,14 @@ import (
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
 	"go.dedis.ch/cs438/orbitalswarm/paxos"
 	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
+// snapshotInterval is the number of patterns flown between two snapshots of
+// the chain
+const snapshotInterval = 8
+
 type targetProposition struct {
 	patternID string
 	targets   []r3.Vec
@@ -18,158 +23,287 @@ type targetProposition struct {
 }
 
 type pathProposition struct {
-	patternID string
-	paths     [][]r3.Vec
-	done      chan [][]r3.Vec
+	patternID    string
+	paths        [][]r3.Vec
+	trajectories []trajectory.Trajectory
+	done         chan *blk.PathBlockContent
 }
 
+// ConsensusParticipant proposes the mappings and the paths of the patterns.
+// Several patterns may be agreed at the same time, the propositions of a
+// pattern being answered by the first block committed for it. As the chain
+// may drop a proposal when another block is committed, the propositions
+// still waiting are proposed again after each block.
+//
+// Every snapshotInterval patterns, the participants propose a snapshot
+// holding the positions reached by the drones, so that the chain can prune
+// the blocks preceding it. The patterns flown before the snapshot are then
+// forgotten as well.
 type ConsensusParticipant struct {
-	blockChain *paxos.BlockChain
+	blockChain     chain
+	numParticipant int
+	latency        latencyRecorder
 
+	mutex sync.Mutex
 	// PatternID -> targets
 	patterns map[string][]r3.Vec
 	// PatternID -> path
-	paths map[string][][]r3.Vec
-
-	mutex       sync.Mutex
-	proposed    bool
-	pending     []*targetProposition
-	pendingPath []*pathProposition
+	paths map[string]*blk.PathBlockContent
+	// PatternID -> propositions waiting for the block of the pattern
+	pending     map[string][]*targetProposition
+	pendingPath map[string][]*pathProposition
+
+	// Snapshot, the pattern of the last one, the patterns flown since then
+	// and the snapshot proposed, if any
+	snapshotInterval int
+	lastSnapshot     string
+	flown            int
+	snapshot         blk.BlockContent
+	// Patterns whose paths were agreed, in the order of the chain, from the
+	// pattern of the last snapshot
+	agreed []string
 }
 
 func NewConsensusParticipant(numDrones, nodeIndex, paxosRetry int) *ConsensusParticipant {
+	return newConsensusParticipant(paxos.NewBlockchain(numDrones, nodeIndex, paxosRetry, blk.NewGenericBlockFactory(), nil), numDrones)
+}
+
+func newConsensusParticipant(blockChain chain, numParticipant int) *ConsensusParticipant {
 	return &ConsensusParticipant{
-		blockChain: paxos.NewBlockchain(numDrones, nodeIndex, paxosRetry, blk.NewGenericBlockFactory()),
+		blockChain:     blockChain,
+		numParticipant: numParticipant,
 
 		patterns: make(map[string][]r3.Vec),
-		paths:    make(map[string][][]r3.Vec),
+		paths:    make(map[string]*blk.PathBlockContent),
+
+		pending:     make(map[string][]*targetProposition),
+		pendingPath: make(map[string][]*pathProposition),
 
-		proposed:    false,
-		pending:     make([]*targetProposition, 0),
-		pendingPath: make([]*pathProposition, 0),
+		snapshotInterval: snapshotInterval,
 	}
 }
 
+// ProposeTargets proposes the mapping of a pattern and returns the mapping
+// agreed by the participants, which may differ from the proposed one
 func (c *ConsensusParticipant) ProposeTargets(g *gossip.Gossiper, patternID string, targets []r3.Vec) []r3.Vec {
+	c.mutex.Lock()
 	//PatternID already mapped
-	if ConsensusParticipant, found := c.patterns[patternID]; found {
-		return ConsensusParticipant
+	if agreement, found := c.patterns[patternID]; found {
+		c.mutex.Unlock()
+		return agreement
 	}
 
-	// Add the propostion to the pending list
+	// Add the propostion to the pending list of the pattern
 	prop := &targetProposition{
 		patternID: patternID,
 		targets:   targets,
-		done:      make(chan []r3.Vec),
+		done:      make(chan []r3.Vec, 1),
 	}
 
-	c.mutex.Lock()
-	c.pending = append(c.pending, prop)
-	if !c.proposed {
-		log.Printf("Propose mapping")
-		c.proposed = true
-		c.blockChain.Propose(g, &blk.MappingBlockContent{
-			PatternID: prop.patternID,
-			Targets:   prop.targets,
-		})
+	c.pending[patternID] = append(c.pending[patternID], prop)
+	if len(c.pending[patternID]) == 1 {
+		log.Printf("Propose mapping of %s", patternID)
+		c.latency.propose(blk.BlockMappingStr, patternID)
+		c.blockChain.Propose(g, prop.content())
 	}
 	c.mutex.Unlock()
 
 	return <-prop.done
 }
 
-func (c *ConsensusParticipant) ProposePaths(g *gossip.Gossiper, patternID string, paths [][]r3.Vec) [][]r3.Vec {
+func (c *ConsensusParticipant) ProposePaths(g *gossip.Gossiper, patternID string, paths [][]r3.Vec, trajectories []trajectory.Trajectory) *blk.PathBlockContent {
+	c.mutex.Lock()
 	//PatternID already mapped
 	if agreement, found := c.paths[patternID]; found {
+		c.mutex.Unlock()
 		return agreement
 	}
 
-	// Add the propostion to the pending list
+	// Add the propostion to the pending list of the pattern
 	prop := &pathProposition{
-		patternID: patternID,
-		paths:     paths,
-		done:      make(chan [][]r3.Vec),
+		patternID:    patternID,
+		paths:        paths,
+		trajectories: trajectories,
+		done:         make(chan *blk.PathBlockContent, 1),
 	}
 
-	c.mutex.Lock()
-	c.pendingPath = append(c.pendingPath, prop)
-	if !c.proposed {
-		log.Printf("Propose paths")
-		c.proposed = true
-		c.blockChain.Propose(g, &blk.PathBlockContent{
-			PatternID: prop.patternID,
-			Paths:     prop.paths,
-		})
+	c.pendingPath[patternID] = append(c.pendingPath[patternID], prop)
+	if len(c.pendingPath[patternID]) == 1 {
+		log.Printf("Propose paths of %s", patternID)
+		c.latency.propose(blk.BlockPathStr, patternID)
+		c.blockChain.Propose(g, prop.content())
 	}
 	c.mutex.Unlock()
 
 	return <-prop.done
 }
 
+func (p *targetProposition) content() blk.BlockContent {
+	return &blk.MappingBlockContent{
+		PatternID: p.patternID,
+		Targets:   p.targets,
+	}
+}
+
+func (p *pathProposition) content() blk.BlockContent {
+	return &blk.PathBlockContent{
+		PatternID:    p.patternID,
+		Paths:        p.paths,
+		Trajectories: p.trajectories,
+	}
+}
+
 func (c *ConsensusParticipant) GetBlocks() (string, map[string]*blk.BlockContainer) {
 	return c.blockChain.GetBlocks()
 }
 
-func (c *ConsensusParticipant) HandleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+// HandleExtraMessage returns the block committed, if any. A block for a
+// pattern already agreed is ignored, as is a message of a participant sent by
+// another node.
+func (c *ConsensusParticipant) HandleExtraMessage(g *gossip.Gossiper, origin string, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+	if err := checkOrigin(c.numParticipant, origin, msg); err != nil {
+		log.Printf("Reject consensus message: %v", err)
+		return nil
+	}
 	blockContainer := c.blockChain.HandleExtraMessage(g, msg)
 	if blockContainer == nil {
 		return nil
 	}
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	first := true
 	switch blockContainer.Type {
 	case blk.BlockMappingStr:
 		log.Printf("Received a mapping block")
-		c.handleMappingBlock(blockContainer)
+		first = c.handleMappingBlock(blockContainer)
 	case blk.BlockPathStr:
 		log.Printf("Received a path block")
-		c.handlePathBlock(blockContainer)
+		first = c.handlePathBlock(blockContainer)
+		if first {
+			c.proposeSnapshot(g, blockContainer.GetContent().(*blk.PathBlockContent).PatternID)
+		}
+	case blk.BlockSnapshotStr:
+		log.Printf("Received a snapshot block")
+		first = c.handleSnapshotBlock(blockContainer)
+	}
+	c.proposeAgain(g)
+
+	if latency, ok := c.latency.commit(blockContainer.Type, patternOf(blockContainer)); ok {
+		log.Printf("Block %d committed in %s (%s)", blockContainer.BlockNumber(), latency, c.latency.get())
+	}
+	if !first {
+		log.Printf("Ignore block %d of a pattern already agreed", blockContainer.BlockNumber())
+		return nil
 	}
 	return blockContainer
 }
 
-func (c *ConsensusParticipant) handleMappingBlock(blockContainer *blk.BlockContainer) {
-	block := blockContainer.Block.(*blk.MappingBlock)
-	if block != nil {
-		blockContent := block.GetContent().(*blk.MappingBlockContent)
-
-		c.patterns[blockContent.PatternID] = blockContent.Targets
+// handleMappingBlock answers the propositions of the pattern of the block and
+// returns whether it is the first mapping of the pattern
+func (c *ConsensusParticipant) handleMappingBlock(blockContainer *blk.BlockContainer) bool {
+	blockContent := blockContainer.GetContent().(*blk.MappingBlockContent)
+	if _, found := c.patterns[blockContent.PatternID]; found {
+		return false
+	}
+	c.patterns[blockContent.PatternID] = blockContent.Targets
 
-		// Handle pending propositions
-		c.mutex.Lock()
-		defer c.mutex.Unlock()
-		c.proposed = false
+	for _, p := range c.pending[blockContent.PatternID] {
+		p.done <- blockContent.Targets
+		close(p.done)
+	}
+	delete(c.pending, blockContent.PatternID)
+	return true
+}
 
-		for _, p := range c.pending {
-			p.done <- blockContent.Targets
-			close(p.done)
-		}
+// handlePathBlock answers the propositions of the pattern of the block and
+// returns whether it holds the first paths of the pattern
+func (c *ConsensusParticipant) handlePathBlock(blockContainer *blk.BlockContainer) bool {
+	blockContent := blockContainer.GetContent().(*blk.PathBlockContent)
+	if _, found := c.paths[blockContent.PatternID]; found {
+		return false
+	}
+	c.paths[blockContent.PatternID] = blockContent
+	c.agreed = append(c.agreed, blockContent.PatternID)
 
-		c.pending = make([]*targetProposition, 0)
+	for _, p := range c.pendingPath[blockContent.PatternID] {
+		p.done <- blockContent
+		close(p.done)
 	}
+	delete(c.pendingPath, blockContent.PatternID)
+	return true
 }
 
-func (c *ConsensusParticipant) handlePathBlock(blockContainer *blk.BlockContainer) {
-	block := blockContainer.Block.(*blk.PathBlock)
-	if block != nil {
-		blockContent := block.GetContent().(*blk.PathBlockContent)
-
-		c.paths[blockContent.PatternID] = blockContent.Paths
+// proposeSnapshot proposes a snapshot once snapshotInterval patterns were
+// flown since the last one. The drones reach the targets of the pattern, so
+// every participant proposes the same snapshot.
+func (c *ConsensusParticipant) proposeSnapshot(g *gossip.Gossiper, patternID string) {
+	c.flown++
+	targets, found := c.patterns[patternID]
+	if c.flown < c.snapshotInterval || !found {
+		return
+	}
+	log.Printf("Propose snapshot after %s", patternID)
+	c.latency.propose(blk.BlockSnapshotStr, patternID)
+	c.snapshot = &blk.SnapshotBlockContent{
+		PatternID: patternID,
+		Positions: targets,
+	}
+	c.blockChain.Propose(g, c.snapshot)
+}
 
-		// Handle pending propositions
-		c.mutex.Lock()
-		defer c.mutex.Unlock()
-		c.proposed = false
+// handleSnapshotBlock forgets the patterns agreed before the pattern of the
+// snapshot and returns whether it is the first snapshot after this pattern
+func (c *ConsensusParticipant) handleSnapshotBlock(blockContainer *blk.BlockContainer) bool {
+	patternID := blockContainer.GetContent().(*blk.SnapshotBlockContent).PatternID
+	if patternID == c.lastSnapshot {
+		return false
+	}
+	c.lastSnapshot = patternID
+	c.flown = 0
+	c.snapshot = nil
+	c.prune(patternID)
+	return true
+}
 
-		for _, p := range c.pendingPath {
-			p.done <- blockContent.Paths
-			close(p.done)
+// prune forgets the patterns agreed before the given one
+func (c *ConsensusParticipant) prune(patternID string) {
+	for i, agreed := range c.agreed {
+		if agreed != patternID {
+			continue
 		}
+		for _, old := range c.agreed[:i] {
+			delete(c.patterns, old)
+			delete(c.paths, old)
+		}
+		c.agreed = append([]string{}, c.agreed[i:]...)
+		return
+	}
+}
 
-		c.pendingPath = make([]*pathProposition, 0)
-		log.Printf("Quit path handle")
+// proposeAgain proposes the first proposition of each pattern still waiting,
+// the chain ignoring the ones it still proposes
+func (c *ConsensusParticipant) proposeAgain(g *gossip.Gossiper) {
+	for _, props := range c.pending {
+		c.blockChain.Propose(g, props[0].content())
 	}
+	for _, props := range c.pendingPath {
+		c.blockChain.Propose(g, props[0].content())
+	}
+	if c.snapshot != nil {
+		c.blockChain.Propose(g, c.snapshot)
+	}
+}
+
+func (c *ConsensusParticipant) CommitLatency() LatencyStats {
+	return c.latency.get()
 }
 
 func (c *ConsensusParticipant) IsProposer() bool {
 	return true
 }
+
+func (c *ConsensusParticipant) Stop() {
+	c.blockChain.Stop()
+}
@@ -1,28 +1,46 @@
 package consensus
 
 import (
+	"log"
+
 	"go.dedis.ch/cs438/orbitalswarm/extramessage"
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
 	"go.dedis.ch/cs438/orbitalswarm/paxos"
 	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
 type ConsensusReader struct {
-	blockChain *paxos.BlockChain
+	blockChain     chain
+	numParticipant int
+	// agreed holds the patterns with a committed block, by block type
+	agreed map[string]map[string]bool
 }
 
 func NewConsensusReader(numDrones, nodeIndex, paxosRetry int) *ConsensusReader {
+	return newConsensusReader(paxos.NewBlockchain(numDrones, nodeIndex, paxosRetry, blk.NewGenericBlockFactory(), nil), numDrones)
+}
+
+func newConsensusReader(blockChain chain, numParticipant int) *ConsensusReader {
 	return &ConsensusReader{
-		blockChain: paxos.NewBlockchain(numDrones, nodeIndex, paxosRetry, blk.NewGenericBlockFactory()),
+		blockChain:     blockChain,
+		numParticipant: numParticipant,
+		agreed: map[string]map[string]bool{
+			blk.BlockMappingStr:  make(map[string]bool),
+			blk.BlockPathStr:     make(map[string]bool),
+			blk.BlockSnapshotStr: make(map[string]bool),
+		},
 	}
 }
 
+// ProposeTargets returns nil as readers do not take part in the agreement,
+// they learn the mapping from the committed block
 func (c *ConsensusReader) ProposeTargets(g *gossip.Gossiper, patternID string, targets []r3.Vec) []r3.Vec {
 	return nil
-
 }
-func (c *ConsensusReader) ProposePaths(g *gossip.Gossiper, patternID string, paths [][]r3.Vec) [][]r3.Vec {
+
+func (c *ConsensusReader) ProposePaths(g *gossip.Gossiper, patternID string, paths [][]r3.Vec, trajectories []trajectory.Trajectory) *blk.PathBlockContent {
 	return nil
 }
 
@@ -30,13 +48,44 @@ func (c *ConsensusReader) GetBlocks() (string, map[string]*blk.BlockContainer) {
 	return c.blockChain.GetBlocks()
 }
 
-func (c *ConsensusReader) HandleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
-	if msg.PaxosTLC != nil {
-		return c.blockChain.HandleExtraMessage(g, msg)
+// HandleExtraMessage learns the committed blocks, and the blocks it missed
+// from the sync replies. As with the participants, only the first block of a
+// pattern is returned.
+func (c *ConsensusReader) HandleExtraMessage(g *gossip.Gossiper, origin string, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+	if msg.PaxosTLC == nil && msg.PaxosSyncRequest == nil && msg.PaxosSyncReply == nil &&
+		msg.BFTProposal == nil && msg.BFTVote == nil {
+		return nil
 	}
-	return nil
+	if err := checkOrigin(c.numParticipant, origin, msg); err != nil {
+		log.Printf("Reject consensus message: %v", err)
+		return nil
+	}
+	blockContainer := c.blockChain.HandleExtraMessage(g, msg)
+	if blockContainer == nil {
+		return nil
+	}
+
+	agreed, found := c.agreed[blockContainer.Type]
+	if !found {
+		return blockContainer
+	}
+	patternID := patternOf(blockContainer)
+	if agreed[patternID] {
+		return nil
+	}
+	agreed[patternID] = true
+	return blockContainer
+}
+
+// CommitLatency returns empty stats as readers do not propose
+func (c *ConsensusReader) CommitLatency() LatencyStats {
+	return LatencyStats{}
 }
 
 func (c *ConsensusReader) IsProposer() bool {
 	return false
 }
+
+func (c *ConsensusReader) Stop() {
+	c.blockChain.Stop()
+}
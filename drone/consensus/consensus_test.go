@@ -0,0 +1,331 @@
+package consensus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/bft"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+type testNode struct {
+	g      *gossip.Gossiper
+	client ConsensusClient
+	blocks chan *blk.BlockContainer
+}
+
+func (n *testNode) stop() {
+	n.client.Stop()
+	n.g.Stop()
+}
+
+// newTestNodes starts numNodes nodes on the loopback, the first numParticipant
+// of them taking part in the consensus
+func newTestNodes(t *testing.T, protocol string, numParticipant, numNodes, firstPort int) []*testNode {
+	addresses := make([]string, numNodes)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("127.0.0.1:%d", firstPort+i)
+	}
+
+	var keys []*bft.Keys
+	if protocol == BFTProtocol {
+		var err error
+		keys, err = bft.GenerateKeys(numParticipant)
+		require.NoError(t, err)
+	}
+
+	nodes := make([]*testNode, numNodes)
+	for i := range nodes {
+		g, err := gossip.GetFactory().New(addresses[i], NodeName(i), 10, 0, numNodes)
+		require.NoError(t, err)
+		var nodeKeys *bft.Keys
+		if i < len(keys) {
+			nodeKeys = keys[i]
+		} else if keys != nil {
+			nodeKeys = keys[0].Public()
+		}
+		client, err := NewConsensusClient(protocol, numParticipant, i, 1, nodeKeys, "")
+		require.NoError(t, err)
+
+		n := &testNode{
+			g:      g,
+			client: client,
+			blocks: make(chan *blk.BlockContainer, 100),
+		}
+		g.RegisterCallback(func(origin string, msg gossip.GossipPacket) {
+			if msg.Rumor != nil && msg.Rumor.Extra != nil {
+				if block := n.client.HandleExtraMessage(n.g, origin, msg.Rumor.Extra); block != nil {
+					n.blocks <- block
+				}
+			}
+		})
+		for j, address := range addresses {
+			if j != i {
+				g.AddAddresses(address)
+			}
+		}
+		ready := make(chan struct{})
+		go g.Run(ready)
+		<-ready
+		nodes[i] = n
+	}
+	return nodes
+}
+
+// proposeTargets makes the node propose a mapping and checks that the other
+// nodes commit the same block
+func proposeTargets(t *testing.T, proposer *testNode, nodes []*testNode, patternID string, targets []r3.Vec) {
+	go proposer.client.ProposeTargets(proposer.g, patternID, targets)
+
+	var committed *blk.BlockContainer
+	for _, n := range nodes {
+		select {
+		case block := <-n.blocks:
+			if committed == nil {
+				committed = block
+			}
+			require.Equal(t, committed.Hash(), block.Hash())
+		case <-time.After(30 * time.Second):
+			t.Fatalf("%s did not commit the mapping of %s", n.g.GetIdentifier(), patternID)
+		}
+	}
+	content := committed.GetContent().(*blk.MappingBlockContent)
+	require.Equal(t, patternID, content.PatternID)
+	require.Equal(t, targets, content.Targets)
+}
+
+func TestConsensus_commitLatency(t *testing.T) {
+	numParticipant := 5
+	rounds := 4
+
+	for p, protocol := range []string{PaxosProtocol, MultiPaxosProtocol, RaftProtocol, BFTProtocol} {
+		nodes := newTestNodes(t, protocol, numParticipant, numParticipant+1, 6000+100*p)
+
+		for r := 0; r < rounds; r++ {
+			targets := []r3.Vec{r3.Vec{X: float64(r), Y: 10, Z: 0}}
+			proposeTargets(t, nodes[r%numParticipant], nodes, fmt.Sprintf("pattern%d", r), targets)
+		}
+
+		var total time.Duration
+		count := 0
+		for _, n := range nodes {
+			stats := n.client.CommitLatency()
+			total += stats.Mean * time.Duration(stats.Count)
+			count += stats.Count
+		}
+		require.Equal(t, rounds, count)
+		t.Logf("%s: mean commit latency %s over %d blocks", protocol, total/time.Duration(count), count)
+
+		for _, n := range nodes {
+			n.stop()
+		}
+	}
+}
+
+func TestMultiPaxos_failover(t *testing.T) {
+	numParticipant := 5
+	nodes := newTestNodes(t, MultiPaxosProtocol, numParticipant, numParticipant, 6500)
+
+	proposeTargets(t, nodes[1], nodes, "pattern0", []r3.Vec{r3.Vec{X: 0, Y: 10, Z: 0}})
+
+	// The leader stops, another participant takes over once its lease expired
+	nodes[0].stop()
+	proposeTargets(t, nodes[2], nodes[1:], "pattern1", []r3.Vec{r3.Vec{X: 1, Y: 10, Z: 0}})
+
+	for _, n := range nodes[1:] {
+		n.stop()
+	}
+}
+
+func TestConsensus_pipelined(t *testing.T) {
+	numParticipant := 5
+	numPatterns := 6
+	nodes := newTestNodes(t, PaxosProtocol, numParticipant, numParticipant+1, 6700)
+
+	// Two participants propose the mappings of several patterns at once
+	agreed := make(chan error, numPatterns)
+	for i := 0; i < numPatterns; i++ {
+		proposer := nodes[i%2]
+		patternID := fmt.Sprintf("pattern%d", i)
+		targets := []r3.Vec{r3.Vec{X: float64(i), Y: 10, Z: 0}}
+		go func() {
+			agreement := proposer.client.ProposeTargets(proposer.g, patternID, targets)
+			if len(agreement) != 1 || agreement[0] != targets[0] {
+				agreed <- fmt.Errorf("%s: got mapping %v instead of %v", patternID, agreement, targets)
+				return
+			}
+			agreed <- nil
+		}()
+	}
+	for i := 0; i < numPatterns; i++ {
+		select {
+		case err := <-agreed:
+			require.NoError(t, err)
+		case <-time.After(30 * time.Second):
+			t.Fatalf("only %d patterns agreed", i)
+		}
+	}
+
+	// Every node returns the first block of each pattern, in the order of the chain
+	var order []string
+	for _, n := range nodes {
+		var patterns []string
+		for len(patterns) < numPatterns {
+			select {
+			case block := <-n.blocks:
+				patterns = append(patterns, block.GetContent().(*blk.MappingBlockContent).PatternID)
+			case <-time.After(30 * time.Second):
+				t.Fatalf("%s returned %d blocks", n.g.GetIdentifier(), len(patterns))
+			}
+		}
+		if order == nil {
+			order = patterns
+		}
+		require.Equal(t, order, patterns)
+	}
+
+	for _, n := range nodes {
+		n.stop()
+	}
+}
+
+func TestConsensus_snapshot(t *testing.T) {
+	numParticipant := 4
+	for p, protocol := range []string{PaxosProtocol, MultiPaxosProtocol, RaftProtocol, BFTProtocol} {
+		nodes := newTestNodes(t, protocol, numParticipant, numParticipant+1, 6800+10*p)
+		for _, n := range nodes[:numParticipant] {
+			n.client.(*ConsensusParticipant).snapshotInterval = 1
+		}
+
+		for i := 0; i < 2; i++ {
+			patternID := fmt.Sprintf("pattern%d", i)
+			targets := []r3.Vec{r3.Vec{X: float64(i), Y: 10, Z: 0}}
+			proposeTargets(t, nodes[0], nodes, patternID, targets)
+			go nodes[1].client.ProposePaths(nodes[1].g, patternID, [][]r3.Vec{targets}, nil)
+
+			// The participants propose a snapshot once the paths are agreed,
+			// and the chain is pruned up to it
+			for _, n := range nodes {
+				var types []string
+				for len(types) < 2 {
+					select {
+					case block := <-n.blocks:
+						types = append(types, block.Type)
+					case <-time.After(30 * time.Second):
+						t.Fatalf("%s: %s returned the blocks %v", protocol, n.g.GetIdentifier(), types)
+					}
+				}
+				require.Equal(t, []string{blk.BlockPathStr, blk.BlockSnapshotStr}, types, protocol)
+
+				tail, blocks := n.client.GetBlocks()
+				require.NoError(t, blk.Verify(tail, blocks), protocol)
+				require.Len(t, blocks, 1, protocol)
+				snapshot := blocks[tail]
+				require.Equal(t, blk.BlockSnapshotStr, snapshot.Type, protocol)
+				require.Equal(t, targets, snapshot.GetContent().(*blk.SnapshotBlockContent).Positions, protocol)
+			}
+		}
+
+		// The participants forget the patterns preceding the last snapshot
+		for _, n := range nodes[:numParticipant] {
+			participant := n.client.(*ConsensusParticipant)
+			participant.mutex.Lock()
+			require.Len(t, participant.patterns, 1, protocol)
+			require.Contains(t, participant.paths, "pattern1", protocol)
+			require.Len(t, participant.paths, 1, protocol)
+			participant.mutex.Unlock()
+		}
+
+		for _, n := range nodes {
+			n.stop()
+		}
+	}
+}
+
+func TestConsensus_forgedOrigin(t *testing.T) {
+	nodes := newTestNodes(t, PaxosProtocol, 3, 3, 6900)
+	n := nodes[0]
+	handle := func(origin string, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+		return n.client.HandleExtraMessage(n.g, origin, msg)
+	}
+	block := blk.NewGenericBlockFactory().NewGenesisBlock(blk.BlockMappingStr, 0, &blk.MappingBlockContent{
+		PatternID: "pattern0",
+		Targets:   []r3.Vec{r3.Vec{X: 0, Y: 10, Z: 0}},
+	})
+	accept := func(acceptor int) *extramessage.ExtraMessage {
+		return &extramessage.ExtraMessage{
+			PaxosAccept: &extramessage.PaxosAccept{PaxosSeqID: 0, ID: 1, Value: block, Acceptor: acceptor},
+		}
+	}
+	tlc := func(node int) *extramessage.ExtraMessage {
+		return &extramessage.ExtraMessage{
+			PaxosTLC: &extramessage.PaxosTLC{Value: block, Node: node},
+		}
+	}
+
+	// The node accepts the block, the messages of the other participants
+	// are ignored when gossiped by another node
+	require.Nil(t, handle(NodeName(1), &extramessage.ExtraMessage{
+		PaxosPropose: &extramessage.PaxosPropose{PaxosSeqID: 0, ID: 1, Value: block},
+	}))
+	require.Nil(t, handle(NodeName(2), accept(1)))
+	require.Nil(t, handle("GS", accept(2)))
+	require.Nil(t, handle(NodeName(5), accept(5)))
+	require.Nil(t, handle(NodeName(2), tlc(1)))
+	require.Nil(t, handle(NodeName(1), tlc(2)))
+	_, blocks := n.client.GetBlocks()
+	require.Empty(t, blocks)
+
+	// They are counted once gossiped by the participants themselves
+	require.Nil(t, handle(NodeName(1), tlc(1)))
+	committed := handle(NodeName(2), tlc(2))
+	require.NotNil(t, committed)
+	require.Equal(t, block.Hash(), committed.Hash())
+
+	for _, n := range nodes {
+		n.stop()
+	}
+}
+
+func TestConsensus_latencyOfProposedBlock(t *testing.T) {
+	var r latencyRecorder
+	r.propose(blk.BlockMappingStr, "pattern0")
+
+	// The blocks of other patterns or types do not end the measure
+	_, ok := r.commit(blk.BlockMappingStr, "pattern1")
+	require.False(t, ok)
+	_, ok = r.commit(blk.BlockPathStr, "pattern0")
+	require.False(t, ok)
+	require.Equal(t, 0, r.get().Count)
+
+	_, ok = r.commit(blk.BlockMappingStr, "pattern0")
+	require.True(t, ok)
+	_, ok = r.commit(blk.BlockMappingStr, "pattern0")
+	require.False(t, ok)
+	require.Equal(t, 1, r.get().Count)
+}
+
+func TestConsensus_storage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consensus")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Only the Paxos protocol persists its state, the others ignore the
+	// storage directory
+	for _, protocol := range []string{PaxosProtocol, MultiPaxosProtocol, RaftProtocol} {
+		client, err := NewConsensusClient(protocol, 3, 0, 1, nil, dir)
+		require.NoError(t, err, protocol)
+		client.Stop()
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "node0.wal")}, files)
+}
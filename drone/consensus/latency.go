@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LatencyStats summarizes the time elapsed between the proposal of a block
+// by a node and the commit of the block of the same type for the same pattern
+type LatencyStats struct {
+	Count int
+	Last  time.Duration
+	Mean  time.Duration
+	Max   time.Duration
+}
+
+func (s LatencyStats) String() string {
+	return fmt.Sprintf("mean %s, max %s over %d blocks", s.Mean, s.Max, s.Count)
+}
+
+type latencyRecorder struct {
+	mutex sync.Mutex
+	// proposed holds the time of the proposals waiting for their block, by
+	// block type and pattern
+	proposed map[string]time.Time
+	total    time.Duration
+	stats    LatencyStats
+}
+
+func latencyKey(blockType, patternID string) string {
+	return blockType + "/" + patternID
+}
+
+// propose starts the measure for the block of the given type for the
+// pattern, unless a proposal of this block is already waiting
+func (r *latencyRecorder) propose(blockType, patternID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.proposed == nil {
+		r.proposed = make(map[string]time.Time)
+	}
+	key := latencyKey(blockType, patternID)
+	if _, found := r.proposed[key]; !found {
+		r.proposed[key] = time.Now()
+	}
+}
+
+// commit ends the measure of the block and returns the latency, if it was
+// proposed by the node
+func (r *latencyRecorder) commit(blockType, patternID string) (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := latencyKey(blockType, patternID)
+	proposed, found := r.proposed[key]
+	if !found {
+		return 0, false
+	}
+
+	latency := time.Since(proposed)
+	delete(r.proposed, key)
+	r.total += latency
+	r.stats.Count++
+	r.stats.Last = latency
+	r.stats.Mean = r.total / time.Duration(r.stats.Count)
+	if latency > r.stats.Max {
+		r.stats.Max = latency
+	}
+	return latency, true
+}
+
+func (r *latencyRecorder) get() LatencyStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
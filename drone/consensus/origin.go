@@ -0,0 +1,63 @@
+package consensus
+
+import (
+	"fmt"
+
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+)
+
+// NodeName returns the name of the gossiper of the node nodeIndex, against
+// which the origin of the consensus messages is checked
+func NodeName(nodeIndex int) string {
+	return fmt.Sprintf("drone%d", nodeIndex)
+}
+
+// checkOrigin returns an error if the message claims to be sent by a
+// participant which is not its origin. The index itself is bounded by the
+// protocols.
+func checkOrigin(numParticipant int, origin string, msg *extramessage.ExtraMessage) error {
+	sender, found := sender(msg)
+	if !found || sender < 0 || sender >= numParticipant {
+		return nil
+	}
+	if origin != NodeName(sender) {
+		return fmt.Errorf("message of participant %d sent by %s", sender, origin)
+	}
+	return nil
+}
+
+// sender returns the index of the node the message claims to be sent by, if
+// it names one
+func sender(msg *extramessage.ExtraMessage) (int, bool) {
+	switch {
+	case msg.PaxosPromise != nil:
+		return msg.PaxosPromise.Acceptor, true
+	case msg.PaxosAccept != nil:
+		return msg.PaxosAccept.Acceptor, true
+	case msg.PaxosTLC != nil:
+		return msg.PaxosTLC.Node, true
+	case msg.PaxosSyncRequest != nil:
+		return msg.PaxosSyncRequest.Node, true
+	case msg.PaxosSyncReply != nil:
+		return msg.PaxosSyncReply.Node, true
+	case msg.MultiPaxosPrepare != nil:
+		return msg.MultiPaxosPrepare.Candidate, true
+	case msg.MultiPaxosPromise != nil:
+		return msg.MultiPaxosPromise.Acceptor, true
+	case msg.MultiPaxosAccept != nil:
+		return msg.MultiPaxosAccept.Acceptor, true
+	case msg.MultiPaxosHeartbeat != nil:
+		return msg.MultiPaxosHeartbeat.Leader, true
+	case msg.RaftRequestVote != nil:
+		return msg.RaftRequestVote.Candidate, true
+	case msg.RaftVote != nil:
+		return msg.RaftVote.Voter, true
+	case msg.RaftAppendEntries != nil:
+		return msg.RaftAppendEntries.Leader, true
+	case msg.RaftAppendResponse != nil:
+		return msg.RaftAppendResponse.Follower, true
+	case msg.RaftInstallSnapshot != nil:
+		return msg.RaftInstallSnapshot.Leader, true
+	}
+	return 0, false
+}
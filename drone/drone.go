@@ -3,14 +3,20 @@
 package drone
 
 import (
+	"context"
+	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
 
 	"go.dedis.ch/cs438/orbitalswarm/drone/consensus"
 	"go.dedis.ch/cs438/orbitalswarm/drone/mapping"
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
 	"go.dedis.ch/cs438/orbitalswarm/pathgenerator"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
@@ -18,6 +24,14 @@ import (
 	"go.dedis.ch/onet/v3/log"
 )
 
+// groundStation is the identifier of the ground station, the only node whose
+// commands are followed
+const groundStation = "GS"
+
+// commandMaxAge is the maximal age of a command followed by the drones, and
+// how far in the future its time may be, as the clocks may drift
+const commandMaxAge = time.Minute
+
 type state int
 
 const (
@@ -32,36 +46,71 @@ type Drone struct {
 	droneID uint32
 	status  state
 
-	position r3.Vec
-	target   r3.Vec
-	path     []r3.Vec
+	position   r3.Vec
+	target     r3.Vec
+	path       []r3.Vec
+	trajectory *trajectory.Trajectory
+
+	// limits of every drone of the swarm, indexed by drone ID, and the velocity profile they fly
+	limits  []trajectory.Limits
+	profile string
+	// safetyRadius is the minimal distance the drones keep while flying
+	safetyRadius float64
 
 	gossiper        *gossip.Gossiper
 	consensusClient consensus.ConsensusClient
 	targetsMapper   mapping.TargetsMapper
 	pathGenerator   pathgenerator.PathGenerator
 	simulator       *simulator
+	environment     *environment.Environment
+
+	// patternID is the pattern currently handled, cancelPattern aborts its path generation
+	patternID     string
+	cancelPattern context.CancelFunc
+	muxPattern    sync.Mutex
+	// lastCommand is the sequence of the last command followed, persisted in
+	// the state file if not empty
+	lastCommand uint64
+	statePath   string
 
 	muxFly sync.Mutex
 }
 
-func NewDrone(droneID uint32, g *gossip.Gossiper, addresses []string, position r3.Vec, targetsMapper mapping.TargetsMapper, consensusClient consensus.ConsensusClient, pathGenerator pathgenerator.PathGenerator) *Drone {
+// NewDrone creates a drone. The sequence of the last command it followed is
+// persisted in the state file, if not empty, so that a restarted drone does
+// not follow a replayed command.
+func NewDrone(droneID uint32, g *gossip.Gossiper, addresses []string, position r3.Vec, targetsMapper mapping.TargetsMapper, consensusClient consensus.ConsensusClient, pathGenerator pathgenerator.PathGenerator, env *environment.Environment, limits []trajectory.Limits, profile string, safetyRadius float64, statePath string) (*Drone, error) {
+	if env == nil {
+		env = environment.Empty()
+	}
+	lastCommand, err := loadLastCommand(statePath)
+	if err != nil {
+		return nil, err
+	}
 
 	d := &Drone{
 		droneID: droneID,
 		status:  IDLE,
 
 		position: position,
+		limits:   limits,
+		profile:  profile,
+
+		safetyRadius: safetyRadius,
 
 		gossiper:        g,
 		consensusClient: consensusClient,
 		targetsMapper:   targetsMapper,
 		pathGenerator:   pathGenerator,
+		environment:     env,
+
+		lastCommand: lastCommand,
+		statePath:   statePath,
 	}
 	g.AddAddresses(addresses...)
 
 	g.RegisterCallback(d.HandleGossipMessage)
-	return d
+	return d, nil
 }
 
 // Run ...
@@ -69,12 +118,23 @@ func (d *Drone) Run() {
 	d.simulator = NewSimulator(d)
 }
 
+// Stop aborts the pattern being prepared by the drone and its consensus
+func (d *Drone) Stop() {
+	d.muxPattern.Lock()
+	if d.cancelPattern != nil {
+		d.cancelPattern()
+	}
+	d.muxPattern.Unlock()
+	d.pathGenerator.Stop()
+	d.consensusClient.Stop()
+}
+
 // UpdateLocation of the drone
 func (d *Drone) UpdateLocation(location r3.Vec) {
 	d.gossiper.AddPrivateMessage(gossip.PrivateMessageData{
 		Location: location,
 		DroneID:  d.droneID,
-	}, "GS", d.gossiper.GetIdentifier(), 10)
+	}, groundStation, d.gossiper.GetIdentifier(), 10)
 	d.position = location
 }
 
@@ -83,31 +143,31 @@ func (d *Drone) HandleGossipMessage(origin string, msg gossip.GossipPacket) {
 
 	if msg.Rumor != nil {
 		if msg.Rumor.Extra != nil {
-			if msg.Rumor.Extra.SwarmInit != nil && d.status == IDLE {
-				d.status = READY
-
-				if d.consensusClient.IsProposer() {
-					go func() {
-						patternID := msg.Rumor.Extra.SwarmInit.PatternID
-						dronePos := msg.Rumor.Extra.SwarmInit.InitialPos
-
-						targets := d.mapTarget(patternID, dronePos, msg.Rumor.Extra.SwarmInit.TargetPos)
-
-						d.generatePaths(patternID, dronePos, targets)
-
-						d.fly()
-					}()
+			if msg.Rumor.Extra.SwarmInit != nil {
+				if origin != groundStation {
+					log.Printf("%s Reject pattern %s from %s", d.gossiper.GetIdentifier(), msg.Rumor.Extra.SwarmInit.PatternID, origin)
+					return
+				}
+				d.handleSwarmInit(msg.Rumor.Extra.SwarmInit)
+			} else if msg.Rumor.Extra.AuctionBid != nil {
+				if mapper, ok := d.targetsMapper.(mapping.DistributedMapper); ok {
+					mapper.HandleBid(msg.Rumor.Extra.AuctionBid)
 				}
 			} else {
 				// log.Printf("Handle")
-				blockContainer := d.consensusClient.HandleExtraMessage(d.gossiper, msg.Rumor.Extra)
+				blockContainer := d.consensusClient.HandleExtraMessage(d.gossiper, origin, msg.Rumor.Extra)
 
 				if blockContainer != nil {
-					if blockContainer.Type == blk.BlockPathStr {
+					if blockContainer.Type == blk.BlockMappingStr {
+						// Every drone, proposer or not, takes its target from the agreed mapping
+						d.setTarget(blockContainer.GetContent().(*blk.MappingBlockContent))
+					} else if blockContainer.Type == blk.BlockPathStr {
 						blockContent := blockContainer.GetContent().(*blk.PathBlockContent)
 
-						d.path = blockContent.Paths[d.droneID]
-						go d.fly()
+						// The paths of an aborted pattern are not flown
+						if d.setPath(blockContent) {
+							go d.fly()
+						}
 					}
 				}
 			}
@@ -115,7 +175,73 @@ func (d *Drone) HandleGossipMessage(origin string, msg gossip.GossipPacket) {
 	}
 }
 
+// handleSwarmInit starts the handling of a new pattern. A newer pattern
+// received while the previous one is still being mapped or generated aborts it.
+// Patterns with targets in a no-fly zone are rejected.
+func (d *Drone) handleSwarmInit(swarmInit *extramessage.SwarmInit) {
+	for _, target := range swarmInit.TargetPos {
+		if !d.environment.Allowed(target) {
+			log.Printf("%s Reject pattern %s: target %v in a no-fly zone", d.gossiper.GetIdentifier(), swarmInit.PatternID, target)
+			return
+		}
+	}
+
+	d.muxPattern.Lock()
+	defer d.muxPattern.Unlock()
+
+	if err := d.checkCommand(swarmInit); err != nil {
+		log.Printf("%s Reject pattern %s: %v", d.gossiper.GetIdentifier(), swarmInit.PatternID, err)
+		return
+	}
+
+	switch d.status {
+	case IDLE:
+	case READY, MAPPING, GENERATING_PATH:
+		if swarmInit.PatternID == d.patternID {
+			return
+		}
+		log.Printf("%s Abort pattern %s for pattern %s", d.gossiper.GetIdentifier(), d.patternID, swarmInit.PatternID)
+		if d.cancelPattern != nil {
+			d.cancelPattern()
+		}
+	default:
+		return
+	}
+
+	// The command is persisted before being followed, never to be followed again
+	if err := saveLastCommand(d.statePath, swarmInit.Sequence); err != nil {
+		log.Printf("%s Reject pattern %s: failed to persist command %d: %v", d.gossiper.GetIdentifier(), swarmInit.PatternID, swarmInit.Sequence, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.lastCommand = swarmInit.Sequence
+	d.status = READY
+	d.patternID = swarmInit.PatternID
+	d.cancelPattern = cancel
+
+	if d.consensusClient.IsProposer() {
+		go func() {
+			patternID := swarmInit.PatternID
+			dronePos := swarmInit.InitialPos
+
+			targets := d.mapTarget(ctx, patternID, dronePos, swarmInit.TargetPos)
+			if targets == nil {
+				return
+			}
+
+			if d.generatePaths(ctx, patternID, dronePos, targets) {
+				d.fly()
+			}
+		}()
+	} else if _, ok := d.targetsMapper.(mapping.DistributedMapper); ok {
+		// Every drone bids for its own target
+		go d.mapTarget(ctx, swarmInit.PatternID, swarmInit.InitialPos, swarmInit.TargetPos)
+	}
+}
+
 func (d *Drone) GetTarget() r3.Vec {
+	d.muxPattern.Lock()
+	defer d.muxPattern.Unlock()
 	return d.target
 }
 
@@ -123,41 +249,174 @@ func (d *Drone) GetDroneID() uint32 {
 	return d.droneID
 }
 
-func (d *Drone) mapTarget(patternID string, initialPos, targetsPos []r3.Vec) []r3.Vec {
+// checkCommand returns an error if the command follows the last command
+// followed or is not fresh
+func (d *Drone) checkCommand(swarmInit *extramessage.SwarmInit) error {
+	if swarmInit.Sequence <= d.lastCommand {
+		return fmt.Errorf("command %d does not follow command %d", swarmInit.Sequence, d.lastCommand)
+	}
+	age := time.Since(time.Unix(0, swarmInit.Time))
+	if age > commandMaxAge || age < -commandMaxAge {
+		return fmt.Errorf("command %d sent %s ago", swarmInit.Sequence, age.Round(time.Second))
+	}
+	return nil
+}
+
+// setStatus sets the status of the drone, unless the pattern of the context
+// was aborted, and returns whether it did
+func (d *Drone) setStatus(ctx context.Context, status state) bool {
+	d.muxPattern.Lock()
+	defer d.muxPattern.Unlock()
+	if ctx.Err() != nil {
+		return false
+	}
+	d.status = status
+	return true
+}
+
+// mapTarget maps the drones to the targets and returns the mapping agreed by
+// the swarm, nil if the pattern is aborted meanwhile or the drone does not
+// take part in the agreement
+func (d *Drone) mapTarget(ctx context.Context, patternID string, initialPos, targetsPos []r3.Vec) []r3.Vec {
 	log.Printf("%s Swarm init received", d.gossiper.GetIdentifier())
 	//Begin mapping phase
-	d.status = MAPPING
+	if !d.setStatus(ctx, MAPPING) {
+		return nil
+	}
 	log.Printf("%s Start mapping", d.gossiper.GetIdentifier())
-	target := d.targetsMapper.MapTargets(initialPos, targetsPos)
-	targets := target
-	// targets := d.consensusClient.ProposeTargets(d.gossiper, patternID, target)
-	d.target = targets[d.droneID]
+	target, idle := d.targetsMapper.MapTargets(initialPos, targetsPos)
+	if len(idle) > 0 {
+		log.Printf("%s Drones %v stay idle for pattern %s", d.gossiper.GetIdentifier(), idle, patternID)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// The paths are generated from the mapping agreed by the swarm, which may
+	// be the one of another proposer
+	log.Printf("%s Propose mapping", d.gossiper.GetIdentifier())
+	agreed := make(chan []r3.Vec, 1)
+	go func() {
+		agreed <- d.consensusClient.ProposeTargets(d.gossiper, patternID, target)
+	}()
+	var targets []r3.Vec
+	select {
+	case targets = <-agreed:
+	case <-ctx.Done():
+		// A newer pattern took over
+		return nil
+	}
+	// The target is set once the mapping block is committed, nil if the
+	// drone is not a proposer
 	return targets
 }
 
-func (d *Drone) generatePaths(patternID string, dronePos, targets []r3.Vec) {
-	d.status = GENERATING_PATH
+// setTarget sets the target of the drone from the agreed mapping of the
+// current pattern
+func (d *Drone) setTarget(content *blk.MappingBlockContent) {
+	d.muxPattern.Lock()
+	defer d.muxPattern.Unlock()
+	if content.PatternID == d.patternID && int(d.droneID) < len(content.Targets) {
+		d.target = content.Targets[d.droneID]
+	}
+}
+
+func (d *Drone) generatePaths(ctx context.Context, patternID string, dronePos, targets []r3.Vec) bool {
+	if !d.setStatus(ctx, GENERATING_PATH) {
+		return false
+	}
 	log.Printf("%s Generate path", d.gossiper.GetIdentifier())
-	chanPath := d.pathGenerator.GeneratePath(dronePos, targets)
+	chanPath := d.pathGenerator.GeneratePathContext(ctx, dronePos, targets)
 	pathsGenerated := <-chanPath
+	if pathsGenerated == nil {
+		if ctx.Err() != nil {
+			// A newer pattern took over
+			return false
+		}
+		log.Printf("%s No valid path generated for pattern %s", d.gossiper.GetIdentifier(), patternID)
+		d.setStatus(ctx, IDLE)
+		return false
+	}
+	trajectories, err := pathgenerator.GenerateTrajectories(dronePos, pathsGenerated, d.limits, d.profile, d.safetyRadius)
+	if err != nil {
+		// Drones fall back to the discrete paths
+		log.Printf("%s No trajectories for pattern %s: %s", d.gossiper.GetIdentifier(), patternID, err)
+	}
+
 	log.Printf("%s Propose path", d.gossiper.GetIdentifier())
-	paths := d.consensusClient.ProposePaths(d.gossiper, patternID, pathsGenerated)
-	d.path = paths[d.droneID]
+	agreed := make(chan *blk.PathBlockContent, 1)
+	go func() {
+		agreed <- d.consensusClient.ProposePaths(d.gossiper, patternID, pathsGenerated, trajectories)
+	}()
+	select {
+	case content := <-agreed:
+		return content != nil && d.setPath(content)
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// setPath sets the path and, if any, the trajectory of the drone from the
+// agreed block, and returns whether the block is the one of the current
+// pattern
+func (d *Drone) setPath(content *blk.PathBlockContent) bool {
+	d.muxPattern.Lock()
+	defer d.muxPattern.Unlock()
+
+	if content.PatternID != d.patternID || int(d.droneID) >= len(content.Paths) {
+		return false
+	}
+	d.path = content.Paths[d.droneID]
+	d.trajectory = nil
+	if int(d.droneID) < len(content.Trajectories) {
+		t := content.Trajectories[d.droneID]
+		d.trajectory = &t
+	}
+	return true
 }
 
 func (d *Drone) fly() {
 	d.muxFly.Lock()
 	defer d.muxFly.Unlock()
 
-	if d.status != IDLE && d.status != MOVING {
+	d.muxPattern.Lock()
+	if d.status == IDLE || d.status == MOVING {
+		d.muxPattern.Unlock()
+		return
+	}
+	d.status = MOVING
+	path := d.path
+	traj := d.trajectory
+	d.muxPattern.Unlock()
+
+	if parked(path) {
+		// Idle drone, nothing to fly
+		log.Printf("%s Parked", d.gossiper.GetIdentifier())
+	} else {
 		log.Printf(d.gossiper.GetIdentifier() + "Start simulation")
-		d.status = MOVING
-		done := d.simulator.launchSimulation(1, 4, d.position, d.path)
+		var done <-chan struct{}
+		if traj != nil {
+			done = d.simulator.launchTrajectory(*traj, 4)
+		} else {
+			done = d.simulator.launchSimulation(1, 4, d.position, path)
+		}
 		<-done
 
 		log.Printf("Simulation ended")
-		d.gossiper.AddMessage(strconv.FormatUint(uint64(d.GetDroneID()), 10))
+	}
+	d.gossiper.AddMessage(strconv.FormatUint(uint64(d.GetDroneID()), 10))
+
+	d.muxPattern.Lock()
+	d.status = IDLE
+	d.muxPattern.Unlock()
+}
 
-		d.status = IDLE
+// parked returns true if the path keeps the drone at its location
+func parked(path []r3.Vec) bool {
+	for _, move := range path {
+		if move != (r3.Vec{}) {
+			return false
+		}
 	}
+	return true
 }
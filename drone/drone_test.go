@@ -0,0 +1,79 @@
+package drone
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestDrone_checkCommand(t *testing.T) {
+	d := &Drone{lastCommand: 3}
+
+	command := func(sequence uint64, sent time.Time) *extramessage.SwarmInit {
+		return &extramessage.SwarmInit{PatternID: "pattern", Sequence: sequence, Time: sent.UnixNano()}
+	}
+	require.NoError(t, d.checkCommand(command(4, time.Now())))
+
+	// A command replayed or older than the last one followed is rejected
+	require.Error(t, d.checkCommand(command(3, time.Now())))
+	require.Error(t, d.checkCommand(command(2, time.Now())))
+
+	// So is a command delivered too late, or from the future
+	require.Error(t, d.checkCommand(command(4, time.Now().Add(-2*commandMaxAge))))
+	require.Error(t, d.checkCommand(command(4, time.Now().Add(2*commandMaxAge))))
+	require.Error(t, d.checkCommand(&extramessage.SwarmInit{PatternID: "pattern", Sequence: 4}))
+}
+
+func TestDrone_persistedCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "drone")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "drone0.json")
+
+	sequence, err := loadLastCommand(path)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), sequence)
+
+	// A restarted drone rejects the commands it already followed
+	require.NoError(t, saveLastCommand(path, 5))
+	sequence, err = loadLastCommand(path)
+	require.NoError(t, err)
+	d := &Drone{lastCommand: sequence}
+	replayed := &extramessage.SwarmInit{PatternID: "pattern", Sequence: 5, Time: time.Now().UnixNano()}
+	require.Error(t, d.checkCommand(replayed))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0644))
+	_, err = loadLastCommand(path)
+	require.Error(t, err)
+}
+
+func TestDrone_abortedPattern(t *testing.T) {
+	d := &Drone{droneID: 1, patternID: "pattern2", status: READY}
+	targets := []r3.Vec{{X: 1}, {X: 2}}
+	paths := [][]r3.Vec{{{X: 1}}, {{Y: 1}}}
+
+	// The blocks of an aborted pattern are ignored
+	d.setTarget(&blk.MappingBlockContent{PatternID: "pattern1", Targets: targets})
+	require.Equal(t, r3.Vec{}, d.GetTarget())
+	require.False(t, d.setPath(&blk.PathBlockContent{PatternID: "pattern1", Paths: paths}))
+	require.Nil(t, d.path)
+
+	d.setTarget(&blk.MappingBlockContent{PatternID: "pattern2", Targets: targets})
+	require.Equal(t, targets[1], d.GetTarget())
+	require.True(t, d.setPath(&blk.PathBlockContent{PatternID: "pattern2", Paths: paths}))
+	require.Equal(t, paths[1], d.path)
+
+	// As is the status set by the goroutine of an aborted pattern
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.False(t, d.setStatus(ctx, MAPPING))
+	require.Equal(t, READY, d.status)
+}
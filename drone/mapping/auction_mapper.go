@@ -0,0 +1,157 @@
+package mapping
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// https://web.mit.edu/dimitrib/www/Auction_Survey.pdf
+
+const (
+	// defaultEpsilon is the final bidding increment of the auctions, the
+	// total cost of the assignment is within n times epsilon of the optimum
+	defaultEpsilon = 1e-4
+	// epsilonScaling divides the bidding increment between two phases
+	epsilonScaling = 4
+)
+
+type auctionMapper struct {
+	cost    CostFunction
+	epsilon float64
+}
+
+// NewAuctionMapper creates a mapper minimizing the sum of the distances with
+// an auction where the drones bid for the targets
+func NewAuctionMapper() *auctionMapper {
+	return NewAuctionMapperWithCost(EuclideanCost)
+}
+
+// NewAuctionMapperWithCost creates an auction mapper minimizing the sum of the given cost
+func NewAuctionMapperWithCost(cost CostFunction) *auctionMapper {
+	return &auctionMapper{
+		cost:    cost,
+		epsilon: defaultEpsilon,
+	}
+}
+
+func (m *auctionMapper) MapTargets(initials []r3.Vec, targets []r3.Vec) ([]r3.Vec, []int) {
+	if len(initials) == 0 || len(targets) == 0 {
+		return idleAssignment(initials)
+	}
+
+	costs := costMatrix(m.cost, initials, targets, true)
+	assignment := m.auction(costs)
+
+	return decodeAuction(initials, targets, assignment)
+}
+
+// auction runs the forward auction on the square cost matrix and returns the
+// target of each row. The bidding increment is scaled down between phases,
+// the prices of a phase being kept as a starting point for the next one.
+func (m *auctionMapper) auction(costs [][]float64) []int {
+	n := len(costs)
+	maxCost := 0.0
+	for _, row := range costs {
+		for _, c := range row {
+			maxCost = math.Max(maxCost, math.Abs(c))
+		}
+	}
+
+	prices := make([]float64, n)
+	assignment := make([]int, n)
+	owners := make([]int, n)
+	epsilon := math.Max(maxCost/epsilonScaling, m.epsilon)
+	for {
+		for i := range assignment {
+			assignment[i] = -1
+			owners[i] = -1
+		}
+		unassigned := make([]int, n)
+		for i := range unassigned {
+			unassigned[i] = i
+		}
+
+		for len(unassigned) > 0 {
+			i := unassigned[len(unassigned)-1]
+			unassigned = unassigned[:len(unassigned)-1]
+
+			target, price := bid(costs[i], prices, epsilon)
+			prices[target] = price
+			if owners[target] >= 0 {
+				assignment[owners[target]] = -1
+				unassigned = append(unassigned, owners[target])
+			}
+			owners[target] = i
+			assignment[i] = target
+		}
+
+		if epsilon <= m.epsilon {
+			return assignment
+		}
+		epsilon = math.Max(epsilon/epsilonScaling, m.epsilon)
+	}
+}
+
+// bid returns the target with the highest value for a drone, the value being
+// the opposite of the cost minus the price, and the price the drone offers:
+// it raises the price until the target is only epsilon better than the second best one.
+func bid(costs []float64, prices []float64, epsilon float64) (int, float64) {
+	best, second := math.Inf(-1), math.Inf(-1)
+	target := 0
+	for j, c := range costs {
+		value := -c - prices[j]
+		if value > best {
+			best, second = value, best
+			target = j
+		} else if value > second {
+			second = value
+		}
+	}
+	if math.IsInf(second, -1) {
+		second = best
+	}
+	return target, prices[target] + best - second + epsilon
+}
+
+// costMatrix returns the costs of each drone for each target. If there are
+// fewer targets than drones, idle targets with a null cost are added. If
+// square is set, idle drones are added when there are fewer drones.
+func costMatrix(cost CostFunction, initials []r3.Vec, targets []r3.Vec, square bool) [][]float64 {
+	columns := len(targets)
+	if len(initials) > columns {
+		columns = len(initials)
+	}
+	rows := len(initials)
+	if square {
+		rows = columns
+	}
+
+	costs := make([][]float64, rows)
+	for i := range costs {
+		costs[i] = make([]float64, columns)
+		if i >= len(initials) {
+			continue
+		}
+		for j, target := range targets {
+			costs[i][j] = cost(i, initials[i], target)
+		}
+	}
+	return costs
+}
+
+// decodeAuction returns the target of each drone and the drones that got an
+// idle target, which keep their initial location as target
+func decodeAuction(initials []r3.Vec, targets []r3.Vec, assignment []int) ([]r3.Vec, []int) {
+	res := make([]r3.Vec, len(initials))
+	idle := make([]int, 0)
+	for i := range initials {
+		if assignment[i] < len(targets) {
+			res[i] = targets[assignment[i]]
+		} else {
+			res[i] = initials[i]
+			idle = append(idle, i)
+		}
+	}
+	return res, idle
+}
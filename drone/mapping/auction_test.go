@@ -0,0 +1,214 @@
+package mapping
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// randomPattern returns n drones on a grid and n random targets
+func randomPattern(rng *rand.Rand, n int) ([]r3.Vec, []r3.Vec) {
+	initials := make([]r3.Vec, n)
+	targets := make([]r3.Vec, n)
+	for i := range initials {
+		initials[i] = r3.Vec{X: float64(2 * (i % 10)), Y: 0, Z: float64(2 * (i / 10))}
+		targets[i] = r3.Vec{X: float64(rng.Intn(30)), Y: float64(rng.Intn(10)), Z: float64(rng.Intn(30))}
+	}
+	return initials, targets
+}
+
+func totalCost(initials []r3.Vec, targets []r3.Vec) float64 {
+	total := 0.0
+	for i := range initials {
+		total += EuclideanCost(i, initials[i], targets[i])
+	}
+	return total
+}
+
+func TestBid(t *testing.T) {
+	costs := []float64{3, 1, 2}
+	prices := []float64{0, 0, 0}
+
+	target, price := bid(costs, prices, 0.5)
+	require.Equal(t, 1, target)
+	require.Equal(t, 1.5, price)
+
+	prices[1] = 3
+	target, price = bid(costs, prices, 0.5)
+	require.Equal(t, 2, target)
+	require.Equal(t, 1.5, price)
+}
+
+func TestAuctionMapTargets(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 5, 30} {
+		initials, targets := randomPattern(rng, n)
+
+		hungarian, _ := NewHungarianMapperWithCost(EuclideanCost).MapTargets(initials, targets)
+		auction, idle := NewAuctionMapper().MapTargets(initials, targets)
+
+		require.Empty(t, idle)
+		require.ElementsMatch(t, targets, auction)
+		require.InDelta(t, totalCost(initials, hungarian), totalCost(initials, auction), float64(n)*defaultEpsilon)
+	}
+}
+
+func TestAuctionMapTargets_unequal(t *testing.T) {
+	initials := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+		r3.Vec{X: 4, Y: 0, Z: 0},
+	}
+	targets := []r3.Vec{
+		r3.Vec{X: 4, Y: 1, Z: 0},
+		r3.Vec{X: 0, Y: 1, Z: 0},
+	}
+
+	res, idle := NewAuctionMapper().MapTargets(initials, targets)
+	require.Equal(t, []r3.Vec{targets[1], initials[1], targets[0]}, res)
+	require.Equal(t, []int{1}, idle)
+
+	res, idle = NewAuctionMapper().MapTargets(targets, initials)
+	require.Equal(t, []r3.Vec{initials[2], initials[0]}, res)
+	require.Empty(t, idle)
+}
+
+func TestDistributedAuctionMapTargets(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	n := 12
+	initials, targets := randomPattern(rng, n)
+	// Fewer targets than drones
+	targets = targets[:n-2]
+
+	mappers := make([]*DistributedAuctionMapper, n)
+	for i := range mappers {
+		i := i
+		mappers[i] = NewDistributedAuctionMapper(i, EuclideanCost, func(bid *extramessage.AuctionBid) {
+			for j, m := range mappers {
+				if j != i {
+					go m.HandleBid(bid)
+				}
+			}
+		})
+		mappers[i].settle = defaultSettle / 5
+	}
+
+	results := make([][]r3.Vec, n)
+	wait := sync.WaitGroup{}
+	for i, m := range mappers {
+		wait.Add(1)
+		go func(i int, m *DistributedAuctionMapper) {
+			defer wait.Done()
+			results[i], _ = m.MapTargets(initials, targets)
+		}(i, m)
+	}
+	wait.Wait()
+
+	central, _ := NewAuctionMapper().MapTargets(initials, targets)
+	for _, res := range results {
+		require.Equal(t, results[0], res)
+	}
+	// A fixed increment only guarantees an assignment within n*epsilon of the optimum
+	require.InDelta(t, totalCost(initials, central), totalCost(initials, results[0]), float64(n)*defaultDistributedEpsilon)
+}
+
+func TestDistributedAuctionMapTargets_invalidBids(t *testing.T) {
+	initials, targets := randomPattern(rand.New(rand.NewSource(4)), 3)
+	auction := auctionID(initials, targets)
+
+	mappers := make([]*DistributedAuctionMapper, 3)
+	for i := range mappers {
+		i := i
+		mappers[i] = NewDistributedAuctionMapper(i, EuclideanCost, func(bid *extramessage.AuctionBid) {
+			for j, m := range mappers {
+				if j != i {
+					go m.HandleBid(bid)
+				}
+			}
+		})
+		mappers[i].settle = defaultSettle / 5
+	}
+
+	// Bids for drones and targets outside of the auction, received before
+	// and during the auction, are ignored
+	invalid := []*extramessage.AuctionBid{
+		{AuctionID: auction, Drone: 3, Target: 0, Price: 100},
+		{AuctionID: auction, Drone: -1, Target: 1, Price: 100},
+		{AuctionID: auction, Drone: 2, Target: 3, Price: 100},
+		{AuctionID: auction, Drone: 2, Target: -1, Price: 100},
+	}
+	for _, m := range mappers {
+		for _, bid := range invalid {
+			m.HandleBid(bid)
+		}
+	}
+
+	results := make([][]r3.Vec, 3)
+	wait := sync.WaitGroup{}
+	for i, m := range mappers {
+		wait.Add(1)
+		go func(i int, m *DistributedAuctionMapper) {
+			defer wait.Done()
+			for _, bid := range invalid {
+				m.HandleBid(bid)
+			}
+			results[i], _ = m.MapTargets(initials, targets)
+		}(i, m)
+	}
+	wait.Wait()
+
+	central, _ := NewAuctionMapper().MapTargets(initials, targets)
+	for _, res := range results {
+		require.Equal(t, central, res)
+	}
+
+	// The settled auctions are dropped
+	for _, m := range mappers {
+		m.mutex.Lock()
+		for _, state := range m.auctions {
+			require.Zero(t, state.drones)
+		}
+		m.mutex.Unlock()
+	}
+
+	// As are the bids of the auctions never joined once they time out
+	m := NewDistributedAuctionMapper(0, EuclideanCost, func(bid *extramessage.AuctionBid) {})
+	m.HandleBid(&extramessage.AuctionBid{AuctionID: "other"})
+	require.Len(t, m.auctions, 1)
+	m.timeout = 0
+	m.HandleBid(&extramessage.AuctionBid{AuctionID: auction})
+	require.Len(t, m.auctions, 1)
+	require.Contains(t, m.auctions, auction)
+}
+
+func benchmarkMapTargets(b *testing.B, mapper TargetsMapper, n int) {
+	initials, targets := randomPattern(rand.New(rand.NewSource(3)), n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mapper.MapTargets(initials, targets)
+	}
+}
+
+func BenchmarkHungarianMapTargets_50(b *testing.B) {
+	benchmarkMapTargets(b, NewHungarianMapperWithCost(EuclideanCost), 50)
+}
+
+func BenchmarkAuctionMapTargets_50(b *testing.B) {
+	benchmarkMapTargets(b, NewAuctionMapper(), 50)
+}
+
+func BenchmarkHungarianMapTargets_200(b *testing.B) {
+	benchmarkMapTargets(b, NewHungarianMapperWithCost(EuclideanCost), 200)
+}
+
+func BenchmarkAuctionMapTargets_200(b *testing.B) {
+	benchmarkMapTargets(b, NewAuctionMapper(), 200)
+}
+
+func BenchmarkBottleneckMapTargets_200(b *testing.B) {
+	benchmarkMapTargets(b, NewBottleneckMapper(), 200)
+}
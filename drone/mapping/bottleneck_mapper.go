@@ -0,0 +1,118 @@
+package mapping
+
+import (
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// https://en.wikipedia.org/wiki/Bottleneck_assignment_problem
+
+type bottleneckMapper struct {
+	cost      CostFunction
+	hungarian *hungarianMapper
+}
+
+// NewBottleneckMapper creates a mapper minimizing the longest distance flown
+// by a drone, ties are broken by the total distance
+func NewBottleneckMapper() *bottleneckMapper {
+	return NewBottleneckMapperWithCost(EuclideanCost)
+}
+
+// NewBottleneckMapperWithCost creates a mapper minimizing the highest cost of
+// a drone, ties are broken by the sum of the costs
+func NewBottleneckMapperWithCost(cost CostFunction) *bottleneckMapper {
+	return &bottleneckMapper{
+		cost:      cost,
+		hungarian: NewHungarianMapperWithCost(cost),
+	}
+}
+
+func (m *bottleneckMapper) MapTargets(initials []r3.Vec, targets []r3.Vec) ([]r3.Vec, []int) {
+	if len(initials) == 0 || len(targets) == 0 {
+		return idleAssignment(initials)
+	}
+
+	matrix := m.hungarian.initMatrix(initials, targets)
+	threshold := m.findThreshold(matrix)
+	mask := m.hungarian.computeAssignment(m.restrictMatrix(matrix, threshold))
+
+	return m.hungarian.decodeAssignement(initials, targets, mask)
+}
+
+// findThreshold returns the lowest cost such that every drone can be given a
+// target costing at most this value, using a binary search on the costs
+func (m *bottleneckMapper) findThreshold(matrix *mat.Dense) float64 {
+	r, c := matrix.Dims()
+	costs := make([]float64, 0, r*c)
+	for i := 0; i < r; i++ {
+		costs = append(costs, matrix.RawRowView(i)...)
+	}
+	sort.Float64s(costs)
+
+	low, high := 0, len(costs)-1
+	for low < high {
+		mid := (low + high) / 2
+		if m.hasPerfectMatching(matrix, costs[mid]) {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	if len(costs) == 0 {
+		return 0
+	}
+	return costs[low]
+}
+
+// hasPerfectMatching returns true if every drone can be given its own target
+// costing at most threshold, using augmenting paths
+func (m *bottleneckMapper) hasPerfectMatching(matrix *mat.Dense, threshold float64) bool {
+	r, c := matrix.Dims()
+	matchOfTarget := make([]int, c)
+	for j := range matchOfTarget {
+		matchOfTarget[j] = -1
+	}
+
+	var augment func(i int, visited []bool) bool
+	augment = func(i int, visited []bool) bool {
+		for j := 0; j < c; j++ {
+			if matrix.At(i, j) > threshold || visited[j] {
+				continue
+			}
+			visited[j] = true
+			if matchOfTarget[j] < 0 || augment(matchOfTarget[j], visited) {
+				matchOfTarget[j] = i
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := 0; i < r; i++ {
+		if !augment(i, make([]bool, c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// restrictMatrix returns a copy of the matrix where the costs above the
+// threshold are higher than the sum of any assignment within the threshold
+func (m *bottleneckMapper) restrictMatrix(matrix *mat.Dense, threshold float64) *mat.Dense {
+	r, c := matrix.Dims()
+	forbidden := float64(r)*threshold + 1
+
+	restricted := mat.NewDense(r, c, nil)
+	for i := 0; i < r; i++ {
+		for j := 0; j < c; j++ {
+			cost := matrix.At(i, j)
+			if cost > threshold {
+				cost = forbidden
+			}
+			restricted.Set(i, j, cost)
+		}
+	}
+	return restricted
+}
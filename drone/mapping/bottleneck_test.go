@@ -0,0 +1,114 @@
+package mapping
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+
+	"github.com/stretchr/testify/require"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestHasPerfectMatching(t *testing.T) {
+	mapper := NewBottleneckMapper()
+	matrix := mat.NewDense(3, 3, []float64{
+		1, 2, 3,
+		2, 4, 6,
+		3, 6, 9,
+	})
+
+	require.True(t, !mapper.hasPerfectMatching(matrix, 2))
+	require.True(t, !mapper.hasPerfectMatching(matrix, 3))
+	require.True(t, mapper.hasPerfectMatching(matrix, 4))
+	require.True(t, mapper.hasPerfectMatching(matrix, 9))
+}
+
+func TestFindThreshold(t *testing.T) {
+	mapper := NewBottleneckMapper()
+
+	//TestCase 1
+	matrix := mat.NewDense(3, 3, []float64{
+		1, 2, 3,
+		2, 4, 6,
+		3, 6, 9,
+	})
+	require.Equal(t, 4.0, mapper.findThreshold(matrix))
+
+	//TestCase 2
+	matrix = mat.NewDense(3, 3, []float64{
+		108, 125, 150,
+		150, 135, 175,
+		122, 148, 250,
+	})
+	require.Equal(t, 150.0, mapper.findThreshold(matrix))
+}
+
+func TestRestrictMatrix(t *testing.T) {
+	mapper := NewBottleneckMapper()
+	matrix := mat.NewDense(2, 2, []float64{
+		0, 3,
+		3, 5,
+	})
+
+	expectedMatrix := mat.NewDense(2, 2, []float64{
+		0, 3,
+		3, 7,
+	})
+
+	restricted := mapper.restrictMatrix(matrix, 3)
+	require.True(t, mat.Equal(expectedMatrix, restricted))
+	// The cost matrix is left untouched
+	require.Equal(t, 5.0, matrix.At(1, 1))
+}
+
+func TestBottleneckMapTargets(t *testing.T) {
+	initialPos := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: -2, Y: 0, Z: -2},
+	}
+	targetPos := []r3.Vec{
+		r3.Vec{X: -1, Y: 0, Z: 1},
+		r3.Vec{X: -2, Y: 0, Z: 2},
+	}
+
+	// The total distance is minimal when the second drone flies 4 units
+	res, _ := NewHungarianMapperWithCost(EuclideanCost).MapTargets(initialPos, targetPos)
+	require.Equal(t, []r3.Vec{targetPos[0], targetPos[1]}, res)
+
+	// No drone flies more than sqrt(10) units otherwise
+	res, _ = NewBottleneckMapper().MapTargets(initialPos, targetPos)
+	require.Equal(t, []r3.Vec{targetPos[1], targetPos[0]}, res)
+}
+
+func TestBottleneckMapTargets_tie(t *testing.T) {
+	// Both assignments have a longest grid distance of 4, the one with the
+	// lowest total distance is chosen
+	initialPos := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 2},
+	}
+	targetPos := []r3.Vec{
+		r3.Vec{X: 1, Y: 0, Z: 0},
+		r3.Vec{X: 4, Y: 0, Z: 0},
+	}
+
+	res, _ := NewBottleneckMapperWithCost(ManhattanCost).MapTargets(initialPos, targetPos)
+	require.Equal(t, []r3.Vec{targetPos[0], targetPos[1]}, res)
+}
+
+func TestBottleneckMapTargets_swarm(t *testing.T) {
+	// Moving a grid of drones up keeps every drone above its location
+	initialPos := make([]r3.Vec, 0)
+	targetPos := make([]r3.Vec, 0)
+	for x := 0; x < 5; x++ {
+		for z := 0; z < 5; z++ {
+			initialPos = append(initialPos, r3.Vec{X: float64(2 * x), Y: 0, Z: float64(2 * z)})
+			targetPos = append(targetPos, r3.Vec{X: float64(2 * (4 - x)), Y: 5, Z: float64(2 * (4 - z))})
+		}
+	}
+
+	res, _ := NewBottleneckMapper().MapTargets(initialPos, targetPos)
+	for i, target := range res {
+		require.Equal(t, initialPos[i].Add(r3.Vec{X: 0, Y: 5, Z: 0}), target)
+	}
+}
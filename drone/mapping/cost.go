@@ -0,0 +1,47 @@
+package mapping
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// CostFunction returns the cost for the given drone to fly from its initial
+// location to a target
+type CostFunction func(drone int, initial, target r3.Vec) float64
+
+// FlooredEuclideanCost is the distance rounded down to the unit
+func FlooredEuclideanCost(drone int, initial, target r3.Vec) float64 {
+	return math.Floor(r3.Norm(target.Sub(initial)))
+}
+
+// EuclideanCost is the straight line distance, minimizing the total distance flown
+func EuclideanCost(drone int, initial, target r3.Vec) float64 {
+	return r3.Norm(target.Sub(initial))
+}
+
+// SquaredEuclideanCost penalizes long flights, which balances the effort
+// among the drones and lowers the longest flight
+func SquaredEuclideanCost(drone int, initial, target r3.Vec) float64 {
+	return r3.Norm2(target.Sub(initial))
+}
+
+// ManhattanCost is the number of unit moves on the grid, as flown by the
+// basic paths of the planners
+func ManhattanCost(drone int, initial, target r3.Vec) float64 {
+	d := target.Sub(initial)
+	return math.Abs(d.X) + math.Abs(d.Y) + math.Abs(d.Z)
+}
+
+// BatteryWeightedCost scales the cost of each drone by the inverse of its
+// battery level, between 0 and 1, such that drones running low are given
+// the closest targets. Drones without level are considered fully charged.
+func BatteryWeightedCost(levels []float64, cost CostFunction) CostFunction {
+	return func(drone int, initial, target r3.Vec) float64 {
+		level := 1.0
+		if drone < len(levels) {
+			level = math.Max(levels[drone], 0.01)
+		}
+		return cost(drone, initial, target) / level
+	}
+}
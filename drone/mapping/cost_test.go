@@ -0,0 +1,59 @@
+package mapping
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestCostFunctions(t *testing.T) {
+	initial := r3.Vec{X: 0, Y: 0, Z: 0}
+	target := r3.Vec{X: 1, Y: 2, Z: 2}
+
+	require.Equal(t, 3.0, FlooredEuclideanCost(0, initial, target))
+	require.Equal(t, 3.0, EuclideanCost(0, initial, target))
+	require.Equal(t, 9.0, SquaredEuclideanCost(0, initial, target))
+	require.Equal(t, 5.0, ManhattanCost(0, initial, target))
+
+	target = r3.Vec{X: 1, Y: 1, Z: 0}
+	require.Equal(t, 1.0, FlooredEuclideanCost(0, initial, target))
+	require.InDelta(t, math.Sqrt2, EuclideanCost(0, initial, target), 1e-9)
+
+	battery := BatteryWeightedCost([]float64{1, 0.5}, ManhattanCost)
+	require.Equal(t, 2.0, battery(0, initial, target))
+	require.Equal(t, 4.0, battery(1, initial, target))
+	require.Equal(t, 2.0, battery(2, initial, target))
+}
+
+func TestMapTargets_cost(t *testing.T) {
+	initials := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 1, Y: 0, Z: 0},
+	}
+	targets := []r3.Vec{
+		r3.Vec{X: 3, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+	}
+
+	// Both assignments fly 4 units, the squared cost balances them
+	res, _ := NewHungarianMapperWithCost(SquaredEuclideanCost).MapTargets(initials, targets)
+	require.Equal(t, []r3.Vec{targets[1], targets[0]}, res)
+
+	// The drone running low flies the shortest distance
+	res, _ = NewHungarianMapperWithCost(BatteryWeightedCost([]float64{1, 0.1}, EuclideanCost)).MapTargets(initials, targets)
+	require.Equal(t, []r3.Vec{targets[0], targets[1]}, res)
+
+	// Sub-unit differences are not lost
+	initials = []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 1},
+	}
+	targets = []r3.Vec{
+		r3.Vec{X: 3, Y: 1, Z: 1},
+		r3.Vec{X: 3, Y: 0, Z: 0},
+	}
+	res, _ = NewHungarianMapperWithCost(EuclideanCost).MapTargets(initials, targets)
+	require.Equal(t, []r3.Vec{targets[1], targets[0]}, res)
+}
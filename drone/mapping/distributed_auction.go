@@ -0,0 +1,258 @@
+package mapping
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/onet/v3/log"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	// defaultDistributedEpsilon is the bidding increment of the distributed
+	// auction. Without scaling phases, the number of bids grows with the costs
+	// divided by the increment, which must stay coarse to limit the messages.
+	defaultDistributedEpsilon = 0.01
+	// defaultSettle is the time without any new bid after which a complete
+	// assignment is considered final
+	defaultSettle = 500 * time.Millisecond
+	// defaultAuctionTimeout bounds the duration of a distributed auction
+	defaultAuctionTimeout = 30 * time.Second
+	// maxPendingBids bounds the number of bids kept for an auction the drone
+	// has not joined yet
+	maxPendingBids = 4096
+)
+
+// DistributedMapper is a TargetsMapper computed jointly by the drones of the
+// swarm: every drone must call MapTargets and forward the bids it receives.
+type DistributedMapper interface {
+	TargetsMapper
+	HandleBid(bid *extramessage.AuctionBid)
+}
+
+// DistributedAuctionMapper runs the auction with the other drones of the
+// swarm, each drone bidding for itself and broadcasting its bids. Every drone
+// keeps the highest bid of each target, so that they all converge to the
+// same assignment. The auction ends once every drone owns a target and no new
+// bid has been received for a while. If the auction does not end in time,
+// the drone falls back to the central auction.
+type DistributedAuctionMapper struct {
+	droneID int
+	cost    CostFunction
+	epsilon float64
+	settle  time.Duration
+	timeout time.Duration
+	send    func(bid *extramessage.AuctionBid)
+
+	fallback *auctionMapper
+
+	mutex    sync.Mutex
+	auctions map[string]*auctionState
+}
+
+// auctionState is the view of a drone on an auction
+type auctionState struct {
+	prices  map[int]float64
+	owners  map[int]int
+	updated chan struct{}
+
+	// drones and targets are the size of the auction, idle targets included,
+	// known once the drone joins it. The bids received before are kept in
+	// pending.
+	drones  int
+	targets int
+	pending []*extramessage.AuctionBid
+	created time.Time
+}
+
+// NewDistributedAuctionMapper creates the mapper of a drone, send broadcasts
+// its bids to the other drones of the swarm
+func NewDistributedAuctionMapper(droneID int, cost CostFunction, send func(bid *extramessage.AuctionBid)) *DistributedAuctionMapper {
+	return &DistributedAuctionMapper{
+		droneID: droneID,
+		cost:    cost,
+		epsilon: defaultDistributedEpsilon,
+		settle:  defaultSettle,
+		timeout: defaultAuctionTimeout,
+		send:    send,
+
+		fallback: NewAuctionMapperWithCost(cost),
+		auctions: make(map[string]*auctionState),
+	}
+}
+
+// HandleBid updates the view of the auction with the bid of another drone
+func (m *DistributedAuctionMapper) HandleBid(bid *extramessage.AuctionBid) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sweep(time.Now())
+	state := m.getAuction(bid.AuctionID)
+	if state.drones == 0 {
+		if len(state.pending) < maxPendingBids {
+			state.pending = append(state.pending, bid)
+		}
+		return
+	}
+	m.applyBid(state, bid)
+}
+
+func (m *DistributedAuctionMapper) MapTargets(initials []r3.Vec, targets []r3.Vec) ([]r3.Vec, []int) {
+	if len(initials) == 0 || len(targets) == 0 {
+		return idleAssignment(initials)
+	}
+	if m.droneID >= len(initials) {
+		// Not part of the swarm
+		return m.fallback.MapTargets(initials, targets)
+	}
+
+	auctionID := auctionID(initials, targets)
+	costs := costMatrix(m.cost, initials, targets, false)
+	deadline := time.After(m.timeout)
+
+	m.mutex.Lock()
+	state := m.getAuction(auctionID)
+	state.drones = len(initials)
+	// Including the idle targets of the drones in excess
+	state.targets = len(costs[m.droneID])
+	for _, pending := range state.pending {
+		m.applyBid(state, pending)
+	}
+	state.pending = nil
+	m.mutex.Unlock()
+	defer func() {
+		m.mutex.Lock()
+		delete(m.auctions, auctionID)
+		m.mutex.Unlock()
+	}()
+
+	for {
+		m.mutex.Lock()
+		if !owns(state, m.droneID) {
+			target, price := bid(costs[m.droneID], pricesOf(state, len(costs[m.droneID])), m.epsilon)
+			ownBid := &extramessage.AuctionBid{
+				AuctionID: auctionID,
+				Drone:     m.droneID,
+				Target:    target,
+				Price:     price,
+			}
+			m.applyBid(state, ownBid)
+			m.send(ownBid)
+		}
+		complete := ownersComplete(state, len(initials))
+		m.mutex.Unlock()
+
+		var settled <-chan time.Time
+		if complete {
+			settled = time.After(m.settle)
+		}
+		select {
+		case <-state.updated:
+		case <-settled:
+			m.mutex.Lock()
+			assignment := make([]int, len(initials))
+			for target, drone := range state.owners {
+				assignment[drone] = target
+			}
+			m.mutex.Unlock()
+			return decodeAuction(initials, targets, assignment)
+		case <-deadline:
+			log.Printf("drone%d Auction %s timed out, fallback to the central auction", m.droneID, auctionID)
+			return m.fallback.MapTargets(initials, targets)
+		}
+	}
+}
+
+// getAuction returns the state of an auction, creating it if needed. The
+// mutex must be held.
+func (m *DistributedAuctionMapper) getAuction(auctionID string) *auctionState {
+	state, found := m.auctions[auctionID]
+	if !found {
+		state = &auctionState{
+			prices:  make(map[int]float64),
+			owners:  make(map[int]int),
+			updated: make(chan struct{}, 1),
+			created: time.Now(),
+		}
+		m.auctions[auctionID] = state
+	}
+	return state
+}
+
+// sweep drops the auctions the drone has not joined within the timeout, such
+// as the ones of the late bids of a settled auction. The mutex must be held.
+func (m *DistributedAuctionMapper) sweep(now time.Time) {
+	for auctionID, state := range m.auctions {
+		if state.drones == 0 && now.Sub(state.created) > m.timeout {
+			delete(m.auctions, auctionID)
+		}
+	}
+}
+
+// applyBid keeps the bid if it is higher than the current one, ties are won
+// by the lowest drone ID. The bids for a drone or a target outside of the
+// auction are dropped. The mutex must be held.
+func (m *DistributedAuctionMapper) applyBid(state *auctionState, bid *extramessage.AuctionBid) {
+	if bid.Drone < 0 || bid.Drone >= state.drones || bid.Target < 0 || bid.Target >= state.targets {
+		log.Printf("drone%d Invalid bid of drone %d for target %d in auction %s", m.droneID, bid.Drone, bid.Target, bid.AuctionID)
+		return
+	}
+	price, found := state.prices[bid.Target]
+	if found && (bid.Price < price || bid.Price == price && bid.Drone >= state.owners[bid.Target]) {
+		return
+	}
+	state.prices[bid.Target] = bid.Price
+	state.owners[bid.Target] = bid.Drone
+
+	select {
+	case state.updated <- struct{}{}:
+	default:
+	}
+}
+
+// owns returns true if the drone is the owner of a target
+func owns(state *auctionState, drone int) bool {
+	for _, owner := range state.owners {
+		if owner == drone {
+			return true
+		}
+	}
+	return false
+}
+
+// ownersComplete returns true if each of the n drones owns a target
+func ownersComplete(state *auctionState, n int) bool {
+	owned := make(map[int]bool, n)
+	for _, owner := range state.owners {
+		owned[owner] = true
+	}
+	return len(owned) == n
+}
+
+func pricesOf(state *auctionState, n int) []float64 {
+	prices := make([]float64, n)
+	for target, price := range state.prices {
+		if target < n {
+			prices[target] = price
+		}
+	}
+	return prices
+}
+
+// auctionID identifies the auction of the given drones and targets, such that
+// all the drones agree on it without communicating
+func auctionID(initials []r3.Vec, targets []r3.Vec) string {
+	h := sha256.New()
+	for _, locations := range [][]r3.Vec{initials, targets} {
+		for _, l := range locations {
+			fmt.Fprintf(h, "%x,%x,%x;", math.Float64bits(l.X), math.Float64bits(l.Y), math.Float64bits(l.Z))
+		}
+		h.Write([]byte{'|'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
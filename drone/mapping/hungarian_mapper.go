@@ -9,32 +9,45 @@ import (
 )
 
 type hungarianMapper struct {
+	cost CostFunction
 }
 
+// NewHungarianMapper creates a mapper minimizing the sum of the floored distances
 func NewHungarianMapper() *hungarianMapper {
-	return &hungarianMapper{}
+	return NewHungarianMapperWithCost(FlooredEuclideanCost)
 }
 
-func (m *hungarianMapper) MapTargets(initials []r3.Vec, targets []r3.Vec) []r3.Vec {
-	if len(initials) != len(targets) {
-		panic("Number of drones not equal to number of targets")
+// NewHungarianMapperWithCost creates a mapper minimizing the sum of the given cost
+func NewHungarianMapperWithCost(cost CostFunction) *hungarianMapper {
+	return &hungarianMapper{
+		cost: cost,
+	}
+}
+
+func (m *hungarianMapper) MapTargets(initials []r3.Vec, targets []r3.Vec) ([]r3.Vec, []int) {
+	if len(initials) == 0 || len(targets) == 0 {
+		return idleAssignment(initials)
 	}
 
 	matrix := m.initMatrix(initials, targets)
 	mask := m.computeAssignment(matrix)
 
-	return m.decodeAssignement(targets, mask)
+	return m.decodeAssignement(initials, targets, mask)
 }
 
-// iniMatrix creates the nxn cost matrix
+// iniMatrix creates the nxn cost matrix, n being the highest number of drones
+// or targets. The matrix is padded with null costs, a drone assigned to a
+// padding column stays idle and a target assigned to a padding row is dropped.
 func (m *hungarianMapper) initMatrix(initials []r3.Vec, targets []r3.Vec) *mat.Dense {
 	n := len(initials)
+	if len(targets) > n {
+		n = len(targets)
+	}
 	matrix := mat.NewDense(n, n, nil)
 
 	for i, drone := range initials {
 		for j, target := range targets {
-			dist := r3.Norm(drone.Sub(target))
-			matrix.Set(i, j, math.Floor(dist))
+			matrix.Set(i, j, m.cost(i, drone, target))
 		}
 	}
 
@@ -83,17 +96,24 @@ func (m *hungarianMapper) computeAssignment(matrix *mat.Dense) *mat.Dense {
 	return mask
 }
 
-func (m *hungarianMapper) decodeAssignement(targets []r3.Vec, mask *mat.Dense) []r3.Vec {
-	r, c := mask.Dims()
-	res := make([]r3.Vec, r)
-	for i := 0; i < r; i++ {
-		for j := 0; j < c; j++ {
+// decodeAssignement returns the target of each drone and the drones that are
+// not assigned any target, which keep their initial location as target
+func (m *hungarianMapper) decodeAssignement(initials []r3.Vec, targets []r3.Vec, mask *mat.Dense) ([]r3.Vec, []int) {
+	res := make([]r3.Vec, len(initials))
+	idle := make([]int, 0)
+	for i := range initials {
+		for j := range targets {
 			if mask.At(i, j) == staredVal {
 				res[i] = targets[j]
+				break
+			}
+			if j == len(targets)-1 {
+				res[i] = initials[i]
+				idle = append(idle, i)
 			}
 		}
 	}
-	return res
+	return res, idle
 }
 
 // step01 : For each row of the matrix, find the smallest element and subtract it from every element in its row. Go to Step 2
@@ -152,8 +152,9 @@ func TestDecodeAssignement(t *testing.T) {
 		r3.Vec{X: 3, Y: 3, Z: 3},
 	}
 
-	res := mapper.decodeAssignement(targetPos, mask)
+	res, idle := mapper.decodeAssignement(initialPos, targetPos, mask)
 	require.Equal(t, expectedRes, res)
+	require.Empty(t, idle)
 	//TestCase 2
 	mask = mat.NewDense(3, 3, []float64{
 		0, 1, 0,
@@ -166,8 +167,9 @@ func TestDecodeAssignement(t *testing.T) {
 		r3.Vec{X: 3, Y: 3, Z: 3},
 	}
 
-	res = mapper.decodeAssignement(targetPos, mask)
+	res, idle = mapper.decodeAssignement(initialPos, targetPos, mask)
 	require.Equal(t, expectedRes, res)
+	require.Empty(t, idle)
 }
 
 func TestStep01(t *testing.T) {
@@ -483,3 +485,80 @@ func TestStep06(t *testing.T) {
 		34, 0, 8,
 	})))
 }
+
+func TestInitMatrix_padding(t *testing.T) {
+	mapper := NewHungarianMapper()
+
+	initialPos := []r3.Vec{
+		r3.Vec{X: 1, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+		r3.Vec{X: 3, Y: 0, Z: 0},
+	}
+	targetPos := []r3.Vec{
+		r3.Vec{X: 3, Y: 0, Z: 0},
+		r3.Vec{X: 4, Y: 0, Z: 0},
+	}
+
+	expectedMatrix := mat.NewDense(3, 3, []float64{
+		2, 3, 0,
+		1, 2, 0,
+		0, 1, 0,
+	})
+
+	matrix := mapper.initMatrix(initialPos, targetPos)
+	require.True(t, mat.Equal(expectedMatrix, matrix))
+
+	expectedMatrix = mat.NewDense(3, 3, []float64{
+		2, 1, 0,
+		3, 2, 1,
+		0, 0, 0,
+	})
+
+	matrix = mapper.initMatrix(targetPos, initialPos)
+	require.True(t, mat.Equal(expectedMatrix, matrix))
+}
+
+func TestMapTargets_moreDrones(t *testing.T) {
+	mapper := NewHungarianMapperWithCost(EuclideanCost)
+
+	initialPos := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+		r3.Vec{X: 4, Y: 0, Z: 0},
+	}
+	targetPos := []r3.Vec{
+		r3.Vec{X: 4, Y: 1, Z: 0},
+		r3.Vec{X: 0, Y: 1, Z: 0},
+	}
+
+	res, idle := mapper.MapTargets(initialPos, targetPos)
+	require.Equal(t, []r3.Vec{targetPos[1], initialPos[1], targetPos[0]}, res)
+	require.Equal(t, []int{1}, idle)
+
+	// No target at all
+	res, idle = mapper.MapTargets(initialPos, []r3.Vec{})
+	require.Equal(t, initialPos, res)
+	require.Equal(t, []int{0, 1, 2}, idle)
+}
+
+func TestMapTargets_moreTargets(t *testing.T) {
+	mapper := NewHungarianMapperWithCost(EuclideanCost)
+
+	initialPos := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 4, Y: 0, Z: 0},
+	}
+	targetPos := []r3.Vec{
+		r3.Vec{X: 10, Y: 0, Z: 0},
+		r3.Vec{X: 4, Y: 2, Z: 0},
+		r3.Vec{X: 0, Y: 2, Z: 0},
+	}
+
+	res, idle := mapper.MapTargets(initialPos, targetPos)
+	require.Equal(t, []r3.Vec{targetPos[2], targetPos[1]}, res)
+	require.Empty(t, idle)
+
+	res, idle = NewBottleneckMapper().MapTargets(initialPos, targetPos)
+	require.Equal(t, []r3.Vec{targetPos[2], targetPos[1]}, res)
+	require.Empty(t, idle)
+}
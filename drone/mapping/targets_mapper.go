@@ -1,6 +1,8 @@
 package mapping
 
 import (
+	"fmt"
+
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
@@ -10,9 +12,49 @@ const (
 	coverVal  = 1
 )
 
+const (
+	// HungarianMapping selects the mapper minimizing the total cost
+	HungarianMapping = "hungarian"
+	// BottleneckMapping selects the mapper minimizing the highest cost
+	BottleneckMapping = "bottleneck"
+	// AuctionMapping selects the auction mapper minimizing the total cost
+	AuctionMapping = "auction"
+	// DistributedAuctionMapping selects the auction run jointly by the drones
+	DistributedAuctionMapping = "distributed-auction"
+)
+
 // https://en.wikipedia.org/wiki/Hungarian_algorithm
 // https://www.researchgate.net/publication/290437481_Tutorial_on_Implementation_of_Munkres'_Assignment_Algorithm
 
+// TargetsMapper assigns the targets to the drones. When there are more drones
+// than targets, the extra drones are idle: they keep their initial location
+// as target. When there are more targets than drones, only the best subset
+// of the targets is used.
 type TargetsMapper interface {
-	MapTargets(initials []r3.Vec, targets []r3.Vec) []r3.Vec
+	// MapTargets returns the target of each drone and the index of the idle drones
+	MapTargets(initials []r3.Vec, targets []r3.Vec) ([]r3.Vec, []int)
+}
+
+// NewTargetsMapper creates the mapper corresponding to the given name, using
+// the cost function. The distributed auction is created with NewDistributedAuctionMapper.
+func NewTargetsMapper(mapper string, cost CostFunction) (TargetsMapper, error) {
+	switch mapper {
+	case AuctionMapping:
+		return NewAuctionMapperWithCost(cost), nil
+	case HungarianMapping:
+		return NewHungarianMapperWithCost(cost), nil
+	case BottleneckMapping:
+		return NewBottleneckMapperWithCost(cost), nil
+	default:
+		return nil, fmt.Errorf("unknown mapper %s", mapper)
+	}
+}
+
+// idleAssignment keeps every drone at its initial location
+func idleAssignment(initials []r3.Vec) ([]r3.Vec, []int) {
+	idle := make([]int, len(initials))
+	for i := range idle {
+		idle[i] = i
+	}
+	return append([]r3.Vec{}, initials...), idle
 }
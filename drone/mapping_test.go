@@ -6,8 +6,13 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/drone/consensus"
+	"go.dedis.ch/cs438/orbitalswarm/drone/mapping"
+	"go.dedis.ch/cs438/orbitalswarm/environment"
 	"go.dedis.ch/cs438/orbitalswarm/extramessage"
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/pathgenerator"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
@@ -17,15 +22,39 @@ func TestMapping(t *testing.T) {
 	antiEntropy := 10
 	numDrones := 5
 
-	swarm, pos := NewSwarm(numDrones, 2222, 5000, antiEntropy, routeTimer, paxosRetry, "127.0.0.1", "127.0.0.1")
+	// The drones only follow the commands signed by the ground station
+	trust := gossip.NewTrustStore()
+	swarm, pos := NewSwarm(SwarmConfig{
+		NumDrones:         numDrones,
+		NumParticipants:   numDrones,
+		FirstUIPort:       2222,
+		FirstGossipPort:   5000,
+		BaseUIAddress:     "127.0.0.1",
+		BaseGossipAddress: "127.0.0.1",
+		AntiEntropy:       antiEntropy,
+		RouteTimer:        routeTimer,
+		PaxosRetry:        paxosRetry,
+		Mapper:            mapping.BottleneckMapping,
+		Protocol:          consensus.PaxosProtocol,
+		Planner:           pathgenerator.GeneticPlanner,
+		Environment:       environment.Empty(),
+		Limits:            trajectory.DefaultLimits,
+		Profile:           trajectory.TrapezoidalProfile,
+		SafetyRadius:      pathgenerator.DefaultSafetyRadius,
+		Trust:             trust,
+	})
 
 	go swarm.Run()
+	defer swarm.Stop()
 
 	fac := gossip.GetFactory()
 	g, err := fac.New("127.0.0.1:33000", "GS", antiEntropy, routeTimer, numDrones)
 	if err != nil {
 		panic(err)
 	}
+	private, err := trust.NewKey("GS")
+	require.NoError(t, err)
+	g.SetKeys(private, trust)
 	time.Sleep(time.Second * 2)
 
 	addresses := swarm.DronesAddresses()
@@ -33,6 +62,7 @@ func TestMapping(t *testing.T) {
 	ready := make(chan struct{})
 	go g.Run(ready)
 	<-ready
+	defer g.Stop()
 
 	fmt.Println("Positions :", pos)
 	targets := []r3.Vec{
@@ -48,14 +78,101 @@ func TestMapping(t *testing.T) {
 			PatternID:  "pattern1",
 			InitialPos: pos,
 			TargetPos:  targets,
+			Sequence:   1,
+			Time:       time.Now().UnixNano(),
 		},
 	})
 
-	time.Sleep(time.Second * 10)
+	// The drones take their target from the committed mapping
+	require.Eventually(t, func() bool {
+		assignments := swarm.DroneTargets()
+		for i, assignment := range assignments {
+			if assignment != targets[i] {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 100*time.Millisecond)
+	fmt.Println("Targets:", swarm.DroneTargets())
+}
+
+func TestMappingConsensus(t *testing.T) {
+	paxosRetry := 3
+	routeTimer := 0
+	antiEntropy := 10
+	numDrones := 7
+	numPaxosDrones := 5
+
+	// The drones and the ground station encrypt the datagrams they exchange
+	trust := gossip.NewTrustStore()
+	swarm, pos := NewSwarm(SwarmConfig{
+		NumDrones:         numDrones,
+		NumParticipants:   numPaxosDrones,
+		FirstUIPort:       2322,
+		FirstGossipPort:   5100,
+		BaseUIAddress:     "127.0.0.1",
+		BaseGossipAddress: "127.0.0.1",
+		AntiEntropy:       antiEntropy,
+		RouteTimer:        routeTimer,
+		PaxosRetry:        paxosRetry,
+		Mapper:            mapping.HungarianMapping,
+		Protocol:          consensus.PaxosProtocol,
+		Planner:           pathgenerator.GeneticPlanner,
+		Environment:       environment.Empty(),
+		Limits:            trajectory.DefaultLimits,
+		Profile:           trajectory.TrapezoidalProfile,
+		SafetyRadius:      pathgenerator.DefaultSafetyRadius,
+		Trust:             trust,
+	})
+	require.NoError(t, swarm.EnableEncryption())
+
+	go swarm.Run()
+	defer swarm.Stop()
+
+	fac := gossip.GetFactory()
+	g, err := fac.New("127.0.0.1:33001", "GS", antiEntropy, routeTimer, numDrones)
+	require.NoError(t, err)
+	private, err := trust.NewKey("GS")
+	require.NoError(t, err)
+	g.SetKeys(private, trust)
+	require.NoError(t, g.EnableEncryption())
+	time.Sleep(time.Second * 2)
+
+	g.AddAddresses(swarm.DronesAddresses()...)
+	ready := make(chan struct{})
+	go g.Run(ready)
+	<-ready
+	defer g.Stop()
+
+	targets := make([]r3.Vec, numDrones)
+	for i := range targets {
+		targets[i] = r3.Vec{X: float64(2 * i), Y: 10, Z: 0}
+	}
+
+	g.AddExtraMessage(&extramessage.ExtraMessage{
+		SwarmInit: &extramessage.SwarmInit{
+			PatternID:  "pattern1",
+			InitialPos: pos,
+			TargetPos:  targets,
+			Sequence:   1,
+			Time:       time.Now().UnixNano(),
+		},
+	})
 
-	assignments := swarm.DroneTargets()
-	fmt.Println("Targets:", assignments)
-	for i, assignment := range assignments {
-		require.Equal(t, targets[i], assignment)
+	// Participants and readers committed the same mapping and took their target from it
+	require.Eventually(t, func() bool {
+		for _, m := range swarm.DroneMappings("pattern1") {
+			if m == nil {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 100*time.Millisecond)
+	mappings := swarm.DroneMappings("pattern1")
+	require.NotNil(t, mappings[0])
+	require.ElementsMatch(t, targets, mappings[0].Targets)
+	for _, m := range mappings {
+		require.Equal(t, mappings[0], m)
 	}
+	require.Equal(t, mappings[0].Targets, swarm.DroneTargets())
 }
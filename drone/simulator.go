@@ -3,6 +3,7 @@ package drone
 import (
 	"time"
 
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
@@ -38,3 +39,21 @@ func (s *simulator) launchSimulation(singleMoveTime int, refreshFrequency int, l
 	}()
 	return s.done
 }
+
+// launchTrajectory samples the trajectory refreshFrequency times per second
+// and reports the positions to the drone until the trajectory ends
+func (s *simulator) launchTrajectory(t trajectory.Trajectory, refreshFrequency int) <-chan struct{} {
+	s.done = make(chan struct{})
+	go func() {
+		sleepDuration := time.Duration(1000/refreshFrequency) * time.Millisecond
+		duration := t.Duration()
+		for step := 1; float64(step)/float64(refreshFrequency) < duration; step++ {
+			time.Sleep(sleepDuration)
+			s.drone.UpdateLocation(t.Sample(float64(step) / float64(refreshFrequency)))
+		}
+		time.Sleep(sleepDuration)
+		s.drone.UpdateLocation(t.Sample(duration))
+		close(s.done)
+	}()
+	return s.done
+}
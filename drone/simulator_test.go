@@ -34,7 +34,7 @@ func TestSimulator(t *testing.T) {
 		r3.Vec{X: 0, Y: 0, Z: 1},
 		r3.Vec{X: -1, Y: 0, Z: 0},
 	}
-	simulator.launchSimulation(timeOneStep, 4, starting, path)
+	done := simulator.launchSimulation(timeOneStep, 4, starting, path)
 
 	expected := []r3.Vec{
 		r3.Vec{X: 0.25, Y: 0, Z: 0},
@@ -55,7 +55,11 @@ func TestSimulator(t *testing.T) {
 		r3.Vec{X: 0, Y: 1, Z: 1},
 	}
 
-	time.Sleep(time.Second * time.Duration(len(path)+1))
+	select {
+	case <-done:
+	case <-time.After(time.Second * time.Duration(len(path)+1)):
+		t.Fatal("simulation not finished")
+	}
 
 	log.Println(expected)
 	log.Println(drone.res)
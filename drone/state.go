@@ -0,0 +1,61 @@
+package drone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// persistedState is what a drone persists, so that a restarted drone never
+// follows a command older than the last one it followed
+type persistedState struct {
+	LastCommand uint64
+}
+
+// loadLastCommand returns the sequence of the last command persisted in the
+// file, 0 if the file does not exist or if the path is empty
+func loadLastCommand(path string) (uint64, error) {
+	if path == "" {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var s persistedState
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid state %s: %v", path, err)
+	}
+	return s.LastCommand, nil
+}
+
+// saveLastCommand persists the sequence of the last command, replacing the
+// file once the new one is synced to disk. An empty path persists nothing.
+func saveLastCommand(path string, sequence uint64) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(persistedState{LastCommand: sequence})
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -3,11 +3,17 @@ package drone
 import (
 	"fmt"
 	"math"
+	"path/filepath"
 
+	"go.dedis.ch/cs438/orbitalswarm/bft"
 	"go.dedis.ch/cs438/orbitalswarm/drone/consensus"
 	"go.dedis.ch/cs438/orbitalswarm/drone/mapping"
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
 	"go.dedis.ch/cs438/orbitalswarm/pathgenerator"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
@@ -15,58 +21,149 @@ import (
 type Swarm struct {
 	drones []*Drone
 	stop   chan struct{}
+	// stopped is closed once the drones are stopped
+	stopped chan struct{}
+	// keys are the public keys of the participants of the BFT consensus
+	keys *bft.Keys
 }
 
-// NewSwarm creates and returns an new Swarm, but do not start the drones
-func NewSwarm(numDrones, numPaxosDrone, firstUIPort, firstGossipPort, antiEntropy, routeTimer, paxosRetry int, baseUIAddress, baseGossipAddress string) (*Swarm, []r3.Vec) {
+// SwarmConfig holds the parameters of the drones of a swarm
+type SwarmConfig struct {
+	// NumDrones is the number of drones, the first NumParticipants of them
+	// taking part in the consensus
+	NumDrones       int
+	NumParticipants int
+	// The drone i serves its UI on FirstUIPort+i of BaseUIAddress and
+	// gossips on FirstGossipPort+i of BaseGossipAddress
+	FirstUIPort       int
+	FirstGossipPort   int
+	BaseUIAddress     string
+	BaseGossipAddress string
+	// AntiEntropy and RouteTimer are the periods of the gossipers, and
+	// PaxosRetry the time a proposer waits before retrying, in seconds
+	AntiEntropy int
+	RouteTimer  int
+	PaxosRetry  int
+	// Mapper assigns the targets to the drones, Protocol is the consensus
+	// agreeing on them and Planner the path generator
+	Mapper   string
+	Protocol string
+	Planner  string
+	// The drones avoid the no-fly zones of the Environment and fly
+	// trajectories following the Profile within the Limits, keeping
+	// SafetyRadius apart
+	Environment  *environment.Environment
+	Limits       trajectory.Limits
+	Profile      string
+	SafetyRadius float64
+	// StorageDir is the directory where the drones persist their state, if
+	// not empty
+	StorageDir string
+	// Trust holds the keys of the nodes, if set each drone signs its packets
+	// with a new key added to it and only accepts the packets of the nodes
+	// it lists
+	Trust *gossip.TrustStore
+}
+
+// NewSwarm creates the drones of the swarm, but does not start them, and
+// returns their initial positions
+func NewSwarm(config SwarmConfig) (*Swarm, []r3.Vec) {
 	swarm := Swarm{
-		drones: make([]*Drone, numDrones),
-		stop:   make(chan struct{}),
+		drones:  make([]*Drone, config.NumDrones),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
 	}
 
 	// Drone parameters initialisation
-	gossipAddresses := make([]string, numDrones)
-	UIAddresses := make([]string, numDrones)
-	positions := make([]r3.Vec, numDrones)
+	gossipAddresses := make([]string, config.NumDrones)
+	UIAddresses := make([]string, config.NumDrones)
+	positions := make([]r3.Vec, config.NumDrones)
+	droneLimits := make([]trajectory.Limits, config.NumDrones)
 	line := 0
 	column := 0
 	space := 2
 
-	edge := int(math.Sqrt(float64(numDrones)))
-	for i := 0; i < numDrones; i++ {
-		gossipAddress := fmt.Sprintf("%s:%d", baseGossipAddress, firstGossipPort+i)
+	edge := int(math.Sqrt(float64(config.NumDrones)))
+	for i := 0; i < config.NumDrones; i++ {
+		gossipAddress := fmt.Sprintf("%s:%d", config.BaseGossipAddress, config.FirstGossipPort+i)
 		gossipAddresses[i] = gossipAddress
-		UIAddress := fmt.Sprintf("%s:%d", baseUIAddress, firstUIPort+i)
+		UIAddress := fmt.Sprintf("%s:%d", config.BaseUIAddress, config.FirstUIPort+i)
 		UIAddresses[i] = UIAddress
 		positions[i] = r3.Vec{X: float64(line * space), Y: 0, Z: float64(column * space)}
+		droneLimits[i] = config.Limits
 		column = (column + 1) % edge
 		if column == 0 {
 			line++
 		}
 	}
 
+	// The participants of the BFT consensus sign their messages
+	var keys []*bft.Keys
+	if config.Protocol == consensus.BFTProtocol {
+		var err error
+		keys, err = bft.GenerateKeys(config.NumParticipants)
+		if err != nil {
+			panic(err)
+		}
+		swarm.keys = keys[0].Public()
+	}
+
 	// Drone creation
 	fac := gossip.GetFactory()
-	for i := 0; i < numDrones; i++ {
-		name := fmt.Sprintf("drone%d", i)
-		g, err := fac.New(gossipAddresses[i], name, antiEntropy, routeTimer, numDrones)
+	for i := 0; i < config.NumDrones; i++ {
+		name := consensus.NodeName(i)
+		g, err := fac.New(gossipAddresses[i], name, config.AntiEntropy, config.RouteTimer, config.NumDrones)
 
 		if err != nil {
 			panic(err)
 		}
-		peers := make([]string, numDrones)
+		if config.Trust != nil {
+			private, err := config.Trust.NewKey(name)
+			if err != nil {
+				panic(err)
+			}
+			g.SetKeys(private, config.Trust)
+		}
+		peers := make([]string, config.NumDrones)
 		copy(peers, gossipAddresses)
 		peers = append(peers[:i], peers[i+1:]...)
 
-		var consensusCli consensus.ConsensusClient
+		droneKeys := swarm.keys
+		if i < len(keys) {
+			droneKeys = keys[i]
+		}
+		consensusCli, err := consensus.NewConsensusClient(config.Protocol, config.NumParticipants, i, config.PaxosRetry, droneKeys, config.StorageDir)
+		if err != nil {
+			panic(err)
+		}
 
-		if i < numPaxosDrone {
-			consensusCli = consensus.NewConsensusParticipant(numPaxosDrone, i, paxosRetry)
+		var targetsMapper mapping.TargetsMapper
+		if config.Mapper == mapping.DistributedAuctionMapping {
+			targetsMapper = mapping.NewDistributedAuctionMapper(i, mapping.EuclideanCost, func(bid *extramessage.AuctionBid) {
+				g.AddExtraMessage(&extramessage.ExtraMessage{
+					AuctionBid: bid,
+				})
+			})
 		} else {
-			consensusCli = consensus.NewConsensusReader(numPaxosDrone, i, paxosRetry)
+			targetsMapper, err = mapping.NewTargetsMapper(config.Mapper, mapping.EuclideanCost)
+			if err != nil {
+				panic(err)
+			}
 		}
 
-		swarm.drones[i] = NewDrone(uint32(i), g, peers, positions[i], mapping.NewHungarianMapper(), consensusCli, pathgenerator.NewGeneticPathGenerator())
+		pathGenerator, err := pathgenerator.NewPathGenerator(config.Planner, config.SafetyRadius, config.Environment)
+		if err != nil {
+			panic(err)
+		}
+
+		var statePath string
+		if config.StorageDir != "" {
+			statePath = filepath.Join(config.StorageDir, name+".json")
+		}
+		swarm.drones[i], err = NewDrone(uint32(i), g, peers, positions[i], targetsMapper, consensusCli, pathGenerator, config.Environment, droneLimits, config.Profile, config.SafetyRadius, statePath)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	return &swarm, positions
@@ -74,6 +171,7 @@ func NewSwarm(numDrones, numPaxosDrone, firstUIPort, firstGossipPort, antiEntrop
 
 // Run the drones composing the drones, this function is blocking until the the stop function is called
 func (s *Swarm) Run() {
+	defer close(s.stopped)
 	for _, drone := range s.drones {
 		ready := make(chan struct{})
 		go drone.gossiper.Run(ready)
@@ -83,11 +181,15 @@ func (s *Swarm) Run() {
 		go drone.Run()
 	}
 	<-s.stop
+	for _, drone := range s.drones {
+		drone.Stop()
+	}
 }
 
-// Stop every drone composing the Swarm
+// Stop every drone composing the Swarm and wait until they are stopped
 func (s *Swarm) Stop() {
 	close(s.stop)
+	<-s.stopped
 }
 
 // DronesAddresses return the drone addresses
@@ -99,6 +201,25 @@ func (s *Swarm) DronesAddresses() []string {
 	return addresses
 }
 
+// EnableEncryption encrypts the datagrams exchanged by the drones, which
+// requires the swarm to be created with a trust store. It must be called
+// before Run.
+func (s *Swarm) EnableEncryption() error {
+	for _, d := range s.drones {
+		err := d.gossiper.EnableEncryption()
+		if err != nil {
+			return fmt.Errorf("drone %d: %v", d.droneID, err)
+		}
+	}
+	return nil
+}
+
+// Keys returns the public keys of the participants of the BFT consensus, nil
+// with the other protocols
+func (s *Swarm) Keys() *bft.Keys {
+	return s.keys
+}
+
 // TO TEST, maybe not useful/good to keep it
 func (s *Swarm) DroneTargets() []r3.Vec {
 	targets := make([]r3.Vec, len(s.drones))
@@ -107,3 +228,22 @@ func (s *Swarm) DroneTargets() []r3.Vec {
 	}
 	return targets
 }
+
+// DroneMappings returns the mapping of the pattern committed in the
+// blockchain of each drone, nil if the drone has not committed it
+func (s *Swarm) DroneMappings(patternID string) []*blk.MappingBlockContent {
+	mappings := make([]*blk.MappingBlockContent, len(s.drones))
+	for _, d := range s.drones {
+		_, blocks := d.consensusClient.GetBlocks()
+		for _, block := range blocks {
+			if block.Type != blk.BlockMappingStr {
+				continue
+			}
+			content := block.GetContent().(*blk.MappingBlockContent)
+			if content.PatternID == patternID {
+				mappings[d.GetDroneID()] = content
+			}
+		}
+	}
+	return mappings
+}
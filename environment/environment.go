@@ -0,0 +1,215 @@
+package environment
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// Box is an axis-aligned obstacle spanning from Min to Max
+type Box struct {
+	Min r3.Vec
+	Max r3.Vec
+}
+
+// Cylinder is a vertical obstacle whose base is centered on Center
+type Cylinder struct {
+	Center r3.Vec
+	Radius float64
+	Height float64
+}
+
+// Environment describes the airspace the drones fly in: the obstacles and
+// no-fly zones they must avoid, and the altitudes they must stay within. The
+// boundaries of the obstacles are part of them. An environment is shared by
+// the planners and the validators, it must not be modified once loaded.
+type Environment struct {
+	// Floor is the lowest altitude allowed, the ground by default
+	Floor float64
+	// Ceiling is the highest altitude allowed, if any
+	Ceiling *float64
+
+	Boxes     []Box
+	Cylinders []Cylinder
+}
+
+// Empty returns an environment without obstacles nor ceiling
+func Empty() *Environment {
+	return &Environment{
+		Boxes:     make([]Box, 0),
+		Cylinders: make([]Cylinder, 0),
+	}
+}
+
+// Load reads the environment described in the given JSON file
+func Load(path string) (*Environment, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read environment: %v", err)
+	}
+
+	env := Empty()
+	err = json.Unmarshal(data, env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse environment %s: %v", path, err)
+	}
+	return env, env.check()
+}
+
+// check rejects the obstacles that are not well formed
+func (e *Environment) check() error {
+	if e.Ceiling != nil && *e.Ceiling < e.Floor {
+		return fmt.Errorf("ceiling %f below floor %f", *e.Ceiling, e.Floor)
+	}
+	for i, b := range e.Boxes {
+		if b.Min.X > b.Max.X || b.Min.Y > b.Max.Y || b.Min.Z > b.Max.Z {
+			return fmt.Errorf("box %d: min %v is not below max %v", i, b.Min, b.Max)
+		}
+	}
+	for i, c := range e.Cylinders {
+		if c.Radius < 0 || c.Height < 0 {
+			return fmt.Errorf("cylinder %d: negative radius or height", i)
+		}
+	}
+	return nil
+}
+
+// HasObstacles returns true if the environment holds any obstacle
+func (e *Environment) HasObstacles() bool {
+	return len(e.Boxes) > 0 || len(e.Cylinders) > 0
+}
+
+// BelowFloor returns true if the location is under the floor
+func (e *Environment) BelowFloor(l r3.Vec) bool {
+	return l.Y < e.Floor
+}
+
+// AboveCeiling returns true if the location is over the ceiling
+func (e *Environment) AboveCeiling(l r3.Vec) bool {
+	return e.Ceiling != nil && l.Y > *e.Ceiling
+}
+
+// Contains returns true if the location is inside an obstacle
+func (e *Environment) Contains(l r3.Vec) bool {
+	for _, b := range e.Boxes {
+		if b.contains(l) {
+			return true
+		}
+	}
+	for _, c := range e.Cylinders {
+		if c.contains(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns true if a drone may be at the location
+func (e *Environment) Allowed(l r3.Vec) bool {
+	return !e.BelowFloor(l) && !e.AboveCeiling(l) && !e.Contains(l)
+}
+
+// Crosses returns true if the straight segment from a to b goes through an obstacle
+func (e *Environment) Crosses(a, b r3.Vec) bool {
+	for _, box := range e.Boxes {
+		if box.intersects(a, b) {
+			return true
+		}
+	}
+	for _, c := range e.Cylinders {
+		if c.intersects(a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the corners of the smallest box enclosing every obstacle
+func (e *Environment) Bounds() (r3.Vec, r3.Vec) {
+	min := r3.Vec{X: math.Inf(1), Y: math.Inf(1), Z: math.Inf(1)}
+	max := r3.Vec{X: math.Inf(-1), Y: math.Inf(-1), Z: math.Inf(-1)}
+	extend := func(lo, hi r3.Vec) {
+		min = r3.Vec{X: math.Min(min.X, lo.X), Y: math.Min(min.Y, lo.Y), Z: math.Min(min.Z, lo.Z)}
+		max = r3.Vec{X: math.Max(max.X, hi.X), Y: math.Max(max.Y, hi.Y), Z: math.Max(max.Z, hi.Z)}
+	}
+	for _, b := range e.Boxes {
+		extend(b.Min, b.Max)
+	}
+	for _, c := range e.Cylinders {
+		extend(r3.Vec{X: c.Center.X - c.Radius, Y: c.Center.Y, Z: c.Center.Z - c.Radius},
+			r3.Vec{X: c.Center.X + c.Radius, Y: c.Center.Y + c.Height, Z: c.Center.Z + c.Radius})
+	}
+	return min, max
+}
+
+func (b Box) contains(l r3.Vec) bool {
+	return l.X >= b.Min.X && l.X <= b.Max.X &&
+		l.Y >= b.Min.Y && l.Y <= b.Max.Y &&
+		l.Z >= b.Min.Z && l.Z <= b.Max.Z
+}
+
+// intersects clips the segment against the three slabs of the box
+func (b Box) intersects(from, to r3.Vec) bool {
+	d := to.Sub(from)
+	tMin, tMax := 0.0, 1.0
+	for _, axis := range []struct{ origin, direction, min, max float64 }{
+		{from.X, d.X, b.Min.X, b.Max.X},
+		{from.Y, d.Y, b.Min.Y, b.Max.Y},
+		{from.Z, d.Z, b.Min.Z, b.Max.Z},
+	} {
+		if axis.direction == 0 {
+			if axis.origin < axis.min || axis.origin > axis.max {
+				return false
+			}
+			continue
+		}
+		t0 := (axis.min - axis.origin) / axis.direction
+		t1 := (axis.max - axis.origin) / axis.direction
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tMin, tMax = math.Max(tMin, t0), math.Min(tMax, t1)
+		if tMin > tMax {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Cylinder) contains(l r3.Vec) bool {
+	dx, dz := l.X-c.Center.X, l.Z-c.Center.Z
+	return l.Y >= c.Center.Y && l.Y <= c.Center.Y+c.Height && dx*dx+dz*dz <= c.Radius*c.Radius
+}
+
+// intersects restricts the segment to the altitudes of the cylinder, then
+// looks for its closest point to the axis
+func (c Cylinder) intersects(from, to r3.Vec) bool {
+	d := to.Sub(from)
+	tMin, tMax := 0.0, 1.0
+	if d.Y == 0 {
+		if from.Y < c.Center.Y || from.Y > c.Center.Y+c.Height {
+			return false
+		}
+	} else {
+		t0 := (c.Center.Y - from.Y) / d.Y
+		t1 := (c.Center.Y + c.Height - from.Y) / d.Y
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tMin, tMax = math.Max(tMin, t0), math.Min(tMax, t1)
+		if tMin > tMax {
+			return false
+		}
+	}
+
+	ox, oz := from.X-c.Center.X, from.Z-c.Center.Z
+	t := tMin
+	if horizontal := d.X*d.X + d.Z*d.Z; horizontal > 0 {
+		t = math.Min(math.Max(-(ox*d.X+oz*d.Z)/horizontal, tMin), tMax)
+	}
+	x, z := ox+t*d.X, oz+t*d.Z
+	return x*x+z*z <= c.Radius*c.Radius
+}
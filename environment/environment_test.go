@@ -0,0 +1,68 @@
+package environment
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestEnvironment_box(t *testing.T) {
+	env := Empty()
+	env.Boxes = append(env.Boxes, Box{Min: r3.Vec{X: 1, Y: 0, Z: 1}, Max: r3.Vec{X: 2, Y: 3, Z: 2}})
+
+	require.True(t, env.Contains(r3.Vec{X: 1, Y: 2, Z: 2}))
+	require.False(t, env.Contains(r3.Vec{X: 0, Y: 2, Z: 2}))
+
+	// Through, above and along the box
+	require.True(t, env.Crosses(r3.Vec{X: 0, Y: 1, Z: 1.5}, r3.Vec{X: 3, Y: 1, Z: 1.5}))
+	require.False(t, env.Crosses(r3.Vec{X: 0, Y: 4, Z: 1.5}, r3.Vec{X: 3, Y: 4, Z: 1.5}))
+	require.False(t, env.Crosses(r3.Vec{X: 0, Y: 1, Z: 0}, r3.Vec{X: 3, Y: 1, Z: 0}))
+}
+
+func TestEnvironment_cylinder(t *testing.T) {
+	env := Empty()
+	env.Cylinders = append(env.Cylinders, Cylinder{Center: r3.Vec{X: 0, Y: 0, Z: 0}, Radius: 1, Height: 2})
+
+	require.True(t, env.Contains(r3.Vec{X: 0.5, Y: 1, Z: 0.5}))
+	require.False(t, env.Contains(r3.Vec{X: 1, Y: 1, Z: 1}))
+	require.False(t, env.Contains(r3.Vec{X: 0, Y: 3, Z: 0}))
+
+	require.True(t, env.Crosses(r3.Vec{X: -2, Y: 1, Z: 0}, r3.Vec{X: 2, Y: 1, Z: 0}))
+	require.False(t, env.Crosses(r3.Vec{X: -2, Y: 1, Z: 1.5}, r3.Vec{X: 2, Y: 1, Z: 1.5}))
+	// Diving through the top of the cylinder
+	require.True(t, env.Crosses(r3.Vec{X: 0, Y: 5, Z: 0}, r3.Vec{X: 0, Y: 1.5, Z: 0}))
+	require.False(t, env.Crosses(r3.Vec{X: 0, Y: 5, Z: 0}, r3.Vec{X: 0, Y: 2.5, Z: 0}))
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "environment")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "env.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"Ceiling": 10,
+		"Boxes": [{"Min": {"X": 0, "Y": 0, "Z": 0}, "Max": {"X": 1, "Y": 1, "Z": 1}}],
+		"Cylinders": [{"Center": {"X": 5, "Y": 0, "Z": 5}, "Radius": 1, "Height": 4}]
+	}`), 0644)
+	require.NoError(t, err)
+
+	env, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, env.Boxes, 1)
+	require.Len(t, env.Cylinders, 1)
+	require.True(t, env.AboveCeiling(r3.Vec{X: 0, Y: 11, Z: 0}))
+	require.True(t, env.BelowFloor(r3.Vec{X: 0, Y: -1, Z: 0}))
+	require.False(t, env.Allowed(r3.Vec{X: 5, Y: 2, Z: 5}))
+	require.True(t, env.Allowed(r3.Vec{X: 3, Y: 2, Z: 3}))
+
+	err = ioutil.WriteFile(path, []byte(`{"Floor": 5, "Ceiling": 2}`), 0644)
+	require.NoError(t, err)
+	_, err = Load(path)
+	require.Error(t, err)
+}
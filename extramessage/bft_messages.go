@@ -0,0 +1,70 @@
+package extramessage
+
+import "go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+
+// BFTProposal is the block proposed by the leader of View for the block
+// number Height. Justify is the prepare certificate of the block when it is
+// proposed again after a view change.
+type BFTProposal struct {
+	Height    int
+	View      int
+	Leader    int
+	Value     *blk.BlockContainer
+	Justify   *BFTCertificate
+	Signature []byte
+}
+
+// BFTVote is the signed vote of a participant for the block of hash Hash in
+// the given phase.
+type BFTVote struct {
+	Phase     int
+	Height    int
+	View      int
+	Hash      []byte
+	Voter     int
+	Signature []byte
+}
+
+// BFTCertificate is a quorum certificate, the votes of a quorum of distinct
+// participants for the same block in the same phase and view.
+type BFTCertificate struct {
+	Phase  int
+	Height int
+	View   int
+	Hash   []byte
+	Votes  []BFTVote
+}
+
+// BFTNewView is sent by a participant moving to View, with the prepare
+// certificate of the highest view it knows for Height and its block, if any.
+type BFTNewView struct {
+	Height    int
+	View      int
+	Node      int
+	HighQC    *BFTCertificate
+	Value     *blk.BlockContainer
+	Signature []byte
+}
+
+// BFTForward carries the proposal of a participant to the leader.
+type BFTForward struct {
+	Node      int
+	Value     *blk.BlockContainer
+	Signature []byte
+}
+
+// Copy performs a deep copy of the certificate
+func (c *BFTCertificate) Copy() *BFTCertificate {
+	if c == nil {
+		return nil
+	}
+	certificate := *c
+	certificate.Hash = append([]byte{}, c.Hash...)
+	certificate.Votes = make([]BFTVote, len(c.Votes))
+	for i, vote := range c.Votes {
+		certificate.Votes[i] = vote
+		certificate.Votes[i].Hash = append([]byte{}, vote.Hash...)
+		certificate.Votes[i].Signature = append([]byte{}, vote.Signature...)
+	}
+	return &certificate
+}
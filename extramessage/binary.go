@@ -0,0 +1,495 @@
+package extramessage
+
+import (
+	"fmt"
+
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/cs438/orbitalswarm/wire"
+)
+
+// Tags of the messages in the binary encoding of an extra message, each
+// message set being written after its tag and the list ending with tagEnd
+const (
+	tagEnd = iota
+	tagPaxosPrepare
+	tagPaxosPromise
+	tagPaxosPropose
+	tagPaxosAccept
+	tagPaxosTLC
+	tagSwarmInit
+	tagAuctionBid
+	tagPaxosSyncRequest
+	tagPaxosSyncReply
+	tagMultiPaxosPrepare
+	tagMultiPaxosPromise
+	tagMultiPaxosPropose
+	tagMultiPaxosAccept
+	tagMultiPaxosHeartbeat
+	tagMultiPaxosForward
+	tagRaftRequestVote
+	tagRaftVote
+	tagRaftAppendEntries
+	tagRaftAppendResponse
+	tagRaftInstallSnapshot
+	tagRaftForward
+	tagBFTProposal
+	tagBFTVote
+	tagBFTNewView
+	tagBFTForward
+)
+
+// EncodeBinary writes the extra message in the binary wire format
+func (e *ExtraMessage) EncodeBinary(w *wire.Writer) {
+	if m := e.PaxosPrepare; m != nil {
+		w.Byte(tagPaxosPrepare)
+		w.Int(m.PaxosSeqID)
+		w.Int(m.ID)
+	}
+	if m := e.PaxosPromise; m != nil {
+		w.Byte(tagPaxosPromise)
+		w.Int(m.PaxosSeqID)
+		w.Int(m.IDp)
+		w.Int(m.IDa)
+		m.Value.EncodeBinary(w)
+		w.Int(m.Acceptor)
+	}
+	if m := e.PaxosPropose; m != nil {
+		w.Byte(tagPaxosPropose)
+		w.Int(m.PaxosSeqID)
+		w.Int(m.ID)
+		m.Value.EncodeBinary(w)
+	}
+	if m := e.PaxosAccept; m != nil {
+		w.Byte(tagPaxosAccept)
+		w.Int(m.PaxosSeqID)
+		w.Int(m.ID)
+		m.Value.EncodeBinary(w)
+		w.Int(m.Acceptor)
+	}
+	if m := e.PaxosTLC; m != nil {
+		w.Byte(tagPaxosTLC)
+		m.Value.EncodeBinary(w)
+		w.Int(m.Node)
+	}
+	if m := e.SwarmInit; m != nil {
+		w.Byte(tagSwarmInit)
+		w.String(m.PatternID)
+		w.Vecs(m.InitialPos)
+		w.Vecs(m.TargetPos)
+		w.Uint(m.Sequence)
+		w.Int64(m.Time)
+	}
+	if m := e.AuctionBid; m != nil {
+		w.Byte(tagAuctionBid)
+		w.String(m.AuctionID)
+		w.Int(m.Drone)
+		w.Int(m.Target)
+		w.Float(m.Price)
+	}
+	if m := e.PaxosSyncRequest; m != nil {
+		w.Byte(tagPaxosSyncRequest)
+		w.Int(m.Node)
+		w.Int(m.Height)
+		w.ByteSlice(m.TailHash)
+	}
+	if m := e.PaxosSyncReply; m != nil {
+		w.Byte(tagPaxosSyncReply)
+		w.Int(m.Node)
+		w.Int(m.To)
+		encodeBlocks(w, m.Blocks)
+	}
+	if m := e.MultiPaxosPrepare; m != nil {
+		w.Byte(tagMultiPaxosPrepare)
+		w.Int(m.Ballot)
+		w.Int(m.Slot)
+		w.Int(m.Candidate)
+	}
+	if m := e.MultiPaxosPromise; m != nil {
+		w.Byte(tagMultiPaxosPromise)
+		w.Int(m.Ballot)
+		w.Int(m.Slot)
+		w.Int(m.Acceptor)
+		w.Slice(len(m.Accepted), m.Accepted == nil)
+		for _, accepted := range m.Accepted {
+			w.Bool(accepted != nil)
+			if accepted != nil {
+				encodeMultiPaxosAccept(w, accepted)
+			}
+		}
+	}
+	if m := e.MultiPaxosPropose; m != nil {
+		w.Byte(tagMultiPaxosPropose)
+		w.Int(m.Ballot)
+		w.Int(m.Slot)
+		m.Value.EncodeBinary(w)
+	}
+	if m := e.MultiPaxosAccept; m != nil {
+		w.Byte(tagMultiPaxosAccept)
+		encodeMultiPaxosAccept(w, m)
+	}
+	if m := e.MultiPaxosHeartbeat; m != nil {
+		w.Byte(tagMultiPaxosHeartbeat)
+		w.Int(m.Ballot)
+		w.Int(m.Leader)
+		w.Int(m.Slot)
+	}
+	if m := e.MultiPaxosForward; m != nil {
+		w.Byte(tagMultiPaxosForward)
+		m.Value.EncodeBinary(w)
+	}
+	if m := e.RaftRequestVote; m != nil {
+		w.Byte(tagRaftRequestVote)
+		w.Int(m.Term)
+		w.Int(m.Candidate)
+		w.Int(m.LastIndex)
+		w.Int(m.LastTerm)
+	}
+	if m := e.RaftVote; m != nil {
+		w.Byte(tagRaftVote)
+		w.Int(m.Term)
+		w.Int(m.Voter)
+		w.Int(m.Candidate)
+		w.Bool(m.Granted)
+	}
+	if m := e.RaftAppendEntries; m != nil {
+		w.Byte(tagRaftAppendEntries)
+		w.Int(m.Term)
+		w.Int(m.Leader)
+		w.Int(m.PrevIndex)
+		w.Int(m.PrevTerm)
+		w.Slice(len(m.Entries), m.Entries == nil)
+		for _, entry := range m.Entries {
+			w.Int(entry.Term)
+			entry.Value.EncodeBinary(w)
+		}
+		w.Int(m.Commit)
+	}
+	if m := e.RaftAppendResponse; m != nil {
+		w.Byte(tagRaftAppendResponse)
+		w.Int(m.Term)
+		w.Int(m.Follower)
+		w.Bool(m.Success)
+		w.Int(m.MatchIndex)
+	}
+	if m := e.RaftInstallSnapshot; m != nil {
+		w.Byte(tagRaftInstallSnapshot)
+		w.Int(m.Term)
+		w.Int(m.Leader)
+		w.Int(m.LastIndex)
+		w.Int(m.LastTerm)
+		encodeBlocks(w, m.Blocks)
+	}
+	if m := e.RaftForward; m != nil {
+		w.Byte(tagRaftForward)
+		m.Value.EncodeBinary(w)
+	}
+	if m := e.BFTProposal; m != nil {
+		w.Byte(tagBFTProposal)
+		w.Int(m.Height)
+		w.Int(m.View)
+		w.Int(m.Leader)
+		m.Value.EncodeBinary(w)
+		encodeCertificate(w, m.Justify)
+		w.ByteSlice(m.Signature)
+	}
+	if m := e.BFTVote; m != nil {
+		w.Byte(tagBFTVote)
+		encodeVote(w, m)
+	}
+	if m := e.BFTNewView; m != nil {
+		w.Byte(tagBFTNewView)
+		w.Int(m.Height)
+		w.Int(m.View)
+		w.Int(m.Node)
+		encodeCertificate(w, m.HighQC)
+		m.Value.EncodeBinary(w)
+		w.ByteSlice(m.Signature)
+	}
+	if m := e.BFTForward; m != nil {
+		w.Byte(tagBFTForward)
+		w.Int(m.Node)
+		m.Value.EncodeBinary(w)
+		w.ByteSlice(m.Signature)
+	}
+	w.Byte(tagEnd)
+}
+
+func encodeBlocks(w *wire.Writer, blocks []*blk.BlockContainer) {
+	w.Slice(len(blocks), blocks == nil)
+	for _, block := range blocks {
+		block.EncodeBinary(w)
+	}
+}
+
+func encodeMultiPaxosAccept(w *wire.Writer, m *MultiPaxosAccept) {
+	w.Int(m.Ballot)
+	w.Int(m.Slot)
+	w.Int(m.Acceptor)
+	m.Value.EncodeBinary(w)
+}
+
+func encodeVote(w *wire.Writer, m *BFTVote) {
+	w.Int(m.Phase)
+	w.Int(m.Height)
+	w.Int(m.View)
+	w.ByteSlice(m.Hash)
+	w.Int(m.Voter)
+	w.ByteSlice(m.Signature)
+}
+
+func encodeCertificate(w *wire.Writer, c *BFTCertificate) {
+	w.Bool(c != nil)
+	if c == nil {
+		return
+	}
+	w.Int(c.Phase)
+	w.Int(c.Height)
+	w.Int(c.View)
+	w.ByteSlice(c.Hash)
+	w.Slice(len(c.Votes), c.Votes == nil)
+	for i := range c.Votes {
+		encodeVote(w, &c.Votes[i])
+	}
+}
+
+// DecodeExtraMessage reads an extra message written by EncodeBinary
+func DecodeExtraMessage(r *wire.Reader) *ExtraMessage {
+	e := &ExtraMessage{}
+	for r.Err() == nil {
+		tag := r.Byte()
+		switch tag {
+		case tagEnd:
+			return e
+		case tagPaxosPrepare:
+			e.PaxosPrepare = &PaxosPrepare{
+				PaxosSeqID: r.Int(),
+				ID:         r.Int(),
+			}
+		case tagPaxosPromise:
+			e.PaxosPromise = &PaxosPromise{
+				PaxosSeqID: r.Int(),
+				IDp:        r.Int(),
+				IDa:        r.Int(),
+				Value:      blk.DecodeBlockContainer(r),
+				Acceptor:   r.Int(),
+			}
+		case tagPaxosPropose:
+			e.PaxosPropose = &PaxosPropose{
+				PaxosSeqID: r.Int(),
+				ID:         r.Int(),
+				Value:      blk.DecodeBlockContainer(r),
+			}
+		case tagPaxosAccept:
+			e.PaxosAccept = &PaxosAccept{
+				PaxosSeqID: r.Int(),
+				ID:         r.Int(),
+				Value:      blk.DecodeBlockContainer(r),
+				Acceptor:   r.Int(),
+			}
+		case tagPaxosTLC:
+			e.PaxosTLC = &PaxosTLC{
+				Value: blk.DecodeBlockContainer(r),
+				Node:  r.Int(),
+			}
+		case tagSwarmInit:
+			e.SwarmInit = &SwarmInit{
+				PatternID:  r.String(),
+				InitialPos: r.Vecs(),
+				TargetPos:  r.Vecs(),
+				Sequence:   r.Uint(),
+				Time:       r.Int64(),
+			}
+		case tagAuctionBid:
+			e.AuctionBid = &AuctionBid{
+				AuctionID: r.String(),
+				Drone:     r.Int(),
+				Target:    r.Int(),
+				Price:     r.Float(),
+			}
+		case tagPaxosSyncRequest:
+			e.PaxosSyncRequest = &PaxosSyncRequest{
+				Node:     r.Int(),
+				Height:   r.Int(),
+				TailHash: r.ByteSlice(),
+			}
+		case tagPaxosSyncReply:
+			e.PaxosSyncReply = &PaxosSyncReply{
+				Node:   r.Int(),
+				To:     r.Int(),
+				Blocks: decodeBlocks(r),
+			}
+		case tagMultiPaxosPrepare:
+			e.MultiPaxosPrepare = &MultiPaxosPrepare{
+				Ballot:    r.Int(),
+				Slot:      r.Int(),
+				Candidate: r.Int(),
+			}
+		case tagMultiPaxosPromise:
+			m := &MultiPaxosPromise{
+				Ballot:   r.Int(),
+				Slot:     r.Int(),
+				Acceptor: r.Int(),
+			}
+			length, isNil := r.Slice(1)
+			if !isNil {
+				m.Accepted = make([]*MultiPaxosAccept, length)
+				for i := range m.Accepted {
+					if r.Bool() {
+						m.Accepted[i] = decodeMultiPaxosAccept(r)
+					}
+				}
+			}
+			e.MultiPaxosPromise = m
+		case tagMultiPaxosPropose:
+			e.MultiPaxosPropose = &MultiPaxosPropose{
+				Ballot: r.Int(),
+				Slot:   r.Int(),
+				Value:  blk.DecodeBlockContainer(r),
+			}
+		case tagMultiPaxosAccept:
+			e.MultiPaxosAccept = decodeMultiPaxosAccept(r)
+		case tagMultiPaxosHeartbeat:
+			e.MultiPaxosHeartbeat = &MultiPaxosHeartbeat{
+				Ballot: r.Int(),
+				Leader: r.Int(),
+				Slot:   r.Int(),
+			}
+		case tagMultiPaxosForward:
+			e.MultiPaxosForward = &MultiPaxosForward{
+				Value: blk.DecodeBlockContainer(r),
+			}
+		case tagRaftRequestVote:
+			e.RaftRequestVote = &RaftRequestVote{
+				Term:      r.Int(),
+				Candidate: r.Int(),
+				LastIndex: r.Int(),
+				LastTerm:  r.Int(),
+			}
+		case tagRaftVote:
+			e.RaftVote = &RaftVote{
+				Term:      r.Int(),
+				Voter:     r.Int(),
+				Candidate: r.Int(),
+				Granted:   r.Bool(),
+			}
+		case tagRaftAppendEntries:
+			m := &RaftAppendEntries{
+				Term:      r.Int(),
+				Leader:    r.Int(),
+				PrevIndex: r.Int(),
+				PrevTerm:  r.Int(),
+			}
+			length, isNil := r.Slice(2)
+			if !isNil {
+				m.Entries = make([]RaftEntry, length)
+				for i := range m.Entries {
+					m.Entries[i].Term = r.Int()
+					m.Entries[i].Value = blk.DecodeBlockContainer(r)
+				}
+			}
+			m.Commit = r.Int()
+			e.RaftAppendEntries = m
+		case tagRaftAppendResponse:
+			e.RaftAppendResponse = &RaftAppendResponse{
+				Term:       r.Int(),
+				Follower:   r.Int(),
+				Success:    r.Bool(),
+				MatchIndex: r.Int(),
+			}
+		case tagRaftInstallSnapshot:
+			e.RaftInstallSnapshot = &RaftInstallSnapshot{
+				Term:      r.Int(),
+				Leader:    r.Int(),
+				LastIndex: r.Int(),
+				LastTerm:  r.Int(),
+				Blocks:    decodeBlocks(r),
+			}
+		case tagRaftForward:
+			e.RaftForward = &RaftForward{
+				Value: blk.DecodeBlockContainer(r),
+			}
+		case tagBFTProposal:
+			e.BFTProposal = &BFTProposal{
+				Height:    r.Int(),
+				View:      r.Int(),
+				Leader:    r.Int(),
+				Value:     blk.DecodeBlockContainer(r),
+				Justify:   decodeCertificate(r),
+				Signature: r.ByteSlice(),
+			}
+		case tagBFTVote:
+			vote := decodeVote(r)
+			e.BFTVote = &vote
+		case tagBFTNewView:
+			e.BFTNewView = &BFTNewView{
+				Height:    r.Int(),
+				View:      r.Int(),
+				Node:      r.Int(),
+				HighQC:    decodeCertificate(r),
+				Value:     blk.DecodeBlockContainer(r),
+				Signature: r.ByteSlice(),
+			}
+		case tagBFTForward:
+			e.BFTForward = &BFTForward{
+				Node:      r.Int(),
+				Value:     blk.DecodeBlockContainer(r),
+				Signature: r.ByteSlice(),
+			}
+		default:
+			r.Fail(fmt.Errorf("unknown extra message tag %d", tag))
+		}
+	}
+	return nil
+}
+
+func decodeBlocks(r *wire.Reader) []*blk.BlockContainer {
+	length, isNil := r.Slice(1)
+	if isNil {
+		return nil
+	}
+	blocks := make([]*blk.BlockContainer, length)
+	for i := range blocks {
+		blocks[i] = blk.DecodeBlockContainer(r)
+	}
+	return blocks
+}
+
+func decodeMultiPaxosAccept(r *wire.Reader) *MultiPaxosAccept {
+	return &MultiPaxosAccept{
+		Ballot:   r.Int(),
+		Slot:     r.Int(),
+		Acceptor: r.Int(),
+		Value:    blk.DecodeBlockContainer(r),
+	}
+}
+
+func decodeVote(r *wire.Reader) BFTVote {
+	return BFTVote{
+		Phase:     r.Int(),
+		Height:    r.Int(),
+		View:      r.Int(),
+		Hash:      r.ByteSlice(),
+		Voter:     r.Int(),
+		Signature: r.ByteSlice(),
+	}
+}
+
+func decodeCertificate(r *wire.Reader) *BFTCertificate {
+	if !r.Bool() {
+		return nil
+	}
+	c := &BFTCertificate{
+		Phase:  r.Int(),
+		Height: r.Int(),
+		View:   r.Int(),
+		Hash:   r.ByteSlice(),
+	}
+	length, isNil := r.Slice(6)
+	if !isNil {
+		c.Votes = make([]BFTVote, length)
+		for i := range c.Votes {
+			c.Votes[i] = decodeVote(r)
+		}
+	}
+	return c
+}
@@ -1,5 +1,7 @@
 package extramessage
 
+import "go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+
 // Paxos messages. Feel free to move that in a separate file and/or package.
 
 // ExtraMessage is carried by a rumor message.
@@ -10,6 +12,29 @@ type ExtraMessage struct {
 	PaxosAccept  *PaxosAccept
 	PaxosTLC     *PaxosTLC
 	SwarmInit    *SwarmInit
+	AuctionBid   *AuctionBid
+
+	PaxosSyncRequest *PaxosSyncRequest
+	PaxosSyncReply   *PaxosSyncReply
+
+	MultiPaxosPrepare   *MultiPaxosPrepare
+	MultiPaxosPromise   *MultiPaxosPromise
+	MultiPaxosPropose   *MultiPaxosPropose
+	MultiPaxosAccept    *MultiPaxosAccept
+	MultiPaxosHeartbeat *MultiPaxosHeartbeat
+	MultiPaxosForward   *MultiPaxosForward
+
+	RaftRequestVote     *RaftRequestVote
+	RaftVote            *RaftVote
+	RaftAppendEntries   *RaftAppendEntries
+	RaftAppendResponse  *RaftAppendResponse
+	RaftInstallSnapshot *RaftInstallSnapshot
+	RaftForward         *RaftForward
+
+	BFTProposal *BFTProposal
+	BFTVote     *BFTVote
+	BFTNewView  *BFTNewView
+	BFTForward  *BFTForward
 }
 
 // Copy performs a deep copy of extra message
@@ -20,6 +45,25 @@ func (e *ExtraMessage) Copy() *ExtraMessage {
 	var paxosAccept *PaxosAccept
 	var paxosTLC *PaxosTLC
 	var swarmInit *SwarmInit
+	var auctionBid *AuctionBid
+	var paxosSyncRequest *PaxosSyncRequest
+	var paxosSyncReply *PaxosSyncReply
+	var multiPaxosPrepare *MultiPaxosPrepare
+	var multiPaxosPromise *MultiPaxosPromise
+	var multiPaxosPropose *MultiPaxosPropose
+	var multiPaxosAccept *MultiPaxosAccept
+	var multiPaxosHeartbeat *MultiPaxosHeartbeat
+	var multiPaxosForward *MultiPaxosForward
+	var raftRequestVote *RaftRequestVote
+	var raftVote *RaftVote
+	var raftAppendEntries *RaftAppendEntries
+	var raftAppendResponse *RaftAppendResponse
+	var raftInstallSnapshot *RaftInstallSnapshot
+	var raftForward *RaftForward
+	var bftProposal *BFTProposal
+	var bftVote *BFTVote
+	var bftNewView *BFTNewView
+	var bftForward *BFTForward
 
 	if e.PaxosPrepare != nil {
 		paxosPrepare = new(PaxosPrepare)
@@ -33,6 +77,7 @@ func (e *ExtraMessage) Copy() *ExtraMessage {
 		paxosPromise.IDp = e.PaxosPromise.IDp
 		paxosPromise.IDa = e.PaxosPromise.IDa
 		paxosPromise.Value = (e.PaxosPromise.Value.Copy())
+		paxosPromise.Acceptor = e.PaxosPromise.Acceptor
 	}
 
 	if e.PaxosPropose != nil {
@@ -47,11 +92,13 @@ func (e *ExtraMessage) Copy() *ExtraMessage {
 		paxosAccept.PaxosSeqID = e.PaxosAccept.PaxosSeqID
 		paxosAccept.ID = e.PaxosAccept.ID
 		paxosAccept.Value = (e.PaxosAccept.Value.Copy())
+		paxosAccept.Acceptor = e.PaxosAccept.Acceptor
 	}
 
 	if e.PaxosTLC != nil {
 		paxosTLC = new(PaxosTLC)
 		paxosTLC.Value = e.PaxosTLC.Value.Copy()
+		paxosTLC.Node = e.PaxosTLC.Node
 	}
 
 	if e.SwarmInit != nil {
@@ -59,6 +106,146 @@ func (e *ExtraMessage) Copy() *ExtraMessage {
 		swarmInit.PatternID = e.SwarmInit.PatternID
 		swarmInit.InitialPos = append(swarmInit.InitialPos, e.SwarmInit.InitialPos...)
 		swarmInit.TargetPos = append(swarmInit.TargetPos, e.SwarmInit.TargetPos...)
+		swarmInit.Sequence = e.SwarmInit.Sequence
+		swarmInit.Time = e.SwarmInit.Time
+	}
+
+	if e.AuctionBid != nil {
+		auctionBid = new(AuctionBid)
+		*auctionBid = *e.AuctionBid
+	}
+
+	if e.PaxosSyncRequest != nil {
+		paxosSyncRequest = new(PaxosSyncRequest)
+		*paxosSyncRequest = *e.PaxosSyncRequest
+		paxosSyncRequest.TailHash = append([]byte{}, e.PaxosSyncRequest.TailHash...)
+	}
+
+	if e.PaxosSyncReply != nil {
+		paxosSyncReply = new(PaxosSyncReply)
+		*paxosSyncReply = *e.PaxosSyncReply
+		paxosSyncReply.Blocks = make([]*blk.BlockContainer, len(e.PaxosSyncReply.Blocks))
+		for i, block := range e.PaxosSyncReply.Blocks {
+			paxosSyncReply.Blocks[i] = block.Copy()
+		}
+	}
+
+	if e.MultiPaxosPrepare != nil {
+		multiPaxosPrepare = new(MultiPaxosPrepare)
+		*multiPaxosPrepare = *e.MultiPaxosPrepare
+	}
+
+	if e.MultiPaxosPromise != nil {
+		multiPaxosPromise = new(MultiPaxosPromise)
+		*multiPaxosPromise = *e.MultiPaxosPromise
+		multiPaxosPromise.Accepted = make([]*MultiPaxosAccept, len(e.MultiPaxosPromise.Accepted))
+		for i, accepted := range e.MultiPaxosPromise.Accepted {
+			multiPaxosPromise.Accepted[i] = &MultiPaxosAccept{
+				Ballot:   accepted.Ballot,
+				Slot:     accepted.Slot,
+				Acceptor: accepted.Acceptor,
+				Value:    accepted.Value.Copy(),
+			}
+		}
+	}
+
+	if e.MultiPaxosPropose != nil {
+		multiPaxosPropose = new(MultiPaxosPropose)
+		*multiPaxosPropose = *e.MultiPaxosPropose
+		multiPaxosPropose.Value = e.MultiPaxosPropose.Value.Copy()
+	}
+
+	if e.MultiPaxosAccept != nil {
+		multiPaxosAccept = new(MultiPaxosAccept)
+		*multiPaxosAccept = *e.MultiPaxosAccept
+		multiPaxosAccept.Value = e.MultiPaxosAccept.Value.Copy()
+	}
+
+	if e.MultiPaxosHeartbeat != nil {
+		multiPaxosHeartbeat = new(MultiPaxosHeartbeat)
+		*multiPaxosHeartbeat = *e.MultiPaxosHeartbeat
+	}
+
+	if e.MultiPaxosForward != nil {
+		multiPaxosForward = new(MultiPaxosForward)
+		multiPaxosForward.Value = e.MultiPaxosForward.Value.Copy()
+	}
+
+	if e.RaftRequestVote != nil {
+		raftRequestVote = new(RaftRequestVote)
+		*raftRequestVote = *e.RaftRequestVote
+	}
+
+	if e.RaftVote != nil {
+		raftVote = new(RaftVote)
+		*raftVote = *e.RaftVote
+	}
+
+	if e.RaftAppendEntries != nil {
+		raftAppendEntries = new(RaftAppendEntries)
+		*raftAppendEntries = *e.RaftAppendEntries
+		raftAppendEntries.Entries = make([]RaftEntry, len(e.RaftAppendEntries.Entries))
+		for i, entry := range e.RaftAppendEntries.Entries {
+			raftAppendEntries.Entries[i] = RaftEntry{
+				Term:  entry.Term,
+				Value: entry.Value.Copy(),
+			}
+		}
+	}
+
+	if e.RaftAppendResponse != nil {
+		raftAppendResponse = new(RaftAppendResponse)
+		*raftAppendResponse = *e.RaftAppendResponse
+	}
+
+	if e.RaftInstallSnapshot != nil {
+		raftInstallSnapshot = new(RaftInstallSnapshot)
+		*raftInstallSnapshot = *e.RaftInstallSnapshot
+		raftInstallSnapshot.Blocks = make([]*blk.BlockContainer, len(e.RaftInstallSnapshot.Blocks))
+		for i, block := range e.RaftInstallSnapshot.Blocks {
+			raftInstallSnapshot.Blocks[i] = block.Copy()
+		}
+	}
+
+	if e.RaftForward != nil {
+		raftForward = new(RaftForward)
+		raftForward.Value = e.RaftForward.Value.Copy()
+	}
+
+	if e.BFTProposal != nil {
+		bftProposal = new(BFTProposal)
+		*bftProposal = *e.BFTProposal
+		if e.BFTProposal.Value != nil {
+			bftProposal.Value = e.BFTProposal.Value.Copy()
+		}
+		bftProposal.Justify = e.BFTProposal.Justify.Copy()
+		bftProposal.Signature = append([]byte{}, e.BFTProposal.Signature...)
+	}
+
+	if e.BFTVote != nil {
+		bftVote = new(BFTVote)
+		*bftVote = *e.BFTVote
+		bftVote.Hash = append([]byte{}, e.BFTVote.Hash...)
+		bftVote.Signature = append([]byte{}, e.BFTVote.Signature...)
+	}
+
+	if e.BFTNewView != nil {
+		bftNewView = new(BFTNewView)
+		*bftNewView = *e.BFTNewView
+		bftNewView.HighQC = e.BFTNewView.HighQC.Copy()
+		if e.BFTNewView.Value != nil {
+			bftNewView.Value = e.BFTNewView.Value.Copy()
+		}
+		bftNewView.Signature = append([]byte{}, e.BFTNewView.Signature...)
+	}
+
+	if e.BFTForward != nil {
+		bftForward = new(BFTForward)
+		*bftForward = *e.BFTForward
+		if e.BFTForward.Value != nil {
+			bftForward.Value = e.BFTForward.Value.Copy()
+		}
+		bftForward.Signature = append([]byte{}, e.BFTForward.Signature...)
 	}
 
 	return &ExtraMessage{
@@ -68,5 +255,28 @@ func (e *ExtraMessage) Copy() *ExtraMessage {
 		PaxosAccept:  paxosAccept,
 		PaxosTLC:     paxosTLC,
 		SwarmInit:    swarmInit,
+		AuctionBid:   auctionBid,
+
+		PaxosSyncRequest: paxosSyncRequest,
+		PaxosSyncReply:   paxosSyncReply,
+
+		MultiPaxosPrepare:   multiPaxosPrepare,
+		MultiPaxosPromise:   multiPaxosPromise,
+		MultiPaxosPropose:   multiPaxosPropose,
+		MultiPaxosAccept:    multiPaxosAccept,
+		MultiPaxosHeartbeat: multiPaxosHeartbeat,
+		MultiPaxosForward:   multiPaxosForward,
+
+		RaftRequestVote:     raftRequestVote,
+		RaftVote:            raftVote,
+		RaftAppendEntries:   raftAppendEntries,
+		RaftAppendResponse:  raftAppendResponse,
+		RaftInstallSnapshot: raftInstallSnapshot,
+		RaftForward:         raftForward,
+
+		BFTProposal: bftProposal,
+		BFTVote:     bftVote,
+		BFTNewView:  bftNewView,
+		BFTForward:  bftForward,
 	}
 }
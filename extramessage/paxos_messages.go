@@ -9,16 +9,16 @@ type PaxosPrepare struct {
 }
 
 // PaxosPromise describes a PROMISE request made by an acceptor to a proposer.
-// IDp is the ID the proposer sent. IDa is the highest ID the acceptor saw and
-// Value is the value it commits to, if any.
-// Value/ID
+// IDp is the ID the proposer sent. IDa is the ID of the value the acceptor
+// accepted and Value is this value, if any. Acceptor is the index of the
+// acceptor.
 type PaxosPromise struct {
 	PaxosSeqID int
 	IDp        int
 
-	IDa   int
-	Value *blk.BlockContainer
-	//Value blk.Block
+	IDa      int
+	Value    *blk.BlockContainer
+	Acceptor int
 }
 
 // PaxosPropose describes a PROPOSE request made by a proposer to an ACCEPTOR.
@@ -30,16 +30,84 @@ type PaxosPropose struct {
 }
 
 // PaxosAccept describes an ACCEPT request that is sent by an acceptor to its
-// proposer and all the learners.
+// proposer and all the learners. Acceptor is the index of the acceptor.
 type PaxosAccept struct {
 	PaxosSeqID int
 	ID         int
 
-	Value *blk.BlockContainer
+	Value    *blk.BlockContainer
+	Acceptor int
 }
 
 // PaxosTLC is the message sent by a node when it knows consensus has been reached
-// for that block.
+// for that block. Node is the index of the sender.
 type PaxosTLC struct {
 	Value *blk.BlockContainer
+	Node  int
+}
+
+// PaxosSyncRequest is sent by a node which may lag behind, to get the blocks
+// following its chain. Height is the number of blocks it has and TailHash the
+// hash of the last one.
+type PaxosSyncRequest struct {
+	Node     int
+	Height   int
+	TailHash []byte
+}
+
+// PaxosSyncReply answers the PaxosSyncRequest of the node To with consecutive
+// blocks following its chain. Node is the index of the sender.
+type PaxosSyncReply struct {
+	Node   int
+	To     int
+	Blocks []*blk.BlockContainer
+}
+
+// MultiPaxosPrepare is sent by a candidate to become the leader of all the
+// slots from Slot onward with the given ballot.
+type MultiPaxosPrepare struct {
+	Ballot    int
+	Slot      int
+	Candidate int
+}
+
+// MultiPaxosPromise is the answer of an acceptor to a MultiPaxosPrepare.
+// Accepted holds the values it accepted for Slot and the following slots.
+type MultiPaxosPromise struct {
+	Ballot   int
+	Slot     int
+	Acceptor int
+
+	Accepted []*MultiPaxosAccept
+}
+
+// MultiPaxosPropose is sent by the leader to the acceptors for a slot, phase 1
+// being skipped as long as the leader keeps its ballot.
+type MultiPaxosPropose struct {
+	Ballot int
+	Slot   int
+
+	Value *blk.BlockContainer
+}
+
+// MultiPaxosAccept is sent by an acceptor to all the learners.
+type MultiPaxosAccept struct {
+	Ballot   int
+	Slot     int
+	Acceptor int
+
+	Value *blk.BlockContainer
+}
+
+// MultiPaxosHeartbeat is sent periodically by the leader to renew its lease.
+// Slot is the next slot the leader will decide.
+type MultiPaxosHeartbeat struct {
+	Ballot int
+	Leader int
+	Slot   int
+}
+
+// MultiPaxosForward carries the proposal of a node to the leader.
+type MultiPaxosForward struct {
+	Value *blk.BlockContainer
 }
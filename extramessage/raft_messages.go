@@ -0,0 +1,62 @@
+package extramessage
+
+import "go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+
+// RaftEntry is an entry of the Raft log, the block at its index in the chain.
+type RaftEntry struct {
+	Term  int
+	Value *blk.BlockContainer
+}
+
+// RaftRequestVote is sent by a candidate to get elected for Term.
+type RaftRequestVote struct {
+	Term      int
+	Candidate int
+	LastIndex int
+	LastTerm  int
+}
+
+// RaftVote is the answer of a node to a RaftRequestVote.
+type RaftVote struct {
+	Term      int
+	Voter     int
+	Candidate int
+	Granted   bool
+}
+
+// RaftAppendEntries replicates the entries following PrevIndex, it is sent
+// periodically by the leader as a heartbeat.
+type RaftAppendEntries struct {
+	Term      int
+	Leader    int
+	PrevIndex int
+	PrevTerm  int
+	Entries   []RaftEntry
+	Commit    int
+}
+
+// RaftAppendResponse is the answer of a follower to a RaftAppendEntries or a
+// RaftInstallSnapshot. MatchIndex is the last index known to match the log
+// of the leader on success, and a hint for the next index to send otherwise.
+type RaftAppendResponse struct {
+	Term       int
+	Follower   int
+	Success    bool
+	MatchIndex int
+}
+
+// RaftInstallSnapshot replaces the log of a follower lagging behind the
+// compacted log of the leader by the committed blocks up to LastIndex, from
+// the last snapshot block or the genesis block.
+type RaftInstallSnapshot struct {
+	Term      int
+	Leader    int
+	LastIndex int
+	LastTerm  int
+	Blocks    []*blk.BlockContainer
+}
+
+// RaftForward carries the proposal of a node to the leader.
+type RaftForward struct {
+	Value *blk.BlockContainer
+}
@@ -5,8 +5,23 @@ import (
 )
 
 // SwarmInit initiates the mapping phase for the swarm. It carries the initials positions of the drones and the target positions. The two list should have the same length.
+//
+// Sequence increases with each command of the ground station and Time is
+// when it was sent, in nanoseconds since the epoch, so that the drones reject
+// the commands replayed or delivered too late.
 type SwarmInit struct {
 	PatternID  string
 	InitialPos []r3.Vec
 	TargetPos  []r3.Vec
+	Sequence   uint64
+	Time       int64
+}
+
+// AuctionBid is the bid of a drone for a target during a distributed auction.
+// AuctionID identifies the mapping the bid belongs to.
+type AuctionBid struct {
+	AuctionID string
+	Drone     int
+	Target    int
+	Price     float64
 }
@@ -0,0 +1,156 @@
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/wire"
+)
+
+// wireVersion is the version of the binary encoding of the packets, written
+// as their first byte. A gossiper advertises it in its status packets and
+// sends the binary encoding to the peers advertising it, the JSON encoding,
+// which starts with '{', to the others, so that the nodes only knowing JSON
+// keep working.
+const wireVersion = 1
+
+// peerVersion returns the version of the binary encoding the peer decodes,
+// 0 if it only decodes JSON
+func (g *Gossiper) peerVersion(addr string) int {
+	version, found := g.versions.Load(addr)
+	if !found {
+		return 0
+	}
+	return version.(int)
+}
+
+// setPeerVersion records the version of the binary encoding of the peer
+func (g *Gossiper) setPeerVersion(addr *net.UDPAddr, version int) {
+	if addr == g.server.Address {
+		return
+	}
+	g.versions.Store(addr.String(), version)
+}
+
+// encodePacket encodes the packet in the binary format of the version, or in
+// JSON for version 0
+func (g *Gossiper) encodePacket(packet GossipPacket, version int) ([]byte, error) {
+	if version < wireVersion {
+		return json.Marshal(packet)
+	}
+	w := wire.NewWriter()
+	w.Byte(wireVersion)
+	packet.EncodeBinary(w)
+	return w.Data(), nil
+}
+
+// unmarshalPacket decodes a packet received in either encoding
+func (g *Gossiper) unmarshalPacket(data []byte, addr *net.UDPAddr) (*GossipPacket, error) {
+	var packet GossipPacket
+	if len(data) > 0 && data[0] == '{' {
+		err := json.Unmarshal(data, &packet)
+		if err != nil {
+			return nil, err
+		}
+		return &packet, nil
+	}
+	if len(data) == 0 || data[0] != wireVersion {
+		return nil, fmt.Errorf("unknown packet encoding")
+	}
+	r := wire.NewReader(data[1:])
+	packet = DecodeGossipPacket(r)
+	if r.Err() == nil && r.Remaining() > 0 {
+		r.Fail(fmt.Errorf("%d bytes after the packet", r.Remaining()))
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	g.setPeerVersion(addr, wireVersion)
+	return &packet, nil
+}
+
+// EncodeBinary writes the packet in the binary wire format
+func (g GossipPacket) EncodeBinary(w *wire.Writer) {
+	w.Bool(g.Rumor != nil)
+	if msg := g.Rumor; msg != nil {
+		w.String(msg.Origin)
+		w.Uint(uint64(msg.ID))
+		w.String(msg.Text)
+		w.Bool(msg.Extra != nil)
+		if msg.Extra != nil {
+			msg.Extra.EncodeBinary(w)
+		}
+		w.ByteSlice(msg.Signature)
+	}
+
+	w.Bool(g.Status != nil)
+	if msg := g.Status; msg != nil {
+		w.Slice(len(msg.Want), msg.Want == nil)
+		for _, peer := range msg.Want {
+			w.String(peer.Identifier)
+			w.Uint(uint64(peer.NextID))
+		}
+		w.Int(msg.Version)
+	}
+
+	w.Bool(g.Private != nil)
+	if msg := g.Private; msg != nil {
+		w.String(msg.Origin)
+		w.Uint(uint64(msg.ID))
+		w.Vec(msg.Data.Location)
+		w.Uint(uint64(msg.Data.DroneID))
+		w.String(msg.Destination)
+		w.Int(msg.HopLimit)
+		w.ByteSlice(msg.Signature)
+	}
+}
+
+// DecodeGossipPacket reads a packet written by EncodeBinary
+func DecodeGossipPacket(r *wire.Reader) GossipPacket {
+	var packet GossipPacket
+
+	if r.Bool() {
+		msg := &RumorMessage{
+			Origin: r.String(),
+			ID:     uint32(r.Uint()),
+			Text:   r.String(),
+		}
+		if r.Bool() {
+			msg.Extra = extramessage.DecodeExtraMessage(r)
+		}
+		msg.Signature = r.ByteSlice()
+		packet.Rumor = msg
+	}
+
+	if r.Bool() {
+		msg := &StatusPacket{}
+		length, isNil := r.Slice(2)
+		if !isNil {
+			msg.Want = make([]PeerStatus, length)
+			for i := range msg.Want {
+				msg.Want[i].Identifier = r.String()
+				msg.Want[i].NextID = uint32(r.Uint())
+			}
+		}
+		msg.Version = r.Int()
+		packet.Status = msg
+	}
+
+	if r.Bool() {
+		packet.Private = &PrivateMessage{
+			Origin: r.String(),
+			ID:     uint32(r.Uint()),
+			Data: PrivateMessageData{
+				Location: r.Vec(),
+				DroneID:  uint32(r.Uint()),
+			},
+			Destination: r.String(),
+			HopLimit:    r.Int(),
+			Signature:   r.ByteSlice(),
+		}
+	}
+
+	return packet
+}
@@ -0,0 +1,292 @@
+package gossip
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
+	"go.dedis.ch/cs438/orbitalswarm/wire"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// pathBlock returns a path block for the given number of drones, each
+// flying the given number of steps with its trajectory
+func pathBlock(drones, steps int) *blk.BlockContainer {
+	content := &blk.PathBlockContent{
+		PatternID:    "pattern",
+		Paths:        make([][]r3.Vec, drones),
+		Trajectories: make([]trajectory.Trajectory, drones),
+	}
+	for i := range content.Paths {
+		start := r3.Vec{X: float64(i), Y: 0, Z: 10}
+		for j := 0; j < steps; j++ {
+			content.Paths[i] = append(content.Paths[i], r3.Vec{X: float64(j % 2), Y: 1, Z: 0})
+		}
+		content.Trajectories[i] = trajectory.FromMoves(start, content.Paths[i], 1,
+			trajectory.DefaultLimits, trajectory.TrapezoidalProfile, false)
+	}
+	return blk.NewGenericBlockFactory().NewBlock(blk.BlockPathStr, 3, make([]byte, 32), content)
+}
+
+func testPackets() []GossipPacket {
+	factory := blk.NewGenericBlockFactory()
+	mapping := factory.NewGenesisBlock(blk.BlockMappingStr, 0, &blk.MappingBlockContent{
+		PatternID: "pattern",
+		Targets:   []r3.Vec{{X: 1, Y: 2, Z: 3}, {X: -1.5, Y: 0, Z: 1e9}},
+	})
+	naming := factory.NewBlock(blk.BlockNamingStr, 1, []byte{}, &blk.NamingBlockContent{
+		Filename: "file",
+		Metahash: []byte{1, 2, 3},
+	})
+	snapshot := factory.NewBlock(blk.BlockSnapshotStr, 8, []byte{4}, &blk.SnapshotBlockContent{
+		PatternID: "pattern",
+		Positions: []r3.Vec{},
+	})
+	withoutContent := factory.NewBlock(blk.BlockPathStr, 2, nil, nil)
+	certificate := &extramessage.BFTCertificate{
+		Phase:  1,
+		Height: 2,
+		View:   -1,
+		Hash:   []byte{5},
+		Votes:  []extramessage.BFTVote{{Phase: 1, Voter: 2, Hash: []byte{5}}},
+	}
+
+	return []GossipPacket{
+		{Rumor: &RumorMessage{Origin: "drone0", ID: 1}},
+		{Rumor: &RumorMessage{Origin: "GS", ID: 2, Text: "text", Signature: []byte{}}},
+		{Rumor: &RumorMessage{
+			Origin: "drone1",
+			ID:     3,
+			Extra: &extramessage.ExtraMessage{
+				PaxosPrepare: &extramessage.PaxosPrepare{PaxosSeqID: 1, ID: -2},
+				PaxosPromise: &extramessage.PaxosPromise{IDp: 1, IDa: 2, Value: mapping, Acceptor: 3},
+				PaxosPropose: &extramessage.PaxosPropose{Value: factory.NewEmptyBlock()},
+				PaxosAccept:  &extramessage.PaxosAccept{ID: 4, Value: withoutContent},
+				PaxosTLC:     &extramessage.PaxosTLC{Value: pathBlock(3, 4), Node: 2},
+				SwarmInit: &extramessage.SwarmInit{
+					PatternID:  "pattern",
+					InitialPos: []r3.Vec{{X: 1}},
+					Sequence:   7,
+					Time:       1 << 60,
+				},
+				AuctionBid:       &extramessage.AuctionBid{AuctionID: "auction", Price: 0.5},
+				PaxosSyncRequest: &extramessage.PaxosSyncRequest{Height: 3, TailHash: []byte{}},
+				PaxosSyncReply: &extramessage.PaxosSyncReply{
+					Blocks: []*blk.BlockContainer{mapping, naming, nil, snapshot},
+				},
+			},
+		}},
+		{Rumor: &RumorMessage{
+			Origin: "drone2",
+			ID:     4,
+			Extra: &extramessage.ExtraMessage{
+				MultiPaxosPrepare: &extramessage.MultiPaxosPrepare{Ballot: 1, Slot: 2, Candidate: 3},
+				MultiPaxosPromise: &extramessage.MultiPaxosPromise{
+					Accepted: []*extramessage.MultiPaxosAccept{{Slot: 1, Value: naming}, nil},
+				},
+				MultiPaxosPropose:   &extramessage.MultiPaxosPropose{Value: mapping},
+				MultiPaxosAccept:    &extramessage.MultiPaxosAccept{Acceptor: 1},
+				MultiPaxosHeartbeat: &extramessage.MultiPaxosHeartbeat{Leader: 2},
+				MultiPaxosForward:   &extramessage.MultiPaxosForward{Value: snapshot},
+				RaftRequestVote:     &extramessage.RaftRequestVote{Term: 1, LastIndex: -1},
+				RaftVote:            &extramessage.RaftVote{Granted: true},
+				RaftAppendEntries: &extramessage.RaftAppendEntries{
+					Entries: []extramessage.RaftEntry{{Term: 1, Value: mapping}},
+					Commit:  1,
+				},
+				RaftAppendResponse:  &extramessage.RaftAppendResponse{Success: true, MatchIndex: 3},
+				RaftInstallSnapshot: &extramessage.RaftInstallSnapshot{Blocks: []*blk.BlockContainer{}},
+				RaftForward:         &extramessage.RaftForward{},
+				BFTProposal:         &extramessage.BFTProposal{Value: mapping, Justify: certificate, Signature: []byte{6}},
+				BFTVote:             &extramessage.BFTVote{Hash: []byte{}},
+				BFTNewView:          &extramessage.BFTNewView{HighQC: &extramessage.BFTCertificate{}},
+				BFTForward:          &extramessage.BFTForward{Value: naming},
+			},
+		}},
+		{Status: &StatusPacket{}},
+		{Status: &StatusPacket{Want: []PeerStatus{{Identifier: "GS", NextID: 3}}, Version: wireVersion}},
+		{Private: &PrivateMessage{
+			Origin:      "drone0",
+			ID:          5,
+			Data:        PrivateMessageData{Location: r3.Vec{X: 1, Y: -2, Z: 3}, DroneID: 4},
+			Destination: "drone1",
+			HopLimit:    10,
+			Signature:   []byte{7},
+		}},
+	}
+}
+
+// TestBinary_matchesJSON checks that a packet decoded from the binary
+// encoding is the packet decoded from JSON, which the signatures rely on
+func TestBinary_matchesJSON(t *testing.T) {
+	g, err := NewGossiper("127.0.0.1:0", "GS", 10, 0, 2)
+	require.NoError(t, err)
+	defer g.server.socket.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	for _, packet := range testPackets() {
+		data, err := g.encodePacket(packet, 0)
+		require.NoError(t, err)
+		fromJSON, err := g.unmarshalPacket(data, addr)
+		require.NoError(t, err)
+
+		data, err = g.encodePacket(packet, wireVersion)
+		require.NoError(t, err)
+		require.Equal(t, byte(wireVersion), data[0])
+		fromBinary, err := g.unmarshalPacket(data, addr)
+		require.NoError(t, err)
+
+		require.Equal(t, fromJSON, fromBinary)
+	}
+}
+
+func TestBinary_invalid(t *testing.T) {
+	g, err := NewGossiper("127.0.0.1:0", "GS", 10, 0, 2)
+	require.NoError(t, err)
+	defer g.server.socket.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	data, err := g.encodePacket(testPackets()[2], wireVersion)
+	require.NoError(t, err)
+
+	// Truncated packets are rejected
+	for _, length := range []int{0, 1, len(data) / 2, len(data) - 1} {
+		_, err = g.unmarshalPacket(data[:length], addr)
+		require.Error(t, err)
+	}
+
+	// An unknown version is rejected
+	_, err = g.unmarshalPacket(append([]byte{wireVersion + 1}, data[1:]...), addr)
+	require.Error(t, err)
+
+	// A length exceeding the data does not allocate
+	w := wire.NewWriter()
+	w.Byte(wireVersion)
+	w.Bool(false)
+	w.Bool(true)
+	w.Uint(1 << 40)
+	_, err = g.unmarshalPacket(w.Data(), addr)
+	require.Error(t, err)
+}
+
+func TestBinary_signature(t *testing.T) {
+	trust := NewTrustStore()
+	sender := newTestGossiper(t, "GS", trust)
+	receiver := newTestGossiper(t, "drone0", trust)
+	defer sender.server.socket.Close()
+	defer receiver.server.socket.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	for _, packet := range testPackets() {
+		if packet.Rumor != nil {
+			sender.signRumor(packet.Rumor)
+		} else if packet.Private != nil {
+			sender.signPrivate(packet.Private)
+		} else {
+			continue
+		}
+
+		data, err := sender.encodePacket(packet, wireVersion)
+		require.NoError(t, err)
+		decoded, err := receiver.unmarshalPacket(data, addr)
+		require.NoError(t, err)
+
+		if decoded.Rumor != nil {
+			require.NoError(t, receiver.authenticate("GS", decoded.Rumor.digest, decoded.Rumor.Signature, addr))
+		} else {
+			require.NoError(t, receiver.authenticate("GS", decoded.Private.digest, decoded.Private.Signature, addr))
+		}
+	}
+}
+
+func TestBinary_negotiation(t *testing.T) {
+	g, err := NewGossiper("127.0.0.1:0", "GS", 10, 0, 2)
+	require.NoError(t, err)
+	defer g.server.socket.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	// A peer is sent JSON until it advertises the binary encoding
+	require.Equal(t, 0, g.peerVersion(addr.String()))
+	require.Equal(t, wireVersion, g.CreateStatusMessage().Status.Version)
+
+	require.NoError(t, (&StatusPacket{Version: wireVersion}).Exec(g, addr))
+	require.Equal(t, wireVersion, g.peerVersion(addr.String()))
+
+	// A peer restarted without the binary encoding gets JSON again
+	require.NoError(t, (&StatusPacket{}).Exec(g, addr))
+	require.Equal(t, 0, g.peerVersion(addr.String()))
+
+	// A peer sending a binary packet decodes it
+	data, err := g.encodePacket(GossipPacket{Status: &StatusPacket{}}, wireVersion)
+	require.NoError(t, err)
+	_, err = g.unmarshalPacket(data, addr)
+	require.NoError(t, err)
+	require.Equal(t, wireVersion, g.peerVersion(addr.String()))
+}
+
+func benchmarkPacket() GossipPacket {
+	return GossipPacket{Rumor: &RumorMessage{
+		Origin: "drone0",
+		ID:     1,
+		Extra: &extramessage.ExtraMessage{
+			PaxosAccept: &extramessage.PaxosAccept{Value: pathBlock(100, 20), Acceptor: 1},
+		},
+		Signature: make([]byte, 64),
+	}}
+}
+
+func benchmarkEncode(b *testing.B, version int) {
+	g, err := NewGossiper("127.0.0.1:0", "GS", 10, 0, 2)
+	require.NoError(b, err)
+	defer g.server.socket.Close()
+	packet := benchmarkPacket()
+
+	var data []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err = g.encodePacket(packet, version)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(data)), "bytes/packet")
+}
+
+func benchmarkDecode(b *testing.B, version int) {
+	g, err := NewGossiper("127.0.0.1:0", "GS", 10, 0, 2)
+	require.NoError(b, err)
+	defer g.server.socket.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	data, err := g.encodePacket(benchmarkPacket(), version)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := g.unmarshalPacket(data, addr); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(data)), "bytes/packet")
+}
+
+// The benchmarks encode and decode the accept of a path block for 100
+// drones flying 20 steps, with their trajectories
+func BenchmarkEncode_JSON(b *testing.B)   { benchmarkEncode(b, 0) }
+func BenchmarkEncode_binary(b *testing.B) { benchmarkEncode(b, wireVersion) }
+func BenchmarkDecode_JSON(b *testing.B)   { benchmarkDecode(b, 0) }
+func BenchmarkDecode_binary(b *testing.B) { benchmarkDecode(b, wireVersion) }
+
+func TestBinary_smallerThanJSON(t *testing.T) {
+	packet := benchmarkPacket()
+	jsonData, err := json.Marshal(packet)
+	require.NoError(t, err)
+	w := wire.NewWriter()
+	packet.EncodeBinary(w)
+	require.Less(t, len(w.Data()), len(jsonData)/2)
+}
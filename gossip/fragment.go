@@ -0,0 +1,305 @@
+package gossip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// fragmentMarker is the first byte of a fragment, which neither a JSON
+	// packet nor a binary one starts with
+	fragmentMarker = 0xff
+	// fragmentHeaderSize is the marker, the ID of the packet, the index of
+	// the fragment and the number of fragments
+	fragmentHeaderSize = 13
+	// nackMarker is the first byte of a NACK, the request of a receiver for
+	// the fragments of a packet it is missing, followed by the ID of the
+	// packet and the indices of the fragments
+	nackMarker     = 0xfe
+	nackHeaderSize = 9
+
+	// maxDatagramSize is the size of the largest datagram sent, the larger
+	// packets being sent in fragments
+	maxDatagramSize = 8192
+	// maxFragments bounds the number of fragments of a packet, so that a
+	// packet is at most 2 MiB
+	maxFragments = 256
+	// fragmentInterval is the time between two fragments sent
+	fragmentInterval = 100 * time.Microsecond
+	// nackInterval is the time without a new fragment after which the
+	// receiver of an incomplete packet asks for the missing fragments
+	nackInterval = 200 * time.Millisecond
+
+	// reassemblyTimeout is the time after which the fragments of an
+	// incomplete packet are dropped
+	reassemblyTimeout = 5 * time.Second
+	// maxReassemblyMemory bounds the size of the fragments kept, and
+	// maxPendingPackets the number of incomplete packets. The fragments of a
+	// new packet exceeding them are dropped.
+	maxReassemblyMemory = 16 << 20
+	maxPendingPackets   = 1024
+)
+
+// fragment splits the data into datagrams of at most maxDatagramSize bytes,
+// the data fitting in a datagram being sent as is. It is only called by the
+// sender of the server.
+func (s *UDPServer) fragment(data []byte) ([][]byte, error) {
+	if len(data) <= maxDatagramSize {
+		return [][]byte{data}, nil
+	}
+	payloadSize := maxDatagramSize - fragmentHeaderSize
+	count := (len(data) + payloadSize - 1) / payloadSize
+	if count > maxFragments {
+		return nil, fmt.Errorf("packet of %d bytes exceeds %d fragments", len(data), maxFragments)
+	}
+
+	s.packetID++
+	fragments := make([][]byte, count)
+	for i := range fragments {
+		end := (i + 1) * payloadSize
+		if end > len(data) {
+			end = len(data)
+		}
+		fragment := make([]byte, fragmentHeaderSize, fragmentHeaderSize+end-i*payloadSize)
+		fragment[0] = fragmentMarker
+		binary.BigEndian.PutUint64(fragment[1:], s.packetID)
+		binary.BigEndian.PutUint16(fragment[9:], uint16(i))
+		binary.BigEndian.PutUint16(fragment[11:], uint16(count))
+		fragments[i] = append(fragment, data[i*payloadSize:end]...)
+	}
+	return fragments, nil
+}
+
+// sentPacket holds the fragments of a packet sent to a peer
+type sentPacket struct {
+	key       string
+	fragments [][]byte
+	size      int
+	sent      time.Time
+}
+
+// sentPackets keeps the fragments of the packets sent in the last
+// reassemblyTimeout, at most maxReassemblyMemory bytes of them, so that the
+// fragments a peer is missing are sent again. The packets are added by the
+// sender of the server and the NACKs answered by its listener.
+type sentPackets struct {
+	mutex   sync.Mutex
+	packets map[string]*sentPacket
+	// order holds the packets from the oldest one
+	order []*sentPacket
+	size  int
+}
+
+func newSentPackets() *sentPackets {
+	return &sentPackets{
+		packets: make(map[string]*sentPacket),
+	}
+}
+
+// add keeps the fragments of the packet id sent to the address, dropping the
+// oldest packets kept if need be
+func (s *sentPackets) add(addr *net.UDPAddr, id uint64, fragments [][]byte, now time.Time) {
+	packet := &sentPacket{
+		key:       fmt.Sprintf("%s/%d", addr, id),
+		fragments: fragments,
+		sent:      now,
+	}
+	for _, fragment := range fragments {
+		packet.size += len(fragment)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.packets[packet.key] = packet
+	s.order = append(s.order, packet)
+	s.size += packet.size
+	for len(s.order) > 0 && (s.size > maxReassemblyMemory || now.Sub(s.order[0].sent) > reassemblyTimeout) {
+		delete(s.packets, s.order[0].key)
+		s.size -= s.order[0].size
+		s.order = s.order[1:]
+	}
+}
+
+// missing returns the fragments requested by the NACK of the peer
+func (s *sentPackets) missing(nack []byte, addr *net.UDPAddr) ([][]byte, error) {
+	if len(nack) < nackHeaderSize || (len(nack)-nackHeaderSize)%2 != 0 {
+		return nil, fmt.Errorf("invalid NACK")
+	}
+	id := binary.BigEndian.Uint64(nack[1:])
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	packet, found := s.packets[fmt.Sprintf("%s/%d", addr, id)]
+	if !found {
+		return nil, fmt.Errorf("NACK for the unknown packet %d", id)
+	}
+	var fragments [][]byte
+	for i := nackHeaderSize; i < len(nack); i += 2 {
+		index := int(binary.BigEndian.Uint16(nack[i:]))
+		if index >= len(packet.fragments) {
+			return nil, fmt.Errorf("NACK for fragment %d of %d", index, len(packet.fragments))
+		}
+		fragments = append(fragments, packet.fragments[index])
+	}
+	return fragments, nil
+}
+
+// pendingPacket holds the fragments of a packet received so far, and the
+// time of the last fragment received or NACK sent for it
+type pendingPacket struct {
+	addr      *net.UDPAddr
+	id        uint64
+	fragments [][]byte
+	missing   int
+	size      int
+	started   time.Time
+	last      time.Time
+}
+
+// reassembler rebuilds the packets sent in fragments. It is only used by the
+// listener of the server.
+type reassembler struct {
+	pending map[string]*pendingPacket
+	size    int
+	// completed holds the time the recent packets were rebuilt, so that the
+	// fragments received again are ignored
+	completed map[string]time.Time
+	lastSweep time.Time
+	lastNack  time.Time
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		pending:   make(map[string]*pendingPacket),
+		completed: make(map[string]time.Time),
+	}
+}
+
+// add returns the data of the datagram: the datagram itself if it is not a
+// fragment, the whole packet if it is its last missing fragment and nil
+// otherwise
+func (r *reassembler) add(datagram []byte, addr *net.UDPAddr, now time.Time) ([]byte, error) {
+	if len(datagram) == 0 || datagram[0] != fragmentMarker {
+		return datagram, nil
+	}
+	if len(datagram) < fragmentHeaderSize {
+		return nil, fmt.Errorf("fragment too short")
+	}
+	id := binary.BigEndian.Uint64(datagram[1:])
+	index := int(binary.BigEndian.Uint16(datagram[9:]))
+	count := int(binary.BigEndian.Uint16(datagram[11:]))
+	if count < 2 || count > maxFragments || index >= count {
+		return nil, fmt.Errorf("invalid fragment %d of %d", index, count)
+	}
+
+	if now.Sub(r.lastSweep) > time.Second {
+		r.sweep(now)
+	}
+
+	key := fmt.Sprintf("%s/%d", addr, id)
+	if _, found := r.completed[key]; found {
+		return nil, nil
+	}
+	packet, found := r.pending[key]
+	if !found {
+		if len(r.pending) >= maxPendingPackets {
+			return nil, fmt.Errorf("too many incomplete packets")
+		}
+		packet = &pendingPacket{
+			addr:      addr,
+			id:        id,
+			fragments: make([][]byte, count),
+			missing:   count,
+			started:   now,
+		}
+		r.pending[key] = packet
+	}
+	if count != len(packet.fragments) {
+		return nil, fmt.Errorf("fragment %d of %d for a packet of %d fragments", index, count, len(packet.fragments))
+	}
+	if packet.fragments[index] != nil {
+		// Fragment received twice
+		return nil, nil
+	}
+	packet.last = now
+	if r.size+len(datagram)-fragmentHeaderSize > maxReassemblyMemory {
+		r.drop(key)
+		return nil, fmt.Errorf("fragments exceed %d bytes", maxReassemblyMemory)
+	}
+
+	// The payload is copied, so that the buffer of the datagram is not kept
+	payload := append([]byte{}, datagram[fragmentHeaderSize:]...)
+	packet.fragments[index] = payload
+	packet.missing--
+	packet.size += len(payload)
+	r.size += len(payload)
+	if packet.missing > 0 {
+		return nil, nil
+	}
+
+	data := make([]byte, 0, packet.size)
+	for _, fragment := range packet.fragments {
+		data = append(data, fragment...)
+	}
+	r.drop(key)
+	r.completed[key] = now
+	return data, nil
+}
+
+// nacks returns the NACKs of the incomplete packets which got no fragment
+// for nackInterval, each to the sender of the packet
+func (r *reassembler) nacks(now time.Time) []UDPPacket {
+	if now.Sub(r.lastNack) < nackInterval/4 {
+		return nil
+	}
+	r.lastNack = now
+	if now.Sub(r.lastSweep) > time.Second {
+		r.sweep(now)
+	}
+
+	var nacks []UDPPacket
+	for _, packet := range r.pending {
+		if now.Sub(packet.last) < nackInterval {
+			continue
+		}
+		packet.last = now
+		nack := make([]byte, nackHeaderSize, nackHeaderSize+2*packet.missing)
+		nack[0] = nackMarker
+		binary.BigEndian.PutUint64(nack[1:], packet.id)
+		for index, fragment := range packet.fragments {
+			if fragment == nil {
+				nack = append(nack, byte(index>>8), byte(index))
+			}
+		}
+		nacks = append(nacks, UDPPacket{data: nack, addr: packet.addr})
+	}
+	return nacks
+}
+
+// sweep drops the incomplete packets older than reassemblyTimeout, and
+// forgets the packets rebuilt before
+func (r *reassembler) sweep(now time.Time) {
+	r.lastSweep = now
+	for key, packet := range r.pending {
+		if now.Sub(packet.started) > reassemblyTimeout {
+			r.drop(key)
+		}
+	}
+	for key, completed := range r.completed {
+		if now.Sub(completed) > reassemblyTimeout {
+			delete(r.completed, key)
+		}
+	}
+}
+
+func (r *reassembler) drop(key string) {
+	packet, found := r.pending[key]
+	if !found {
+		return
+	}
+	r.size -= packet.size
+	delete(r.pending, key)
+}
@@ -0,0 +1,227 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+)
+
+func TestFragment_reassemble(t *testing.T) {
+	server := &UDPServer{}
+	r := newReassembler()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	now := time.Now()
+
+	// A small packet is sent as is
+	datagrams, err := server.fragment([]byte("{}"))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("{}")}, datagrams)
+	data, err := r.add(datagrams[0], addr, now)
+	require.NoError(t, err)
+	require.Equal(t, []byte("{}"), data)
+
+	packet := make([]byte, 300*1024)
+	rand.Read(packet)
+	datagrams, err = server.fragment(packet)
+	require.NoError(t, err)
+	require.Len(t, datagrams, 38)
+	for _, datagram := range datagrams {
+		require.LessOrEqual(t, len(datagram), maxDatagramSize)
+	}
+
+	// The fragments received out of order, some twice, give the packet once
+	rand.Shuffle(len(datagrams), func(i, j int) {
+		datagrams[i], datagrams[j] = datagrams[j], datagrams[i]
+	})
+	datagrams = append(datagrams, datagrams[:10]...)
+	var received [][]byte
+	for _, datagram := range datagrams {
+		data, err := r.add(datagram, addr, now)
+		require.NoError(t, err)
+		if data != nil {
+			received = append(received, data)
+		}
+	}
+	require.Len(t, received, 1)
+	require.True(t, bytes.Equal(packet, received[0]))
+	require.Empty(t, r.pending)
+	require.Equal(t, 0, r.size)
+
+	// The packets too large are not sent
+	_, err = server.fragment(make([]byte, maxFragments*maxDatagramSize))
+	require.Error(t, err)
+}
+
+func TestFragment_limits(t *testing.T) {
+	server := &UDPServer{}
+	r := newReassembler()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	now := time.Now()
+
+	datagrams, err := server.fragment(make([]byte, 3*maxDatagramSize))
+	require.NoError(t, err)
+
+	// Invalid fragments are rejected
+	invalid := append([]byte{}, datagrams[0]...)
+	invalid[10] = byte(len(datagrams))
+	_, err = r.add(invalid, addr, now)
+	require.Error(t, err)
+	_, err = r.add(datagrams[0][:fragmentHeaderSize-1], addr, now)
+	require.Error(t, err)
+
+	// The fragments of an incomplete packet are dropped after the timeout
+	_, err = r.add(datagrams[0], addr, now)
+	require.NoError(t, err)
+	require.Len(t, r.pending, 1)
+	_, err = r.add(datagrams[1], addr, now.Add(reassemblyTimeout+time.Second))
+	require.NoError(t, err)
+	require.Len(t, r.pending, 1)
+	require.Equal(t, len(datagrams[1])-fragmentHeaderSize, r.size)
+
+	// The fragments kept are bounded
+	err = nil
+	for err == nil {
+		datagrams, fragmentErr := server.fragment(make([]byte, maxFragments*(maxDatagramSize-fragmentHeaderSize)))
+		require.NoError(t, fragmentErr)
+		for _, datagram := range datagrams[1:] {
+			if _, err = r.add(datagram, addr, now); err != nil {
+				break
+			}
+		}
+	}
+	require.LessOrEqual(t, r.size, maxReassemblyMemory)
+	require.Greater(t, r.size, maxReassemblyMemory-maxFragments*maxDatagramSize)
+
+	// So are the incomplete packets
+	r = newReassembler()
+	for i := 0; i < maxPendingPackets; i++ {
+		datagrams, err := server.fragment(make([]byte, 2*maxDatagramSize))
+		require.NoError(t, err)
+		_, err = r.add(datagrams[0], addr, now)
+		require.NoError(t, err)
+	}
+	datagrams, err = server.fragment(make([]byte, 2*maxDatagramSize))
+	require.NoError(t, err)
+	_, err = r.add(datagrams[0], addr, now)
+	require.Error(t, err)
+}
+
+func TestFragment_retransmit(t *testing.T) {
+	server := &UDPServer{}
+	sent := newSentPackets()
+	r := newReassembler()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	now := time.Now()
+
+	packet := make([]byte, 100*1024)
+	rand.Read(packet)
+	datagrams, err := server.fragment(packet)
+	require.NoError(t, err)
+	sent.add(addr, server.packetID, datagrams, now)
+
+	// Some fragments are lost, the receiver asks for them once it got no
+	// fragment for nackInterval
+	lost := map[int]bool{0: true, 5: true, len(datagrams) - 1: true}
+	for i, datagram := range datagrams {
+		if !lost[i] {
+			data, err := r.add(datagram, addr, now)
+			require.NoError(t, err)
+			require.Nil(t, data)
+		}
+	}
+	require.Empty(t, r.nacks(now.Add(nackInterval/2)))
+	nacks := r.nacks(now.Add(nackInterval))
+	require.Len(t, nacks, 1)
+	require.Equal(t, addr, nacks[0].addr)
+	require.Empty(t, r.nacks(now.Add(nackInterval*5/4)))
+
+	// The sender sends them again, which completes the packet
+	fragments, err := sent.missing(nacks[0].data, addr)
+	require.NoError(t, err)
+	require.Len(t, fragments, len(lost))
+	var received []byte
+	for _, fragment := range fragments {
+		data, err := r.add(fragment, addr, now.Add(nackInterval))
+		require.NoError(t, err)
+		if data != nil {
+			received = data
+		}
+	}
+	require.True(t, bytes.Equal(packet, received))
+	require.Empty(t, r.nacks(now.Add(3*nackInterval)))
+
+	// The NACKs of unknown packets or fragments are refused
+	_, err = sent.missing(nacks[0].data, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2})
+	require.Error(t, err)
+	invalid := append([]byte{}, nacks[0].data[:nackHeaderSize]...)
+	_, err = sent.missing(append(invalid, 0xff, 0xff), addr)
+	require.Error(t, err)
+
+	// The packets sent are kept for reassemblyTimeout
+	sent.add(addr, server.packetID+1, datagrams, now.Add(reassemblyTimeout+time.Second))
+	_, err = sent.missing(nacks[0].data, addr)
+	require.Error(t, err)
+	require.Len(t, sent.packets, 1)
+}
+
+// TestFragment_gossip sends path blocks of several hundred kilobytes between
+// two gossipers, in JSON and then in the binary encoding. The fragments lost
+// are sent again, the deadlines leaving room for the encoding of the blocks
+// under the race detector.
+func TestFragment_gossip(t *testing.T) {
+	test := func(t *testing.T, encrypt bool) {
+		trust := NewTrustStore()
+		sender := newTestGossiper(t, "GS", trust)
+		receiver := newTestGossiper(t, "drone0", trust)
+		if encrypt {
+			require.NoError(t, sender.EnableEncryption())
+			require.NoError(t, receiver.EnableEncryption())
+		}
+
+		delivered := make(chan GossipPacket, 10)
+		receiver.RegisterCallback(func(origin string, packet GossipPacket) {
+			delivered <- packet
+		})
+		for _, g := range []*Gossiper{sender, receiver} {
+			ready := make(chan struct{})
+			go g.Run(ready)
+			<-ready
+			defer g.Stop()
+		}
+		require.NoError(t, sender.AddAddresses(receiver.GetLocalAddr()))
+		require.NoError(t, receiver.AddAddresses(sender.GetLocalAddr()))
+
+		block := pathBlock(100, 60)
+		data, err := json.Marshal(block)
+		require.NoError(t, err)
+		require.Greater(t, len(data), 300*1024)
+
+		for i := 0; i < 2; i++ {
+			sender.AddExtraMessage(&extramessage.ExtraMessage{
+				PaxosTLC: &extramessage.PaxosTLC{Value: block},
+			})
+
+			select {
+			case packet := <-delivered:
+				require.Equal(t, block.Hash(), packet.Rumor.Extra.PaxosTLC.Value.Hash())
+			case <-time.After(20 * time.Second):
+				t.Fatalf("block %d not delivered", i)
+			}
+
+			// The status acknowledging the rumor makes the sender use the
+			// binary encoding
+			require.Eventually(t, func() bool {
+				return sender.peerVersion(receiver.GetLocalAddr()) == wireVersion
+			}, 20*time.Second, 10*time.Millisecond)
+		}
+	}
+
+	t.Run("plain", func(t *testing.T) { test(t, false) })
+	t.Run("encrypted", func(t *testing.T) { test(t, true) })
+}
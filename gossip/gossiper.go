@@ -3,7 +3,7 @@ package gossip
 // ========== CS-438 Project ===========
 
 import (
-	"encoding/json"
+	"crypto/ed25519"
 	"errors"
 	"math/rand"
 	"net"
@@ -43,7 +43,6 @@ type Gossiper struct {
 
 	server  *UDPServer
 	handler *MessageHandler
-	sending chan<- UDPPacket
 
 	identifier  string
 	address     string
@@ -64,6 +63,15 @@ type Gossiper struct {
 	timerRouteRumor     *time.Ticker
 	chanAntiEntropyStop chan bool
 	timerAntiEntropy    *time.Ticker
+
+	// privateKey signs the packets originating from the gossiper and trust
+	// verifies the ones received, if set
+	privateKey ed25519.PrivateKey
+	trust      *TrustStore
+
+	// versions holds the version of the binary encoding of the peers, by
+	// address
+	versions sync.Map // map[string]int
 }
 
 // NewGossiper returns a Gossiper that is able to listen to the given address
@@ -98,8 +106,7 @@ func NewGossiper(address, identifier string, antiEntropy int, routeTimer int, nu
 		routeTimer:  routeTimer,
 		callback:    nil,
 
-		server:  server,
-		sending: nil,
+		server: server,
 
 		nextID:              1,
 		chanRouteRumorStop:  make(chan bool, 1),
@@ -131,13 +138,12 @@ func (g *Gossiper) decodePacket(chPacket <-chan UDPPacket) chan HandlingPacket {
 	go func() {
 		defer close(ch)
 		for packet := range chPacket {
-			var decodedPacket GossipPacket
-			err := json.Unmarshal(packet.data, &decodedPacket)
+			decodedPacket, err := g.unmarshalPacket(packet.data, packet.addr)
 			if err != nil {
 				log.Printf("Discard decoded packet, %s", err)
 			} else {
 				ch <- HandlingPacket{
-					data: &decodedPacket,
+					data: decodedPacket,
 					addr: packet.addr,
 				}
 			}
@@ -150,13 +156,9 @@ func (g *Gossiper) decodePacket(chPacket <-chan UDPPacket) chan HandlingPacket {
 // on the given address and starts the antientropy. This is a blocking function.
 func (g *Gossiper) Run(ready chan struct{}) {
 	//Start server
-	listener, sender, handlingFinished := g.server.Run()
+	listener, handlingFinished := g.server.Run()
 
 	handlerClosed := g.handler.Run(g, g.decodePacket(listener))
-	g.sending = sender
-
-	// Ready to receive packets -> close ready channel
-	close(ready)
 
 	// Anti-entropy
 	if g.antiEntropy > 0 {
@@ -184,21 +186,20 @@ func (g *Gossiper) Run(ready chan struct{}) {
 	if g.routeTimer > 0 {
 		g.timerRouteRumor = time.NewTicker(time.Second * time.Duration(g.routeTimer))
 
-		msg := GossipPacket{
-			Rumor: &RumorMessage{
-				Origin: g.identifier,
-				ID:     0,
-				Text:   "",
-			},
-		}
-
 		sendEmptyRouteRumor := func() {
 			g.mutexNextID.Lock()
 			id := g.nextID
 			g.nextID++
 			g.mutexNextID.Unlock()
 
-			msg.Rumor.ID = id
+			msg := GossipPacket{
+				Rumor: &RumorMessage{
+					Origin: g.identifier,
+					ID:     id,
+					Text:   "",
+				},
+			}
+			g.signRumor(msg.Rumor)
 			// To one address
 			g.handler.HandlePacket(g, HandlingPacket{data: &msg, addr: g.server.Address})
 		}
@@ -216,6 +217,10 @@ func (g *Gossiper) Run(ready chan struct{}) {
 		}()
 	}
 
+	// Ready to receive packets -> close ready channel, once the timers
+	// stopped by Stop are set
+	close(ready)
+
 	// Connect close handling to handler close event
 	handlingFinished <- <-handlerClosed
 }
@@ -255,6 +260,13 @@ func (g *Gossiper) updateRoute(destination, nextHop string, lastID uint32, offic
 	// }
 }
 
+// next returns the ID of the next rumor expected from the origin
+func (t *messageTracking) next() uint32 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.nextID
+}
+
 func (g *Gossiper) trackRumor(msg *RumorMessage) (uint32, uint32) {
 	trackMessage := func(tracking *messageTracking) (uint32, uint32) {
 		tracking.mutex.Lock()
@@ -306,6 +318,7 @@ func (g *Gossiper) AddPrivateMessage(data PrivateMessageData, dest, origin strin
 			HopLimit:    hoplimit,
 		},
 	}
+	g.signPrivate(msg.Private)
 
 	g.handler.HandlePacket(g, HandlingPacket{
 		data: msg,
@@ -331,6 +344,7 @@ func (g *Gossiper) AddMessage(text string) uint32 {
 			Text:   text,
 		},
 	}
+	g.signRumor(msg.Rumor)
 	// Simply dispatch message
 	g.handler.HandlePacket(g, HandlingPacket{
 		data: msg,
@@ -355,6 +369,7 @@ func (g *Gossiper) AddExtraMessage(paxosMsg *extramessage.ExtraMessage) uint32 {
 			Extra:  paxosMsg,
 		},
 	}
+	g.signRumor(msg.Rumor)
 
 	// Simply dispatch message
 	g.handler.HandlePacket(g, HandlingPacket{
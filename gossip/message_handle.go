@@ -14,7 +14,8 @@ import (
 type MessageHandler struct {
 	chanPackets       chan HandlingPacket
 	chanReinvokeQueue chan *ReinvokeRumor
-	close             bool
+	// closed is closed once the handler is stopped
+	closed chan struct{}
 
 	mutexReinvoke sync.Mutex
 	reinvokeMap   map[string]*ReinvokeAddr
@@ -45,7 +46,7 @@ func NewMessageHandler() *MessageHandler {
 	return &MessageHandler{
 		chanPackets:       make(chan HandlingPacket, 100),
 		chanReinvokeQueue: make(chan *ReinvokeRumor, 100),
-		close:             false,
+		closed:            make(chan struct{}),
 		reinvokeMap:       make(map[string]*ReinvokeAddr),
 	}
 }
@@ -55,39 +56,23 @@ func NewMessageHandler() *MessageHandler {
 func (h *MessageHandler) Run(g *Gossiper, packets chan HandlingPacket) <-chan bool {
 	packetHandler := func(done chan bool) {
 		defer close(done)
-		closePacket, closePackets, closeReinvoke := false, false, false
-		for {
+		closed := h.closed
+		// Handle the packets until the handler is closed, and drop the ones
+		// received afterwards until the listener is closed
+		for packets != nil || closed != nil {
 			select {
 			case packet, ok := <-packets:
-				if ok {
-					h.handlePacket(g, packet)
-				} else {
-					closePackets = true
+				if !ok {
 					packets = nil
-					if closePacket && closePackets && closeReinvoke {
-						return
-					}
-				}
-			case packet, ok := <-h.chanPackets:
-				if ok {
+				} else if closed != nil {
 					h.handlePacket(g, packet)
-				} else {
-					closePacket = true
-					h.chanPackets = nil
-					if closePacket && closePackets && closeReinvoke {
-						return
-					}
-				}
-			case reinvoke, ok := <-h.chanReinvokeQueue:
-				if ok {
-					reinvoke.msg.PropagateRumor(g, reinvoke.addr, reinvoke.exceptNodes)
-				} else {
-					closeReinvoke = true
-					h.chanReinvokeQueue = nil
-					if closePacket && closePackets && closeReinvoke {
-						return
-					}
 				}
+			case packet := <-h.chanPackets:
+				h.handlePacket(g, packet)
+			case reinvoke := <-h.chanReinvokeQueue:
+				reinvoke.msg.PropagateRumor(g, reinvoke.addr, reinvoke.exceptNodes)
+			case <-closed:
+				closed = nil
 			}
 		}
 	}
@@ -100,26 +85,40 @@ func (h *MessageHandler) Run(g *Gossiper, packets chan HandlingPacket) <-chan bo
 
 // Stop gracefully the runing process
 func (h *MessageHandler) Stop() {
-	h.close = true
-	close(h.chanPackets)
+	h.mutexReinvoke.Lock()
+	defer h.mutexReinvoke.Unlock()
 
-	// Stop all reinvoke timers
-	func() {
-		h.mutexReinvoke.Lock()
-		defer h.mutexReinvoke.Unlock()
+	close(h.closed)
 
-		for _, address := range h.reinvokeMap {
-			//Switch to local lock
-			address.mutex.Lock()
-			defer address.mutex.Unlock()
+	// Stop all reinvoke timers
+	for _, address := range h.reinvokeMap {
+		//Switch to local lock
+		address.mutex.Lock()
+		defer address.mutex.Unlock()
 
-			for _, rumor := range address.rumors {
-				rumor.timer.Stop()
-			}
-			address.rumors = nil
+		for _, rumor := range address.rumors {
+			rumor.timer.Stop()
 		}
-		close(h.chanReinvokeQueue)
-	}()
+		address.rumors = nil
+	}
+}
+
+// isClosed returns true once the handler is stopped
+func (h *MessageHandler) isClosed() bool {
+	select {
+	case <-h.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// reinvoke queues the rumor to propagate again, unless the handler is stopped
+func (h *MessageHandler) reinvoke(rumor *ReinvokeRumor) {
+	select {
+	case h.chanReinvokeQueue <- rumor:
+	case <-h.closed:
+	}
 }
 
 func (h *MessageHandler) extractMessage(packet GossipPacket) (interface{}, error) {
@@ -143,13 +142,17 @@ func (h *MessageHandler) extractMessage(packet GossipPacket) (interface{}, error
 
 // HandlePacket handle the packet
 func (h *MessageHandler) HandlePacket(g *Gossiper, packet HandlingPacket) error {
-	if h.close {
+	if h.isClosed() {
 		err := errors.New("Handler is closed")
 		return err
 	}
 
-	h.chanPackets <- packet
-	return nil
+	select {
+	case h.chanPackets <- packet:
+		return nil
+	case <-h.closed:
+		return errors.New("Handler is closed")
+	}
 }
 
 func (h *MessageHandler) handlePacket(g *Gossiper, packet HandlingPacket) {
@@ -1,22 +1,12 @@
 package gossip
 
 import (
-	"encoding/json"
 	"errors"
 	"net"
 
 	"go.dedis.ch/onet/v3/log"
 )
 
-func (g *Gossiper) encodePacket(packet GossipPacket) ([]byte, error) {
-	encodedPacket, err := json.Marshal(packet)
-	if err != nil {
-		return make([]byte, 0), err
-	}
-
-	return encodedPacket, nil
-}
-
 // BroadcastMessage broadcast a message to all known hosts
 func (g *Gossiper) BroadcastMessage(msg GossipPacket) {
 	g.BroadcastMessageExcept(msg, "")
@@ -24,23 +14,37 @@ func (g *Gossiper) BroadcastMessage(msg GossipPacket) {
 
 // BroadcastMessageExcept broadcast a message to all known hosts except to the given host
 func (g *Gossiper) BroadcastMessageExcept(msg GossipPacket, exceptAddresses string) {
-	jsonData, err := g.encodePacket(msg)
-	if err != nil {
-		// Discard invalid message
-		return
+	g.mutexNodes.RLock()
+	nodes := make(map[string]*net.UDPAddr, len(g.nodes))
+	for node, addr := range g.nodes {
+		nodes[node] = addr
 	}
+	g.mutexNodes.RUnlock()
 
-	for node, addr := range g.nodes {
+	// Encode the message once for each version of the peers
+	encoded := make(map[int][]byte)
+
+	for node, addr := range nodes {
 		if node != exceptAddresses {
-			defer recover()
-			g.sending <- UDPPacket{data: jsonData, addr: addr}
+			version := g.peerVersion(node)
+			data, found := encoded[version]
+			if !found {
+				var err error
+				data, err = g.encodePacket(msg, version)
+				if err != nil {
+					// Discard invalid message
+					return
+				}
+				encoded[version] = data
+			}
+			g.server.send(UDPPacket{data: data, addr: addr})
 		}
 	}
 }
 
 // SendMessageTo send a given message to a given host
 func (g *Gossiper) SendMessageTo(msg GossipPacket, addr string) {
-	packet, ok := g.encodePacket(msg)
+	packet, ok := g.encodePacket(msg, g.peerVersion(addr))
 	if ok != nil {
 		log.Printf("Discard invalid message while encoding")
 		return
@@ -53,23 +57,18 @@ func (g *Gossiper) SendMessageTo(msg GossipPacket, addr string) {
 		return
 	}
 
-	defer func() {
-		err := recover()
-		if err != nil {
-			log.Printf("Recover from sender closed")
-		}
-	}()
-	g.sending <- UDPPacket{data: packet, addr: address}
+	g.server.send(UDPPacket{data: packet, addr: address})
 }
 
 // CreateStatusMessage send a status message to the given address
 func (g *Gossiper) CreateStatusMessage() *GossipPacket {
 	msg := StatusPacket{
-		Want: make([]PeerStatus, 0),
+		Want:    make([]PeerStatus, 0),
+		Version: wireVersion,
 	}
 	g.messages.Range(func(identifier, track interface{}) bool {
 		msg.Want = append(msg.Want, PeerStatus{
-			NextID:     track.(*messageTracking).nextID,
+			NextID:     track.(*messageTracking).next(),
 			Identifier: identifier.(string),
 		})
 		return true
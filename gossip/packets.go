@@ -33,6 +33,7 @@ func (g GossipPacket) Copy() GossipPacket {
 		rumor.Origin = g.Rumor.Origin
 		rumor.ID = g.Rumor.ID
 		rumor.Text = g.Rumor.Text
+		rumor.Signature = append([]byte{}, g.Rumor.Signature...)
 
 		if g.Rumor.Extra != nil {
 			rumor.Extra = g.Rumor.Extra.Copy()
@@ -42,6 +43,7 @@ func (g GossipPacket) Copy() GossipPacket {
 	if g.Status != nil {
 		status = new(StatusPacket)
 		status.Want = append([]PeerStatus{}, g.Status.Want...)
+		status.Version = g.Status.Version
 	}
 
 	if g.Private != nil {
@@ -50,6 +52,7 @@ func (g GossipPacket) Copy() GossipPacket {
 		private.HopLimit = g.Private.HopLimit
 		private.ID = g.Private.ID
 		private.Origin = g.Private.Origin
+		private.Signature = append([]byte{}, g.Private.Signature...)
 		private.Data = PrivateMessageData{
 			Location: g.Private.Data.Location,
 			DroneID:  g.Private.Data.DroneID,
@@ -70,12 +73,18 @@ type RumorMessage struct {
 	Text   string `json:"text"`
 
 	Extra *extramessage.ExtraMessage `json:"extra"`
+	// Signature of the origin, if the gossipers have keys
+	Signature []byte `json:"signature"`
 }
 
 // StatusPacket is sent as a status of the current local state of messages seen
 // so far. It can start a rumormongering process in the network.
+//
+// Version is the version of the binary encoding the sender decodes, 0 if it
+// only decodes JSON.
 type StatusPacket struct {
-	Want []PeerStatus `json:"want"`
+	Want    []PeerStatus `json:"want"`
+	Version int          `json:"version,omitempty"`
 }
 
 // PeerStatus shows how far have a node see messages coming from a peer in
@@ -107,6 +116,8 @@ type PrivateMessage struct {
 	Data        PrivateMessageData `json:"data"`
 	Destination string             `json:"destination"`
 	HopLimit    int                `json:"hoplimit"`
+	// Signature of the origin, if the gossipers have keys
+	Signature []byte `json:"signature"`
 }
 
 // NewMessageCallback is the type of function that users of the library should
@@ -0,0 +1,418 @@
+package gossip
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// Type of the datagrams, given by their first byte
+	datagramInit  = 1
+	datagramReply = 2
+	datagramData  = 3
+
+	// handshakeRetry is the time after which an unanswered handshake is sent again
+	handshakeRetry = time.Second
+	// handshakeMaxAge is the maximal age of a handshake accepted by a node
+	handshakeMaxAge = 30 * time.Second
+	// sessionLifetime is the time after which a node renews its session key
+	// with a peer, so that a peer which lost the session recovers
+	sessionLifetime = time.Minute
+	// maxQueuedDatagrams is the number of datagrams kept while a handshake
+	// is running, the next ones being dropped, enough for the fragments of
+	// the largest packet
+	maxQueuedDatagrams = maxFragments
+
+	// headerSize is the type and the counter of a data datagram
+	headerSize = 9
+)
+
+// handshakeInit starts a session with an ephemeral key, signed by the node
+// with the time it was sent
+type handshakeInit struct {
+	Identifier string
+	Ephemeral  []byte
+	Time       int64
+	Signature  []byte
+}
+
+// handshakeReply completes the session with the ephemeral key of the peer,
+// signed with the ephemeral key of the initiator
+type handshakeReply struct {
+	Identifier string
+	Ephemeral  []byte
+	Signature  []byte
+}
+
+// sendingSession encrypts the datagrams sent to a peer, once the peer
+// answered the handshake
+type sendingSession struct {
+	ephemeral *ephemeralKey
+	init      []byte
+	initSent  time.Time
+	queue     [][]byte
+
+	aead    cipher.AEAD
+	counter uint64
+	started time.Time
+}
+
+// receivingSession decrypts the datagrams received from a peer
+type receivingSession struct {
+	identifier string
+	ephemeral  []byte
+	reply      []byte
+	aead       cipher.AEAD
+	window     replayWindow
+}
+
+// secureTransport seals the datagrams sent to each peer with a session key
+// agreed by a handshake, in the way of Noise: the nodes exchange ephemeral
+// X25519 keys signed by their identity key, listed in the trust store, and
+// derive an AES-GCM key from the shared secret. A session only carries the
+// datagrams of its initiator, each node starting its own session to send to a
+// peer. Each datagram carries a counter, used as nonce, the replayed ones
+// being dropped. The identifier of the node at an address is pinned by its
+// first handshake, so that another trusted node cannot answer in its place.
+type secureTransport struct {
+	mutex      sync.Mutex
+	identifier string
+	private    ed25519.PrivateKey
+	trust      *TrustStore
+
+	// sending and receiving hold the sessions by peer address
+	sending   map[string]*sendingSession
+	receiving map[string]*receivingSession
+	// lastInit is the time of the last handshake accepted, by identifier
+	lastInit map[string]int64
+	// peers holds the identifier of the node at each address
+	peers map[string]string
+}
+
+func newSecureTransport(identifier string, private ed25519.PrivateKey, trust *TrustStore) *secureTransport {
+	return &secureTransport{
+		identifier: identifier,
+		private:    private,
+		trust:      trust,
+		sending:    make(map[string]*sendingSession),
+		receiving:  make(map[string]*receivingSession),
+		lastInit:   make(map[string]int64),
+		peers:      make(map[string]string),
+	}
+}
+
+// EnableEncryption encrypts the datagrams exchanged with the other nodes,
+// which must enable it as well. The sessions are authenticated by the keys
+// set with SetKeys. It must be called before Run, the handlers receiving the
+// packets decrypted.
+func (g *Gossiper) EnableEncryption() error {
+	if g.privateKey == nil || g.trust == nil {
+		return fmt.Errorf("encryption requires the keys of the gossiper")
+	}
+	g.server.secure = newSecureTransport(g.identifier, g.privateKey, g.trust)
+	return nil
+}
+
+// seal returns the datagrams to send to the peer for the data: the data
+// encrypted, or the handshake starting a session, the data being sent once
+// the session is established
+func (t *secureTransport) seal(data []byte, addr *net.UDPAddr) [][]byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	session, found := t.sending[addr.String()]
+	if found && session.aead != nil && time.Since(session.started) < sessionLifetime {
+		return [][]byte{session.seal(data)}
+	}
+	if !found || session.aead != nil {
+		var err error
+		session, err = t.newSendingSession()
+		if err != nil {
+			log.Printf("Failed to start session with %s: %v", addr, err)
+			return nil
+		}
+		t.sending[addr.String()] = session
+	}
+
+	if len(session.queue) < maxQueuedDatagrams {
+		session.queue = append(session.queue, data)
+	}
+	if time.Since(session.initSent) < handshakeRetry {
+		return nil
+	}
+	session.initSent = time.Now()
+	return [][]byte{session.init}
+}
+
+// open returns the data decrypted from the datagram, if any, and the
+// datagrams to send back to the peer
+func (t *secureTransport) open(datagram []byte, addr *net.UDPAddr) ([]byte, [][]byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var data []byte
+	var replies [][]byte
+	var err error
+	switch datagram[0] {
+	case datagramInit:
+		replies, err = t.uponInit(datagram[1:], addr)
+	case datagramReply:
+		replies, err = t.uponReply(datagram[1:], addr)
+	case datagramData:
+		data, err = t.uponData(datagram, addr)
+	default:
+		err = fmt.Errorf("unknown datagram type %d", datagram[0])
+	}
+	if err != nil {
+		log.Printf("Discard datagram from %s: %v", addr, err)
+		return nil, nil
+	}
+	return data, replies
+}
+
+func (t *secureTransport) newSendingSession() (*sendingSession, error) {
+	ephemeral, err := newEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+	init := handshakeInit{
+		Identifier: t.identifier,
+		Ephemeral:  ephemeral.public,
+		Time:       time.Now().UnixNano(),
+	}
+	init.Signature = ed25519.Sign(t.private, initDigest(init))
+	encoded, err := json.Marshal(init)
+	if err != nil {
+		return nil, err
+	}
+	return &sendingSession{
+		ephemeral: ephemeral,
+		init:      append([]byte{datagramInit}, encoded...),
+	}, nil
+}
+
+// uponInit answers a handshake, replacing the previous session of the peer.
+// A handshake sent again is answered with the same reply.
+func (t *secureTransport) uponInit(data []byte, addr *net.UDPAddr) ([][]byte, error) {
+	var init handshakeInit
+	if err := json.Unmarshal(data, &init); err != nil {
+		return nil, err
+	}
+	if err := t.trust.verify(init.Identifier, initDigest(init), init.Signature); err != nil {
+		return nil, err
+	}
+	if err := t.checkPeer(init.Identifier, addr); err != nil {
+		return nil, err
+	}
+
+	session, found := t.receiving[addr.String()]
+	if found && bytes.Equal(session.ephemeral, init.Ephemeral) {
+		return [][]byte{session.reply}, nil
+	}
+	age := time.Since(time.Unix(0, init.Time))
+	if age > handshakeMaxAge || age < -handshakeMaxAge || init.Time <= t.lastInit[init.Identifier] {
+		return nil, fmt.Errorf("stale handshake from %s", init.Identifier)
+	}
+
+	ephemeral, err := newEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := sessionCipher(ephemeral, init.Ephemeral, init.Ephemeral, ephemeral.public)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := handshakeReply{
+		Identifier: t.identifier,
+		Ephemeral:  ephemeral.public,
+	}
+	reply.Signature = ed25519.Sign(t.private, replyDigest(reply, init.Ephemeral))
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lastInit[init.Identifier] = init.Time
+	t.peers[addr.String()] = init.Identifier
+	t.receiving[addr.String()] = &receivingSession{
+		identifier: init.Identifier,
+		ephemeral:  init.Ephemeral,
+		reply:      append([]byte{datagramReply}, encoded...),
+		aead:       aead,
+	}
+	return [][]byte{t.receiving[addr.String()].reply}, nil
+}
+
+// uponReply establishes the session started with the peer and returns the
+// datagrams queued, encrypted
+func (t *secureTransport) uponReply(data []byte, addr *net.UDPAddr) ([][]byte, error) {
+	var reply handshakeReply
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return nil, err
+	}
+	session, found := t.sending[addr.String()]
+	if !found || session.aead != nil {
+		return nil, fmt.Errorf("unexpected handshake reply")
+	}
+	local := session.ephemeral.public
+	if err := t.trust.verify(reply.Identifier, replyDigest(reply, local), reply.Signature); err != nil {
+		return nil, err
+	}
+	if err := t.checkPeer(reply.Identifier, addr); err != nil {
+		return nil, err
+	}
+	t.peers[addr.String()] = reply.Identifier
+
+	var err error
+	session.aead, err = sessionCipher(session.ephemeral, reply.Ephemeral, local, reply.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	session.started = time.Now()
+
+	datagrams := make([][]byte, len(session.queue))
+	for i, queued := range session.queue {
+		datagrams[i] = session.seal(queued)
+	}
+	session.queue = nil
+	return datagrams, nil
+}
+
+// checkPeer returns an error if another node completed a handshake from the
+// address
+func (t *secureTransport) checkPeer(identifier string, addr *net.UDPAddr) error {
+	expected, found := t.peers[addr.String()]
+	if found && expected != identifier {
+		return fmt.Errorf("handshake of %s from the address of %s", identifier, expected)
+	}
+	return nil
+}
+
+// uponData decrypts the datagram, dropping it if it was already received
+func (t *secureTransport) uponData(datagram []byte, addr *net.UDPAddr) ([]byte, error) {
+	session, found := t.receiving[addr.String()]
+	if !found {
+		return nil, fmt.Errorf("no session")
+	}
+	if len(datagram) < headerSize {
+		return nil, fmt.Errorf("datagram too short")
+	}
+	counter := binary.BigEndian.Uint64(datagram[1:headerSize])
+	if !session.window.check(counter) {
+		return nil, fmt.Errorf("replayed datagram %d", counter)
+	}
+	data, err := session.aead.Open(nil, nonce(counter), datagram[headerSize:], datagram[:headerSize])
+	if err != nil {
+		return nil, err
+	}
+	session.window.update(counter)
+	return data, nil
+}
+
+// seal encrypts the data with the next counter
+func (s *sendingSession) seal(data []byte) []byte {
+	s.counter++
+	header := make([]byte, headerSize, headerSize+len(data)+s.aead.Overhead())
+	header[0] = datagramData
+	binary.BigEndian.PutUint64(header[1:], s.counter)
+	return s.aead.Seal(header, nonce(s.counter), data, header)
+}
+
+// ephemeralKey is an X25519 key pair used for a single session
+type ephemeralKey struct {
+	scalar []byte
+	public []byte
+}
+
+func newEphemeralKey() (*ephemeralKey, error) {
+	scalar := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(scalar); err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &ephemeralKey{scalar: scalar, public: public}, nil
+}
+
+// sessionCipher derives the key of the session from the shared secret and
+// the ephemeral keys of the initiator and of the responder
+func sessionCipher(private *ephemeralKey, peer, initiator, responder []byte) (cipher.AEAD, error) {
+	secret, err := curve25519.X25519(private.scalar, peer)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte("orbitalswarm session"))
+	h.Write(secret)
+	h.Write(initiator)
+	h.Write(responder)
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(counter uint64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[4:], counter)
+	return n
+}
+
+func initDigest(init handshakeInit) []byte {
+	return []byte(fmt.Sprintf("init/%s/%x/%d", init.Identifier, init.Ephemeral, init.Time))
+}
+
+func replyDigest(reply handshakeReply, initiator []byte) []byte {
+	return []byte(fmt.Sprintf("reply/%s/%x/%x", reply.Identifier, initiator, reply.Ephemeral))
+}
+
+// replayWindow tracks the counters received among the 64 last ones
+type replayWindow struct {
+	highest uint64
+	seen    uint64
+}
+
+// check returns whether the counter was not received yet and is recent enough
+func (w *replayWindow) check(counter uint64) bool {
+	if counter == 0 {
+		return false
+	}
+	if counter > w.highest {
+		return true
+	}
+	offset := w.highest - counter
+	return offset < 64 && w.seen&(1<<offset) == 0
+}
+
+func (w *replayWindow) update(counter uint64) {
+	if counter > w.highest {
+		shift := counter - w.highest
+		if shift >= 64 {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.highest = counter
+		return
+	}
+	w.seen |= 1 << (w.highest - counter)
+}
@@ -0,0 +1,123 @@
+package gossip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTransport(t *testing.T, identifier string, trust *TrustStore) *secureTransport {
+	private, err := trust.NewKey(identifier)
+	require.NoError(t, err)
+	return newSecureTransport(identifier, private, trust)
+}
+
+func TestSecureTransport_session(t *testing.T) {
+	trust := NewTrustStore()
+	drone := newTestTransport(t, "drone0", trust)
+	gs := newTestTransport(t, "GS", trust)
+	droneAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	gsAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	// The first datagram waits for the handshake
+	datagrams := drone.seal([]byte("location"), gsAddr)
+	require.Len(t, datagrams, 1)
+	require.Equal(t, byte(datagramInit), datagrams[0][0])
+	require.Empty(t, drone.seal([]byte("status"), gsAddr))
+
+	data, replies := gs.open(datagrams[0], droneAddr)
+	require.Nil(t, data)
+	require.Len(t, replies, 1)
+	data, queued := drone.open(replies[0], gsAddr)
+	require.Nil(t, data)
+	require.Len(t, queued, 2)
+
+	// The queued datagrams are encrypted
+	for i, plain := range []string{"location", "status"} {
+		require.NotContains(t, string(queued[i]), plain)
+		data, _ := gs.open(queued[i], droneAddr)
+		require.Equal(t, plain, string(data))
+	}
+
+	// A replayed datagram is dropped
+	data, _ = gs.open(queued[0], droneAddr)
+	require.Nil(t, data)
+
+	// So is a modified one
+	datagram := drone.seal([]byte("command"), gsAddr)[0]
+	datagram[len(datagram)-1] ^= 1
+	data, _ = gs.open(datagram, droneAddr)
+	require.Nil(t, data)
+
+	// The datagrams received out of order are accepted once
+	first := drone.seal([]byte("first"), gsAddr)[0]
+	second := drone.seal([]byte("second"), gsAddr)[0]
+	data, _ = gs.open(second, droneAddr)
+	require.Equal(t, "second", string(data))
+	data, _ = gs.open(first, droneAddr)
+	require.Equal(t, "first", string(data))
+	data, _ = gs.open(first, droneAddr)
+	require.Nil(t, data)
+}
+
+func TestSecureTransport_handshake(t *testing.T) {
+	trust := NewTrustStore()
+	drone := newTestTransport(t, "drone0", trust)
+	gs := newTestTransport(t, "GS", trust)
+	rogue := newSecureTransport("drone1", drone.private, NewTrustStore())
+	droneAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	gsAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	// A handshake signed by a key which is not trusted for the identifier is rejected
+	init := rogue.seal([]byte("command"), gsAddr)[0]
+	_, replies := gs.open(init, droneAddr)
+	require.Empty(t, replies)
+
+	// A handshake sent again is answered with the same reply
+	init = drone.seal([]byte("location"), gsAddr)[0]
+	_, replies = gs.open(init, droneAddr)
+	require.Len(t, replies, 1)
+	_, again := gs.open(init, droneAddr)
+	require.Equal(t, replies, again)
+
+	// A replayed handshake does not replace a newer session
+	drone.sending = make(map[string]*sendingSession)
+	newer := drone.seal([]byte("location"), gsAddr)[0]
+	_, replies = gs.open(newer, droneAddr)
+	require.Len(t, replies, 1)
+	_, stale := gs.open(init, droneAddr)
+	require.Empty(t, stale)
+	_, queued := drone.open(replies[0], gsAddr)
+	require.Len(t, queued, 1)
+	data, _ := gs.open(queued[0], droneAddr)
+	require.Equal(t, "location", string(data))
+}
+
+func TestSecureTransport_pinnedPeer(t *testing.T) {
+	trust := NewTrustStore()
+	drone := newTestTransport(t, "drone0", trust)
+	gs := newTestTransport(t, "GS", trust)
+	impostor := newTestTransport(t, "drone1", trust)
+	droneAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	gsAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+
+	init := drone.seal([]byte("location"), gsAddr)[0]
+	_, replies := gs.open(init, droneAddr)
+	_, queued := drone.open(replies[0], gsAddr)
+	require.Len(t, queued, 1)
+
+	// Another trusted node cannot answer the next handshake in place of the
+	// ground station
+	drone.sending = make(map[string]*sendingSession)
+	init = drone.seal([]byte("location"), gsAddr)[0]
+	_, replies = impostor.open(init, droneAddr)
+	require.Len(t, replies, 1)
+	_, queued = drone.open(replies[0], gsAddr)
+	require.Empty(t, queued)
+
+	// Nor start a session from the address of the drone
+	init = impostor.seal([]byte("command"), gsAddr)[0]
+	_, replies = gs.open(init, droneAddr)
+	require.Empty(t, replies)
+}
@@ -4,11 +4,10 @@
 package gossip
 
 import (
+	"fmt"
 	"math/rand"
 	"net"
 	"time"
-
-	"go.dedis.ch/onet/v3/log"
 )
 
 // TimeoutMongering time we wait for an ack before reinvoking the rumor
@@ -20,6 +19,10 @@ const ackSynchronised = 2
 
 // Exec is the function that the gossiper uses to execute the handler for a RumorMessage
 func (msg *RumorMessage) Exec(g *Gossiper, addr *net.UDPAddr) error {
+	// Reject the rumors not signed by their origin
+	if err := g.authenticate(msg.Origin, msg.digest, msg.Signature, addr); err != nil {
+		return fmt.Errorf("reject rumor %d: %v", msg.ID, err)
+	}
 
 	// If we receive our own rumor
 	if msg.Origin == g.identifier && addr != g.server.Address {
@@ -85,7 +88,7 @@ func (msg *RumorMessage) PropagateRumor(g *Gossiper, addr *net.UDPAddr, exceptNo
 	g.handler.mutexReinvoke.Lock()
 	defer g.handler.mutexReinvoke.Unlock()
 
-	if !g.handler.close {
+	if !g.handler.isClosed() {
 		reinvoke, ok := g.handler.reinvokeMap[addr.String()]
 		if !ok {
 			reinvoke = &ReinvokeAddr{rumors: make([]*ReinvokeRumor, 0)}
@@ -105,7 +108,7 @@ func (msg *RumorMessage) PropagateRumor(g *Gossiper, addr *net.UDPAddr, exceptNo
 			func() {
 				reinvoke.mutex.Lock()
 				defer reinvoke.mutex.Unlock()
-				if g.handler.close {
+				if g.handler.isClosed() {
 					// Cancel wake up
 					return
 				}
@@ -121,10 +124,7 @@ func (msg *RumorMessage) PropagateRumor(g *Gossiper, addr *net.UDPAddr, exceptNo
 				reinvoke.rumors = reinvoke.rumors[:len(reinvoke.rumors)-1]
 			}()
 
-			defer func() {
-				recover()
-			}()
-			g.handler.chanReinvokeQueue <- reinvokeRumor
+			g.handler.reinvoke(reinvokeRumor)
 		})
 	}
 
@@ -138,6 +138,9 @@ func (msg *RumorMessage) PropagateRumor(g *Gossiper, addr *net.UDPAddr, exceptNo
 
 // Exec is the function that the gossiper uses to execute the handler for a StatusMessage
 func (msg *StatusPacket) Exec(g *Gossiper, addr *net.UDPAddr) error {
+	// Encode the next packets sent to the peer in the version it decodes
+	g.setPeerVersion(addr, msg.Version)
+
 	// Compare vector clock
 	messageToSend := make([]PeerStatus, 0)
 	messageToReceive := false
@@ -147,7 +150,7 @@ func (msg *StatusPacket) Exec(g *Gossiper, addr *net.UDPAddr) error {
 		id := identifier.(string)
 		for _, peer := range msg.Want {
 			if peer.Identifier == id {
-				if peer.NextID < tracking.nextID {
+				if peer.NextID < tracking.next() {
 					messageToSend = append(messageToSend, peer)
 				}
 				return true
@@ -164,7 +167,7 @@ func (msg *StatusPacket) Exec(g *Gossiper, addr *net.UDPAddr) error {
 	if len(messageToSend) == 0 {
 		for _, msg := range msg.Want {
 			track, ok := g.messages.Load(msg.Identifier)
-			if !ok || track.(*messageTracking).nextID < msg.NextID {
+			if !ok || track.(*messageTracking).next() < msg.NextID {
 				messageToReceive = true
 				break
 			}
@@ -185,7 +188,7 @@ func (msg *StatusPacket) Exec(g *Gossiper, addr *net.UDPAddr) error {
 		g.handler.mutexReinvoke.Lock()
 		defer g.handler.mutexReinvoke.Unlock()
 
-		if g.handler.close {
+		if g.handler.isClosed() {
 			return
 		}
 
@@ -208,15 +211,7 @@ func (msg *StatusPacket) Exec(g *Gossiper, addr *net.UDPAddr) error {
 
 					if coin {
 						// Continue rumor mongering
-						go func() {
-							defer func() {
-								err := recover()
-								if err != nil {
-									log.Printf("recover %s", err)
-								}
-							}()
-							g.handler.chanReinvokeQueue <- rumor
-						}()
+						go g.handler.reinvoke(rumor)
 					}
 				}
 			}
@@ -231,7 +226,7 @@ func (msg *StatusPacket) Exec(g *Gossiper, addr *net.UDPAddr) error {
 				tracking := track.(*messageTracking)
 				var rangeID uint32 = 1
 				if ok {
-					rangeID = tracking.nextID
+					rangeID = tracking.next()
 				}
 				for i := packet.NextID; i < rangeID; i++ {
 					message, _ := tracking.messages.Load(i)
@@ -251,6 +246,10 @@ func (msg *StatusPacket) Exec(g *Gossiper, addr *net.UDPAddr) error {
 
 // Exec is the function that the gossiper uses to execute the handler for a PrivateMessage
 func (msg *PrivateMessage) Exec(g *Gossiper, addr *net.UDPAddr) error {
+	// Reject the private messages not signed by their origin
+	if err := g.authenticate(msg.Origin, msg.digest, msg.Signature, addr); err != nil {
+		return fmt.Errorf("reject private message: %v", err)
+	}
 	// Update route
 	g.updateRoute(msg.Origin, addr.String(), msg.ID, true)
 
@@ -0,0 +1,136 @@
+package gossip
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// TrustStore lists the public keys of the nodes, the ground station and the
+// drones, by identifier. The packets of the nodes it does not list are
+// rejected.
+type TrustStore struct {
+	mutex sync.RWMutex
+	keys  map[string]ed25519.PublicKey
+}
+
+// NewTrustStore returns an empty trust store
+func NewTrustStore() *TrustStore {
+	return &TrustStore{
+		keys: make(map[string]ed25519.PublicKey),
+	}
+}
+
+// Add trusts the key for the packets originating from the identifier
+func (t *TrustStore) Add(identifier string, key ed25519.PublicKey) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.keys[identifier] = key
+}
+
+// NewKey generates the key pair of the identifier, trusting its public key,
+// and returns its private key
+func (t *TrustStore) NewKey(identifier string) (ed25519.PrivateKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the key of %s: %v", identifier, err)
+	}
+	t.Add(identifier, public)
+	return private, nil
+}
+
+// verify checks that the signature of the data was made by the origin
+func (t *TrustStore) verify(origin string, data []byte, signature []byte) error {
+	t.mutex.RLock()
+	key, found := t.keys[origin]
+	t.mutex.RUnlock()
+
+	if !found {
+		return fmt.Errorf("unknown origin %s", origin)
+	}
+	if len(signature) == 0 {
+		return fmt.Errorf("unsigned packet from %s", origin)
+	}
+	if len(signature) != ed25519.SignatureSize || !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("invalid signature from %s", origin)
+	}
+	return nil
+}
+
+// SetKeys makes the gossiper sign the rumors and the private messages it
+// originates with the private key, and reject the ones received which are not
+// signed by their origin as listed in the trust store. It must be called
+// before Run. Without keys, the packets are neither signed nor verified.
+func (g *Gossiper) SetKeys(private ed25519.PrivateKey, trust *TrustStore) {
+	g.privateKey = private
+	g.trust = trust
+}
+
+// digest returns the data signed for the rumor, its encoding without the
+// signature
+func (msg *RumorMessage) digest() ([]byte, error) {
+	unsigned := *msg
+	unsigned.Signature = nil
+	return json.Marshal(unsigned)
+}
+
+// digest returns the data signed for the private message, its encoding
+// without the signature and the hop limit, decreased by each hop
+func (msg *PrivateMessage) digest() ([]byte, error) {
+	unsigned := *msg
+	unsigned.Signature = nil
+	unsigned.HopLimit = 0
+	return json.Marshal(unsigned)
+}
+
+// signRumor signs the rumor as the receivers decode it from the network, as
+// the encoding of some fields, such as empty slices, changes once decoded
+func (g *Gossiper) signRumor(msg *RumorMessage) {
+	if g.privateKey == nil {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err == nil {
+		var decoded RumorMessage
+		err = json.Unmarshal(data, &decoded)
+		if err == nil {
+			data, err = decoded.digest()
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to sign rumor %d: %v", msg.ID, err)
+		return
+	}
+	msg.Signature = ed25519.Sign(g.privateKey, data)
+}
+
+// signPrivate signs the private message
+func (g *Gossiper) signPrivate(msg *PrivateMessage) {
+	if g.privateKey == nil {
+		return
+	}
+	data, err := msg.digest()
+	if err != nil {
+		log.Printf("Failed to sign private message: %v", err)
+		return
+	}
+	msg.Signature = ed25519.Sign(g.privateKey, data)
+}
+
+// authenticate checks the signature of a packet received from the network.
+// The packets originating from the gossiper itself are handled as is.
+func (g *Gossiper) authenticate(origin string, digest func() ([]byte, error), signature []byte, addr *net.UDPAddr) error {
+	if g.trust == nil || addr == g.server.Address {
+		return nil
+	}
+	data, err := digest()
+	if err != nil {
+		return err
+	}
+	return g.trust.verify(origin, data, signature)
+}
@@ -0,0 +1,95 @@
+package gossip
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func newTestGossiper(t *testing.T, identifier string, trust *TrustStore) *Gossiper {
+	g, err := NewGossiper("127.0.0.1:0", identifier, 10, 0, 2)
+	require.NoError(t, err)
+	private, err := trust.NewKey(identifier)
+	require.NoError(t, err)
+	g.SetKeys(private, trust)
+	return g
+}
+
+// receive returns the packet as decoded by a receiver
+func receive(t *testing.T, g *Gossiper, packet GossipPacket) *GossipPacket {
+	data, err := g.encodePacket(packet, 0)
+	require.NoError(t, err)
+	var decoded GossipPacket
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	return &decoded
+}
+
+func TestTrustStore_rumor(t *testing.T) {
+	trust := NewTrustStore()
+	sender := newTestGossiper(t, "GS", trust)
+	receiver := newTestGossiper(t, "drone0", trust)
+	defer sender.server.socket.Close()
+	defer receiver.server.socket.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	block := blk.NewGenericBlockFactory().NewGenesisBlock(blk.BlockMappingStr, 0, &blk.MappingBlockContent{
+		PatternID: "pattern",
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 2, Z: 3}},
+	})
+	rumor := &RumorMessage{
+		Origin: "GS",
+		ID:     1,
+		Extra:  &extramessage.ExtraMessage{PaxosTLC: &extramessage.PaxosTLC{Value: block}},
+	}
+	sender.signRumor(rumor)
+
+	decoded := receive(t, sender, GossipPacket{Rumor: rumor}).Rumor
+	require.NoError(t, receiver.authenticate(decoded.Origin, decoded.digest, decoded.Signature, addr))
+
+	// A rumor relayed by another node keeps its signature
+	relayed := receive(t, receiver, GossipPacket{Rumor: decoded}).Rumor
+	require.NoError(t, receiver.authenticate(relayed.Origin, relayed.digest, relayed.Signature, addr))
+
+	// A modified rumor is rejected
+	decoded.Extra.PaxosTLC.Node = 3
+	require.Error(t, receiver.authenticate(decoded.Origin, decoded.digest, decoded.Signature, addr))
+
+	// So is a rumor claiming another origin, or an unsigned rumor
+	forged := receive(t, sender, GossipPacket{Rumor: rumor}).Rumor
+	forged.Origin = "drone0"
+	require.Error(t, receiver.authenticate(forged.Origin, forged.digest, forged.Signature, addr))
+	unsigned := &RumorMessage{Origin: "GS", ID: 2, Text: "unsigned"}
+	require.Error(t, unsigned.Exec(receiver, addr))
+	unknown := &RumorMessage{Origin: "rogue", ID: 1, Text: "unknown"}
+	require.Error(t, unknown.Exec(receiver, addr))
+}
+
+func TestTrustStore_private(t *testing.T) {
+	trust := NewTrustStore()
+	sender := newTestGossiper(t, "drone0", trust)
+	receiver := newTestGossiper(t, "GS", trust)
+	defer sender.server.socket.Close()
+	defer receiver.server.socket.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	private := &PrivateMessage{
+		Origin:      "drone0",
+		Data:        PrivateMessageData{Location: r3.Vec{X: 1, Y: 2, Z: 3}, DroneID: 0},
+		Destination: "GS",
+		HopLimit:    10,
+	}
+	sender.signPrivate(private)
+
+	// The hop limit decreased by the hops is not signed
+	decoded := receive(t, sender, GossipPacket{Private: private}).Private
+	decoded.HopLimit--
+	require.NoError(t, receiver.authenticate(decoded.Origin, decoded.digest, decoded.Signature, addr))
+
+	decoded.Data.Location.X = 5
+	require.Error(t, decoded.Exec(receiver, addr))
+}
@@ -2,7 +2,9 @@ package gossip
 
 import (
 	"bytes"
+	"math/rand"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"go.dedis.ch/onet/v3/log"
@@ -12,6 +14,10 @@ import (
 // connection, so that the listener knows it can stop listening.
 const stopMsg = "stop"
 
+// readBufferSize is the size of the largest UDP datagram, so that no
+// datagram is truncated
+const readBufferSize = 65536
+
 // UDPServer server
 type UDPServer struct {
 	Address *net.UDPAddr
@@ -19,10 +25,24 @@ type UDPServer struct {
 
 	listener       <-chan UDPPacket
 	listenerClosed <-chan bool
-	sender         chan<- UDPPacket
+	sender         chan UDPPacket
 	senderClosed   <-chan bool
+	// stopped is closed once the server stops, the packets sent afterwards
+	// being dropped
+	stopped chan struct{}
+
+	// close is set atomically once the server is stopping
+	close int32
 
-	close bool
+	// secure encrypts the datagrams, if enabled
+	secure *secureTransport
+
+	// packetID is the ID of the last packet sent in fragments, sent keeps
+	// their fragments and fragments reassembles the packets received in
+	// fragments
+	packetID  uint64
+	sent      *sentPackets
+	fragments *reassembler
 
 	handlingFinished chan bool
 }
@@ -31,6 +51,11 @@ type UDPServer struct {
 type UDPPacket struct {
 	data []byte
 	addr *net.UDPAddr
+
+	// datagrams are sent as is instead of the data, if set, and fragments
+	// are sent again, encrypted if need be
+	datagrams [][]byte
+	fragments [][]byte
 }
 
 // NewUDPServer create a new udp server
@@ -52,34 +77,46 @@ func NewUDPServer(addr string) (*UDPServer, error) {
 		Address:          udpAddress.(*net.UDPAddr),
 		handlingFinished: make(chan bool),
 		socket:           socket,
+		sender:           make(chan UDPPacket, 1024),
+		stopped:          make(chan struct{}),
+		packetID:         rand.Uint64(),
+		sent:             newSentPackets(),
+		fragments:        newReassembler(),
 	}
 
 	return server, nil
 }
 
 // Run Start the udp server
-func (s *UDPServer) Run() (<-chan UDPPacket, chan<- UDPPacket, chan<- bool) {
+func (s *UDPServer) Run() (<-chan UDPPacket, chan<- bool) {
 
-	// Start udpSender and udpListener
-	listener, listenerClosed := s.udpListener()
-	sender, senderClosed := s.udpSender()
+	// Start udpSender and udpListener, which sends the handshake replies
+	// through the sender
+	s.senderClosed = s.udpSender()
 
+	listener, listenerClosed := s.udpListener()
 	s.listenerClosed = listenerClosed
 	s.listener = listener
-	s.senderClosed = senderClosed
-	s.sender = sender
 
-	return listener, sender, s.handlingFinished
+	return listener, s.handlingFinished
+}
+
+// send queues the packet for the sender, unless the server is stopped
+func (s *UDPServer) send(packet UDPPacket) {
+	select {
+	case s.sender <- packet:
+	case <-s.stopped:
+	}
 }
 
 // Stop the server
 func (s *UDPServer) Stop() {
-	s.close = true
+	atomic.StoreInt32(&s.close, 1)
 	s.socket.WriteTo([]byte(stopMsg), s.Address)
 
 	<-s.listenerClosed
 	<-s.handlingFinished
-	close(s.sender)
+	close(s.stopped)
 
 	<-s.senderClosed
 	s.socket.Close()
@@ -99,13 +136,18 @@ func (s *UDPServer) udpListener() (<-chan UDPPacket, <-chan bool) {
 			}
 		}()
 		for {
-			buffer := make([]byte, 20480)
+			buffer := make([]byte, readBufferSize)
 
-			s.socket.SetReadDeadline(time.Now().Add(2 * time.Second))
+			// The listener wakes up to ask for the missing fragments
+			timeout := 2 * time.Second
+			if len(s.fragments.pending) > 0 {
+				timeout = nackInterval
+			}
+			s.socket.SetReadDeadline(time.Now().Add(timeout))
 
 			length, src, _ := s.socket.ReadFromUDP(buffer)
 
-			if bytes.Compare(buffer[:len(stopBytes)], stopBytes) == 0 || src == s.Address || s.close {
+			if bytes.Compare(buffer[:len(stopBytes)], stopBytes) == 0 || src == s.Address || atomic.LoadInt32(&s.close) == 1 {
 				// Close listener
 				close(listener)
 				close(listeningClosed)
@@ -113,7 +155,20 @@ func (s *UDPServer) udpListener() (<-chan UDPPacket, <-chan bool) {
 			} else if length == 0 {
 				// Discard the message
 			} else {
-				listener <- UDPPacket{data: buffer[:length], addr: src}
+				data := buffer[:length]
+				if s.secure != nil {
+					var replies [][]byte
+					data, replies = s.secure.open(data, src)
+					if len(replies) > 0 {
+						s.send(UDPPacket{addr: src, datagrams: replies})
+					}
+				}
+				if data != nil {
+					s.receive(data, src, listener)
+				}
+			}
+			for _, nack := range s.fragments.nacks(time.Now()) {
+				s.send(nack)
 			}
 		}
 	}()
@@ -121,20 +176,30 @@ func (s *UDPServer) udpListener() (<-chan UDPPacket, <-chan bool) {
 	return listener, listeningClosed
 }
 
-func (s *UDPServer) udpSender() (chan<- UDPPacket, <-chan bool) {
-	sending := make(chan UDPPacket, 1024)
+func (s *UDPServer) udpSender() <-chan bool {
 	sendingClosed := make(chan bool)
 
 	go func() {
 		for {
-			packet, ok := <-sending
-			if ok {
-				_, err := s.socket.WriteTo(packet.data, packet.addr)
-				if err != nil {
-					// Discard the message
-					log.Printf("Discarded message while sending on socket")
+			select {
+			case packet := <-s.sender:
+				datagrams := packet.datagrams
+				if datagrams == nil {
+					datagrams = s.seal(packet)
 				}
-			} else {
+				for i, datagram := range datagrams {
+					if i > 0 {
+						// Pace the fragments, so that they do not overflow
+						// the receive buffer of the peer
+						time.Sleep(fragmentInterval)
+					}
+					_, err := s.socket.WriteTo(datagram, packet.addr)
+					if err != nil {
+						// Discard the message
+						log.Printf("Discarded message while sending on socket")
+					}
+				}
+			case <-s.stopped:
 				// Close sender
 				s.socket.Close()
 				close(sendingClosed)
@@ -142,5 +207,52 @@ func (s *UDPServer) udpSender() (chan<- UDPPacket, <-chan bool) {
 			}
 		}
 	}()
-	return sending, sendingClosed
+	return sendingClosed
+}
+
+// seal returns the datagrams carrying the data of the packet, in fragments
+// and encrypted if need be
+func (s *UDPServer) seal(packet UDPPacket) [][]byte {
+	datagrams := packet.fragments
+	if datagrams == nil {
+		var err error
+		datagrams, err = s.fragment(packet.data)
+		if err != nil {
+			log.Printf("Discarded message to %s: %v", packet.addr, err)
+			return nil
+		}
+		if len(datagrams) > 1 {
+			s.sent.add(packet.addr, s.packetID, datagrams, time.Now())
+		}
+	}
+	if s.secure == nil {
+		return datagrams
+	}
+	var sealed [][]byte
+	for _, datagram := range datagrams {
+		sealed = append(sealed, s.secure.seal(datagram, packet.addr)...)
+	}
+	return sealed
+}
+
+// receive hands the data of a datagram to the listener once its packet is
+// complete, and sends again the fragments requested by a NACK
+func (s *UDPServer) receive(data []byte, src *net.UDPAddr, listener chan<- UDPPacket) {
+	if len(data) > 0 && data[0] == nackMarker {
+		fragments, err := s.sent.missing(data, src)
+		if err != nil {
+			log.Printf("Discard NACK from %s: %v", src, err)
+			return
+		}
+		s.send(UDPPacket{addr: src, fragments: fragments})
+		return
+	}
+	data, err := s.fragments.add(data, src, time.Now())
+	if err != nil {
+		log.Printf("Discard fragment from %s: %v", src, err)
+		return
+	}
+	if data != nil {
+		listener <- UDPPacket{data: data, addr: src}
+	}
 }
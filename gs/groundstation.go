@@ -16,6 +16,7 @@ import (
 	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
 
 	"go.dedis.ch/cs438/orbitalswarm/drone/consensus"
+	"go.dedis.ch/cs438/orbitalswarm/environment"
 	"go.dedis.ch/cs438/orbitalswarm/extramessage"
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
 	"gonum.org/v1/gonum/spatial/r3"
@@ -44,8 +45,10 @@ type GroundStation struct {
 
 	consensus    consensus.ConsensusClient
 	patternID    int
+	statePath    string
 	drones       []r3.Vec
 	nextPosition []r3.Vec
+	environment  *environment.Environment
 
 	running int
 	handler chan []byte
@@ -53,8 +56,15 @@ type GroundStation struct {
 
 // NewGroundStation returns the controller that sets up the gossiping state machine
 // as well as the web routing. It uses the same gossiping address for the
-// identifier.
-func NewGroundStation(identifier, uiAddress, gossipAddress string, g *gossip.Gossiper, drones []r3.Vec, consensus consensus.ConsensusClient) *GroundStation {
+// identifier. Targets in the no-fly zones of the environment are rejected.
+// The last pattern ID is persisted in the state file, if not empty, the
+// pattern IDs sequencing the commands sent to the drones.
+func NewGroundStation(identifier, uiAddress, gossipAddress string, g *gossip.Gossiper, drones []r3.Vec, env *environment.Environment, consensus consensus.ConsensusClient, statePath string) (*GroundStation, error) {
+	patternID, err := loadPatternID(statePath)
+	if err != nil {
+		return nil, err
+	}
+
 	handler := make(chan []byte)
 	gs := &GroundStation{
 		identifier:    identifier,
@@ -63,14 +73,16 @@ func NewGroundStation(identifier, uiAddress, gossipAddress string, g *gossip.Gos
 		gossiper:      g,
 		handler:       handler,
 
-		consensus: consensus,
-		patternID: 0,
-		drones:    drones,
-		running:   0,
+		consensus:   consensus,
+		patternID:   patternID,
+		statePath:   statePath,
+		drones:      drones,
+		environment: env,
+		running:     0,
 	}
 
 	g.RegisterCallback(gs.handleGossipMessage)
-	return gs
+	return gs, nil
 }
 
 // Run Launch the groundstation
@@ -111,8 +123,9 @@ func (g *GroundStation) Run() {
 
 func (g *GroundStation) getInitialData() []byte {
 	data, _ := json.Marshal(InitMessage{
-		Identifier: g.identifier,
-		Drones:     g.drones,
+		Identifier:  g.identifier,
+		Drones:      g.drones,
+		Environment: g.environment,
 	})
 	return data
 }
@@ -127,16 +140,36 @@ func (g *GroundStation) handleWebSocketMessage(message []byte) []byte {
 		return nil
 	}
 
+	for i, target := range m.Targets {
+		if !g.environment.Allowed(target) {
+			log.Printf("Target %v in a no-fly zone. Pattern rejected", target)
+			message, _ := json.Marshal(ErrorMessage{
+				Error: fmt.Sprintf("target %d at (%g, %g, %g) is in a no-fly zone", i, target.X, target.Y, target.Z),
+			})
+			return message
+		}
+	}
+
+	// The pattern ID is persisted before being used, never to be reused
 	g.patternID++
+	if err := savePatternID(g.statePath, g.patternID); err != nil {
+		log.Printf("Failed to persist pattern %d: %v", g.patternID, err)
+		message, _ := json.Marshal(ErrorMessage{
+			Error: fmt.Sprintf("pattern %d could not be persisted", g.patternID),
+		})
+		return message
+	}
+
 	log.Printf("Send swarmInit")
 	g.gossiper.AddExtraMessage(&extramessage.ExtraMessage{
 		SwarmInit: &extramessage.SwarmInit{
 			PatternID:  strconv.Itoa(g.patternID),
 			InitialPos: g.drones,
 			TargetPos:  m.Targets,
+			Sequence:   uint64(g.patternID),
+			Time:       time.Now().UnixNano(),
 		},
 	})
-	g.nextPosition = m.Targets
 	g.running = len(g.drones)
 
 	// Nothing to send back
@@ -148,10 +181,15 @@ func (g *GroundStation) handleGossipMessage(origin string, msg gossip.GossipPack
 	// In case of other type of message
 	if msg.Rumor != nil {
 		if msg.Rumor.Extra != nil {
-			blockContainer := g.consensus.HandleExtraMessage(g.gossiper, msg.Rumor.Extra)
+			blockContainer := g.consensus.HandleExtraMessage(g.gossiper, origin, msg.Rumor.Extra)
 			if blockContainer != nil && blockContainer.Type == blk.BlockPathStr {
+				// Audit the chain agreed by the drones before showing the paths
+				if err := blk.Verify(g.consensus.GetBlocks()); err != nil {
+					log.Printf("Invalid chain: %v", err)
+				}
 				block := blockContainer.GetContent().(*blk.PathBlockContent)
 				paths := block.Paths
+				g.nextPosition = finalPositions(g.drones, paths)
 				log.Printf("Detect simulation for UI")
 				message, _ := json.Marshal(SimulationMessage{
 					Paths: paths,
@@ -186,6 +224,22 @@ func (g *GroundStation) handleGossipMessage(origin string, msg gossip.GossipPack
 	}
 }
 
+// finalPositions returns the locations reached by the drones at the end of
+// their paths. The targets sent to the drones may not be all used, and idle
+// drones keep their location.
+func finalPositions(drones []r3.Vec, paths [][]r3.Vec) []r3.Vec {
+	positions := append([]r3.Vec{}, drones...)
+	for i, path := range paths {
+		if i >= len(positions) {
+			break
+		}
+		for _, move := range path {
+			positions[i] = positions[i].Add(move)
+		}
+	}
+	return positions
+}
+
 // logging is a utility function that logs the http server events
 func logging(logger zerolog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -0,0 +1,104 @@
+package gs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/drone"
+	"go.dedis.ch/cs438/orbitalswarm/drone/consensus"
+	"go.dedis.ch/cs438/orbitalswarm/drone/mapping"
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/pathgenerator"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// TestGroundStation_restart restarts the ground station while the drones
+// run, the commands sent afterwards following the ones sent before
+func TestGroundStation_restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	numDrones := 3
+
+	trust := gossip.NewTrustStore()
+	swarm, positions := drone.NewSwarm(drone.SwarmConfig{
+		NumDrones:         numDrones,
+		NumParticipants:   numDrones,
+		FirstUIPort:       2522,
+		FirstGossipPort:   5300,
+		BaseUIAddress:     "127.0.0.1",
+		BaseGossipAddress: "127.0.0.1",
+		AntiEntropy:       10,
+		PaxosRetry:        3,
+		Mapper:            mapping.BottleneckMapping,
+		Protocol:          consensus.PaxosProtocol,
+		Planner:           pathgenerator.GeneticPlanner,
+		Environment:       environment.Empty(),
+		Limits:            trajectory.DefaultLimits,
+		Profile:           trajectory.TrapezoidalProfile,
+		SafetyRadius:      pathgenerator.DefaultSafetyRadius,
+		StorageDir:        dir,
+		Trust:             trust,
+	})
+	go swarm.Run()
+	defer swarm.Stop()
+
+	g, err := gossip.GetFactory().New("127.0.0.1:33002", "GS", 10, 0, numDrones)
+	require.NoError(t, err)
+	private, err := trust.NewKey("GS")
+	require.NoError(t, err)
+	g.SetKeys(private, trust)
+	g.AddAddresses(swarm.DronesAddresses()...)
+	ready := make(chan struct{})
+	go g.Run(ready)
+	<-ready
+	defer g.Stop()
+
+	// start creates a ground station from the state file, as main does with
+	// a storage directory
+	start := func() *GroundStation {
+		reader, err := consensus.NewConsensusClient(consensus.PaxosProtocol, numDrones, numDrones+1, 3, nil, "")
+		require.NoError(t, err)
+		station, err := NewGroundStation("GS", "127.0.0.1:0", "", g, positions, environment.Empty(), reader, filepath.Join(dir, "gs.json"))
+		require.NoError(t, err)
+		station.hub = newHub(station.getInitialData, station.handleWebSocketMessage)
+		go station.hub.run()
+		return station
+	}
+	// send makes the ground station send the targets, and waits until every
+	// drone committed their mapping
+	send := func(station *GroundStation, patternID string, targets []r3.Vec) {
+		message, err := json.Marshal(TargetMessage{Targets: targets})
+		require.NoError(t, err)
+		require.Nil(t, station.handleWebSocketMessage(message))
+		require.Eventually(t, func() bool {
+			for _, mapping := range swarm.DroneMappings(patternID) {
+				if mapping == nil {
+					return false
+				}
+			}
+			return true
+		}, 30*time.Second, 100*time.Millisecond, patternID)
+	}
+
+	send(start(), "1", []r3.Vec{
+		r3.Vec{X: 0, Y: 10, Z: 0},
+		r3.Vec{X: 0, Y: 10, Z: 2},
+		r3.Vec{X: 2, Y: 10, Z: 0},
+	})
+
+	// The restarted ground station goes on from the pattern ID it persisted,
+	// so that the drones accept its next command
+	send(start(), "2", []r3.Vec{
+		r3.Vec{X: 0, Y: 20, Z: 0},
+		r3.Vec{X: 0, Y: 20, Z: 2},
+		r3.Vec{X: 2, Y: 20, Z: 0},
+	})
+}
@@ -68,7 +68,7 @@ func (h *Hub) run() {
 				}
 			}
 		case message := <-h.wsReceived:
-			log.Printf("Broad %s", message)
+			log.Printf("Broad %s", message.data)
 			res := h.onMessageReceived(message.data)
 			if res != nil {
 				select {
@@ -1,6 +1,9 @@
 package gs
 
-import "gonum.org/v1/gonum/spatial/r3"
+import (
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"gonum.org/v1/gonum/spatial/r3"
+)
 
 type Message interface{}
 
@@ -13,8 +16,9 @@ type SimulationMessage struct {
 }
 
 type InitMessage struct {
-	Identifier string
-	Drones     []r3.Vec
+	Identifier  string
+	Drones      []r3.Vec
+	Environment *environment.Environment
 }
 
 type UpdateMessage struct {
@@ -25,3 +29,7 @@ type UpdateMessage struct {
 type ReadyMessage struct {
 	Ready bool
 }
+
+type ErrorMessage struct {
+	Error string
+}
@@ -0,0 +1,61 @@
+package gs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// state is what the ground station persists, so that a restarted ground
+// station never reuses a pattern ID, which is the sequence of its commands
+type state struct {
+	PatternID int
+}
+
+// loadPatternID returns the last pattern ID persisted in the file, 0 if the
+// file does not exist or if the path is empty
+func loadPatternID(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var s state
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid state %s: %v", path, err)
+	}
+	return s.PatternID, nil
+}
+
+// savePatternID persists the pattern ID, replacing the file once the new one
+// is synced to disk. An empty path persists nothing.
+func savePatternID(path string, patternID int) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state{PatternID: patternID})
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -124,6 +124,65 @@ App.scene = {
    swap: () => {
       App.scene.data.swapped = !App.scene.data.swapped;
    },
+   addEnvironment: (environment) => {
+      const material = new THREE.MeshLambertMaterial({
+         color: 0xff3333,
+         transparent: true,
+         opacity: 0.4,
+      });
+      const meshes = [];
+
+      (environment.Boxes || []).forEach((b) => {
+         const geometry = new THREE.BoxGeometry(
+            b.Max.X - b.Min.X,
+            b.Max.Y - b.Min.Y,
+            b.Max.Z - b.Min.Z
+         );
+         const mesh = new THREE.Mesh(geometry, material);
+         // Drones are drawn half a unit above their location
+         mesh.position.set(
+            (b.Min.X + b.Max.X) / 2,
+            (b.Min.Y + b.Max.Y) / 2 + 0.5,
+            (b.Min.Z + b.Max.Z) / 2
+         );
+         meshes.push(mesh);
+      });
+      (environment.Cylinders || []).forEach((c) => {
+         const geometry = new THREE.CylinderGeometry(
+            c.Radius,
+            c.Radius,
+            c.Height,
+            32
+         );
+         const mesh = new THREE.Mesh(geometry, material);
+         mesh.position.set(
+            c.Center.X,
+            c.Center.Y + c.Height / 2 + 0.5,
+            c.Center.Z
+         );
+         meshes.push(mesh);
+      });
+      if (environment.Ceiling != null) {
+         const geometry = new THREE.PlaneGeometry(100, 100);
+         const mesh = new THREE.Mesh(
+            geometry,
+            new THREE.MeshBasicMaterial({
+               color: 0xff3333,
+               transparent: true,
+               opacity: 0.1,
+               side: THREE.DoubleSide,
+            })
+         );
+         mesh.rotation.x = Math.PI / 2;
+         mesh.position.y = environment.Ceiling + 0.5;
+         meshes.push(mesh);
+      }
+
+      meshes.forEach((mesh) => {
+         App.scene.data.sceneReal.add(mesh);
+         App.scene.data.sceneSimu.add(mesh.clone());
+      });
+   },
 };
 
 App.state = {
@@ -294,6 +353,10 @@ App.ui = {
    updateNbDrones: (nbDrones) => {
       document.getElementById("nbDrone").innerHTML = nbDrones;
    },
+   showError: (error) => {
+      App.ui.updateStatus(true);
+      document.getElementById("status").innerHTML = "Rejected: " + error;
+   },
    updateStatus: (ready) => {
       App.state.running = !ready;
       document.getElementById("status").innerHTML = ready
@@ -325,6 +388,14 @@ const handleMessage = (message) => {
       App.state.createDrones(message.Drones);
    }
 
+   if (message.Environment != null) {
+      App.scene.addEnvironment(message.Environment);
+   }
+
+   if (message.Error != null) {
+      App.ui.showError(message.Error);
+   }
+
    if (message.DroneId != null && message.Location != null) {
       App.state.updateDrone(message.DroneId, message.Location);
    }
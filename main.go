@@ -8,13 +8,18 @@ package main
 import (
 	"flag"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/rs/zerolog"
 	"go.dedis.ch/cs438/orbitalswarm/drone"
 	"go.dedis.ch/cs438/orbitalswarm/drone/consensus"
+	"go.dedis.ch/cs438/orbitalswarm/drone/mapping"
+	"go.dedis.ch/cs438/orbitalswarm/environment"
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
 	"go.dedis.ch/cs438/orbitalswarm/gs"
+	"go.dedis.ch/cs438/orbitalswarm/pathgenerator"
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 )
 
 const defaultGossipAddr = "127.0.0.1:33000" // IP address:port number for gossiping
@@ -47,9 +52,40 @@ func main() {
 
 	numDrones := flag.Int("numDrones", defaultNumDrones, "number of drones")
 	numPaxosProposerAcceptors := flag.Int("numProposer", defaultNumPaxosProposerAcceptors, "number of proposer/accpetor in the Paxos consensus box.")
+	mapper := flag.String("mapper", mapping.BottleneckMapping, "targets mapper used by the drones: bottleneck, hungarian, auction or distributed-auction")
+	protocol := flag.String("consensus", consensus.PaxosProtocol, "consensus protocol used by the drones: paxos, multi-paxos, raft or bft")
+	planner := flag.String("planner", pathgenerator.GeneticPlanner, "path planner used by the drones: genetic or cbs")
+	maxVelocity := flag.Float64("maxVelocity", trajectory.DefaultLimits.MaxVelocity, "maximal velocity of the drones in units per second")
+	maxAcceleration := flag.Float64("maxAcceleration", trajectory.DefaultLimits.MaxAcceleration, "maximal acceleration of the drones in units per second squared")
+	profile := flag.String("profile", trajectory.TrapezoidalProfile, "velocity profile of the trajectories: trapezoidal or minimum-jerk")
+	safetyRadius := flag.Float64("safetyRadius", pathgenerator.DefaultSafetyRadius, "minimal distance the drones keep between each other")
+	environmentFile := flag.String("environment", "", "JSON file describing the obstacles and no-fly zones, none by default")
+	storageDir := flag.String("storage", "", "directory where the drones persist their last command to recover from a crash, and the nodes their consensus state with the paxos protocol only, the other protocols keeping it in memory; in memory only by default")
+	gsState := flag.String("gsState", "", "file where the ground station persists its last pattern ID, so that a restarted ground station never reuses one, gs.json in the storage directory by default, in memory only without one")
+	encrypt := flag.Bool("encrypt", false, "encrypt the datagrams exchanged by the drones and the ground station")
 
 	flag.Parse()
 
+	env := environment.Empty()
+	if *environmentFile != "" {
+		var err error
+		env, err = environment.Load(*environmentFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if *storageDir != "" {
+		err := os.MkdirAll(*storageDir, 0755)
+		if err != nil {
+			panic(err)
+		}
+		// The drones persist their last command, so must the ground station
+		if *gsState == "" {
+			*gsState = filepath.Join(*storageDir, "gs.json")
+		}
+	}
+
 	// Generate address for the groundStation
 	gossipAddress := ""
 	fac := gossip.GetFactory()
@@ -58,12 +94,56 @@ func main() {
 		panic(err)
 	}
 
-	swarm, locations := drone.NewSwarm(*numDrones, *numPaxosProposerAcceptors, 2222, 5000, *antiEntropy, *routeTimer, *paxosRetry, "127.0.0.1", "127.0.0.1")
+	// The ground station and the drones only accept the packets signed by one another
+	trust := gossip.NewTrustStore()
+	private, err := trust.NewKey("GS")
+	if err != nil {
+		panic(err)
+	}
+	g.SetKeys(private, trust)
+
+	swarm, locations := drone.NewSwarm(drone.SwarmConfig{
+		NumDrones:         *numDrones,
+		NumParticipants:   *numPaxosProposerAcceptors,
+		FirstUIPort:       2222,
+		FirstGossipPort:   5000,
+		BaseUIAddress:     "127.0.0.1",
+		BaseGossipAddress: "127.0.0.1",
+		AntiEntropy:       *antiEntropy,
+		RouteTimer:        *routeTimer,
+		PaxosRetry:        *paxosRetry,
+		Mapper:            *mapper,
+		Protocol:          *protocol,
+		Planner:           *planner,
+		Environment:       env,
+		Limits:            trajectory.Limits{MaxVelocity: *maxVelocity, MaxAcceleration: *maxAcceleration},
+		Profile:           *profile,
+		SafetyRadius:      *safetyRadius,
+		StorageDir:        *storageDir,
+		Trust:             trust,
+	})
+
+	if *encrypt {
+		err = g.EnableEncryption()
+		if err == nil {
+			err = swarm.EnableEncryption()
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	addresses := swarm.DronesAddresses()
 	g.AddAddresses(addresses...)
 
-	groundStation := gs.NewGroundStation("GS", "127.0.0.1:"+*UIPort, gossipAddress, g, locations, consensus.NewConsensusReader(*numPaxosProposerAcceptors, *numDrones+1, *paxosRetry))
+	reader, err := consensus.NewConsensusClient(*protocol, *numPaxosProposerAcceptors, *numDrones+1, *paxosRetry, swarm.Keys(), *storageDir)
+	if err != nil {
+		panic(err)
+	}
+	groundStation, err := gs.NewGroundStation("GS", "127.0.0.1:"+*UIPort, gossipAddress, g, locations, env, reader, *gsState)
+	if err != nil {
+		panic(err)
+	}
 
 	go swarm.Run()
 	groundStation.Run()
@@ -0,0 +1,510 @@
+package pathgenerator
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"math"
+
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// https://www.aaai.org/ocs/index.php/AAAI/AAAI12/paper/viewFile/5062/5239
+// https://www.aaai.org/ocs/index.php/SOCS/SOCS14/paper/viewFile/8911/8875
+
+const (
+	// defaultSuboptimality is the default bound on the makespan of the CBS plans
+	defaultSuboptimality = 1.2
+	// defaultMaxExpansions bounds the number of high level nodes expanded by CBS
+	defaultMaxExpansions = 5000
+	// gridMargin is the number of cells around the drones the planner may use
+	gridMargin = 2
+)
+
+// CBSPathGenerator plans collision free paths on the integer grid using
+// conflict-based search, with space-time A* as low level planner.
+//
+// With a suboptimality of 1 the plans have a minimal makespan, otherwise their
+// makespan is at most suboptimality times the minimal one.
+type CBSPathGenerator struct {
+	workers
+	suboptimality float64
+	maxExpansions int
+	environment   *environment.Environment
+	validator     *Validator
+}
+
+// NewCBSPathGenerator creates a bounded-suboptimal CBS path generator
+func NewCBSPathGenerator() *CBSPathGenerator {
+	return NewCBSPathGeneratorWithParams(defaultSuboptimality, defaultMaxExpansions, DefaultMinSeparation, nil)
+}
+
+// NewCBSPathGeneratorWithParams creates a CBS path generator with the given
+// suboptimality bound (>= 1) and maximal number of high level expansions,
+// keeping the drones minSeparation apart and avoiding the no-fly zones of the
+// environment. A nil environment has no obstacles.
+func NewCBSPathGeneratorWithParams(suboptimality float64, maxExpansions int, minSeparation float64, env *environment.Environment) *CBSPathGenerator {
+	if suboptimality < 1 {
+		suboptimality = 1
+	}
+	if env == nil {
+		env = environment.Empty()
+	}
+	return &CBSPathGenerator{
+		workers:       newWorkers(),
+		suboptimality: suboptimality,
+		maxExpansions: maxExpansions,
+		environment:   env,
+		validator:     NewValidator(minSeparation, env),
+	}
+}
+
+// GeneratePath computes collision free paths from the initial locations to the destinations.
+// The channel is closed without value if no valid paths were found.
+func (g *CBSPathGenerator) GeneratePath(from []r3.Vec, dest []r3.Vec) <-chan [][]r3.Vec {
+	return g.GeneratePathContext(context.Background(), from, dest)
+}
+
+// GeneratePathContext is like GeneratePath but the search is aborted once ctx is done
+func (g *CBSPathGenerator) GeneratePathContext(ctx context.Context, from []r3.Vec, dest []r3.Vec) <-chan [][]r3.Vec {
+	done := make(chan [][]r3.Vec, 1)
+	quit, finished := g.start()
+
+	go func() {
+		defer finished()
+		defer close(done)
+
+		paths := g.search(ctx, quit, from, dest)
+		if paths == nil {
+			if ctx.Err() == nil {
+				log.Printf("No valid path found")
+			}
+		} else if err := g.validator.Validate(from, dest, paths); err != nil {
+			log.Printf("ERROR : %s", err)
+		} else {
+			done <- paths
+		}
+	}()
+	return done
+}
+
+// constraint forbids an agent to be at a location at a given time
+type constraint struct {
+	location r3.Vec
+	time     int
+}
+
+// conflict between two agents. Either agentA must not be at location at
+// timeA, or agentB must not be at location at timeB.
+type conflict struct {
+	agentA, agentB int
+	timeA, timeB   int
+	location       r3.Vec
+}
+
+// cbsNode is a node of the high level constraint tree
+type cbsNode struct {
+	constraints [][]constraint
+	// plans are the locations of each agent at every time step
+	plans     [][]r3.Vec
+	makespan  int
+	cost      int
+	conflicts int
+	index     int
+}
+
+func (n *cbsNode) less(o *cbsNode) bool {
+	if n.makespan != o.makespan {
+		return n.makespan < o.makespan
+	}
+	return n.cost < o.cost
+}
+
+// search runs the high level of CBS and returns the moves of each agent
+func (g *CBSPathGenerator) search(ctx context.Context, quit <-chan struct{}, from []r3.Vec, dest []r3.Vec) [][]r3.Vec {
+	grid := newGridBounds(from, dest, g.environment)
+
+	root := &cbsNode{
+		constraints: make([][]constraint, len(from)),
+		plans:       make([][]r3.Vec, len(from)),
+	}
+	for i := range from {
+		plan := spaceTimeAStar(from[i], dest[i], nil, root.plans, i, grid)
+		if plan == nil {
+			return nil
+		}
+		root.plans[i] = plan
+	}
+	root.evaluate()
+
+	open := &cbsQueue{}
+	heap.Push(open, root)
+	for expansions := 0; open.Len() > 0 && expansions < g.maxExpansions; expansions++ {
+		if aborted(ctx, quit) {
+			return nil
+		}
+
+		node := open.popFocal(g.suboptimality)
+		c, found := findConflict(node.plans)
+		if !found {
+			return plansToMoves(node.plans)
+		}
+
+		for _, split := range []struct {
+			agent int
+			time  int
+		}{{c.agentA, c.timeA}, {c.agentB, c.timeB}} {
+			if split.time <= 0 {
+				// The initial locations cannot be changed
+				continue
+			}
+			child := &cbsNode{
+				constraints: make([][]constraint, len(node.constraints)),
+				plans:       make([][]r3.Vec, len(node.plans)),
+			}
+			copy(child.constraints, node.constraints)
+			copy(child.plans, node.plans)
+			child.constraints[split.agent] = append(append([]constraint{}, node.constraints[split.agent]...),
+				constraint{location: c.location, time: split.time})
+
+			plan := spaceTimeAStar(from[split.agent], dest[split.agent], child.constraints[split.agent], child.plans, split.agent, grid)
+			if plan == nil {
+				continue
+			}
+			child.plans[split.agent] = plan
+			child.evaluate()
+			heap.Push(open, child)
+		}
+	}
+	return nil
+}
+
+// evaluate computes the makespan, sum of costs and number of conflicts of the node
+func (n *cbsNode) evaluate() {
+	n.makespan, n.cost = 0, 0
+	for _, plan := range n.plans {
+		n.cost += len(plan) - 1
+		if len(plan)-1 > n.makespan {
+			n.makespan = len(plan) - 1
+		}
+	}
+	n.conflicts = countConflicts(n.plans)
+}
+
+// locationAt returns the location of an agent at time t, agents wait at their
+// destination once it is reached
+func locationAt(plan []r3.Vec, t int) r3.Vec {
+	if t >= len(plan) {
+		return plan[len(plan)-1]
+	}
+	return plan[t]
+}
+
+func makespanOf(plans [][]r3.Vec) int {
+	makespan := 0
+	for _, plan := range plans {
+		if len(plan)-1 > makespan {
+			makespan = len(plan) - 1
+		}
+	}
+	return makespan
+}
+
+// findConflict returns the first conflict between two plans. Two agents
+// conflict when they share a location at the same time, or when one enters
+// the location the other is leaving, which includes swaps.
+func findConflict(plans [][]r3.Vec) (conflict, bool) {
+	makespan := makespanOf(plans)
+	for t := 0; t <= makespan; t++ {
+		occupied := make(map[r3.Vec]int, len(plans))
+		previous := make(map[r3.Vec]int, len(plans))
+		for i, plan := range plans {
+			location := locationAt(plan, t)
+			if j, found := occupied[location]; found {
+				return conflict{agentA: j, agentB: i, timeA: t, timeB: t, location: location}, true
+			}
+			occupied[location] = i
+			if t > 0 {
+				previous[locationAt(plan, t-1)] = i
+			}
+		}
+		if t == 0 {
+			continue
+		}
+		for i, plan := range plans {
+			location := locationAt(plan, t)
+			if j, found := previous[location]; found && j != i {
+				return conflict{agentA: i, agentB: j, timeA: t, timeB: t - 1, location: location}, true
+			}
+		}
+	}
+	return conflict{}, false
+}
+
+// countConflicts returns the number of conflicting pairs of agents over all time steps
+func countConflicts(plans [][]r3.Vec) int {
+	count := 0
+	makespan := makespanOf(plans)
+	for t := 0; t <= makespan; t++ {
+		occupied := make(map[r3.Vec]int, len(plans))
+		previous := make(map[r3.Vec]int, len(plans))
+		for i, plan := range plans {
+			location := locationAt(plan, t)
+			count += occupied[location]
+			occupied[location]++
+			if t > 0 {
+				previous[locationAt(plan, t-1)] = i
+			}
+		}
+		if t == 0 {
+			continue
+		}
+		for i, plan := range plans {
+			if j, found := previous[locationAt(plan, t)]; found && j != i {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// plansToMoves converts the locations of each agent into moves of same length
+func plansToMoves(plans [][]r3.Vec) [][]r3.Vec {
+	makespan := makespanOf(plans)
+	paths := make([][]r3.Vec, len(plans))
+	for i, plan := range plans {
+		paths[i] = make([]r3.Vec, makespan)
+		for t := 0; t < makespan; t++ {
+			paths[i][t] = locationAt(plan, t+1).Sub(locationAt(plan, t))
+		}
+	}
+	normalizePaths(paths)
+	return paths
+}
+
+// cbsQueue is the open list of the high level search
+type cbsQueue []*cbsNode
+
+func (q cbsQueue) Len() int           { return len(q) }
+func (q cbsQueue) Less(i, j int) bool { return q[i].less(q[j]) }
+func (q cbsQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *cbsQueue) Push(x interface{}) {
+	node := x.(*cbsNode)
+	node.index = len(*q)
+	*q = append(*q, node)
+}
+
+func (q *cbsQueue) Pop() interface{} {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// popFocal removes and returns, among the nodes whose makespan is within the
+// suboptimality bound of the best one, the node with the fewest conflicts
+func (q *cbsQueue) popFocal(suboptimality float64) *cbsNode {
+	bound := int(math.Floor(float64((*q)[0].makespan) * suboptimality))
+	best := (*q)[0]
+	for _, node := range *q {
+		if node.makespan <= bound && (node.conflicts < best.conflicts ||
+			node.conflicts == best.conflicts && node.less(best)) {
+			best = node
+		}
+	}
+	return heap.Remove(q, best.index).(*cbsNode)
+}
+
+// gridBounds delimits the cells usable by the planner
+type gridBounds struct {
+	min, max    r3.Vec
+	environment *environment.Environment
+}
+
+// newGridBounds encloses the drones with a margin. When the environment has
+// obstacles, the grid is extended around them such that they can be bypassed.
+func newGridBounds(from []r3.Vec, dest []r3.Vec, env *environment.Environment) gridBounds {
+	bounds := gridBounds{
+		min:         r3.Vec{X: math.Inf(1), Y: math.Ceil(env.Floor), Z: math.Inf(1)},
+		max:         r3.Vec{X: math.Inf(-1), Y: math.Inf(-1), Z: math.Inf(-1)},
+		environment: env,
+	}
+	extend := func(min, max r3.Vec) {
+		bounds.min.X = math.Min(bounds.min.X, math.Floor(min.X)-gridMargin)
+		bounds.min.Z = math.Min(bounds.min.Z, math.Floor(min.Z)-gridMargin)
+		bounds.max.X = math.Max(bounds.max.X, math.Ceil(max.X)+gridMargin)
+		bounds.max.Y = math.Max(bounds.max.Y, math.Ceil(max.Y)+gridMargin)
+		bounds.max.Z = math.Max(bounds.max.Z, math.Ceil(max.Z)+gridMargin)
+	}
+	for _, locations := range [][]r3.Vec{from, dest} {
+		for _, l := range locations {
+			extend(l, l)
+		}
+	}
+	if env.HasObstacles() {
+		extend(env.Bounds())
+	}
+	if env.Ceiling != nil {
+		bounds.max.Y = math.Min(bounds.max.Y, math.Floor(*env.Ceiling))
+	}
+	return bounds
+}
+
+func (b gridBounds) contains(l r3.Vec) bool {
+	return l.X >= b.min.X && l.X <= b.max.X &&
+		l.Y >= b.min.Y && l.Y <= b.max.Y &&
+		l.Z >= b.min.Z && l.Z <= b.max.Z
+}
+
+// allows returns true if a drone may move from a location to the next one
+func (b gridBounds) allows(from, to r3.Vec) bool {
+	return b.contains(to) && (from == to || !b.environment.Crosses(from, to))
+}
+
+// detour bounds the number of extra moves needed to bypass the obstacles
+func (b gridBounds) detour() int {
+	if !b.environment.HasObstacles() {
+		return 0
+	}
+	size := b.max.Sub(b.min)
+	return int(size.X + size.Y + size.Z)
+}
+
+func manhattan(a, b r3.Vec) int {
+	d := a.Sub(b)
+	return int(math.Abs(d.X) + math.Abs(d.Y) + math.Abs(d.Z))
+}
+
+type spaceTime struct {
+	location r3.Vec
+	time     int
+}
+
+// astarNode is a node of the low level space-time search
+type astarNode struct {
+	spaceTime
+	f         int
+	conflicts int
+	parent    *astarNode
+	index     int
+}
+
+type astarQueue []*astarNode
+
+func (q astarQueue) Len() int { return len(q) }
+func (q astarQueue) Less(i, j int) bool {
+	if q[i].f != q[j].f {
+		return q[i].f < q[j].f
+	}
+	if q[i].conflicts != q[j].conflicts {
+		return q[i].conflicts < q[j].conflicts
+	}
+	return q[i].time > q[j].time
+}
+func (q astarQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *astarQueue) Push(x interface{}) {
+	*q = append(*q, x.(*astarNode))
+}
+
+func (q *astarQueue) Pop() interface{} {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// spaceTimeAStar returns the shortest sequence of locations from start to goal
+// respecting the constraints of the agent. Ties are broken in favour of the
+// plans having the fewest conflicts with the current plans of the other agents.
+func spaceTimeAStar(start, goal r3.Vec, constraints []constraint, plans [][]r3.Vec, agent int, grid gridBounds) []r3.Vec {
+	forbidden := make(map[spaceTime]bool, len(constraints))
+	lastGoalConstraint := -1
+	lastConstraint := 0
+	for _, c := range constraints {
+		forbidden[spaceTime{location: c.location, time: c.time}] = true
+		if c.location == goal && c.time > lastGoalConstraint {
+			lastGoalConstraint = c.time
+		}
+		if c.time > lastConstraint {
+			lastConstraint = c.time
+		}
+	}
+	if forbidden[spaceTime{location: start, time: 0}] {
+		return nil
+	}
+
+	// Bound the search such that an unreachable goal does not lead to the
+	// exploration of the whole space-time
+	horizon := lastConstraint + manhattan(start, goal) + len(plans) + 2*gridMargin + grid.detour()
+
+	// Reservation of the other agents to count the conflicts
+	othersAt := func(t int) map[r3.Vec]bool {
+		reserved := make(map[r3.Vec]bool, len(plans))
+		for i, plan := range plans {
+			if i != agent && plan != nil {
+				reserved[locationAt(plan, t)] = true
+			}
+		}
+		return reserved
+	}
+	reservations := make(map[int]map[r3.Vec]bool)
+	reserved := func(l r3.Vec, t int) bool {
+		r, ok := reservations[t]
+		if !ok {
+			r = othersAt(t)
+			reservations[t] = r
+		}
+		return r[l]
+	}
+
+	open := &astarQueue{}
+	closed := make(map[spaceTime]bool)
+	heap.Push(open, &astarNode{
+		spaceTime: spaceTime{location: start, time: 0},
+		f:         manhattan(start, goal),
+	})
+
+	for open.Len() > 0 {
+		node := heap.Pop(open).(*astarNode)
+		if closed[node.spaceTime] {
+			continue
+		}
+		closed[node.spaceTime] = true
+
+		if node.location == goal && node.time > lastGoalConstraint {
+			plan := make([]r3.Vec, node.time+1)
+			for n := node; n != nil; n = n.parent {
+				plan[n.time] = n.location
+			}
+			return plan
+		}
+		if node.time >= horizon {
+			continue
+		}
+
+		for _, move := range append([]r3.Vec{still}, directions...) {
+			next := spaceTime{location: node.location.Add(move), time: node.time + 1}
+			if !grid.allows(node.location, next.location) || forbidden[next] || closed[next] {
+				continue
+			}
+			conflicts := node.conflicts
+			if reserved(next.location, next.time) || (move != still && reserved(next.location, node.time)) {
+				conflicts++
+			}
+			heap.Push(open, &astarNode{
+				spaceTime: next,
+				f:         next.time + manhattan(next.location, goal),
+				conflicts: conflicts,
+				parent:    node,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package pathgenerator
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestCBSPathGenerator_exchange(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 1, Y: 0, Z: 0},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 1, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 0},
+	}
+
+	res := <-NewCBSPathGeneratorWithParams(1, defaultMaxExpansions, DefaultMinSeparation, nil).GeneratePath(from, dest)
+
+	require.NotNil(t, res)
+	require.True(t, validatePaths(from, dest, res, DefaultMinSeparation))
+	// One drone goes around while the other waits for its cell to be freed
+	require.Equal(t, 3, len(res[0]))
+
+}
+
+func TestCBSPathGenerator_cross(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 2, Y: 1, Z: 0},
+		r3.Vec{X: 0, Y: 1, Z: 0},
+	}
+
+	res := <-NewCBSPathGeneratorWithParams(1, defaultMaxExpansions, DefaultMinSeparation, nil).GeneratePath(from, dest)
+
+	require.NotNil(t, res)
+	require.True(t, validatePaths(from, dest, res, DefaultMinSeparation))
+	require.Equal(t, 3, len(res[0]))
+}
+
+func TestCBSPathGenerator_swarm(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Same layout as the swarm, shuffled on a denser grid
+	numDrones := 25
+	from := make([]r3.Vec, numDrones)
+	dest := make([]r3.Vec, numDrones)
+	for i, j := range rng.Perm(numDrones) {
+		from[i] = r3.Vec{X: float64(i / 5 * 2), Y: 0, Z: float64(i % 5 * 2)}
+		dest[i] = r3.Vec{X: float64(j / 5), Y: 5, Z: float64(j % 5)}
+	}
+
+	res := <-NewCBSPathGenerator().GeneratePath(from, dest)
+
+	require.NotNil(t, res)
+	require.True(t, validatePaths(from, dest, res, DefaultMinSeparation))
+}
+
+func TestFindConflict(t *testing.T) {
+	plans := [][]r3.Vec{
+		[]r3.Vec{r3.Vec{X: 0}, r3.Vec{X: 1}},
+		[]r3.Vec{r3.Vec{X: 1}, r3.Vec{X: 1, Y: 1}},
+	}
+	// Drone 0 enters the cell drone 1 is leaving
+	c, found := findConflict(plans)
+	require.True(t, found)
+	require.Equal(t, conflict{agentA: 0, agentB: 1, timeA: 1, timeB: 0, location: r3.Vec{X: 1}}, c)
+
+	plans[1] = []r3.Vec{r3.Vec{X: 2}, r3.Vec{X: 2}, r3.Vec{X: 1}}
+	// Drone 1 reaches the destination where drone 0 waits
+	c, found = findConflict(plans)
+	require.True(t, found)
+	require.Equal(t, conflict{agentA: 0, agentB: 1, timeA: 2, timeB: 2, location: r3.Vec{X: 1}}, c)
+}
+
+func TestCBSPathGenerator_obstacle(t *testing.T) {
+	// A wall between the drones and their destinations, up to the ceiling
+	ceiling := 3.0
+	env := environment.Empty()
+	env.Ceiling = &ceiling
+	env.Boxes = append(env.Boxes, environment.Box{
+		Min: r3.Vec{X: 2, Y: 0, Z: -1},
+		Max: r3.Vec{X: 3, Y: 3, Z: 2},
+	})
+
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 1},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 5, Y: 0, Z: 0},
+		r3.Vec{X: 5, Y: 0, Z: 1},
+	}
+
+	res := <-NewCBSPathGeneratorWithParams(defaultSuboptimality, defaultMaxExpansions, DefaultMinSeparation, env).GeneratePath(from, dest)
+
+	require.NotNil(t, res)
+	require.NoError(t, NewValidator(DefaultMinSeparation, env).Validate(from, dest, res))
+	require.Error(t, NewValidator(DefaultMinSeparation, env).Validate(from, dest, generateBasicPath(from, dest)))
+}
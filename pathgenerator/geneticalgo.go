@@ -1,41 +1,398 @@
 package pathgenerator
 
 import (
+	"context"
 	"log"
+	"math/rand"
+	"sort"
+	"time"
 
+	"go.dedis.ch/cs438/orbitalswarm/environment"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
+const (
+	// conflictPenalty is the fitness cost of a single collision, swap or
+	// move into a no-fly zone. It dominates the length terms so that any valid
+	// individual is always fitter than an invalid one.
+	conflictPenalty = 1000.0
+	// makespanWeight weights the number of rounds needed by the slowest drone
+	makespanWeight = 2.0
+)
+
+var (
+	still = r3.Vec{X: 0, Y: 0, Z: 0}
+
+	directions = []r3.Vec{
+		r3.Vec{X: 1, Y: 0, Z: 0},
+		r3.Vec{X: -1, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 1, Z: 0},
+		r3.Vec{X: 0, Y: -1, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 1},
+		r3.Vec{X: 0, Y: 0, Z: -1},
+	}
+)
+
+// GeneticConfig holds the parameters of the evolutionary search
+type GeneticConfig struct {
+	// Seed of the random source, two runs with the same seed and inputs
+	// produce the same paths as long as the Timeout is not reached
+	Seed int64
+	// PopulationSize is the number of individuals per generation
+	PopulationSize int
+	// EliteSize is the number of best individuals copied as-is in the next generation
+	EliteSize int
+	// MutationRate is the probability for a child to be mutated
+	MutationRate float64
+	// MaxGenerations bounds the number of generations
+	MaxGenerations int
+	// StallGenerations stops the search when a valid solution has not been
+	// improved for this number of generations
+	StallGenerations int
+	// Timeout bounds the duration of the search, 0 disables it
+	Timeout time.Duration
+	// MinSeparation is the minimal distance the drones must keep
+	MinSeparation float64
+	// Environment holds the no-fly zones to avoid, nil if there are none
+	Environment *environment.Environment
+}
+
+// DefaultGeneticConfig returns the configuration used by NewGeneticPathGenerator
+func DefaultGeneticConfig() GeneticConfig {
+	return GeneticConfig{
+		Seed:             time.Now().UnixNano(),
+		PopulationSize:   60,
+		EliteSize:        4,
+		MutationRate:     0.8,
+		MaxGenerations:   2000,
+		StallGenerations: 100,
+		Timeout:          5 * time.Second,
+		MinSeparation:    DefaultMinSeparation,
+	}
+}
+
 type GeneticPathGenerator struct {
-	done chan [][]r3.Vec
+	workers
+	config    GeneticConfig
+	validator *Validator
 }
 
 func NewGeneticPathGenerator() *GeneticPathGenerator {
+	return NewGeneticPathGeneratorWithConfig(DefaultGeneticConfig())
+}
+
+// NewGeneticPathGeneratorWithConfig creates a genetic path generator using the given search parameters
+func NewGeneticPathGeneratorWithConfig(config GeneticConfig) *GeneticPathGenerator {
+	if config.PopulationSize < 2 {
+		config.PopulationSize = 2
+	}
+	if config.EliteSize >= config.PopulationSize {
+		config.EliteSize = config.PopulationSize - 1
+	}
 	g := &GeneticPathGenerator{
-		done: make(chan [][]r3.Vec),
+		workers:   newWorkers(),
+		config:    config,
+		validator: NewValidator(config.MinSeparation, config.Environment),
 	}
 	return g
 }
 
+// GeneratePath computes collision free paths from the initial locations to the destinations.
+// The channel is closed without value if no valid paths were found within the budget.
 func (g *GeneticPathGenerator) GeneratePath(from []r3.Vec, dest []r3.Vec) <-chan [][]r3.Vec {
+	return g.GeneratePathContext(context.Background(), from, dest)
+}
+
+// GeneratePathContext is like GeneratePath but the search is aborted once ctx is done
+func (g *GeneticPathGenerator) GeneratePathContext(ctx context.Context, from []r3.Vec, dest []r3.Vec) <-chan [][]r3.Vec {
+	done := make(chan [][]r3.Vec, 1)
+	quit, finished := g.start()
+
 	go func() {
-		paths := generateBasicPath(from, dest)
-		// if validatePaths(from, dest, paths) {
-		g.done <- paths
-		// } else {
-		// 	println("Basic path not correct")
-		// }
+		defer finished()
+		defer close(done)
+
+		paths := g.evolve(ctx, quit, from, dest)
+		if paths == nil {
+			if ctx.Err() == nil {
+				log.Printf("No valid path found")
+			}
+		} else if err := g.validator.Validate(from, dest, paths); err != nil {
+			log.Printf("ERROR : %s", err)
+		} else {
+			done <- paths
+		}
 	}()
-	return g.done
+	return done
+}
+
+// individual is a candidate solution of the genetic algorithm
+type individual struct {
+	paths     [][]r3.Vec
+	conflicts int
+	fitness   float64
+	// conflicting holds the index of the drones involved in a conflict
+	conflicting []int
 }
 
-func (g *GeneticPathGenerator) Stop() {
+// evolve runs the genetic algorithm starting from the basic path and returns
+// the best conflict free paths found, or nil if there are none or if the
+// search has been aborted through ctx or quit.
+func (g *GeneticPathGenerator) evolve(ctx context.Context, quit <-chan struct{}, from []r3.Vec, dest []r3.Vec) [][]r3.Vec {
+	if len(from) != len(dest) {
+		log.Printf("ERROR : %d locations for %d destinations", len(from), len(dest))
+		return nil
+	}
+	basic := g.newIndividual(from, generateBasicPath(from, dest))
+	if basic.conflicts == 0 {
+		// Every drone already flies its shortest path
+		return basic.paths
+	}
 
+	rng := rand.New(rand.NewSource(g.config.Seed))
+	var deadline time.Time
+	if g.config.Timeout > 0 {
+		deadline = time.Now().Add(g.config.Timeout)
+	}
+
+	population := make([]*individual, g.config.PopulationSize)
+	population[0] = basic
+	for i := 1; i < len(population); i++ {
+		paths := copyPaths(basic.paths)
+		for m := rng.Intn(len(from)) + 1; m > 0; m-- {
+			mutate(rng, paths, basic.conflicting)
+		}
+		population[i] = g.newIndividual(from, paths)
+	}
+
+	var best *individual
+	stall := 0
+	for generation := 0; generation < g.config.MaxGenerations; generation++ {
+		sort.SliceStable(population, func(i, j int) bool {
+			return population[i].fitness < population[j].fitness
+		})
+
+		if population[0].conflicts == 0 {
+			if best == nil || population[0].fitness < best.fitness {
+				best = population[0]
+				stall = 0
+			} else {
+				stall++
+			}
+			if stall >= g.config.StallGenerations {
+				break
+			}
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		if aborted(ctx, quit) {
+			return nil
+		}
+
+		next := make([]*individual, 0, len(population))
+		next = append(next, population[:g.config.EliteSize]...)
+		for len(next) < len(population) {
+			a, b := tournament(rng, population), tournament(rng, population)
+			paths := crossover(rng, a, b)
+			if rng.Float64() < g.config.MutationRate {
+				mutate(rng, paths, a.conflicting)
+			}
+			next = append(next, g.newIndividual(from, paths))
+		}
+		population = next
+	}
+
+	if best == nil {
+		return nil
+	}
+	return best.paths
+}
+
+func (g *GeneticPathGenerator) newIndividual(from []r3.Vec, paths [][]r3.Vec) *individual {
+	normalizePaths(paths)
+	conflicting, length, makespan := evaluatePaths(from, paths)
+	for i, blocked := range g.validator.Blocked(from, paths) {
+		conflicting[i] += blocked
+	}
+	// The normalized paths have one round count, and evolve checked that
+	// there is one per location
+	violations, _ := g.validator.Violations(from, paths)
+	for _, violation := range violations {
+		conflicting[violation.DroneA]++
+		conflicting[violation.DroneB]++
+	}
+	conflicts := 0
+	drones := make([]int, 0)
+	for i, c := range conflicting {
+		conflicts += c
+		if c > 0 {
+			drones = append(drones, i)
+		}
+	}
+	return &individual{
+		paths:       paths,
+		conflicts:   conflicts,
+		fitness:     conflictPenalty*float64(conflicts) + float64(length) + makespanWeight*float64(makespan),
+		conflicting: drones,
+	}
+}
+
+// evaluatePaths returns the number of conflicts of each drone (collisions and
+// entering a cell that another drone is leaving, which covers swaps), the
+// total number of moves and the makespan.
+func evaluatePaths(from []r3.Vec, paths [][]r3.Vec) ([]int, int, int) {
+	conflicts := make([]int, len(paths))
+	length, makespan := 0, 0
+	if len(paths) == 0 {
+		return conflicts, 0, 0
+	}
+
+	locations := append([]r3.Vec{}, from...)
+	next := make([]r3.Vec, len(locations))
+	for round := 0; round < len(paths[0]); round++ {
+		previous := make(map[r3.Vec]int, len(paths))
+		for i, location := range locations {
+			previous[location] = i
+		}
+
+		occupied := make(map[r3.Vec]int, len(paths))
+		for i := range paths {
+			step := paths[i][round]
+			next[i] = locations[i].Add(step)
+			if step != still {
+				length++
+				makespan = round + 1
+				if j, found := previous[next[i]]; found {
+					conflicts[i]++
+					conflicts[j]++
+				}
+			}
+			if j, found := occupied[next[i]]; found {
+				conflicts[i]++
+				conflicts[j]++
+			}
+			occupied[next[i]] = i
+		}
+		locations, next = next, locations
+	}
+	return conflicts, length, makespan
+}
+
+// tournament selects the fittest of three random individuals
+func tournament(rng *rand.Rand, population []*individual) *individual {
+	best := population[rng.Intn(len(population))]
+	for i := 0; i < 2; i++ {
+		challenger := population[rng.Intn(len(population))]
+		if challenger.fitness < best.fitness {
+			best = challenger
+		}
+	}
+	return best
+}
+
+// crossover builds a child by picking the path of each drone from one of the two parents
+func crossover(rng *rand.Rand, a, b *individual) [][]r3.Vec {
+	paths := make([][]r3.Vec, len(a.paths))
+	for i := range paths {
+		if rng.Intn(2) == 0 {
+			paths[i] = append([]r3.Vec{}, a.paths[i]...)
+		} else {
+			paths[i] = append([]r3.Vec{}, b.paths[i]...)
+		}
+	}
+	return paths
+}
+
+// mutate alters the path of a drone, picked among the conflicting ones most
+// of the time. Every mutation keeps the sum of the moves unchanged such that
+// the destination stays reached.
+func mutate(rng *rand.Rand, paths [][]r3.Vec, conflicting []int) {
+	if len(paths) == 0 {
+		return
+	}
+	i := rng.Intn(len(paths))
+	if len(conflicting) > 0 && rng.Float64() < 0.8 {
+		i = conflicting[rng.Intn(len(conflicting))]
+	}
+	path := paths[i]
+
+	switch rng.Intn(5) {
+	case 0:
+		// Wait one round
+		pos := rng.Intn(len(path) + 1)
+		path = insertMove(path, pos, still)
+	case 1:
+		// Remove a wait
+		for _, pos := range rng.Perm(len(path)) {
+			if path[pos] == still {
+				path = append(path[:pos], path[pos+1:]...)
+				break
+			}
+		}
+	case 2:
+		// Reorder two consecutive moves
+		if len(path) > 1 {
+			pos := rng.Intn(len(path) - 1)
+			path[pos], path[pos+1] = path[pos+1], path[pos]
+		}
+	case 3:
+		// Detour: move away and come back later
+		dir := directions[rng.Intn(len(directions))]
+		start := rng.Intn(len(path) + 1)
+		path = insertMove(path, start, dir)
+		end := start + 1 + rng.Intn(len(path)-start)
+		path = insertMove(path, end, dir.Scale(-1))
+	case 4:
+		// Change altitude for a part of the path
+		up := r3.Vec{X: 0, Y: 1, Z: 0}
+		start := rng.Intn(len(path) + 1)
+		end := start + rng.Intn(len(path)-start+1)
+		path = insertMove(path, end, up.Scale(-1))
+		path = insertMove(path, start, up)
+	}
+	paths[i] = path
+}
+
+func insertMove(path []r3.Vec, pos int, move r3.Vec) []r3.Vec {
+	path = append(path, still)
+	copy(path[pos+1:], path[pos:])
+	path[pos] = move
+	return path
+}
+
+// normalizePaths pads every path with still moves to the same length and
+// drops the trailing rounds where no drone moves.
+func normalizePaths(paths [][]r3.Vec) {
+	length := 0
+	for _, path := range paths {
+		for j := len(path) - 1; j >= 0; j-- {
+			if path[j] != still {
+				if j+1 > length {
+					length = j + 1
+				}
+				break
+			}
+		}
+	}
+	for i, path := range paths {
+		for len(path) < length {
+			path = append(path, still)
+		}
+		paths[i] = path[:length]
+	}
+}
+
+func copyPaths(paths [][]r3.Vec) [][]r3.Vec {
+	res := make([][]r3.Vec, len(paths))
+	for i, path := range paths {
+		res[i] = append([]r3.Vec{}, path...)
+	}
+	return res
 }
 
 // generateBasicPath generate a basic path such as to have a minimal set of moves to reach the mapping target
 func generateBasicPath(from []r3.Vec, dest []r3.Vec) [][]r3.Vec {
-	still := r3.Vec{X: 0, Y: 0, Z: 0}
 	right := r3.Vec{X: 1, Y: 0, Z: 0}
 	left := r3.Vec{X: -1, Y: 0, Z: 0}
 	up := r3.Vec{X: 0, Y: 1, Z: 0}
@@ -86,58 +443,14 @@ func generateBasicPath(from []r3.Vec, dest []r3.Vec) [][]r3.Vec {
 	return paths
 }
 
-// validatePaths check that no path intersect at a time t
+// validatePaths check that the drones keep the minimal separation along the
+// paths, stay above the ground and reach their destination
 // paths : [pathId][...steps]
-func validatePaths(from []r3.Vec, dest []r3.Vec, paths [][]r3.Vec) bool {
-	locations := make([][]r3.Vec, len(from))
-	for i := range locations {
-		locations[i] = make([]r3.Vec, len(paths[0])+1)
-	}
-	for i, location := range from {
-		locations[i][0] = location
-	}
-
-	if len(paths) == 0 {
-		return true
+func validatePaths(from []r3.Vec, dest []r3.Vec, paths [][]r3.Vec, minSeparation float64) bool {
+	err := NewValidator(minSeparation, nil).Validate(from, dest, paths)
+	if err != nil {
+		log.Printf("ERROR : %s", err)
+		return false
 	}
-
-	for round := 1; round <= len(paths[0]); round++ {
-		set := make(map[r3.Vec]bool)
-
-		// Detect position identical for 2 drones or underground
-		for i := 0; i < len(paths); i++ {
-			locations[i][round] = locations[i][round-1].Add(paths[i][round-1])
-			if locations[i][round].Y < 0 {
-				log.Printf("ERROR : drone under ground level")
-				return false
-			}
-			if set[locations[i][round]] == true {
-				log.Printf("ERROR : drone at same location at given round")
-				return false
-			}
-			set[locations[i][round]] = true
-		}
-
-		// Detect position exchange for 2 drones
-		for i := 0; i < len(locations); i++ {
-			for j := i + 1; j < len(locations); j++ {
-				if locations[i][round] == locations[j][round-1] &&
-					locations[j][round-1] == locations[i][round] {
-					log.Printf("ERROR : Drone switch location")
-					return false
-				}
-			}
-		}
-	}
-
-	// Validate final location
-	for i := range locations {
-		final := locations[i][len(paths[0])].Sub(dest[i])
-		if final != (r3.Vec{X: 0, Y: 0, Z: 0}) {
-			println("ERROR : Destination not reached")
-			return false
-		}
-	}
-
 	return true
 }
@@ -1,7 +1,9 @@
 package pathgenerator
 
 import (
+	"context"
 	"log"
+	"math"
 	"testing"
 	"time"
 
@@ -72,7 +74,7 @@ func TestGenerateBasicPath(t *testing.T) {
 		}
 	}
 
-	require.Equal(t, true, validatePaths(from, dest, res))
+	require.Equal(t, true, validatePaths(from, dest, res, DefaultMinSeparation))
 }
 
 func TestValidatePaths_exchange(t *testing.T) {
@@ -88,7 +90,7 @@ func TestValidatePaths_exchange(t *testing.T) {
 
 	time.Sleep(time.Second * time.Duration(3))
 
-	require.Equal(t, false, validatePaths(from, dest, res))
+	require.Equal(t, false, validatePaths(from, dest, res, DefaultMinSeparation))
 }
 
 func TestValidatePaths_cross(t *testing.T) {
@@ -104,5 +106,112 @@ func TestValidatePaths_cross(t *testing.T) {
 
 	time.Sleep(time.Second * time.Duration(3))
 
-	require.Equal(t, false, validatePaths(from, dest, res))
+	require.Equal(t, false, validatePaths(from, dest, res, DefaultMinSeparation))
+}
+
+func newTestGeneticGenerator(seed int64) *GeneticPathGenerator {
+	config := DefaultGeneticConfig()
+	config.Seed = seed
+	config.Timeout = 0
+	return NewGeneticPathGeneratorWithConfig(config)
+}
+
+func TestGeneticPathGenerator_exchange(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 1, Y: 0, Z: 0},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 1, Y: 1, Z: 0},
+		r3.Vec{X: 0, Y: 1, Z: 0},
+	}
+
+	res := <-newTestGeneticGenerator(1).GeneratePath(from, dest)
+
+	require.NotNil(t, res)
+	require.True(t, validatePaths(from, dest, res, DefaultMinSeparation))
+}
+
+func TestGeneticPathGenerator_cross(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 2},
+		r3.Vec{X: 2, Y: 0, Z: 2},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 2, Y: 1, Z: 2},
+		r3.Vec{X: 0, Y: 1, Z: 2},
+		r3.Vec{X: 2, Y: 1, Z: 0},
+		r3.Vec{X: 0, Y: 1, Z: 0},
+	}
+
+	res := <-newTestGeneticGenerator(2).GeneratePath(from, dest)
+
+	require.NotNil(t, res)
+	require.True(t, validatePaths(from, dest, res, DefaultMinSeparation))
+}
+
+func TestGeneticPathGenerator_seed(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 2, Y: 1, Z: 0},
+		r3.Vec{X: 0, Y: 1, Z: 0},
+	}
+
+	first := <-newTestGeneticGenerator(42).GeneratePath(from, dest)
+	second := <-newTestGeneticGenerator(42).GeneratePath(from, dest)
+
+	require.NotNil(t, first)
+	require.Equal(t, first, second)
+}
+
+func TestGeneticPathGenerator_cancel(t *testing.T) {
+	// Both drones share the same destination, no valid path exists
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 1, Y: 1, Z: 0},
+		r3.Vec{X: 1, Y: 1, Z: 0},
+	}
+	config := DefaultGeneticConfig()
+	config.MaxGenerations = math.MaxInt32
+	config.Timeout = 0
+	generator := NewGeneticPathGeneratorWithConfig(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := generator.GeneratePathContext(ctx, from, dest)
+	second := generator.GeneratePath(from, dest)
+
+	cancel()
+	select {
+	case res, ok := <-first:
+		require.False(t, ok)
+		require.Nil(t, res)
+	case <-time.After(time.Second):
+		t.Fatal("generation not cancelled")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		generator.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("generation not stopped")
+	}
+	_, ok := <-second
+	require.False(t, ok)
+
+	// The generator is still usable after Stop
+	from[1] = r3.Vec{X: 1, Y: 0, Z: 0}
+	dest[1] = r3.Vec{X: 0, Y: 1, Z: 0}
+	require.NotNil(t, <-generator.GeneratePath(from, dest))
 }
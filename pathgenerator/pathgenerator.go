@@ -1,10 +1,92 @@
 package pathgenerator
 
 import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.dedis.ch/cs438/orbitalswarm/environment"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
+// PathGenerator computes the paths bringing each drone from its location to its target.
+// Each call returns its own channel, which is closed without value if no
+// valid paths were found or if the request was cancelled.
 type PathGenerator interface {
 	GeneratePath(from []r3.Vec, dest []r3.Vec) <-chan [][]r3.Vec
+	// GeneratePathContext is like GeneratePath but the generation is aborted once ctx is done
+	GeneratePathContext(ctx context.Context, from []r3.Vec, dest []r3.Vec) <-chan [][]r3.Vec
+	// Stop aborts every running generation and waits for their termination
 	Stop()
 }
+
+const (
+	// GeneticPlanner selects the GeneticPathGenerator
+	GeneticPlanner = "genetic"
+	// CBSPlanner selects the CBSPathGenerator
+	CBSPlanner = "cbs"
+)
+
+// NewPathGenerator creates the path generator corresponding to the given
+// planner name, keeping the drones minSeparation apart and avoiding the no-fly
+// zones of the environment
+func NewPathGenerator(planner string, minSeparation float64, env *environment.Environment) (PathGenerator, error) {
+	switch planner {
+	case GeneticPlanner:
+		config := DefaultGeneticConfig()
+		config.MinSeparation = minSeparation
+		config.Environment = env
+		return NewGeneticPathGeneratorWithConfig(config), nil
+	case CBSPlanner:
+		return NewCBSPathGeneratorWithParams(defaultSuboptimality, defaultMaxExpansions, minSeparation, env), nil
+	default:
+		return nil, fmt.Errorf("unknown planner %s", planner)
+	}
+}
+
+// workers keeps track of the generations running in the background such
+// that they can all be aborted by Stop
+type workers struct {
+	// quit is closed by Stop to abort the running generations
+	quit    chan struct{}
+	mutex   sync.Mutex
+	running sync.WaitGroup
+}
+
+func newWorkers() workers {
+	return workers{
+		quit: make(chan struct{}),
+	}
+}
+
+// start registers a new generation. The returned channel is closed when the
+// generation must be aborted and done must be called once it terminates.
+func (w *workers) start() (quit <-chan struct{}, done func()) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.running.Add(1)
+	return w.quit, w.running.Done
+}
+
+// Stop aborts the running generations and waits for them to terminate. The
+// generator can still be used afterwards.
+func (w *workers) Stop() {
+	w.mutex.Lock()
+	close(w.quit)
+	w.quit = make(chan struct{})
+	w.mutex.Unlock()
+
+	w.running.Wait()
+}
+
+// aborted returns whether ctx is done or quit is closed
+func aborted(ctx context.Context, quit <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package pathgenerator
+
+import (
+	"fmt"
+	"sort"
+
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	// DefaultSafetyRadius is the minimal distance to keep between two drones flying their trajectories
+	DefaultSafetyRadius = 0.5
+	// maxSplits bounds the number of times an interval is halved to find the
+	// closest approach of two drones
+	maxSplits = 20
+)
+
+// GenerateTrajectories converts the discrete paths into smooth trajectories
+// respecting the limits of each drone. Straight runs are flown as single
+// segments when it keeps the drones at least safetyRadius apart, otherwise
+// every move is flown on its own, following the timing of the paths.
+func GenerateTrajectories(from []r3.Vec, paths [][]r3.Vec, limits []trajectory.Limits, profile string, safetyRadius float64) ([]trajectory.Trajectory, error) {
+	if len(limits) != len(paths) {
+		return nil, fmt.Errorf("expected limits for %d drones, got %d", len(paths), len(limits))
+	}
+	stepDuration := trajectory.StepDuration(limits, profile)
+
+	for _, merge := range []bool{true, false} {
+		trajectories := make([]trajectory.Trajectory, len(paths))
+		for i, path := range paths {
+			trajectories[i] = trajectory.FromMoves(from[i], path, stepDuration, limits[i], profile, merge)
+		}
+		if validateTrajectories(trajectories, safetyRadius) {
+			return trajectories, nil
+		}
+	}
+	return nil, fmt.Errorf("trajectories do not keep a separation of %f", safetyRadius)
+}
+
+// validateTrajectories checks that every pair of drones stays at least
+// safetyRadius apart along their trajectories
+func validateTrajectories(trajectories []trajectory.Trajectory, safetyRadius float64) bool {
+	for i := range trajectories {
+		for j := i + 1; j < len(trajectories); j++ {
+			if !separated(trajectories[i], trajectories[j], safetyRadius) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// separated checks the closest approach of two drones during each interval in
+// which both fly a single segment. Their distance changes at most at the sum
+// of their peak speeds, such that an interval is split until this bound
+// proves the drones apart or they are found closer than safetyRadius.
+func separated(a, b trajectory.Trajectory, safetyRadius float64) bool {
+	times := make([]float64, 0, len(a.Waypoints)+len(b.Waypoints))
+	for _, w := range a.Waypoints {
+		times = append(times, w.Time)
+	}
+	for _, w := range b.Waypoints {
+		times = append(times, w.Time)
+	}
+	sort.Float64s(times)
+
+	for k := 1; k < len(times); k++ {
+		start, end := times[k-1], times[k]
+		if end <= start {
+			continue
+		}
+		speed := peakSpeed(a, (start+end)/2) + peakSpeed(b, (start+end)/2)
+		if !separatedDuring(a, b, start, end, speed, safetyRadius, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// separatedDuring checks that the drones are safetyRadius apart between start
+// and end, their distance changing at most at the given speed
+func separatedDuring(a, b trajectory.Trajectory, start, end, speed, safetyRadius float64, depth int) bool {
+	startDistance := r3.Norm(a.Sample(start).Sub(b.Sample(start)))
+	endDistance := r3.Norm(a.Sample(end).Sub(b.Sample(end)))
+	if startDistance < safetyRadius || endDistance < safetyRadius {
+		return false
+	}
+	// Lowest distance reachable from both ends of the interval
+	if (startDistance+endDistance-speed*(end-start))/2 >= safetyRadius || depth == maxSplits {
+		return true
+	}
+	middle := (start + end) / 2
+	return separatedDuring(a, b, start, middle, speed, safetyRadius, depth+1) &&
+		separatedDuring(a, b, middle, end, speed, safetyRadius, depth+1)
+}
+
+// peakSpeed bounds the speed of the drone at the given time. Every profile
+// flies a segment at most twice as fast as its average speed.
+func peakSpeed(t trajectory.Trajectory, time float64) float64 {
+	for i := 1; i < len(t.Waypoints); i++ {
+		start, end := t.Waypoints[i-1], t.Waypoints[i]
+		if time < end.Time {
+			if time < start.Time || end.Time <= start.Time {
+				return 0
+			}
+			return 2 * r3.Norm(end.Position.Sub(start.Position)) / (end.Time - start.Time)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package pathgenerator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestTrajectories_merged(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 1},
+	}
+	paths := [][]r3.Vec{
+		{r3.Vec{X: 1}, r3.Vec{X: 1}, r3.Vec{X: 1}},
+		{r3.Vec{X: 1}, r3.Vec{X: 1}, r3.Vec{X: 1}},
+	}
+	limits := []trajectory.Limits{trajectory.DefaultLimits, trajectory.DefaultLimits}
+
+	trajectories, err := GenerateTrajectories(from, paths, limits, trajectory.TrapezoidalProfile, DefaultSafetyRadius)
+	require.NoError(t, err)
+	require.Len(t, trajectories, 2)
+	for i, traj := range trajectories {
+		// The straight run is flown as a single segment
+		require.Len(t, traj.Waypoints, 2)
+		require.Equal(t, from[i].Add(r3.Vec{X: 3}), traj.Sample(traj.Duration()))
+	}
+
+	_, err = GenerateTrajectories(from, paths, limits[:1], trajectory.TrapezoidalProfile, DefaultSafetyRadius)
+	require.Error(t, err)
+}
+
+func TestTrajectories_nearMiss(t *testing.T) {
+	// The first drone crosses in front of the second one at 200 units per
+	// second, getting 0.4 apart from it at 0.5025 seconds
+	fast := trajectory.Trajectory{
+		Waypoints: []trajectory.Waypoint{
+			{Time: 0, Position: r3.Vec{X: -100}},
+			{Time: 1, Position: r3.Vec{X: 100}},
+		},
+	}
+	hover := trajectory.Trajectory{
+		Waypoints: []trajectory.Waypoint{
+			{Time: 0, Position: r3.Vec{X: 0.5, Y: 0.4}},
+			{Time: 1, Position: r3.Vec{X: 0.5, Y: 0.4}},
+		},
+	}
+	trajectories := []trajectory.Trajectory{fast, hover}
+
+	// Sampling every 0.05 seconds never finds them closer than 0.6
+	for time := 0.0; time <= 1; time += 0.05 {
+		require.Greater(t, r3.Norm(fast.Sample(time).Sub(hover.Sample(time))), 0.6)
+	}
+	require.False(t, validateTrajectories(trajectories, 0.5))
+	require.True(t, validateTrajectories(trajectories, 0.39))
+}
+
+func TestTrajectories_unmerged(t *testing.T) {
+	// The first drone flies two moves while the second one leaves the
+	// location it reaches. Flown as a single segment, the first drone is
+	// ahead of its schedule during the second move and gets too close.
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 0},
+	}
+	paths := [][]r3.Vec{
+		{r3.Vec{X: 1}, r3.Vec{X: 1}},
+		{r3.Vec{}, r3.Vec{Z: 1}},
+	}
+	limits := []trajectory.Limits{trajectory.DefaultLimits, trajectory.DefaultLimits}
+
+	trajectories, err := GenerateTrajectories(from, paths, limits, trajectory.MinimumJerkProfile, 0.4)
+	require.NoError(t, err)
+	require.Len(t, trajectories[0].Waypoints, 2)
+
+	trajectories, err = GenerateTrajectories(from, paths, limits, trajectory.MinimumJerkProfile, 0.6)
+	require.NoError(t, err)
+	require.Len(t, trajectories[0].Waypoints, 3)
+
+	_, err = GenerateTrajectories(from, paths, limits, trajectory.MinimumJerkProfile, 1)
+	require.Error(t, err)
+}
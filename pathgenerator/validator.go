@@ -0,0 +1,249 @@
+package pathgenerator
+
+import (
+	"fmt"
+	"math"
+
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// DefaultMinSeparation is the minimal distance two drones must keep while
+// following their paths. It forbids sharing a cell and swapping cells.
+const DefaultMinSeparation = 0.5
+
+// SeparationError reports two drones getting closer than the minimal separation
+type SeparationError struct {
+	DroneA   int
+	DroneB   int
+	Round    int
+	Distance float64
+}
+
+func (e *SeparationError) Error() string {
+	return fmt.Sprintf("drones %d and %d are %f apart during round %d", e.DroneA, e.DroneB, e.Distance, e.Round)
+}
+
+// UndergroundError reports a drone going under the floor of the environment
+type UndergroundError struct {
+	Drone int
+	Round int
+}
+
+func (e *UndergroundError) Error() string {
+	return fmt.Sprintf("drone %d under ground level at round %d", e.Drone, e.Round)
+}
+
+// ObstacleError reports a drone flying through an obstacle or above the ceiling
+type ObstacleError struct {
+	Drone    int
+	Round    int
+	Location r3.Vec
+}
+
+func (e *ObstacleError) Error() string {
+	return fmt.Sprintf("drone %d enters a no-fly zone at round %d on its way to %v", e.Drone, e.Round, e.Location)
+}
+
+// DestinationError reports a drone not reaching its destination
+type DestinationError struct {
+	Drone    int
+	Location r3.Vec
+}
+
+func (e *DestinationError) Error() string {
+	return fmt.Sprintf("drone %d ends at %v instead of its destination", e.Drone, e.Location)
+}
+
+// Validator checks that paths keep the drones apart and out of the no-fly
+// zones of the environment. During a round, each drone flies in straight line
+// at constant speed from one location to the next, the swept segments of
+// every pair of drones are checked.
+type Validator struct {
+	minSeparation float64
+	environment   *environment.Environment
+}
+
+// NewValidator creates a validator enforcing the given minimal separation in
+// the environment. A nil environment has no obstacles.
+func NewValidator(minSeparation float64, env *environment.Environment) *Validator {
+	if env == nil {
+		env = environment.Empty()
+	}
+	return &Validator{
+		minSeparation: minSeparation,
+		environment:   env,
+	}
+}
+
+// Validate returns the first violation found in the paths, or nil if the
+// paths are valid. paths : [pathId][...steps]
+func (v *Validator) Validate(from []r3.Vec, dest []r3.Vec, paths [][]r3.Vec) error {
+	if err := checkLengths(from, paths); err != nil {
+		return err
+	}
+	if len(dest) != len(paths) {
+		return fmt.Errorf("%d destinations for %d paths", len(dest), len(paths))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	locations := append([]r3.Vec{}, from...)
+	for round := 0; round < len(paths[0]); round++ {
+		moves := make([]r3.Vec, len(paths))
+		for i, path := range paths {
+			moves[i] = path[round]
+			next := locations[i].Add(moves[i])
+			if v.environment.BelowFloor(next) {
+				return &UndergroundError{Drone: i, Round: round}
+			}
+			if v.environment.AboveCeiling(next) || v.environment.Crosses(locations[i], next) {
+				return &ObstacleError{Drone: i, Round: round, Location: next}
+			}
+		}
+		if violations := v.roundViolations(round, locations, moves, true); len(violations) > 0 {
+			return &violations[0]
+		}
+		for i := range locations {
+			locations[i] = locations[i].Add(moves[i])
+		}
+	}
+
+	for i := range locations {
+		if locations[i] != dest[i] {
+			return &DestinationError{Drone: i, Location: locations[i]}
+		}
+	}
+	return nil
+}
+
+// Violations returns, for every round, each pair of drones getting closer than the minimal separation
+func (v *Validator) Violations(from []r3.Vec, paths [][]r3.Vec) ([]SeparationError, error) {
+	if err := checkLengths(from, paths); err != nil {
+		return nil, err
+	}
+	violations := make([]SeparationError, 0)
+	if len(paths) == 0 {
+		return violations, nil
+	}
+
+	locations := append([]r3.Vec{}, from...)
+	for round := 0; round < len(paths[0]); round++ {
+		moves := make([]r3.Vec, len(paths))
+		for i, path := range paths {
+			moves[i] = path[round]
+		}
+		violations = append(violations, v.roundViolations(round, locations, moves, false)...)
+		for i := range locations {
+			locations[i] = locations[i].Add(moves[i])
+		}
+	}
+	return violations, nil
+}
+
+// checkLengths returns an error if there is not one location per path or if
+// the paths do not all have the same number of rounds
+func checkLengths(from []r3.Vec, paths [][]r3.Vec) error {
+	if len(from) != len(paths) {
+		return fmt.Errorf("%d locations for %d paths", len(from), len(paths))
+	}
+	for i, path := range paths {
+		if len(path) != len(paths[0]) {
+			return fmt.Errorf("path %d has %d rounds instead of %d", i, len(path), len(paths[0]))
+		}
+	}
+	return nil
+}
+
+// Blocked returns, for each drone, the number of moves leaving the allowed
+// altitudes or going through an obstacle
+func (v *Validator) Blocked(from []r3.Vec, paths [][]r3.Vec) []int {
+	blocked := make([]int, len(paths))
+	for i, path := range paths {
+		location := from[i]
+		for _, move := range path {
+			next := location.Add(move)
+			if move != still && (!v.environment.Allowed(next) || v.environment.Crosses(location, next)) {
+				blocked[i]++
+			}
+			location = next
+		}
+	}
+	return blocked
+}
+
+// roundViolations checks the swept segments of a round. Drones are indexed in
+// a spatial hash by their location at the beginning of the round, such that
+// only drones in neighbouring cells are compared.
+func (v *Validator) roundViolations(round int, locations, moves []r3.Vec, firstOnly bool) []SeparationError {
+	type cell struct {
+		x, y, z int
+	}
+
+	maxMove := 0.0
+	for _, m := range moves {
+		maxMove = math.Max(maxMove, r3.Norm(m))
+	}
+	// Two drones closer than the separation during the round start at most
+	// cellSize apart, so they are in neighbouring cells
+	cellSize := v.minSeparation + 2*maxMove
+	if cellSize <= 0 {
+		cellSize = 1
+	}
+	cellOf := func(l r3.Vec) cell {
+		return cell{
+			x: int(math.Floor(l.X / cellSize)),
+			y: int(math.Floor(l.Y / cellSize)),
+			z: int(math.Floor(l.Z / cellSize)),
+		}
+	}
+
+	grid := make(map[cell][]int, len(locations))
+	for i, l := range locations {
+		c := cellOf(l)
+		grid[c] = append(grid[c], i)
+	}
+
+	violations := make([]SeparationError, 0)
+	for i, l := range locations {
+		c := cellOf(l)
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dz := -1; dz <= 1; dz++ {
+					for _, j := range grid[cell{x: c.x + dx, y: c.y + dy, z: c.z + dz}] {
+						if j <= i {
+							continue
+						}
+						distance := sweptDistance(locations[i], moves[i], locations[j], moves[j])
+						if distance < v.minSeparation {
+							violations = append(violations, SeparationError{
+								DroneA:   i,
+								DroneB:   j,
+								Round:    round,
+								Distance: distance,
+							})
+							if firstOnly {
+								return violations
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// sweptDistance returns the minimal distance between two drones moving at
+// constant speed from a to a+moveA and from b to b+moveB during the same time
+func sweptDistance(a, moveA, b, moveB r3.Vec) float64 {
+	offset := a.Sub(b)
+	relative := moveA.Sub(moveB)
+
+	t := 0.0
+	if speed := relative.Dot(relative); speed > 0 {
+		t = math.Min(math.Max(-offset.Dot(relative)/speed, 0), 1)
+	}
+	return r3.Norm(offset.Add(relative.Scale(t)))
+}
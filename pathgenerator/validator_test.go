@@ -0,0 +1,126 @@
+package pathgenerator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.dedis.ch/cs438/orbitalswarm/environment"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestValidator_swap(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 1, Y: 0, Z: 0},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 1, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 0},
+	}
+	paths := [][]r3.Vec{
+		{r3.Vec{X: 1, Y: 0, Z: 0}},
+		{r3.Vec{X: -1, Y: 0, Z: 0}},
+	}
+
+	err := NewValidator(DefaultMinSeparation, nil).Validate(from, dest, paths)
+	require.IsType(t, &SeparationError{}, err)
+
+	separation := err.(*SeparationError)
+	require.Equal(t, 0, separation.DroneA)
+	require.Equal(t, 1, separation.DroneB)
+	require.Equal(t, 0, separation.Round)
+	require.InDelta(t, 0, separation.Distance, 1e-9)
+}
+
+func TestValidator_separation(t *testing.T) {
+	// Two drones flying side by side, one unit apart
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 1},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 2, Y: 0, Z: 0},
+		r3.Vec{X: 2, Y: 0, Z: 1},
+	}
+	paths := [][]r3.Vec{
+		{r3.Vec{X: 1, Y: 0, Z: 0}, r3.Vec{X: 1, Y: 0, Z: 0}},
+		{r3.Vec{X: 1, Y: 0, Z: 0}, r3.Vec{X: 1, Y: 0, Z: 0}},
+	}
+
+	require.NoError(t, NewValidator(DefaultMinSeparation, nil).Validate(from, dest, paths))
+
+	violations, err := NewValidator(1.5, nil).Violations(from, paths)
+	require.NoError(t, err)
+	require.Len(t, violations, 2)
+	require.Equal(t, 1, violations[1].Round)
+	require.InDelta(t, 1, violations[1].Distance, 1e-9)
+}
+
+func TestValidator_largeSwarm(t *testing.T) {
+	// A grid of drones all moving up at once never violates the separation
+	from := make([]r3.Vec, 0)
+	paths := make([][]r3.Vec, 0)
+	for x := 0; x < 30; x++ {
+		for z := 0; z < 30; z++ {
+			from = append(from, r3.Vec{X: float64(x), Y: 0, Z: float64(z)})
+			paths = append(paths, []r3.Vec{{X: 0, Y: 1, Z: 0}})
+		}
+	}
+
+	violations, err := NewValidator(DefaultMinSeparation, nil).Violations(from, paths)
+	require.NoError(t, err)
+	require.Empty(t, violations)
+	violations, err = NewValidator(1.1, nil).Violations(from, paths)
+	require.NoError(t, err)
+	require.Len(t, violations, 2*30*29)
+}
+
+func TestValidator_obstacle(t *testing.T) {
+	env := environment.Empty()
+	env.Cylinders = append(env.Cylinders, environment.Cylinder{Center: r3.Vec{X: 1, Y: 0, Z: 0}, Radius: 0.5, Height: 1.5})
+
+	from := []r3.Vec{r3.Vec{X: 0, Y: 1, Z: 0}}
+	dest := []r3.Vec{r3.Vec{X: 2, Y: 1, Z: 0}}
+	paths := [][]r3.Vec{{r3.Vec{X: 1, Y: 0, Z: 0}, r3.Vec{X: 1, Y: 0, Z: 0}}}
+
+	err := NewValidator(DefaultMinSeparation, env).Validate(from, dest, paths)
+	require.IsType(t, &ObstacleError{}, err)
+	require.Equal(t, 0, err.(*ObstacleError).Round)
+	require.Equal(t, []int{2}, NewValidator(DefaultMinSeparation, env).Blocked(from, paths))
+
+	// Flying over the obstacle
+	paths = [][]r3.Vec{{
+		r3.Vec{X: 0, Y: 1, Z: 0}, r3.Vec{X: 1, Y: 0, Z: 0}, r3.Vec{X: 1, Y: 0, Z: 0}, r3.Vec{X: 0, Y: -1, Z: 0},
+	}}
+	require.NoError(t, NewValidator(DefaultMinSeparation, env).Validate(from, dest, paths))
+}
+
+func TestValidator_lengths(t *testing.T) {
+	from := []r3.Vec{
+		r3.Vec{X: 0, Y: 0, Z: 0},
+		r3.Vec{X: 0, Y: 0, Z: 2},
+	}
+	dest := []r3.Vec{
+		r3.Vec{X: 2, Y: 0, Z: 0},
+		r3.Vec{X: 1, Y: 0, Z: 2},
+	}
+	validator := NewValidator(DefaultMinSeparation, nil)
+
+	// Ragged paths
+	paths := [][]r3.Vec{
+		{r3.Vec{X: 1, Y: 0, Z: 0}, r3.Vec{X: 1, Y: 0, Z: 0}},
+		{r3.Vec{X: 1, Y: 0, Z: 0}},
+	}
+	require.Error(t, validator.Validate(from, dest, paths))
+	_, err := validator.Violations(from, paths)
+	require.Error(t, err)
+
+	// Missing locations and destinations
+	paths[1] = append(paths[1], r3.Vec{})
+	require.NoError(t, validator.Validate(from, dest, paths))
+	require.Error(t, validator.Validate(from[:1], dest, paths))
+	require.Error(t, validator.Validate(from, dest[:1], paths))
+	_, err = validator.Violations(from[:1], paths)
+	require.Error(t, err)
+}
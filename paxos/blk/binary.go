@@ -0,0 +1,159 @@
+package blk
+
+import (
+	"fmt"
+
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
+	"go.dedis.ch/cs438/orbitalswarm/wire"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// EncodeBinary writes the block in the binary wire format. As with JSON, the
+// type of a container without block is not kept, and a block without content
+// is decoded with an empty content.
+func (b *BlockContainer) EncodeBinary(w *wire.Writer) {
+	w.Bool(b != nil)
+	if b == nil {
+		return
+	}
+	w.Bool(b.Block != nil)
+	if b.Block == nil {
+		return
+	}
+	w.String(b.Type)
+	w.Int(b.BlockNumber())
+	w.ByteSlice(b.PreviousHash())
+
+	switch content := b.GetContent().(type) {
+	case *NamingBlockContent:
+		w.ByteSlice(content.Metahash)
+		w.String(content.Filename)
+	case *MappingBlockContent:
+		w.String(content.PatternID)
+		w.Vecs(content.Targets)
+	case *PathBlockContent:
+		encodePathContent(w, content)
+	case *SnapshotBlockContent:
+		w.String(content.PatternID)
+		w.Vecs(content.Positions)
+	default:
+		// A block without content is decoded with the empty content of its type
+		encodeEmptyContent(w, b.Type)
+	}
+}
+
+func encodeEmptyContent(w *wire.Writer, blockType string) {
+	switch blockType {
+	case BlockNamingStr:
+		w.ByteSlice(nil)
+		w.String("")
+	case BlockPathStr:
+		encodePathContent(w, &PathBlockContent{})
+	default:
+		w.String("")
+		w.Vecs(nil)
+	}
+}
+
+func encodePathContent(w *wire.Writer, content *PathBlockContent) {
+	w.String(content.PatternID)
+	w.Slice(len(content.Paths), content.Paths == nil)
+	for _, path := range content.Paths {
+		w.Vecs(path)
+	}
+	w.Slice(len(content.Trajectories), content.Trajectories == nil)
+	for _, t := range content.Trajectories {
+		w.String(t.Profile)
+		w.Float(t.Limits.MaxVelocity)
+		w.Float(t.Limits.MaxAcceleration)
+		w.Slice(len(t.Waypoints), t.Waypoints == nil)
+		for _, waypoint := range t.Waypoints {
+			w.Float(waypoint.Time)
+			w.Vec(waypoint.Position)
+		}
+	}
+}
+
+// DecodeBlockContainer reads a block written by EncodeBinary. The content is
+// set as UnmarshalJSON does, so that both decodings give the same block.
+func DecodeBlockContainer(r *wire.Reader) *BlockContainer {
+	if !r.Bool() {
+		return nil
+	}
+	if !r.Bool() {
+		return &BlockContainer{}
+	}
+	blockType := r.String()
+	number := r.Int()
+	prevHash := r.ByteSlice()
+
+	var block Block
+	var content BlockContent
+	switch blockType {
+	case BlockNamingStr:
+		block = &NamingBlock{BlockNum: number, PrevHash: prevHash}
+		content = &NamingBlockContent{
+			Metahash: r.ByteSlice(),
+			Filename: r.String(),
+		}
+	case BlockMappingStr:
+		block = &MappingBlock{BlockNum: number, PrevHash: prevHash}
+		content = &MappingBlockContent{
+			PatternID: r.String(),
+			Targets:   r.Vecs(),
+		}
+	case BlockPathStr:
+		block = &PathBlock{BlockNum: number, PrevHash: prevHash}
+		content = decodePathContent(r)
+	case BlockSnapshotStr:
+		block = &SnapshotBlock{BlockNum: number, PrevHash: prevHash}
+		content = &SnapshotBlockContent{
+			PatternID: r.String(),
+			Positions: r.Vecs(),
+		}
+	default:
+		r.Fail(fmt.Errorf("unknown block type %q", blockType))
+		return nil
+	}
+	if r.Err() != nil {
+		return nil
+	}
+	block.SetContent(content)
+	return &BlockContainer{
+		Type:  blockType,
+		Block: block,
+	}
+}
+
+func decodePathContent(r *wire.Reader) *PathBlockContent {
+	content := &PathBlockContent{PatternID: r.String()}
+
+	length, isNil := r.Slice(1)
+	if !isNil {
+		content.Paths = make([][]r3.Vec, length)
+		for i := range content.Paths {
+			content.Paths[i] = r.Vecs()
+		}
+	}
+
+	length, isNil = r.Slice(1)
+	if !isNil {
+		content.Trajectories = make([]trajectory.Trajectory, length)
+		for i := range content.Trajectories {
+			t := &content.Trajectories[i]
+			t.Profile = r.String()
+			t.Limits.MaxVelocity = r.Float()
+			t.Limits.MaxAcceleration = r.Float()
+			count, isNil := r.Slice(4)
+			if isNil {
+				continue
+			}
+			t.Waypoints = make([]trajectory.Waypoint, count)
+			for j := range t.Waypoints {
+				t.Waypoints[j].Time = r.Float()
+				t.Waypoints[j].Position = r.Vec()
+			}
+		}
+	}
+	return content
+}
@@ -4,6 +4,8 @@ const (
 	BlockNamingStr  = "NamingBlock"
 	BlockMappingStr = "MappingBlock"
 	BlockPathStr    = "PathBlock"
+	// BlockSnapshotStr summarizes the blocks preceding it, which may be pruned
+	BlockSnapshotStr = "SnapshotBlock"
 )
 
 // Block describes the content of a block in the blockchain.
@@ -22,6 +24,8 @@ type BlockContent interface {
 	Hash() []byte
 	Copy() BlockContent
 	BlockType() string
+	// Validate returns an error if the content is not well formed
+	Validate() error
 }
 
 type BlockFactory interface {
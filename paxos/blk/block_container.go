@@ -15,14 +15,16 @@ type BlockContainer struct {
 func (b *BlockContainer) UnmarshalJSON(data []byte) error {
 	//Setup blocktypes
 	blockTypes := map[string]reflect.Type{
-		BlockNamingStr:  reflect.TypeOf(NamingBlock{}),
-		BlockMappingStr: reflect.TypeOf(MappingBlock{}),
-		BlockPathStr:    reflect.TypeOf(PathBlock{}),
+		BlockNamingStr:   reflect.TypeOf(NamingBlock{}),
+		BlockMappingStr:  reflect.TypeOf(MappingBlock{}),
+		BlockPathStr:     reflect.TypeOf(PathBlock{}),
+		BlockSnapshotStr: reflect.TypeOf(SnapshotBlock{}),
 	}
 	blockContentTypes := map[string]reflect.Type{
-		BlockNamingStr:  reflect.TypeOf(NamingBlockContent{}),
-		BlockMappingStr: reflect.TypeOf(MappingBlockContent{}),
-		BlockPathStr:    reflect.TypeOf(PathBlockContent{}),
+		BlockNamingStr:   reflect.TypeOf(NamingBlockContent{}),
+		BlockMappingStr:  reflect.TypeOf(MappingBlockContent{}),
+		BlockPathStr:     reflect.TypeOf(PathBlockContent{}),
+		BlockSnapshotStr: reflect.TypeOf(SnapshotBlockContent{}),
 	}
 
 	//Unmarshall in generic map[string]interface{}
@@ -42,6 +42,15 @@ func (f GenericBlockFactory) NewBlock(blockType string, blockNumber int, previou
 				Content:  content,
 			},
 		}
+	case BlockSnapshotStr:
+		return &BlockContainer{
+			Type: BlockSnapshotStr,
+			Block: &SnapshotBlock{
+				BlockNum: blockNumber,
+				PrevHash: previousHash,
+				Content:  content,
+			},
+		}
 	default:
 		panic("Unknown type of blocks")
 	}
@@ -41,6 +41,22 @@ func (c *MappingBlockContent) BlockType() string {
 	return BlockMappingStr
 }
 
+// Validate checks that the targets of the pattern are given
+func (c *MappingBlockContent) Validate() error {
+	if c.PatternID == "" {
+		return fmt.Errorf("mapping without pattern")
+	}
+	if c.Targets == nil {
+		return fmt.Errorf("mapping of pattern %s without targets", c.PatternID)
+	}
+	for i, target := range c.Targets {
+		if !finite(target) {
+			return fmt.Errorf("mapping of pattern %s: invalid target %d %v", c.PatternID, i, target)
+		}
+	}
+	return nil
+}
+
 func (b *MappingBlock) Hash() []byte {
 	h := sha256.New()
 
@@ -1,6 +1,9 @@
 package blk
 
-import "crypto/sha256"
+import (
+	"crypto/sha256"
+	"fmt"
+)
 
 // NamingBlock ...
 type NamingBlock struct {
@@ -33,6 +36,14 @@ func (c *NamingBlockContent) BlockType() string {
 	return BlockNamingStr
 }
 
+// Validate checks that the file is named and has a metahash
+func (c *NamingBlockContent) Validate() error {
+	if c.Filename == "" || c.Metahash == nil {
+		return fmt.Errorf("naming block without file name or metahash")
+	}
+	return nil
+}
+
 // Hash returns the hash of a block. It doesn't take the index.
 func (b *NamingBlock) Hash() []byte {
 	h := sha256.New()
@@ -3,7 +3,9 @@ package blk
 import (
 	"crypto/sha256"
 	"fmt"
+	"math"
 
+	"go.dedis.ch/cs438/orbitalswarm/trajectory"
 	"gonum.org/v1/gonum/spatial/r3"
 )
 
@@ -17,6 +19,8 @@ type PathBlock struct {
 type PathBlockContent struct {
 	PatternID string
 	Paths     [][]r3.Vec
+	// Trajectories are the timed trajectories following the paths, if any
+	Trajectories []trajectory.Trajectory
 }
 
 func (c *PathBlockContent) Hash() []byte {
@@ -29,6 +33,13 @@ func (c *PathBlockContent) Hash() []byte {
 		}
 	}
 
+	for _, t := range c.Trajectories {
+		h.Write([]byte(fmt.Sprintf("%s%v", t.Profile, t.Limits)))
+		for _, waypoint := range t.Waypoints {
+			h.Write([]byte(fmt.Sprintf("%v", waypoint)))
+		}
+	}
+
 	return h.Sum(nil)
 }
 
@@ -42,9 +53,18 @@ func (c *PathBlockContent) Copy() BlockContent {
 		}
 	}
 
+	var trajectoriesCopy []trajectory.Trajectory
+	if c.Trajectories != nil {
+		trajectoriesCopy = make([]trajectory.Trajectory, len(c.Trajectories))
+		for i, t := range c.Trajectories {
+			trajectoriesCopy[i] = t.Copy()
+		}
+	}
+
 	return &PathBlockContent{
-		PatternID: c.PatternID,
-		Paths:     pathCopy,
+		PatternID:    c.PatternID,
+		Paths:        pathCopy,
+		Trajectories: trajectoriesCopy,
 	}
 }
 
@@ -52,6 +72,35 @@ func (c *PathBlockContent) BlockType() string {
 	return BlockPathStr
 }
 
+// Validate checks that the paths of the pattern are given, with a trajectory
+// per path if any
+func (c *PathBlockContent) Validate() error {
+	if c.PatternID == "" {
+		return fmt.Errorf("paths without pattern")
+	}
+	if c.Paths == nil {
+		return fmt.Errorf("pattern %s without paths", c.PatternID)
+	}
+	for i, path := range c.Paths {
+		for _, step := range path {
+			if !finite(step) {
+				return fmt.Errorf("pattern %s: invalid step %v in path %d", c.PatternID, step, i)
+			}
+		}
+	}
+	if len(c.Trajectories) > 0 && len(c.Trajectories) != len(c.Paths) {
+		return fmt.Errorf("pattern %s: %d trajectories for %d paths", c.PatternID, len(c.Trajectories), len(c.Paths))
+	}
+	for i, t := range c.Trajectories {
+		for _, waypoint := range t.Waypoints {
+			if !finite(waypoint.Position) || math.IsNaN(waypoint.Time) || math.IsInf(waypoint.Time, 0) {
+				return fmt.Errorf("pattern %s: invalid waypoint %v in trajectory %d", c.PatternID, waypoint, i)
+			}
+		}
+	}
+	return nil
+}
+
 func (b *PathBlock) Hash() []byte {
 	h := sha256.New()
 
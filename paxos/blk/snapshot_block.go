@@ -0,0 +1,115 @@
+package blk
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// SnapshotBlock summarizes the chain preceding it, so that the older blocks
+// can be pruned. Its previous hash links it to the last block summarized.
+type SnapshotBlock struct {
+	BlockNum int // not included in the hash
+	PrevHash []byte
+
+	Content BlockContent
+}
+
+// SnapshotBlockContent holds the positions of the drones once the paths of
+// the last pattern are followed
+type SnapshotBlockContent struct {
+	PatternID string
+	Positions []r3.Vec
+}
+
+func (c *SnapshotBlockContent) Hash() []byte {
+	h := sha256.New()
+	h.Write([]byte(c.PatternID))
+
+	for _, p := range c.Positions {
+		h.Write([]byte(fmt.Sprintf("%v", p)))
+	}
+
+	return h.Sum(nil)
+}
+
+func (c *SnapshotBlockContent) Copy() BlockContent {
+	return &SnapshotBlockContent{
+		PatternID: c.PatternID,
+		Positions: append([]r3.Vec{}, c.Positions...),
+	}
+}
+
+func (c *SnapshotBlockContent) BlockType() string {
+	return BlockSnapshotStr
+}
+
+// Validate checks that the positions following the pattern are given
+func (c *SnapshotBlockContent) Validate() error {
+	if c.PatternID == "" {
+		return fmt.Errorf("snapshot without pattern")
+	}
+	if c.Positions == nil {
+		return fmt.Errorf("snapshot of pattern %s without positions", c.PatternID)
+	}
+	for i, position := range c.Positions {
+		if !finite(position) {
+			return fmt.Errorf("snapshot of pattern %s: invalid position %d %v", c.PatternID, i, position)
+		}
+	}
+	return nil
+}
+
+func (b *SnapshotBlock) Hash() []byte {
+	h := sha256.New()
+
+	h.Write(b.PrevHash)
+	h.Write(b.Content.Hash())
+
+	return h.Sum(nil)
+}
+
+func (b *SnapshotBlock) Copy() Block {
+	if b.Content == nil {
+		return &SnapshotBlock{
+			BlockNum: b.BlockNum,
+			PrevHash: append([]byte{}, b.PrevHash...),
+		}
+	}
+	return &SnapshotBlock{
+		BlockNum: b.BlockNum,
+		PrevHash: append([]byte{}, b.PrevHash...),
+
+		Content: b.Content.Copy(),
+	}
+}
+
+func (b *SnapshotBlock) BlockNumber() int {
+	return b.BlockNum
+}
+
+func (b *SnapshotBlock) PreviousHash() []byte {
+	return b.PrevHash
+}
+
+func (b *SnapshotBlock) SetPreviousHash(prevHash []byte) {
+	b.PrevHash = prevHash
+}
+
+func (b *SnapshotBlock) GetContent() BlockContent {
+	return b.Content
+}
+
+func (b *SnapshotBlock) SetContent(blockContent BlockContent) {
+	snapshotContent, ok := blockContent.(*SnapshotBlockContent)
+
+	if ok {
+		b.Content = snapshotContent.Copy()
+	}
+}
+
+func (b *SnapshotBlock) IsContentNil() bool {
+	snapshotContent := b.Content.(*SnapshotBlockContent)
+	return snapshotContent.Positions == nil
+}
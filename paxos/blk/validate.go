@@ -0,0 +1,109 @@
+package blk
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// Validate returns an error if the block is empty, if its content does not
+// match its type or if the content is not well formed
+func (b *BlockContainer) Validate() error {
+	if b == nil || b.Block == nil {
+		return fmt.Errorf("empty block")
+	}
+	content := b.GetContent()
+	if content == nil {
+		return fmt.Errorf("block %d has no content", b.BlockNumber())
+	}
+	if content.BlockType() != b.Type {
+		return fmt.Errorf("block %d of type %s holds a content of type %s", b.BlockNumber(), b.Type, content.BlockType())
+	}
+	if err := content.Validate(); err != nil {
+		return fmt.Errorf("block %d: %v", b.BlockNumber(), err)
+	}
+	return nil
+}
+
+// Follows returns an error if the block is not the block following previous,
+// a nil previous block standing for the genesis block
+func (b *BlockContainer) Follows(previous *BlockContainer) error {
+	number, previousHash := 0, make([]byte, 32)
+	if previous != nil {
+		number, previousHash = previous.BlockNumber()+1, previous.Hash()
+	}
+	if b.BlockNumber() != number {
+		return fmt.Errorf("block %d found instead of block %d", b.BlockNumber(), number)
+	}
+	if !bytes.Equal(b.PreviousHash(), previousHash) {
+		return fmt.Errorf("block %d is not linked to the previous block", b.BlockNumber())
+	}
+	return nil
+}
+
+// StartsChain returns whether the chain may start with the block: the genesis
+// block, or a snapshot once the blocks preceding it are pruned
+func (b *BlockContainer) StartsChain() bool {
+	return b.Type == BlockSnapshotStr || b.Follows(nil) == nil
+}
+
+// Verify walks the chain ending with the block of hash tail from its first
+// block, validating each block and its link to the previous one. The chain
+// starts with the genesis block or with a snapshot whose previous blocks were
+// pruned. The blocks are indexed by their hexadecimal hash, as returned by
+// GetBlocks, and must all belong to the chain.
+func Verify(tail string, blocks map[string]*BlockContainer) error {
+	chain := make([]*BlockContainer, 0, len(blocks))
+	hash := tail
+	for hash != hex.EncodeToString(make([]byte, 32)) {
+		block, found := blocks[hash]
+		if !found && len(chain) > 0 && chain[len(chain)-1].Type == BlockSnapshotStr {
+			// The blocks preceding the snapshot are pruned
+			break
+		}
+		if !found {
+			return fmt.Errorf("missing block %s", hash)
+		}
+		if err := block.Validate(); err != nil {
+			return err
+		}
+		if hex.EncodeToString(block.Hash()) != hash {
+			return fmt.Errorf("block %d is not stored under its hash", block.BlockNumber())
+		}
+		if len(chain) == len(blocks) {
+			return fmt.Errorf("the chain loops on block %d", block.BlockNumber())
+		}
+		chain = append(chain, block)
+		hash = hex.EncodeToString(block.PreviousHash())
+	}
+	if len(chain) != len(blocks) {
+		return fmt.Errorf("%d blocks are not in the chain", len(blocks)-len(chain))
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+
+	previous := chain[len(chain)-1]
+	if !previous.StartsChain() {
+		return fmt.Errorf("the chain starts with block %d", previous.BlockNumber())
+	}
+	for i := len(chain) - 2; i >= 0; i-- {
+		if err := chain[i].Follows(previous); err != nil {
+			return err
+		}
+		previous = chain[i]
+	}
+	return nil
+}
+
+func finite(v r3.Vec) bool {
+	for _, x := range []float64{v.X, v.Y, v.Z} {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,11 @@
 package paxos
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
 
 	"go.dedis.ch/cs438/orbitalswarm/extramessage"
 	"go.dedis.ch/cs438/orbitalswarm/gossip"
@@ -9,61 +13,464 @@ import (
 	"go.dedis.ch/onet/v3/log"
 )
 
-// BlockChain allow to handle HandlingPackets
+// window is the number of consecutive blocks agreed concurrently
+const window = 4
+
+// BlockChain allow to handle HandlingPackets. With Paxos, the blocks of a
+// window following the tail are agreed concurrently by a TLC instance each,
+// and added to the chain in order. A block proposed beyond the next one does
+// not know its previous block yet, it is linked to it once added.
+//
+// Once a snapshot block is added, the blocks preceding it are pruned from
+// memory and from the storage, the chain starting with the snapshot.
 type BlockChain struct {
 	numParticipant int
 	nodeIndex      int
 	paxosRetry     int
 
+	mutex  sync.Mutex
 	tail   *blk.BlockContainer
 	blocks map[string]*blk.BlockContainer
-	tlc    *TLC
+	// chain holds the blocks by number from base, the number of its first block
+	chain []*blk.BlockContainer
+	base  int
+	// applied are the blocks added to the chain but not yet returned
+	applied []*blk.BlockContainer
+	// tlcs are the instances of the window, by block number
+	tlcs map[int]*TLC
+	// decided holds the blocks decided beyond the next one until it is added
+	decided map[int]*blk.BlockContainer
+
+	// Proposer, the contents proposed by block number and the ones waiting
+	// for a free instance. The proposals are dropped when another block is
+	// decided in their instance.
+	gossiper  *gossip.Gossiper
+	proposals map[int]blk.BlockContent
+	queue     []blk.BlockContent
+	// multiPaxos replaces the TLC instances when the blocks are agreed with a stable leader
+	multiPaxos *MultiPaxos
+	// storage persists the committed blocks and the acceptor state, if not nil
+	storage *Storage
+
+	// Catch-up, the height of the last sync request and when it was sent,
+	// and the participants holding each snapshot further ahead than the tail
+	requestedHeight int
+	lastRequest     time.Time
+	snapshots       map[string]*snapshotHolders
 
 	blockFactory blk.BlockFactory
 }
 
-func NewBlockchain(numParticipant int, nodeIndex int, paxosRetry int, blockFactory blk.BlockFactory) *BlockChain {
-	blocks := make(map[string]*blk.BlockContainer)
+// NewBlockchain creates a blockchain whose blocks are agreed by a Paxos
+// instance each. With a storage, the blocks and the state of the acceptor it
+// holds are restored and the new ones are persisted, the blockchain closing
+// the storage when stopped. A nil storage keeps everything in memory.
+func NewBlockchain(numParticipant int, nodeIndex int, paxosRetry int, blockFactory blk.BlockFactory, storage *Storage) *BlockChain {
+	b := &BlockChain{
+		numParticipant: numParticipant,
+		nodeIndex:      nodeIndex,
+		paxosRetry:     paxosRetry,
+
+		tail:         nil,
+		blocks:       make(map[string]*blk.BlockContainer),
+		tlcs:         make(map[int]*TLC),
+		decided:      make(map[int]*blk.BlockContainer),
+		proposals:    make(map[int]blk.BlockContent),
+		storage:      storage,
+		blockFactory: blockFactory,
+
+		requestedHeight: -1,
+		snapshots:       make(map[string]*snapshotHolders),
+	}
 
+	if storage != nil {
+		for _, block := range storage.blocks {
+			b.blocks[hex.EncodeToString(block.Hash())] = block
+			b.chain = append(b.chain, block)
+			b.tail = block
+		}
+		if b.tail != nil {
+			b.base = b.chain[0].BlockNumber()
+			log.Printf("Restored blocks %d to %d", b.base, b.tail.BlockNumber())
+		}
+	}
+	for number := b.height(); number < b.height()+window; number++ {
+		b.tlcs[number] = b.newTLC(number)
+		if storage != nil {
+			b.tlcs[number].paxos.restore(storage.instances[number])
+		}
+	}
+	return b
+}
+
+// NewMultiPaxosBlockchain creates a blockchain whose blocks are agreed by
+// Multi-Paxos, the leader skipping the prepare phase for consecutive blocks
+func NewMultiPaxosBlockchain(numParticipant int, nodeIndex int, paxosRetry int, blockFactory blk.BlockFactory) *BlockChain {
 	return &BlockChain{
 		numParticipant: numParticipant,
 		nodeIndex:      nodeIndex,
 		paxosRetry:     paxosRetry,
 
-		tlc:          NewTLC(numParticipant, nodeIndex, paxosRetry, 0, blockFactory),
+		multiPaxos:   NewMultiPaxos(numParticipant, nodeIndex, paxosRetry, blockFactory),
 		tail:         nil,
-		blocks:       blocks,
+		blocks:       make(map[string]*blk.BlockContainer),
 		blockFactory: blockFactory,
 	}
 }
 
+// Propose proposes a block with the given content. With Paxos, the content
+// is proposed in the first instance of the window without a proposal of the
+// node, unless the node already proposes it.
 func (b *BlockChain) Propose(g *gossip.Gossiper, blockContent blk.BlockContent) {
-	if b.tail == nil {
+	if err := blockContent.Validate(); err != nil {
+		log.Errorf("Reject proposal: %v", err)
+		return
+	}
+	if b.multiPaxos != nil {
+		b.multiPaxos.propose(g, blockContent)
+		return
+	}
+	log.Printf("Block type of propose : %s", blockContent.BlockType())
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.gossiper = g
+	if b.proposing(blockContent) {
+		return
+	}
+	b.queue = append(b.queue, blockContent)
+	b.schedule()
+}
+
+// proposing returns whether the content is already proposed or queued
+func (b *BlockChain) proposing(blockContent blk.BlockContent) bool {
+	hash := blockContent.Hash()
+	for _, content := range b.proposals {
+		if bytes.Equal(content.Hash(), hash) {
+			return true
+		}
+	}
+	for _, content := range b.queue {
+		if bytes.Equal(content.Hash(), hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// schedule proposes the queued contents in the free instances of the window
+func (b *BlockChain) schedule() {
+	for number := b.height(); number < b.height()+window && len(b.queue) > 0; number++ {
+		if _, found := b.proposals[number]; found {
+			continue
+		}
+		content := b.queue[0]
+		b.queue = b.queue[1:]
+		b.proposals[number] = content
+		b.tlcs[number].propose(b.gossiper, b.newBlockAt(number, content))
+	}
+}
+
+// newTLC returns the TLC of the given block number, persisting its state in
+// the storage of the blockchain
+func (b *BlockChain) newTLC(blockNumber int) *TLC {
+	tlc := NewTLC(b.numParticipant, b.nodeIndex, b.paxosRetry, blockNumber, b.blockFactory)
+	tlc.paxos.storage = b.storage
+	return tlc
+}
+
+// newBlock returns the block following the tail with the given content
+func (b *BlockChain) newBlock(blockContent blk.BlockContent) *blk.BlockContainer {
+	return b.newBlockAt(b.height(), blockContent)
+}
+
+// newBlockAt returns the block of the given number with the given content,
+// without previous hash if the previous block is not known yet
+func (b *BlockChain) newBlockAt(number int, blockContent blk.BlockContent) *blk.BlockContainer {
+	if number == 0 {
 		// First block
-		log.Printf("Block type of propose : %s", blockContent.BlockType())
-		b.tlc.propose(g, b.blockFactory.NewGenesisBlock(blockContent.BlockType(), 0, blockContent))
-	} else {
-		b.tlc.propose(g, b.blockFactory.NewBlock(blockContent.BlockType(), b.tail.BlockNumber()+1, b.tail.Hash(), blockContent))
+		return b.blockFactory.NewGenesisBlock(blockContent.BlockType(), 0, blockContent)
+	}
+	var previousHash []byte
+	if number == b.height() {
+		previousHash = b.tail.Hash()
 	}
+	return b.blockFactory.NewBlock(blockContent.BlockType(), number, previousHash, blockContent)
+}
+
+// link returns the block linked to the previous one if it was proposed
+// without previous hash
+func (b *BlockChain) link(block *blk.BlockContainer, previous *blk.BlockContainer) *blk.BlockContainer {
+	if len(block.PreviousHash()) > 0 || previous == nil {
+		return block
+	}
+	return b.blockFactory.NewBlock(block.Type, block.BlockNumber(), previous.Hash(), block.GetContent())
 }
 
 // GetBlocks returns all the blocks added so far. Key should be hexadecimal
 // representation of the block's hash. The first return is the hexadecimal
 // hash of the last block.
 func (b *BlockChain) GetBlocks() (string, map[string]*blk.BlockContainer) {
-	if b.tail == nil {
-		return hex.EncodeToString(make([]byte, 32)), b.blocks
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	blocks := make(map[string]*blk.BlockContainer, len(b.blocks))
+	for hash, block := range b.blocks {
+		blocks[hash] = block
 	}
-	return hex.EncodeToString(b.tail.Hash()), b.blocks
+	return hex.EncodeToString(b.tailHash()), blocks
 }
 
 func (b *BlockChain) HandleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
-	block := b.tlc.handleExtraMessage(g, msg)
-	if block != nil {
+	if b.multiPaxos != nil {
+		block := b.multiPaxos.handleExtraMessage(g, msg)
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if block == nil || !b.accept(block) {
+			return nil
+		}
 		b.blocks[hex.EncodeToString(block.Hash())] = block
+		b.chain = append(b.chain, block)
 		b.tail = block
-		b.tlc.stop()
-		b.tlc = NewTLC(b.numParticipant, b.nodeIndex, b.paxosRetry, b.tail.BlockNumber()+1, b.blockFactory)
+		if block.Type == blk.BlockSnapshotStr {
+			b.prune()
+		}
+		return block
 	}
+
+	b.mutex.Lock()
+	block, out := b.handle(msg)
+	b.mutex.Unlock()
+	send(g, out)
 	return block
 }
+
+// handle returns the next block added to the chain, if any, and the messages
+// to send. The blocks are added when committed by the TLC or learned from
+// another node, several blocks learned at once being returned one per call.
+func (b *BlockChain) handle(msg *extramessage.ExtraMessage) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	// A node joining or restarting asks for the blocks it missed
+	var out []*extramessage.ExtraMessage
+	if b.requestedHeight < 0 {
+		out = b.request()
+	}
+
+	if msg.PaxosSyncRequest != nil {
+		out = append(out, b.uponSyncRequest(msg.PaxosSyncRequest)...)
+	} else if msg.PaxosSyncReply != nil {
+		out = append(out, b.uponSyncReply(msg.PaxosSyncReply)...)
+	} else {
+		number := instanceOf(msg)
+		if number >= b.height()+window {
+			// The node missed the commit of the next block
+			out = append(out, b.request()...)
+		}
+		if msg.PaxosTLC != nil && msg.PaxosTLC.Value.Validate() == nil {
+			b.detectFork(msg.PaxosTLC.Value, msg.PaxosTLC.Node)
+			b.confirmSnapshot(msg.PaxosTLC.Value, msg.PaxosTLC.Node)
+		}
+		if tlc, found := b.tlcs[number]; found {
+			block, tlcOut := tlc.handle(msg)
+			out = append(out, tlcOut...)
+			if block != nil {
+				b.decided[number] = block
+				b.addDecided()
+			}
+		}
+	}
+
+	if len(b.applied) == 0 {
+		return nil, out
+	}
+	block := b.applied[0]
+	b.applied = b.applied[1:]
+	return block, out
+}
+
+// accept returns whether the block is well formed and follows the tail,
+// logging why it is rejected otherwise
+func (b *BlockChain) accept(block *blk.BlockContainer) bool {
+	err := block.Validate()
+	if err == nil {
+		err = block.Follows(b.tail)
+	}
+	if err != nil {
+		log.Errorf("Reject block: %v", err)
+		return false
+	}
+	return true
+}
+
+// detectFork logs a block announced by the node for a number already in the
+// chain with another hash
+func (b *BlockChain) detectFork(block *blk.BlockContainer, node int) {
+	number := block.BlockNumber()
+	if number < b.base || number >= b.height() {
+		return
+	}
+	var previous *blk.BlockContainer
+	if number > b.base {
+		previous = b.chain[number-b.base-1]
+	}
+	if !bytes.Equal(b.chain[number-b.base].Hash(), b.link(block, previous).Hash()) {
+		log.Errorf("Fork detected: node %d has another block %d", node, number)
+	}
+}
+
+// addDecided adds the decided blocks following the tail, then proposes the
+// queued contents in the instances freed
+func (b *BlockChain) addDecided() {
+	for {
+		decided, found := b.decided[b.height()]
+		if !found {
+			break
+		}
+		block := b.link(decided, b.tail)
+		if !b.accept(block) {
+			// The block is learned again from the other nodes
+			delete(b.decided, b.height())
+			break
+		}
+		b.add(block)
+	}
+	if b.gossiper != nil {
+		b.schedule()
+	}
+}
+
+// Verify walks the chain from its first block, the genesis block or the last
+// snapshot, checking that each block is well formed and linked to the previous
+// one
+func (b *BlockChain) Verify() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var previous *blk.BlockContainer
+	for _, block := range b.chain {
+		if err := block.Validate(); err != nil {
+			return err
+		}
+		if previous == nil && !block.StartsChain() {
+			return fmt.Errorf("the chain starts with block %d", block.BlockNumber())
+		}
+		if previous == nil && block.BlockNumber() != b.base {
+			return fmt.Errorf("block %d found instead of block %d", block.BlockNumber(), b.base)
+		}
+		if previous != nil {
+			if err := block.Follows(previous); err != nil {
+				return err
+			}
+		}
+		if b.blocks[hex.EncodeToString(block.Hash())] != block {
+			return fmt.Errorf("block %d is not stored under its hash", block.BlockNumber())
+		}
+		previous = block
+	}
+	if previous != b.tail || len(b.blocks) != len(b.chain) {
+		return fmt.Errorf("the tail or the stored blocks do not match the chain")
+	}
+	return nil
+}
+
+// add appends the block to the chain and slides the window, dropping the
+// proposal of the node for this block
+func (b *BlockChain) add(block *blk.BlockContainer) {
+	number := block.BlockNumber()
+	err := b.storage.append(record{Type: recordBlock, PaxosSeqID: number, Value: block})
+	if err != nil {
+		log.Errorf("Failed to persist block %d: %v", number, err)
+	}
+	b.blocks[hex.EncodeToString(block.Hash())] = block
+	b.chain = append(b.chain, block)
+	b.applied = append(b.applied, block)
+	b.tail = block
+
+	b.tlcs[number].stop()
+	delete(b.tlcs, number)
+	delete(b.decided, number)
+	delete(b.proposals, number)
+	b.tlcs[number+window] = b.newTLC(number + window)
+
+	if block.Type == blk.BlockSnapshotStr {
+		b.prune()
+	}
+}
+
+// prune drops the blocks preceding the snapshot at the tail, which becomes
+// the first block of the chain, and compacts the storage
+func (b *BlockChain) prune() {
+	for _, block := range b.chain[:len(b.chain)-1] {
+		delete(b.blocks, hex.EncodeToString(block.Hash()))
+	}
+	b.chain = []*blk.BlockContainer{b.tail}
+	b.base = b.tail.BlockNumber()
+	for hash, holders := range b.snapshots {
+		if holders.number <= b.base {
+			delete(b.snapshots, hash)
+		}
+	}
+
+	err := b.storage.compact(b.tail)
+	if err != nil {
+		log.Errorf("Failed to compact storage: %v", err)
+	}
+	log.Printf("Pruned the blocks before snapshot %d", b.base)
+}
+
+// jump adds a snapshot further ahead than the tail, the blocks in between
+// being already pruned by the other nodes. The instances and the proposals
+// up to the snapshot are dropped.
+func (b *BlockChain) jump(snapshot *blk.BlockContainer) {
+	number := snapshot.BlockNumber()
+	for n, tlc := range b.tlcs {
+		if n <= number {
+			tlc.stop()
+			delete(b.tlcs, n)
+		}
+	}
+	for n := range b.decided {
+		if n <= number {
+			delete(b.decided, n)
+		}
+	}
+	for n := range b.proposals {
+		if n <= number {
+			delete(b.proposals, n)
+		}
+	}
+
+	b.blocks[hex.EncodeToString(snapshot.Hash())] = snapshot
+	b.chain = append(b.chain, snapshot)
+	b.applied = append(b.applied, snapshot)
+	b.tail = snapshot
+	b.prune()
+
+	for n := number + 1; n < number+1+window; n++ {
+		if _, found := b.tlcs[n]; !found {
+			b.tlcs[n] = b.newTLC(n)
+		}
+	}
+}
+
+// current returns the instance of the block following the tail
+func (b *BlockChain) current() *TLC {
+	return b.tlcs[b.height()]
+}
+
+// Stop aborts the pending proposals and, with Multi-Paxos, the heartbeats,
+// and closes the storage
+func (b *BlockChain) Stop() {
+	if b.multiPaxos != nil {
+		b.multiPaxos.stop()
+	} else {
+		b.mutex.Lock()
+		for _, tlc := range b.tlcs {
+			tlc.stop()
+		}
+		b.mutex.Unlock()
+	}
+	err := b.storage.Close()
+	if err != nil {
+		log.Errorf("Failed to close storage: %v", err)
+	}
+}
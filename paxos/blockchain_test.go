@@ -0,0 +1,145 @@
+package paxos
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestBlockChain_verify(t *testing.T) {
+	numParticipant := 3
+	chains := make([]*BlockChain, numParticipant)
+	for i := range chains {
+		chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), nil)
+	}
+	for number := 0; number < 3; number++ {
+		commitBlock(t, chains, number)
+	}
+	for _, chain := range chains {
+		require.NoError(t, chain.Verify())
+		require.NoError(t, blk.Verify(chain.GetBlocks()))
+	}
+
+	// A block altered after being added breaks the link with the next one
+	altered := chains[0].chain[1].Copy()
+	altered.GetContent().(*blk.MappingBlockContent).PatternID = "altered"
+	chains[0].chain[1] = altered
+	require.Error(t, chains[0].Verify())
+
+	// So does a missing block
+	require.Error(t, blk.Verify(hex.EncodeToString(chains[1].tailHash()), map[string]*blk.BlockContainer{
+		hex.EncodeToString(chains[1].chain[2].Hash()): chains[1].chain[2],
+		hex.EncodeToString(chains[1].chain[0].Hash()): chains[1].chain[0],
+	}))
+}
+
+func TestBlockChain_rejectInvalidBlocks(t *testing.T) {
+	factory := blk.NewGenericBlockFactory()
+	content := &blk.MappingBlockContent{
+		PatternID: "pattern",
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 2, Z: 3}},
+	}
+	invalid := map[string]*blk.BlockContainer{
+		"not linked":     factory.NewBlock(blk.BlockMappingStr, 0, []byte("previous"), content),
+		"wrong number":   factory.NewGenesisBlock(blk.BlockMappingStr, 1, content),
+		"no target":      factory.NewGenesisBlock(blk.BlockMappingStr, 0, &blk.MappingBlockContent{PatternID: "pattern"}),
+		"invalid target": factory.NewGenesisBlock(blk.BlockMappingStr, 0, &blk.MappingBlockContent{PatternID: "pattern", Targets: []r3.Vec{r3.Vec{X: math.NaN()}}}),
+		"wrong type":     &blk.BlockContainer{Type: blk.BlockPathStr, Block: factory.NewGenesisBlock(blk.BlockMappingStr, 0, content).Block},
+	}
+
+	for name, block := range invalid {
+		chain := NewBlockchain(3, 0, 1, factory, nil)
+		// The block is decided by a majority of the participants
+		for node := 1; node < 3; node++ {
+			committed, _ := chain.handle(&extramessage.ExtraMessage{
+				PaxosTLC: &extramessage.PaxosTLC{Value: block, Node: node},
+			})
+			require.Nil(t, committed, name)
+		}
+		require.Equal(t, 0, chain.height(), name)
+		require.NoError(t, chain.Verify(), name)
+	}
+}
+
+func TestBlockChain_rejectInvalidProposals(t *testing.T) {
+	factory := blk.NewGenericBlockFactory()
+	invalid := &blk.MappingBlockContent{PatternID: "pattern"}
+	valid := &blk.MappingBlockContent{
+		PatternID: "pattern",
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 2, Z: 3}},
+	}
+
+	// The acceptors refuse an invalid value, which is never decided
+	accepts := func(chain *BlockChain, block *blk.BlockContainer) bool {
+		_, out := chain.handle(&extramessage.ExtraMessage{
+			PaxosPropose: &extramessage.PaxosPropose{PaxosSeqID: 0, ID: 1, Value: block},
+		})
+		for _, msg := range out {
+			if msg.PaxosAccept != nil {
+				return true
+			}
+		}
+		return false
+	}
+	chain := NewBlockchain(3, 0, 1, factory, nil)
+	require.False(t, accepts(chain, factory.NewGenesisBlock(blk.BlockMappingStr, 0, invalid)))
+	require.False(t, accepts(chain, factory.NewGenesisBlock(blk.BlockMappingStr, 1, valid)))
+	require.True(t, accepts(chain, factory.NewGenesisBlock(blk.BlockMappingStr, 0, valid)))
+
+	// Neither is an invalid content proposed
+	chain.Propose(nil, invalid)
+	require.Empty(t, chain.queue)
+	require.Empty(t, chain.proposals)
+}
+
+func TestBlockChain_rejectUnknownAcceptors(t *testing.T) {
+	factory := blk.NewGenericBlockFactory()
+	block := factory.NewGenesisBlock(blk.BlockMappingStr, 0, &blk.MappingBlockContent{
+		PatternID: "pattern",
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 2, Z: 3}},
+	})
+	decided := func(out []*extramessage.ExtraMessage) bool {
+		for _, msg := range out {
+			if msg.PaxosTLC != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The node accepts the value, the acceptances of nodes which are not
+	// participants are not counted towards the quorum
+	chain := NewBlockchain(3, 0, 1, factory, nil)
+	_, out := chain.handle(&extramessage.ExtraMessage{
+		PaxosPropose: &extramessage.PaxosPropose{PaxosSeqID: 0, ID: 1, Value: block},
+	})
+	require.False(t, decided(out))
+	for _, acceptor := range []int{-1, 3, 42} {
+		_, out = chain.handle(&extramessage.ExtraMessage{
+			PaxosAccept: &extramessage.PaxosAccept{PaxosSeqID: 0, ID: 1, Value: block, Acceptor: acceptor},
+		})
+		require.False(t, decided(out), acceptor)
+	}
+	_, out = chain.handle(&extramessage.ExtraMessage{
+		PaxosAccept: &extramessage.PaxosAccept{PaxosSeqID: 0, ID: 1, Value: block, Acceptor: 1},
+	})
+	require.True(t, decided(out))
+
+	// Nor are their promises to a Multi-Paxos candidate or their votes
+	m := NewMultiPaxos(3, 1, 1, factory)
+	m.ballot = 4
+	m.candidate = true
+	m.promises = map[int]*extramessage.MultiPaxosPromise{1: m.promise(0)}
+	for _, acceptor := range []int{-1, 3} {
+		m.uponPromise(&extramessage.MultiPaxosPromise{Ballot: 4, Acceptor: acceptor})
+		m.vote(&extramessage.MultiPaxosAccept{Ballot: 4, Slot: 0, Acceptor: acceptor, Value: block})
+	}
+	m.vote(&extramessage.MultiPaxosAccept{Ballot: 4, Slot: 0, Acceptor: 1, Value: block})
+	require.True(t, m.candidate)
+	require.Nil(t, m.decided[0])
+}
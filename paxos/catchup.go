@@ -0,0 +1,164 @@
+package paxos
+
+import (
+	"bytes"
+	"encoding/hex"
+	"time"
+
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// maxSyncBlocks is the maximal number of blocks sent in a sync reply, the
+// lagging node asking for the next ones once they are added
+const maxSyncBlocks = 4
+
+// snapshotHolders are the participants holding a snapshot further ahead than
+// the tail
+type snapshotHolders struct {
+	number int
+	nodes  map[int]bool
+}
+
+// height returns the number of the block following the tail
+func (b *BlockChain) height() int {
+	return b.base + len(b.chain)
+}
+
+func (b *BlockChain) tailHash() []byte {
+	if b.tail == nil {
+		return make([]byte, 32)
+	}
+	return b.tail.Hash()
+}
+
+// instanceOf returns the number of the block the message is about, -1 if none
+func instanceOf(msg *extramessage.ExtraMessage) int {
+	if msg.PaxosPrepare != nil {
+		return msg.PaxosPrepare.PaxosSeqID
+	} else if msg.PaxosPromise != nil {
+		return msg.PaxosPromise.PaxosSeqID
+	} else if msg.PaxosPropose != nil {
+		return msg.PaxosPropose.PaxosSeqID
+	} else if msg.PaxosAccept != nil {
+		return msg.PaxosAccept.PaxosSeqID
+	} else if msg.PaxosTLC != nil && msg.PaxosTLC.Value != nil && msg.PaxosTLC.Value.Block != nil {
+		return msg.PaxosTLC.Value.BlockNumber()
+	}
+	return -1
+}
+
+// request advertises the tail of the chain, asking the nodes further ahead for
+// the following blocks. The request of a height is only repeated after
+// paxosRetry seconds.
+func (b *BlockChain) request() []*extramessage.ExtraMessage {
+	retry := time.Duration(b.paxosRetry) * time.Second
+	if b.requestedHeight == b.height() && time.Since(b.lastRequest) < retry {
+		return nil
+	}
+	b.requestedHeight = b.height()
+	b.lastRequest = time.Now()
+
+	return []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			PaxosSyncRequest: &extramessage.PaxosSyncRequest{
+				Node:     b.nodeIndex,
+				Height:   b.height(),
+				TailHash: b.tailHash(),
+			},
+		},
+	}
+}
+
+// uponSyncRequest sends the blocks following the chain of the requester, or
+// asks for the blocks of a node further ahead
+func (b *BlockChain) uponSyncRequest(msg *extramessage.PaxosSyncRequest) []*extramessage.ExtraMessage {
+	if msg.Height > b.height() {
+		return b.request()
+	}
+	if msg.Height == b.height() || msg.Height < 0 {
+		return nil
+	}
+
+	// The blocks preceding the snapshot are pruned, the requester jumps to it
+	start := msg.Height
+	if start <= b.base {
+		start = b.base
+	} else if !bytes.Equal(b.chain[start-b.base-1].Hash(), msg.TailHash) {
+		log.Errorf("Node %d has another block %d", msg.Node, msg.Height-1)
+		return nil
+	}
+
+	end := start + maxSyncBlocks
+	if end > b.height() {
+		end = b.height()
+	}
+	return []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			PaxosSyncReply: &extramessage.PaxosSyncReply{
+				Node:   b.nodeIndex,
+				To:     msg.Node,
+				Blocks: append([]*blk.BlockContainer{}, b.chain[start-b.base:end-b.base]...),
+			},
+		},
+	}
+}
+
+// uponSyncReply fast-forwards the chain with the blocks which extend it, the
+// node asking for the next ones. The replies to other nodes are used as well.
+// As the blocks skipped cannot be checked, the node only jumps to a snapshot
+// further ahead once a majority of the participants sent or announced it.
+func (b *BlockChain) uponSyncReply(msg *extramessage.PaxosSyncReply) []*extramessage.ExtraMessage {
+	height := b.height()
+	for _, block := range msg.Blocks {
+		if err := block.Validate(); err != nil {
+			log.Errorf("Reject block from node %d: %v", msg.Node, err)
+			break
+		}
+		if block.BlockNumber() < b.height() {
+			b.detectFork(block, msg.Node)
+			continue
+		}
+		if block.BlockNumber() > b.height() && block.Type == blk.BlockSnapshotStr {
+			if !b.confirmSnapshot(block, msg.Node) {
+				break
+			}
+			b.jump(block)
+			continue
+		}
+		// A late reply to an older request may start further ahead
+		if block.BlockNumber() > b.height() || !b.accept(block) {
+			break
+		}
+		b.add(block)
+	}
+	if b.height() == height {
+		return nil
+	}
+	b.addDecided()
+
+	log.Printf("Caught up from block %d to block %d with node %d", height, b.height()-1, msg.Node)
+	return b.request()
+}
+
+// confirmSnapshot records that the participant holds the block if it is a
+// snapshot further ahead than the tail, and returns whether a majority of the
+// participants hold it
+func (b *BlockChain) confirmSnapshot(block *blk.BlockContainer, node int) bool {
+	if block.Type != blk.BlockSnapshotStr || block.BlockNumber() <= b.height() ||
+		node < 0 || node >= b.numParticipant || node == b.nodeIndex {
+		return false
+	}
+	hash := hex.EncodeToString(block.Hash())
+	holders, found := b.snapshots[hash]
+	if !found {
+		holders = &snapshotHolders{
+			number: block.BlockNumber(),
+			nodes:  make(map[int]bool),
+		}
+		b.snapshots[hash] = holders
+	}
+	holders.nodes[node] = true
+	return len(holders.nodes) >= b.numParticipant/2+1
+}
@@ -0,0 +1,110 @@
+package paxos
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// commitBlock makes the first node propose a block and delivers the messages
+// between the given nodes only
+func commitBlock(t *testing.T, chains []*BlockChain, number int) {
+	commitContent(t, chains, number, &blk.MappingBlockContent{
+		PatternID: fmt.Sprintf("pattern%d", number),
+		Targets:   []r3.Vec{r3.Vec{X: float64(number), Y: 1, Z: 2}},
+	})
+}
+
+// commitContent commits a block with the given content as commitBlock does
+func commitContent(t *testing.T, chains []*BlockChain, number int, content blk.BlockContent) {
+	block := chains[0].newBlock(content)
+	chains[0].current().paxos.setBlock(block)
+	deliver(chains, 0, chains[0].current().paxos.retry())
+	for _, chain := range chains {
+		require.Equal(t, number+1, chain.height())
+	}
+}
+
+func TestCatchUp_laggingNode(t *testing.T) {
+	numParticipant := 3
+	chains := make([]*BlockChain, numParticipant)
+	for i := range chains {
+		chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), nil)
+	}
+
+	// The last node misses more blocks than a reply holds
+	numBlocks := maxSyncBlocks + 2
+	for number := 0; number < numBlocks; number++ {
+		commitBlock(t, chains[:2], number)
+	}
+	require.Equal(t, 0, chains[2].height())
+
+	// A message of a later block reveals that the node lags behind
+	block, out := chains[2].handle(&extramessage.ExtraMessage{
+		PaxosPrepare: &extramessage.PaxosPrepare{PaxosSeqID: numBlocks, ID: 3},
+	})
+	require.Nil(t, block)
+	require.NotEmpty(t, out)
+	committed := deliver(chains, 2, out)
+
+	// The blocks learned at once are returned one per message
+	learned := committed[2]
+	for {
+		block, _ := chains[2].handle(&extramessage.ExtraMessage{})
+		if block == nil {
+			break
+		}
+		learned = append(learned, block)
+	}
+
+	tail, blocks := chains[0].GetBlocks()
+	lagTail, lagBlocks := chains[2].GetBlocks()
+	require.Equal(t, tail, lagTail)
+	require.Len(t, lagBlocks, len(blocks))
+	require.Len(t, learned, numBlocks)
+	for number, block := range learned {
+		require.Equal(t, number, block.BlockNumber())
+		require.Equal(t, chains[0].chain[number].Hash(), block.Hash())
+	}
+
+	// The node takes part in the next block
+	commitBlock(t, chains, numBlocks)
+}
+
+func TestCatchUp_invalidBlocks(t *testing.T) {
+	numParticipant := 3
+	chains := make([]*BlockChain, numParticipant)
+	for i := range chains {
+		chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), nil)
+	}
+	commitBlock(t, chains[:2], 0)
+
+	// A block which is not linked to the chain is rejected
+	factory := blk.NewGenericBlockFactory()
+	content := &blk.MappingBlockContent{
+		PatternID: "forged",
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 1, Z: 1}},
+	}
+	chains[2].handle(&extramessage.ExtraMessage{
+		PaxosSyncReply: &extramessage.PaxosSyncReply{
+			Node:   0,
+			To:     2,
+			Blocks: []*blk.BlockContainer{factory.NewBlock(blk.BlockMappingStr, 0, []byte("forged"), content)},
+		},
+	})
+	require.Equal(t, 0, chains[2].height())
+
+	// Neither is a block skipping a number
+	chains[2].handle(&extramessage.ExtraMessage{
+		PaxosSyncReply: &extramessage.PaxosSyncReply{
+			Node:   0,
+			To:     2,
+			Blocks: []*blk.BlockContainer{factory.NewBlock(blk.BlockMappingStr, 1, chains[0].tailHash(), content)},
+		},
+	})
+	require.Equal(t, 0, chains[2].height())
+}
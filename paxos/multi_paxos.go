@@ -0,0 +1,482 @@
+package paxos
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// heartbeatsPerLease is the number of heartbeats sent by the leader during a lease
+const heartbeatsPerLease = 3
+
+// MultiPaxos agrees on the blocks of the chain slot after slot with a stable
+// leader. Once elected with a prepare/promise round covering all the next
+// slots, the leader only runs phase 2 for each block and renews its lease
+// with heartbeats. A participant that does not hear from the leader for a
+// lease runs for election with a higher ballot. The leader announces the
+// decided blocks with a PaxosTLC message, from which the nodes that do not
+// take part in the consensus learn them.
+type MultiPaxos struct {
+	numParticipant int
+	nodeIndex      int
+	lease          time.Duration
+	blockFactory   blk.BlockFactory
+
+	mutex   sync.Mutex
+	started bool
+	chanEnd chan struct{}
+
+	// Leadership, the leader of a ballot is the node that generated it
+	idGenerator   UniqueIDGenerator
+	ballot        int
+	leader        int
+	isLeader      bool
+	candidate     bool
+	lastHeartbeat time.Time
+	promises      map[int]*extramessage.MultiPaxosPromise
+	recovered     map[int]*blk.BlockContainer
+
+	// Acceptor
+	accepted map[int]*extramessage.MultiPaxosAccept
+
+	// Learner
+	slot    int
+	tail    *blk.BlockContainer
+	votes   map[int]map[int]map[int]bool
+	decided map[int]*blk.BlockContainer
+
+	// Proposer
+	pending  blk.BlockContent
+	inFlight bool
+}
+
+// NewMultiPaxos creates a Multi-Paxos instance starting at the first slot,
+// node 0 being the initial leader. The lease of the leader lasts paxosRetry
+// seconds.
+func NewMultiPaxos(numParticipant int, nodeIndex int, paxosRetry int, blockFactory blk.BlockFactory) *MultiPaxos {
+	m := &MultiPaxos{
+		numParticipant: numParticipant,
+		nodeIndex:      nodeIndex,
+		lease:          time.Duration(paxosRetry) * time.Second,
+		blockFactory:   blockFactory,
+
+		chanEnd: make(chan struct{}),
+
+		idGenerator: newSeqGen(nodeIndex, numParticipant),
+		ballot:      0,
+		leader:      0,
+		isLeader:    nodeIndex == 0,
+		recovered:   make(map[int]*blk.BlockContainer),
+
+		accepted: make(map[int]*extramessage.MultiPaxosAccept),
+
+		votes:   make(map[int]map[int]map[int]bool),
+		decided: make(map[int]*blk.BlockContainer),
+	}
+	if m.isLeader {
+		// Ballot 0 is implicitly promised by every acceptor
+		m.idGenerator.GetNext()
+	}
+	return m
+}
+
+func (m *MultiPaxos) participant() bool {
+	return m.nodeIndex < m.numParticipant
+}
+
+// isAcceptor returns whether the index is the index of an acceptor
+func (m *MultiPaxos) isAcceptor(index int) bool {
+	return index >= 0 && index < m.numParticipant
+}
+
+func (m *MultiPaxos) quorum() int {
+	return m.numParticipant/2 + 1
+}
+
+func (m *MultiPaxos) leaderOf(ballot int) int {
+	return ballot % m.numParticipant
+}
+
+// start launches the heartbeats and the failure detector of a participant
+func (m *MultiPaxos) start(g *gossip.Gossiper) {
+	if m.started || !m.participant() {
+		return
+	}
+	m.started = true
+	m.lastHeartbeat = time.Now()
+	go m.run(g)
+}
+
+func (m *MultiPaxos) run(g *gossip.Gossiper) {
+	ticker := time.NewTicker(m.lease / heartbeatsPerLease)
+	defer ticker.Stop()
+	// Participants run for election one after the other
+	timeout := m.lease + m.lease*time.Duration(m.nodeIndex)/time.Duration(m.numParticipant)
+
+	for {
+		select {
+		case <-m.chanEnd:
+			return
+		case <-ticker.C:
+		}
+
+		var out []*extramessage.ExtraMessage
+		m.mutex.Lock()
+		if m.isLeader {
+			out = append(out, m.heartbeat())
+		} else if time.Since(m.lastHeartbeat) > timeout {
+			out = append(out, m.runElection(g))
+		}
+		m.mutex.Unlock()
+		send(g, out)
+	}
+}
+
+func (m *MultiPaxos) stop() {
+	defer func() {
+		recover()
+	}()
+	close(m.chanEnd)
+}
+
+func (m *MultiPaxos) propose(g *gossip.Gossiper, blockContent blk.BlockContent) {
+	var out []*extramessage.ExtraMessage
+	m.mutex.Lock()
+	m.start(g)
+	if m.pending == nil {
+		m.pending = blockContent
+	}
+	if m.isLeader {
+		out = m.tryPropose()
+	} else if m.leader >= 0 {
+		out = append(out, m.forward())
+	}
+	m.mutex.Unlock()
+	send(g, out)
+}
+
+func (m *MultiPaxos) handleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+	var out []*extramessage.ExtraMessage
+	m.mutex.Lock()
+	m.start(g)
+
+	if msg.PaxosTLC != nil {
+		m.uponCommit(msg.PaxosTLC)
+	} else if msg.MultiPaxosAccept != nil {
+		m.vote(msg.MultiPaxosAccept)
+	} else if m.participant() {
+		if msg.MultiPaxosHeartbeat != nil {
+			out = m.uponHeartbeat(msg.MultiPaxosHeartbeat)
+		} else if msg.MultiPaxosPrepare != nil {
+			out = m.uponPrepare(msg.MultiPaxosPrepare)
+		} else if msg.MultiPaxosPromise != nil {
+			out = m.uponPromise(msg.MultiPaxosPromise)
+		} else if msg.MultiPaxosPropose != nil {
+			out = m.uponPropose(msg.MultiPaxosPropose)
+		} else if msg.MultiPaxosForward != nil {
+			out = m.uponForward(msg.MultiPaxosForward)
+		}
+	}
+
+	block, commitOut := m.commit(g)
+	out = append(out, commitOut...)
+	m.mutex.Unlock()
+
+	send(g, out)
+	return block
+}
+
+// --- Leadership ---
+
+func (m *MultiPaxos) heartbeat() *extramessage.ExtraMessage {
+	return &extramessage.ExtraMessage{
+		MultiPaxosHeartbeat: &extramessage.MultiPaxosHeartbeat{
+			Ballot: m.ballot,
+			Leader: m.nodeIndex,
+			Slot:   m.slot,
+		},
+	}
+}
+
+func (m *MultiPaxos) runElection(g *gossip.Gossiper) *extramessage.ExtraMessage {
+	ballot := m.idGenerator.GetNext()
+	for ballot <= m.ballot {
+		ballot = m.idGenerator.GetNext()
+	}
+	log.Printf("%s Run for election with ballot %d", g.GetIdentifier(), ballot)
+
+	m.ballot = ballot
+	m.leader = -1
+	m.candidate = true
+	m.inFlight = false
+	m.lastHeartbeat = time.Now()
+	m.promises = map[int]*extramessage.MultiPaxosPromise{
+		m.nodeIndex: m.promise(m.slot),
+	}
+
+	return &extramessage.ExtraMessage{
+		MultiPaxosPrepare: &extramessage.MultiPaxosPrepare{
+			Ballot:    ballot,
+			Slot:      m.slot,
+			Candidate: m.nodeIndex,
+		},
+	}
+}
+
+// promise returns the promise of the acceptor for the current ballot, with
+// the values it accepted from the given slot onward
+func (m *MultiPaxos) promise(slot int) *extramessage.MultiPaxosPromise {
+	accepted := make([]*extramessage.MultiPaxosAccept, 0)
+	for s, a := range m.accepted {
+		if s >= slot {
+			accepted = append(accepted, a)
+		}
+	}
+	return &extramessage.MultiPaxosPromise{
+		Ballot:   m.ballot,
+		Slot:     slot,
+		Acceptor: m.nodeIndex,
+		Accepted: accepted,
+	}
+}
+
+func (m *MultiPaxos) uponHeartbeat(msg *extramessage.MultiPaxosHeartbeat) []*extramessage.ExtraMessage {
+	if msg.Ballot < m.ballot {
+		return nil
+	}
+	newLeader := m.leader != msg.Leader
+	m.follow(msg.Ballot, msg.Leader)
+
+	if newLeader && m.pending != nil {
+		// The proposal may have been sent to the previous leader
+		return []*extramessage.ExtraMessage{m.forward()}
+	}
+	return nil
+}
+
+func (m *MultiPaxos) uponPrepare(msg *extramessage.MultiPaxosPrepare) []*extramessage.ExtraMessage {
+	// A candidate missing committed slots could not learn their values
+	if msg.Ballot <= m.ballot || msg.Slot < m.slot {
+		return nil
+	}
+	// The leader is known once elected
+	m.follow(msg.Ballot, -1)
+
+	return []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			MultiPaxosPromise: m.promise(msg.Slot),
+		},
+	}
+}
+
+func (m *MultiPaxos) uponPromise(msg *extramessage.MultiPaxosPromise) []*extramessage.ExtraMessage {
+	if !m.candidate || msg.Ballot != m.ballot || !m.isAcceptor(msg.Acceptor) {
+		return nil
+	}
+	m.promises[msg.Acceptor] = msg
+	if len(m.promises) < m.quorum() {
+		return nil
+	}
+
+	// Elected, the values possibly chosen by previous leaders must be proposed again
+	m.candidate = false
+	m.isLeader = true
+	m.leader = m.nodeIndex
+	m.recovered = make(map[int]*blk.BlockContainer)
+	highest := make(map[int]int)
+	for _, promise := range m.promises {
+		for _, a := range promise.Accepted {
+			if ballot, found := highest[a.Slot]; a.Slot >= m.slot && (!found || a.Ballot > ballot) {
+				highest[a.Slot] = a.Ballot
+				m.recovered[a.Slot] = a.Value
+			}
+		}
+	}
+	m.promises = nil
+
+	return append([]*extramessage.ExtraMessage{m.heartbeat()}, m.tryPropose()...)
+}
+
+// follow adopts the ballot of another node, the leader being -1 if unknown
+func (m *MultiPaxos) follow(ballot int, leader int) {
+	if ballot > m.ballot {
+		m.isLeader = false
+		m.candidate = false
+		m.inFlight = false
+	}
+	m.ballot = ballot
+	m.leader = leader
+	m.lastHeartbeat = time.Now()
+}
+
+// --- Proposal ---
+
+// newBlock returns the block of the next slot with the given content
+func (m *MultiPaxos) newBlock(content blk.BlockContent) *blk.BlockContainer {
+	if m.tail == nil {
+		return m.blockFactory.NewGenesisBlock(content.BlockType(), m.slot, content)
+	}
+	return m.blockFactory.NewBlock(content.BlockType(), m.slot, m.tail.Hash(), content)
+}
+
+func (m *MultiPaxos) forward() *extramessage.ExtraMessage {
+	return &extramessage.ExtraMessage{
+		MultiPaxosForward: &extramessage.MultiPaxosForward{
+			Value: m.newBlock(m.pending),
+		},
+	}
+}
+
+func (m *MultiPaxos) uponForward(msg *extramessage.MultiPaxosForward) []*extramessage.ExtraMessage {
+	if !m.isLeader {
+		return nil
+	}
+	if err := msg.Value.Validate(); err != nil {
+		log.Errorf("Reject forwarded proposal: %v", err)
+		return nil
+	}
+	if m.pending == nil {
+		m.pending = msg.Value.GetContent()
+	}
+	return m.tryPropose()
+}
+
+// tryPropose starts phase 2 for the current slot if the leader is not already
+// waiting for it. A value recovered from the election has priority.
+func (m *MultiPaxos) tryPropose() []*extramessage.ExtraMessage {
+	if !m.isLeader || m.inFlight {
+		return nil
+	}
+
+	value, found := m.recovered[m.slot]
+	if !found {
+		if m.pending == nil {
+			return nil
+		}
+		value = m.newBlock(m.pending)
+	}
+	m.inFlight = true
+
+	accept := &extramessage.MultiPaxosAccept{
+		Ballot:   m.ballot,
+		Slot:     m.slot,
+		Acceptor: m.nodeIndex,
+		Value:    value,
+	}
+	m.accepted[m.slot] = accept
+	m.vote(accept)
+
+	return []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			MultiPaxosPropose: &extramessage.MultiPaxosPropose{
+				Ballot: m.ballot,
+				Slot:   m.slot,
+				Value:  value,
+			},
+		},
+	}
+}
+
+func (m *MultiPaxos) uponPropose(msg *extramessage.MultiPaxosPropose) []*extramessage.ExtraMessage {
+	if msg.Ballot < m.ballot || msg.Slot < m.slot {
+		return nil
+	}
+	if err := validateValue(msg.Value, msg.Slot); err != nil {
+		log.Errorf("Reject proposal of ballot %d: %v", msg.Ballot, err)
+		return nil
+	}
+	m.follow(msg.Ballot, m.leaderOf(msg.Ballot))
+
+	accept := &extramessage.MultiPaxosAccept{
+		Ballot:   msg.Ballot,
+		Slot:     msg.Slot,
+		Acceptor: m.nodeIndex,
+		Value:    msg.Value,
+	}
+	m.accepted[msg.Slot] = accept
+	m.vote(accept)
+
+	return []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			MultiPaxosAccept: accept,
+		},
+	}
+}
+
+// --- Learner ---
+
+// vote counts the acceptance of a value, the value is decided once a majority
+// of the acceptors accepted it with the same ballot
+func (m *MultiPaxos) vote(msg *extramessage.MultiPaxosAccept) {
+	if msg.Slot < m.slot || m.decided[msg.Slot] != nil || !m.isAcceptor(msg.Acceptor) {
+		return
+	}
+	ballots, found := m.votes[msg.Slot]
+	if !found {
+		ballots = make(map[int]map[int]bool)
+		m.votes[msg.Slot] = ballots
+	}
+	acceptors, found := ballots[msg.Ballot]
+	if !found {
+		acceptors = make(map[int]bool)
+		ballots[msg.Ballot] = acceptors
+	}
+	acceptors[msg.Acceptor] = true
+
+	if len(acceptors) >= m.quorum() {
+		m.decided[msg.Slot] = msg.Value
+	}
+}
+
+func (m *MultiPaxos) uponCommit(msg *extramessage.PaxosTLC) {
+	slot := msg.Value.BlockNumber()
+	if slot >= m.slot && m.decided[slot] == nil {
+		m.decided[slot] = msg.Value
+	}
+}
+
+// commit returns the block of the current slot if it is decided and moves to
+// the next slot. The leader announces it and proposes the next block.
+func (m *MultiPaxos) commit(g *gossip.Gossiper) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	block, found := m.decided[m.slot]
+	if !found {
+		return nil, nil
+	}
+
+	delete(m.decided, m.slot)
+	delete(m.votes, m.slot)
+	delete(m.accepted, m.slot)
+	delete(m.recovered, m.slot)
+	m.tail = block
+	m.slot++
+
+	// As with a new Paxos instance, the proposals of the slot are dropped
+	m.pending = nil
+	m.inFlight = false
+
+	if !m.isLeader {
+		return block, nil
+	}
+	log.Printf("%s Block %d committed by the leader", g.GetIdentifier(), block.BlockNumber())
+	out := []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			PaxosTLC: &extramessage.PaxosTLC{
+				Value: block,
+				Node:  m.nodeIndex,
+			},
+		},
+	}
+	return block, append(out, m.tryPropose()...)
+}
+
+// send broadcasts the messages, it must be called without holding the mutex
+// as the gossiper may be blocked on the messages being handled
+func send(g *gossip.Gossiper, out []*extramessage.ExtraMessage) {
+	for _, msg := range out {
+		g.AddExtraMessage(msg)
+	}
+}
@@ -1,6 +1,8 @@
 package paxos
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
 	"go.dedis.ch/cs438/orbitalswarm/extramessage"
@@ -16,7 +18,11 @@ const (
 	stateConsensus    = 4
 )
 
-// Paxos data structure
+// Paxos data structure. Every node is a proposer, an acceptor and a learner.
+// The messages of a node are handled locally by its own acceptor and learner,
+// as the gossiper does not deliver them back. A majority always means
+// numParticipant/2+1 distinct nodes, the messages carrying the identity of
+// their sender so that duplicates are not counted twice.
 type Paxos struct {
 	// base config
 	paxosSequenceID int
@@ -24,26 +30,33 @@ type Paxos struct {
 	numParticipant  int
 	paxosRetry      int
 
+	mutex sync.Mutex
+
 	// Round Tracking
 	idGenerator UniqueIDGenerator
 	ID          int
 
 	// Proposal
-	proposedID   int
-	state        int
-	count        int
-	value        *blk.BlockContainer
-	chanMajority chan bool
-
-	// chain BlockChain
+	block      *blk.BlockContainer
+	proposedID int
+	state      int
+	promises   map[int]bool
+	// value is the accepted value with the highest ID among the promises, if any
+	value   *blk.BlockContainer
+	valueID int
+
+	// Acceptor
 	blockFactory        blk.BlockFactory
 	latestPrepareID     int
 	latestAcceptedID    int
-	acceptedCount       int
 	latestAcceptedValue *blk.BlockContainer
 
-	learnerCount int
-	learnerData  map[int]int
+	// Learner, ID -> acceptors
+	learnerData map[int]map[int]bool
+	decided     bool
+
+	// storage persists the rounds and the acceptor state, if not nil
+	storage *Storage
 
 	// stop
 	chanEnd chan bool
@@ -62,92 +75,85 @@ func NewPaxos(paxosSequenceID int, numParticipant int, nodeIndex int, paxosRetry
 		idGenerator: seqGen,
 		ID:          nodeIndex,
 
-		proposedID:   -1,
-		state:        stateNoProposal,
-		count:        0,
-		value:        nil,
-		chanMajority: make(chan bool),
+		proposedID: -1,
+		state:      stateNoProposal,
+		valueID:    -1,
 
 		blockFactory:        blockFactory,
 		latestPrepareID:     -1,
 		latestAcceptedID:    -1,
 		latestAcceptedValue: blockFactory.NewEmptyBlock(),
 
-		learnerCount: 0,
-		learnerData:  make(map[int]int),
+		learnerData: make(map[int]map[int]bool),
 
 		chanEnd: make(chan bool),
 	}
 }
 
-func (p *Paxos) propose(g *gossip.Gossiper, block *blk.BlockContainer) {
-	go func() {
-		// log.Printf("%s Call Propose value %s", g.identifier, block.Filename)
-		if p.value == nil {
-			p.value = block
+// restore resumes the instance from the records of its storage, so that the
+// acceptor keeps its promises and the proposer does not reuse an ID
+func (p *Paxos) restore(records []record) {
+	highestID := -1
+	for _, r := range records {
+		if r.ID > highestID {
+			highestID = r.ID
 		}
-		for {
-			id := p.idGenerator.GetNext()
-			p.proposedID = id
-			p.state = stateAwaitPromise
+		switch r.Type {
+		case recordPromise:
+			if r.ID > p.latestPrepareID {
+				p.latestPrepareID = r.ID
+			}
+		case recordAccept:
+			if r.ID > p.latestPrepareID {
+				p.latestPrepareID = r.ID
+			}
+			p.latestAcceptedID = r.ID
+			p.latestAcceptedValue = r.Value
+		}
+	}
 
-			// log.Printf("%s Propose value %s", g.identifier, p.value.Filename)
+	next := p.idGenerator.GetNext()
+	for next <= highestID {
+		next = p.idGenerator.GetNext()
+	}
+	p.idGenerator = newSeqGen(next, p.numParticipant)
+}
 
-			// Phase 1
-			g.AddExtraMessage(&extramessage.ExtraMessage{
-				PaxosPrepare: &extramessage.PaxosPrepare{
-					PaxosSeqID: p.paxosSequenceID,
-					ID:         id,
-				},
-			})
+func (p *Paxos) quorum() int {
+	return p.numParticipant/2 + 1
+}
 
-			// log.Printf("%s Proposed value %s", g.identifier, p.value.Filename)
+// isAcceptor returns whether the index is the index of an acceptor
+func (p *Paxos) isAcceptor(index int) bool {
+	return index >= 0 && index < p.numParticipant
+}
 
-			// Create timer
-			timer := time.NewTimer(time.Duration(p.paxosRetry) * time.Second)
+// propose runs rounds for the block until a value is decided or the instance
+// is stopped, a new round starting every paxosRetry seconds
+func (p *Paxos) propose(g *gossip.Gossiper, block *blk.BlockContainer) {
+	p.setBlock(block)
 
+	go func() {
+		for {
+			send(g, p.retry())
+
+			timer := time.NewTimer(time.Duration(p.paxosRetry) * time.Second)
 			select {
 			case <-timer.C:
-				// log.Printf("%s timeout phase 1 - %d s", g.identifier, p.paxosRetry)
-				continue
-			case <-p.chanMajority:
-				// log.Printf("%s majority phase 1", g.identifier)
-				timer.Stop()
-				// Move to next step
 			case <-p.chanEnd:
-				// log.Printf("%s end", g.identifier)
 				timer.Stop()
 				return
 			}
-
-			// Phase 2
-			log.Printf("Enter phase 2")
-			g.AddExtraMessage(&extramessage.ExtraMessage{
-				PaxosPropose: &extramessage.PaxosPropose{
-					PaxosSeqID: p.paxosSequenceID,
-					ID:         id,
-					Value:      p.value,
-				},
-			})
-
-			// Create timer
-			timer = time.NewTimer(time.Duration(p.paxosRetry) * time.Second)
-
-			select {
-			case <-timer.C:
-				// log.Printf("%s timeout phase 2 - %d s", g.identifier, p.paxosRetry)
-				continue
-			case <-p.chanMajority:
-			case <-p.chanEnd:
-			}
-
-			// log.Printf("%s consensus", g.identifier)
-			timer.Stop()
-			return
 		}
 	}()
 }
 
+func (p *Paxos) setBlock(block *blk.BlockContainer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.block = block
+}
+
 func (p *Paxos) stop() {
 	defer func() {
 		recover()
@@ -155,132 +161,190 @@ func (p *Paxos) stop() {
 	close(p.chanEnd)
 }
 
-func (p *Paxos) handle(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
-	// log.Printf("MANAGE handle")
+func (p *Paxos) handle(msg *extramessage.ExtraMessage) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
-	// Upon promise
 	if msg.PaxosPrepare != nil {
-		//log.Printf("Handle prepare %d vs %d ID %d", msg.PaxosPrepare.PaxosSeqID, msg.PaxosPrepare.PaxosSeqID, msg.PaxosPrepare.ID)
-		p.uponPaxosPrepare(g, msg.PaxosPrepare)
+		return nil, p.uponPaxosPrepare(msg.PaxosPrepare)
 	} else if msg.PaxosPromise != nil {
-		//log.Printf("Handle promise %d vs %d ID %d", msg.PaxosPromise.PaxosSeqID, msg.PaxosPromise.PaxosSeqID, msg.PaxosPromise.IDp)
-		p.uponPaxosPromise(g, msg.PaxosPromise)
+		return p.uponPaxosPromise(msg.PaxosPromise)
 	} else if msg.PaxosPropose != nil {
-		//log.Printf("Handle propose %d vs %d ID %d", msg.PaxosPropose.PaxosSeqID, msg.PaxosPropose.PaxosSeqID, msg.PaxosPropose.ID)
-		p.uponPaxosPropose(g, msg.PaxosPropose)
+		return p.uponPaxosPropose(msg.PaxosPropose)
 	} else if msg.PaxosAccept != nil {
-		//log.Printf("Handle accept %d vs %d ID %d", msg.PaxosAccept.PaxosSeqID, msg.PaxosAccept.PaxosSeqID, msg.PaxosAccept.ID)
-		return p.uponPaxosAccept(g, msg.PaxosAccept)
+		return p.uponPaxosAccept(msg.PaxosAccept), nil
 	}
-	return nil
+	return nil, nil
 }
 
 // --- Phase 1 ---
 
-func (p *Paxos) uponPaxosPrepare(g *gossip.Gossiper, msg *extramessage.PaxosPrepare) {
-	if msg.PaxosSeqID != p.paxosSequenceID {
-		// log.Printf("Discarded prepare %d vs %d", msg.PaxosSeqID, p.paxosSequenceID)
-		return // Discard
+// retry starts a new round for the proposed block
+func (p *Paxos) retry() []*extramessage.ExtraMessage {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.startRound()
+}
+
+// startRound sends a prepare with a new ID, the mutex must be held
+func (p *Paxos) startRound() []*extramessage.ExtraMessage {
+	if p.block == nil || p.state == stateConsensus {
+		return nil
 	}
 
-	// log.Printf("MANAGE prepare %d vs %d ID %d", msg.PaxosSeqID, p.paxosSequenceID, p.ID)
-	if p.latestPrepareID < msg.ID && p.latestAcceptedID == -1 {
-		// send response back to the sender
-		// log.Printf("Accept prepare %d vs %d", msg.PaxosSeqID, p.paxosSequenceID)
-		p.latestPrepareID = msg.ID
-		if p.proposedID != msg.ID {
-			g.AddExtraMessage(&extramessage.ExtraMessage{
-				PaxosPromise: &extramessage.PaxosPromise{
-					PaxosSeqID: p.paxosSequenceID,
-					IDp:        msg.ID,
-					IDa:        -1,
-					Value:      p.blockFactory.NewEmptyBlock(),
-				},
-			})
-		}
-	} else if p.proposedID != msg.ID {
-		// Send previously accepted value
-		g.AddExtraMessage(&extramessage.ExtraMessage{
+	id := p.idGenerator.GetNext()
+	if err := p.storage.append(record{Type: recordRound, PaxosSeqID: p.paxosSequenceID, ID: id}); err != nil {
+		log.Errorf("Failed to persist round %d: %v", id, err)
+		return nil
+	}
+	p.proposedID = id
+	p.state = stateAwaitPromise
+	p.promises = make(map[int]bool)
+	p.value = nil
+	p.valueID = -1
+
+	prepare := &extramessage.PaxosPrepare{
+		PaxosSeqID: p.paxosSequenceID,
+		ID:         p.proposedID,
+	}
+	out := []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			PaxosPrepare: prepare,
+		},
+	}
+
+	// Our own acceptor answers as well
+	for _, promise := range p.uponPaxosPrepare(prepare) {
+		_, next := p.uponPaxosPromise(promise.PaxosPromise)
+		out = append(out, next...)
+	}
+	return out
+}
+
+func (p *Paxos) uponPaxosPrepare(msg *extramessage.PaxosPrepare) []*extramessage.ExtraMessage {
+	if msg.PaxosSeqID != p.paxosSequenceID || msg.ID <= p.latestPrepareID {
+		return nil // Discard
+	}
+
+	// Promise not to accept lower IDs, with the previously accepted value if any
+	if err := p.storage.append(record{Type: recordPromise, PaxosSeqID: p.paxosSequenceID, ID: msg.ID}); err != nil {
+		log.Errorf("Failed to persist promise %d: %v", msg.ID, err)
+		return nil
+	}
+	p.latestPrepareID = msg.ID
+	return []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
 			PaxosPromise: &extramessage.PaxosPromise{
 				PaxosSeqID: p.paxosSequenceID,
 				IDp:        msg.ID,
 				IDa:        p.latestAcceptedID,
 				Value:      p.latestAcceptedValue,
+				Acceptor:   p.nodeIndex,
 			},
-		})
+		},
 	}
 }
 
-func (p *Paxos) uponPaxosPromise(g *gossip.Gossiper, msg *extramessage.PaxosPromise) {
-	if msg.PaxosSeqID != p.paxosSequenceID {
-		// log.Printf("Discarded promise %d vs %d", msg.PaxosSeqID, p.paxosSequenceID)
-		return // Discard
+func (p *Paxos) uponPaxosPromise(msg *extramessage.PaxosPromise) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	if msg.PaxosSeqID != p.paxosSequenceID || msg.IDp != p.proposedID || p.state != stateAwaitPromise || !p.isAcceptor(msg.Acceptor) {
+		return nil, nil // Discard
 	}
 
-	if msg.IDp == p.proposedID && p.state == stateAwaitPromise {
-		p.count++
-		if !msg.Value.IsContentNil() {
-			p.value = msg.Value
-		}
+	p.promises[msg.Acceptor] = true
+	if msg.IDa > p.valueID && !msg.Value.IsContentNil() {
+		p.valueID = msg.IDa
+		p.value = msg.Value
+	}
+	if len(p.promises) < p.quorum() {
+		return nil, nil
+	}
 
-		if p.count > p.numParticipant/2+1 {
-			// next phase
-			p.count = 0
-			p.state = stateAwaitAccept
-			p.chanMajority <- true
-		}
+	// next phase, with the value accepted with the highest ID or our own block
+	p.state = stateAwaitAccept
+	value := p.value
+	if value == nil {
+		value = p.block
+	}
+	propose := &extramessage.PaxosPropose{
+		PaxosSeqID: p.paxosSequenceID,
+		ID:         p.proposedID,
+		Value:      value,
 	}
+	decided, out := p.uponPaxosPropose(propose)
+	return decided, append([]*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			PaxosPropose: propose,
+		},
+	}, out...)
 }
 
 // --- Phase 2 ---
 
-func (p *Paxos) uponPaxosPropose(g *gossip.Gossiper, msg *extramessage.PaxosPropose) {
-	if msg.PaxosSeqID != p.paxosSequenceID {
-		// log.Printf("Discarded propose %d vs %d", msg.PaxosSeqID, p.paxosSequenceID)
-		return // Discard
+func (p *Paxos) uponPaxosPropose(msg *extramessage.PaxosPropose) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	if msg.PaxosSeqID != p.paxosSequenceID || msg.ID < p.latestPrepareID {
+		return nil, nil // Discard
+	}
+	// An invalid value is never accepted, so that it cannot be decided
+	if err := validateValue(msg.Value, msg.PaxosSeqID); err != nil {
+		log.Errorf("Reject proposal %d: %v", msg.ID, err)
+		return nil, nil
 	}
 
-	// log.Printf("Propose %d vs %d - %d", msg.PaxosSeqID, p.paxosSequenceID, len(msg.Value.PreviousHash))
-	if msg.ID >= p.latestPrepareID {
-		p.latestAcceptedID = msg.ID
-		p.latestAcceptedValue = msg.Value
-
-		if p.proposedID != msg.ID {
-			// Send to all an accept response
-			g.AddExtraMessage(&extramessage.ExtraMessage{
-				PaxosAccept: &extramessage.PaxosAccept{
-					PaxosSeqID: msg.PaxosSeqID,
-					ID:         msg.ID,
-					Value:      msg.Value,
-				},
-			})
-		}
+	if err := p.storage.append(record{Type: recordAccept, PaxosSeqID: p.paxosSequenceID, ID: msg.ID, Value: msg.Value}); err != nil {
+		log.Errorf("Failed to persist accepted value %d: %v", msg.ID, err)
+		return nil, nil
+	}
+	p.latestPrepareID = msg.ID
+	p.latestAcceptedID = msg.ID
+	p.latestAcceptedValue = msg.Value
+
+	// Send to all an accept response, our own learner included
+	accept := &extramessage.PaxosAccept{
+		PaxosSeqID: msg.PaxosSeqID,
+		ID:         msg.ID,
+		Value:      msg.Value,
+		Acceptor:   p.nodeIndex,
+	}
+	return p.uponPaxosAccept(accept), []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			PaxosAccept: accept,
+		},
 	}
 }
 
-func (p *Paxos) uponPaxosAccept(g *gossip.Gossiper, msg *extramessage.PaxosAccept) *blk.BlockContainer {
-	if msg.PaxosSeqID != p.paxosSequenceID {
-		//log.Printf("Discarded accept %d vs %d", msg.PaxosSeqID, p.paxosSequenceID)
+// uponPaxosAccept returns the value the first time a majority of acceptors
+// accepted the same ID
+func (p *Paxos) uponPaxosAccept(msg *extramessage.PaxosAccept) *blk.BlockContainer {
+	if msg.PaxosSeqID != p.paxosSequenceID || p.decided || !p.isAcceptor(msg.Acceptor) {
 		return nil // Discard
 	}
 
-	count, ok := p.learnerData[msg.ID]
+	acceptors, ok := p.learnerData[msg.ID]
 	if !ok {
-		count = 1
-	} else {
-		count++
+		acceptors = make(map[int]bool)
+		p.learnerData[msg.ID] = acceptors
 	}
-	p.learnerData[msg.ID] = count
-
-	// > or >= ??
-	if count >= p.numParticipant/2+1 {
-		p.acceptedCount = 0
-		if msg.ID == p.proposedID && p.state == stateAwaitAccept {
-			p.state = stateConsensus
-			p.chanMajority <- true
-			close(p.chanEnd)
-		}
-		return msg.Value
+	acceptors[msg.Acceptor] = true
+
+	if len(acceptors) < p.quorum() {
+		return nil
+	}
+	p.decided = true
+	if p.block != nil {
+		p.state = stateConsensus
+		p.stop()
+	}
+	return msg.Value
+}
+
+// validateValue returns an error if the value proposed is not a well formed
+// block of the given number
+func validateValue(value *blk.BlockContainer, number int) error {
+	if err := value.Validate(); err != nil {
+		return err
+	}
+	if value.BlockNumber() != number {
+		return fmt.Errorf("block %d proposed for block %d", value.BlockNumber(), number)
 	}
 	return nil
 }
@@ -0,0 +1,124 @@
+package paxos
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	dropRate      = 0.2
+	duplicateRate = 0.1
+	retryRate     = 0.05
+)
+
+type delivery struct {
+	to  int
+	msg *extramessage.ExtraMessage
+}
+
+// simulation runs the nodes of a chain on a network which drops, duplicates
+// and reorders the messages, following a schedule drawn from a seeded source
+type simulation struct {
+	rand     *rand.Rand
+	chains   []*BlockChain
+	inFlight []delivery
+	// committed holds the hash of the first block committed for each number
+	committed map[int][]byte
+	commits   int
+}
+
+func newSimulation(seed int64, numParticipant int) *simulation {
+	sim := &simulation{
+		rand:      rand.New(rand.NewSource(seed)),
+		chains:    make([]*BlockChain, numParticipant),
+		committed: make(map[int][]byte),
+	}
+	for i := range sim.chains {
+		sim.chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), nil)
+	}
+	return sim
+}
+
+// send broadcasts the messages to the other nodes, each copy being dropped
+// independently
+func (sim *simulation) send(from int, out []*extramessage.ExtraMessage) {
+	for _, msg := range out {
+		for to := range sim.chains {
+			if to != from && sim.rand.Float64() >= dropRate {
+				sim.inFlight = append(sim.inFlight, delivery{to: to, msg: msg})
+			}
+		}
+	}
+}
+
+// propose makes the node propose its own block for a number of its window,
+// if it does not have a proposal yet, and starts a new round
+func (sim *simulation) propose(node int) {
+	chain := sim.chains[node]
+	tlc := chain.tlcs[chain.height()+sim.rand.Intn(window)]
+	if tlc.block == nil {
+		tlc.block = chain.newBlockAt(tlc.blockNumber, &blk.MappingBlockContent{
+			PatternID: fmt.Sprintf("node%d-block%d", node, tlc.blockNumber),
+			Targets:   []r3.Vec{r3.Vec{X: float64(node), Y: 10, Z: 0}},
+		})
+		tlc.paxos.setBlock(tlc.block)
+	}
+	sim.send(node, tlc.paxos.retry())
+}
+
+// step delivers a random message in flight, or lets a node retry
+func (sim *simulation) step(t *testing.T, seed int64) {
+	if len(sim.inFlight) == 0 || sim.rand.Float64() < retryRate {
+		sim.propose(sim.rand.Intn(len(sim.chains)))
+		return
+	}
+
+	i := sim.rand.Intn(len(sim.inFlight))
+	d := sim.inFlight[i]
+	if sim.rand.Float64() >= duplicateRate {
+		sim.inFlight[i] = sim.inFlight[len(sim.inFlight)-1]
+		sim.inFlight = sim.inFlight[:len(sim.inFlight)-1]
+	}
+
+	block, out := sim.chains[d.to].handle(d.msg)
+	sim.send(d.to, out)
+	if block == nil {
+		return
+	}
+
+	sim.commits++
+	if hash, ok := sim.committed[block.BlockNumber()]; ok {
+		require.True(t, bytes.Equal(hash, block.Hash()),
+			"seed %d: node%d committed another block %d", seed, d.to, block.BlockNumber())
+	} else {
+		sim.committed[block.BlockNumber()] = block.Hash()
+	}
+	// The node goes on with the next block
+	sim.propose(d.to)
+}
+
+func TestPaxos_safety(t *testing.T) {
+	schedules := 2000
+	steps := 400
+	if testing.Short() {
+		schedules = 200
+	}
+
+	commits := 0
+	for seed := int64(0); seed < int64(schedules); seed++ {
+		sim := newSimulation(seed, 3+int(seed%3))
+		for i := 0; i < steps; i++ {
+			sim.step(t, seed)
+		}
+		commits += sim.commits
+	}
+	require.NotZero(t, commits, "no schedule committed a block")
+	t.Logf("%d blocks committed over %d schedules", commits, schedules)
+}
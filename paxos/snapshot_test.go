@@ -0,0 +1,142 @@
+package paxos
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func newSnapshot() *blk.SnapshotBlockContent {
+	return &blk.SnapshotBlockContent{
+		PatternID: "pattern2",
+		Positions: []r3.Vec{r3.Vec{X: 2, Y: 1, Z: 2}},
+	}
+}
+
+func TestSnapshot_prune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	numParticipant := 3
+	chains := make([]*BlockChain, numParticipant)
+	for i := range chains {
+		chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), newTestStorage(t, dir, i))
+	}
+	for number := 0; number < 3; number++ {
+		commitBlock(t, chains, number)
+	}
+	commitContent(t, chains, 3, newSnapshot())
+	commitBlock(t, chains, 4)
+
+	// The chain starts with the snapshot and remains verifiable
+	for _, chain := range chains {
+		require.Equal(t, 3, chain.base)
+		require.Len(t, chain.chain, 2)
+		require.NoError(t, chain.Verify())
+		tail, blocks := chain.GetBlocks()
+		require.Len(t, blocks, 2)
+		require.NoError(t, blk.Verify(tail, blocks))
+	}
+
+	// A block altered after the snapshot is still detected
+	tail, blocks := chains[0].GetBlocks()
+	altered := chains[0].chain[0].Copy()
+	altered.GetContent().(*blk.SnapshotBlockContent).PatternID = "altered"
+	chains[0].chain[0] = altered
+	require.Error(t, chains[0].Verify())
+
+	for _, chain := range chains {
+		chain.Stop()
+	}
+
+	// The storage only holds the blocks from the snapshot
+	restarted := NewBlockchain(numParticipant, 1, 1, blk.NewGenericBlockFactory(), newTestStorage(t, dir, 1))
+	defer restarted.Stop()
+	restoredTail, restoredBlocks := restarted.GetBlocks()
+	require.Equal(t, tail, restoredTail)
+	require.Len(t, restoredBlocks, len(blocks))
+	require.Equal(t, 5, restarted.height())
+	require.NoError(t, restarted.Verify())
+}
+
+func TestSnapshot_jump(t *testing.T) {
+	numParticipant := 3
+	chains := make([]*BlockChain, numParticipant)
+	for i := range chains {
+		chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), nil)
+	}
+
+	// The last node misses blocks pruned by the others
+	for number := 0; number < 3; number++ {
+		commitBlock(t, chains[:2], number)
+	}
+	commitContent(t, chains[:2], 3, newSnapshot())
+	commitBlock(t, chains[:2], 4)
+	require.Equal(t, 0, chains[2].height())
+
+	_, out := chains[2].handle(&extramessage.ExtraMessage{
+		PaxosPrepare: &extramessage.PaxosPrepare{PaxosSeqID: 5, ID: 3},
+	})
+	committed := deliver(chains, 2, out)
+	for {
+		block, _ := chains[2].handle(&extramessage.ExtraMessage{})
+		if block == nil {
+			break
+		}
+		committed[2] = append(committed[2], block)
+	}
+
+	// The node jumps to the snapshot and learns the following blocks
+	require.Len(t, committed[2], 2)
+	require.Equal(t, blk.BlockSnapshotStr, committed[2][0].Type)
+	tail, blocks := chains[0].GetBlocks()
+	lagTail, lagBlocks := chains[2].GetBlocks()
+	require.Equal(t, tail, lagTail)
+	require.Len(t, lagBlocks, len(blocks))
+	require.NoError(t, chains[2].Verify())
+
+	// The node takes part in the next block
+	commitBlock(t, chains, 5)
+}
+
+func TestSnapshot_confirmedJump(t *testing.T) {
+	numParticipant := 3
+	chains := make([]*BlockChain, numParticipant)
+	for i := range chains {
+		chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), nil)
+	}
+	for number := 0; number < 3; number++ {
+		commitBlock(t, chains[:2], number)
+	}
+	commitContent(t, chains[:2], 3, newSnapshot())
+	commitBlock(t, chains[:2], 4)
+
+	reply := func(from int) *extramessage.ExtraMessage {
+		out := chains[from].uponSyncRequest(&extramessage.PaxosSyncRequest{
+			Node:     2,
+			Height:   0,
+			TailHash: make([]byte, 32),
+		})
+		require.Len(t, out, 1)
+		return out[0]
+	}
+
+	// A single node, even repeating its reply, does not make the node jump
+	for i := 0; i < 2; i++ {
+		chains[2].handle(reply(0))
+		require.Equal(t, 0, chains[2].height())
+	}
+
+	// The snapshot sent by a majority is trusted
+	chains[2].handle(reply(1))
+	require.Equal(t, 5, chains[2].height())
+	require.Equal(t, 3, chains[2].base)
+	require.Empty(t, chains[2].snapshots)
+	require.NoError(t, chains[2].Verify())
+}
@@ -0,0 +1,238 @@
+package paxos
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+)
+
+const (
+	// recordRound is the ID of a round started by the proposer
+	recordRound = "round"
+	// recordPromise is the ID promised by the acceptor
+	recordPromise = "promise"
+	// recordAccept is the ID and the value accepted by the acceptor
+	recordAccept = "accept"
+	// recordBlock is a committed block
+	recordBlock = "block"
+)
+
+// record is a line of the write-ahead log
+type record struct {
+	Type       string
+	PaxosSeqID int
+	ID         int
+	Value      *blk.BlockContainer `json:",omitempty"`
+}
+
+// Storage is an append-only write-ahead log holding the committed blocks and
+// the state of the acceptor, so that a node restarting with the same storage
+// keeps its history and its promises. Each record is a JSON line synced to
+// disk before the node acts on it.
+//
+// Once a snapshot is committed, the log is compacted: it is rewritten with
+// the snapshot as first block, followed by the records of the instances
+// still running.
+type Storage struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+
+	// blocks are the committed blocks read when the storage was opened
+	blocks []*blk.BlockContainer
+	// instances hold the records of the Paxos instances following the last
+	// block, by block number
+	instances map[int][]record
+}
+
+// OpenStorage opens the log at the given path, creating it if needed, and
+// reads the state it holds. A last record cut short by a crash is dropped.
+func OpenStorage(path string) (*Storage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %v", err)
+	}
+
+	s := &Storage{
+		path:      path,
+		file:      file,
+		instances: make(map[int][]record),
+	}
+	err = s.load()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to load storage %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// load replays the records, truncating the log after the last complete one
+func (s *Storage) load() error {
+	reader := bufio.NewReader(s.file)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// An incomplete line is a record whose write was interrupted
+			break
+		} else if err != nil {
+			return err
+		}
+
+		var r record
+		err = json.Unmarshal(line, &r)
+		if err != nil {
+			if _, err := reader.Peek(1); err == io.EOF {
+				break
+			}
+			return fmt.Errorf("record at offset %d: %v", offset, err)
+		}
+		err = s.replay(r)
+		if err != nil {
+			return fmt.Errorf("record at offset %d: %v", offset, err)
+		}
+		offset += int64(len(line))
+	}
+
+	err := s.file.Truncate(offset)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (s *Storage) replay(r record) error {
+	if r.Type != recordBlock {
+		if r.PaxosSeqID >= s.height() {
+			s.instances[r.PaxosSeqID] = append(s.instances[r.PaxosSeqID], r)
+		}
+		return nil
+	}
+
+	block := r.Value
+	if err := block.Validate(); err != nil {
+		return err
+	}
+	if len(s.blocks) == 0 {
+		if !block.StartsChain() {
+			return fmt.Errorf("the log starts with block %d", block.BlockNumber())
+		}
+	} else if err := block.Follows(s.blocks[len(s.blocks)-1]); err != nil {
+		return err
+	}
+	s.blocks = append(s.blocks, block)
+	delete(s.instances, block.BlockNumber())
+	return nil
+}
+
+// height returns the number of the block following the blocks read
+func (s *Storage) height() int {
+	if len(s.blocks) == 0 {
+		return 0
+	}
+	return s.blocks[len(s.blocks)-1].BlockNumber() + 1
+}
+
+// append writes the record and syncs it to disk. A nil storage keeps nothing.
+func (s *Storage) append(r record) error {
+	if s == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	// The records of the running instances are kept for the compaction
+	if r.Type == recordBlock {
+		delete(s.instances, r.PaxosSeqID)
+	} else {
+		s.instances[r.PaxosSeqID] = append(s.instances[r.PaxosSeqID], r)
+	}
+	return nil
+}
+
+// compact rewrites the log with the snapshot as first block, followed by the
+// records of the instances after it. The new log replaces the old one once
+// synced to disk, so that a crash leaves either of them. A nil storage keeps
+// nothing.
+func (s *Storage) compact(snapshot *blk.BlockContainer) error {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	number := snapshot.BlockNumber()
+	records := []record{record{Type: recordBlock, PaxosSeqID: number, Value: snapshot}}
+	instances := make([]int, 0, len(s.instances))
+	for instance := range s.instances {
+		if instance > number {
+			instances = append(instances, instance)
+		} else {
+			delete(s.instances, instance)
+		}
+	}
+	sort.Ints(instances)
+	for _, instance := range instances {
+		records = append(records, s.instances[instance]...)
+	}
+
+	file, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = os.Rename(s.path+".tmp", s.path)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file.Close()
+	s.file = file
+	s.blocks = []*blk.BlockContainer{snapshot}
+	return nil
+}
+
+// Close closes the log
+func (s *Storage) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,173 @@
+package paxos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func newTestStorage(t *testing.T, dir string, node int) *Storage {
+	storage, err := OpenStorage(filepath.Join(dir, fmt.Sprintf("node%d.wal", node)))
+	require.NoError(t, err)
+	return storage
+}
+
+// deliver broadcasts the messages until there is none left, returning the
+// blocks committed by each node
+func deliver(chains []*BlockChain, from int, out []*extramessage.ExtraMessage) map[int][]*blk.BlockContainer {
+	type delivery struct {
+		from int
+		msg  *extramessage.ExtraMessage
+	}
+	queue := make([]delivery, 0)
+	for _, msg := range out {
+		queue = append(queue, delivery{from: from, msg: msg})
+	}
+
+	committed := make(map[int][]*blk.BlockContainer)
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		for to, chain := range chains {
+			if to == d.from {
+				continue
+			}
+			block, next := chain.handle(d.msg)
+			if block != nil {
+				committed[to] = append(committed[to], block)
+			}
+			for _, msg := range next {
+				queue = append(queue, delivery{from: to, msg: msg})
+			}
+		}
+	}
+	return committed
+}
+
+func TestStorage_restoreBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	numParticipant := 3
+	chains := make([]*BlockChain, numParticipant)
+	for i := range chains {
+		chains[i] = NewBlockchain(numParticipant, i, 1, blk.NewGenericBlockFactory(), newTestStorage(t, dir, i))
+	}
+
+	for number := 0; number < 2; number++ {
+		block := chains[0].newBlock(&blk.MappingBlockContent{
+			PatternID: "pattern",
+			Targets:   []r3.Vec{r3.Vec{X: float64(number), Y: 1, Z: 2}},
+		})
+		chains[0].current().paxos.setBlock(block)
+		deliver(chains, 0, chains[0].current().paxos.retry())
+		for _, chain := range chains {
+			require.NotNil(t, chain.tail)
+			require.Equal(t, number, chain.tail.BlockNumber())
+		}
+	}
+	tail, blocks := chains[1].GetBlocks()
+	for _, chain := range chains {
+		chain.Stop()
+	}
+
+	// The restarted node resumes after the last block
+	restarted := NewBlockchain(numParticipant, 1, 1, blk.NewGenericBlockFactory(), newTestStorage(t, dir, 1))
+	defer restarted.Stop()
+	restoredTail, restoredBlocks := restarted.GetBlocks()
+	require.Equal(t, tail, restoredTail)
+	require.Len(t, restoredBlocks, len(blocks))
+	for hash, block := range blocks {
+		require.Equal(t, block.Hash(), restoredBlocks[hash].Hash())
+	}
+	require.Equal(t, 2, restarted.current().blockNumber)
+	require.Equal(t, 2, restarted.newBlock(&blk.MappingBlockContent{PatternID: "next"}).BlockNumber())
+}
+
+func TestStorage_restoreAcceptor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	numParticipant := 3
+	block := blk.NewGenericBlockFactory().NewGenesisBlock(blk.BlockMappingStr, 0, &blk.MappingBlockContent{
+		PatternID: "pattern",
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 2, Z: 3}},
+	})
+
+	chain := NewBlockchain(numParticipant, 1, 1, blk.NewGenericBlockFactory(), newTestStorage(t, dir, 1))
+	_, out := chain.handle(&extramessage.ExtraMessage{
+		PaxosPrepare: &extramessage.PaxosPrepare{PaxosSeqID: 0, ID: 6},
+	})
+	// The node asks for the blocks it missed as it starts
+	require.Len(t, out, 2)
+	_, out = chain.handle(&extramessage.ExtraMessage{
+		PaxosPropose: &extramessage.PaxosPropose{PaxosSeqID: 0, ID: 6, Value: block},
+	})
+	require.Len(t, out, 1)
+	_, out = chain.handle(&extramessage.ExtraMessage{
+		PaxosPrepare: &extramessage.PaxosPrepare{PaxosSeqID: 0, ID: 9},
+	})
+	require.Len(t, out, 1)
+	chain.Stop()
+
+	restarted := NewBlockchain(numParticipant, 1, 1, blk.NewGenericBlockFactory(), newTestStorage(t, dir, 1))
+	defer restarted.Stop()
+
+	// The proposer does not reuse an ID known before the restart
+	require.Greater(t, restarted.current().paxos.idGenerator.GetNext(), 9)
+
+	// The promise of ID 9 is kept
+	_, out = restarted.handle(&extramessage.ExtraMessage{
+		PaxosPrepare: &extramessage.PaxosPrepare{PaxosSeqID: 0, ID: 7},
+	})
+	require.Len(t, out, 1)
+	require.NotNil(t, out[0].PaxosSyncRequest)
+
+	// The accepted value is reported to the next proposer
+	_, out = restarted.handle(&extramessage.ExtraMessage{
+		PaxosPrepare: &extramessage.PaxosPrepare{PaxosSeqID: 0, ID: 12},
+	})
+	require.Len(t, out, 1)
+	promise := out[0].PaxosPromise
+	require.NotNil(t, promise)
+	require.Equal(t, 6, promise.IDa)
+	require.Equal(t, block.Hash(), promise.Value.Hash())
+}
+
+func TestStorage_truncatedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node.wal")
+	err = ioutil.WriteFile(path, []byte("{\"Type\":\"promise\",\"PaxosSeqID\":0,\"ID\":4}\n{\"Type\":\"prom"), 0644)
+	require.NoError(t, err)
+
+	storage, err := OpenStorage(path)
+	require.NoError(t, err)
+	require.Len(t, storage.instances[0], 1)
+	require.NoError(t, storage.append(record{Type: recordPromise, PaxosSeqID: 0, ID: 7}))
+	require.NoError(t, storage.Close())
+
+	// The interrupted record was dropped before appending
+	storage, err = OpenStorage(path)
+	require.NoError(t, err)
+	require.Len(t, storage.instances[0], 2)
+	require.Equal(t, 7, storage.instances[0][1].ID)
+	require.NoError(t, storage.Close())
+
+	// A corrupted record before the end is an error
+	err = ioutil.WriteFile(path, []byte("{\"Type\":\"prom\n{\"Type\":\"promise\",\"PaxosSeqID\":0,\"ID\":4}\n"), 0644)
+	require.NoError(t, err)
+	_, err = OpenStorage(path)
+	require.Error(t, err)
+}
@@ -10,19 +10,22 @@ import (
 type TLC struct {
 	paxos          *Paxos
 	numParticipant int
+	nodeIndex      int
 	blockNumber    int
 
-	tlcConfirmed int
-	block        *blk.BlockContainer
+	// confirmations holds the participants which announced the consensus
+	confirmations map[int]bool
+	block         *blk.BlockContainer
 }
 
 func NewTLC(numParticipant int, nodeIndex int, paxosRetry int, blockNumber int, blockFactory blk.BlockFactory) *TLC {
 	return &TLC{
 		paxos:          NewPaxos(blockNumber, numParticipant, nodeIndex, paxosRetry, blockFactory),
 		numParticipant: numParticipant,
+		nodeIndex:      nodeIndex,
 		blockNumber:    blockNumber,
 
-		tlcConfirmed: 0,
+		confirmations: make(map[int]bool),
 	}
 }
 
@@ -35,26 +38,38 @@ func (t *TLC) stop() {
 	t.paxos.stop()
 }
 
-func (t *TLC) handleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+// handle returns the block once a majority of the participants announced
+// that consensus was reached, and the messages to send
+func (t *TLC) handle(msg *extramessage.ExtraMessage) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
 	if msg.PaxosTLC != nil {
-		if msg.PaxosTLC.Value.BlockNumber() == t.blockNumber {
-			// log.Printf("%s Consensus call ! %d", g.GetIdentifier(), t.block.BlockNumber())
-			t.tlcConfirmed++
-			if t.tlcConfirmed >= t.numParticipant/2+1 {
-				log.Printf("%s Consensus of consensus !", g.GetIdentifier())
-				return msg.PaxosTLC.Value
-			}
-		}
-	} else {
-		block := t.paxos.handle(g, msg)
-
-		if block != nil {
-			g.AddExtraMessage(&extramessage.ExtraMessage{
-				PaxosTLC: &extramessage.PaxosTLC{
-					Value: block,
-				},
-			})
+		if msg.PaxosTLC.Value.BlockNumber() == t.blockNumber && t.paxos.isAcceptor(msg.PaxosTLC.Node) {
+			return t.confirm(msg.PaxosTLC.Node, msg.PaxosTLC.Value), nil
 		}
+		return nil, nil
+	}
+
+	decided, out := t.paxos.handle(msg)
+	if decided == nil {
+		return nil, out
+	}
+	out = append(out, &extramessage.ExtraMessage{
+		PaxosTLC: &extramessage.PaxosTLC{
+			Value: decided,
+			Node:  t.nodeIndex,
+		},
+	})
+	return t.confirm(t.nodeIndex, decided), out
+}
+
+func (t *TLC) confirm(node int, block *blk.BlockContainer) *blk.BlockContainer {
+	if len(t.confirmations) >= t.numParticipant/2+1 {
+		// Already returned
+		return nil
+	}
+	t.confirmations[node] = true
+	if len(t.confirmations) < t.numParticipant/2+1 {
+		return nil
 	}
-	return nil
+	log.Printf("Consensus of consensus on block %d", t.blockNumber)
+	return block
 }
@@ -0,0 +1,727 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// https://raft.github.io/raft.pdf
+
+const (
+	// heartbeatsPerTimeout is the number of heartbeats sent by the leader during an election timeout
+	heartbeatsPerTimeout = 3
+	// defaultSnapshotThreshold is the number of applied entries after which the log is compacted
+	defaultSnapshotThreshold = 32
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// Node replicates the blocks of the chain with Raft, the entry at index i
+// of the log being the block number i. The messages are carried by the
+// gossiper, every message being received by all the nodes. The leader
+// announces the applied blocks with a PaxosTLC message, from which the nodes
+// that do not take part in the consensus learn them. As with the other
+// consensus, a node proposes a single block at a time and the proposals not
+// chosen are dropped once a block is committed.
+//
+// The chain is pruned at each snapshot block, the log being compacted up to
+// it, so that a lagging follower is sent the blocks from the last snapshot
+// block only.
+type Node struct {
+	numParticipant    int
+	nodeIndex         int
+	timeout           time.Duration
+	snapshotThreshold int
+	blockFactory      blk.BlockFactory
+
+	mutex   sync.Mutex
+	started bool
+	chanEnd chan struct{}
+
+	// Log, the entries up to snapshotIndex are compacted in the chain
+	term          int
+	votedFor      int
+	log           []extramessage.RaftEntry
+	snapshotIndex int
+	snapshotTerm  int
+
+	role         role
+	leader       int
+	votes        map[int]bool
+	deadline     time.Time
+	commitIndex  int
+	nextIndex    map[int]int
+	matchIndex   map[int]int
+	lastResponse map[int]time.Time
+
+	// State machine, the applied blocks by number from base, the number of
+	// the first one
+	chain     []*blk.BlockContainer
+	base      int
+	blocks    map[string]*blk.BlockContainer
+	committed map[int]*blk.BlockContainer
+
+	pending blk.BlockContent
+}
+
+// NewNode creates a Raft node, node 0 being the leader of the first term.
+// The election timeout is between paxosRetry and twice paxosRetry seconds.
+func NewNode(numParticipant int, nodeIndex int, paxosRetry int, blockFactory blk.BlockFactory) *Node {
+	n := &Node{
+		numParticipant:    numParticipant,
+		nodeIndex:         nodeIndex,
+		timeout:           time.Duration(paxosRetry) * time.Second,
+		snapshotThreshold: defaultSnapshotThreshold,
+		blockFactory:      blockFactory,
+
+		chanEnd: make(chan struct{}),
+
+		// Every node voted for node 0 in the first term
+		term:          0,
+		votedFor:      0,
+		log:           make([]extramessage.RaftEntry, 0),
+		snapshotIndex: -1,
+		snapshotTerm:  -1,
+
+		role:        follower,
+		leader:      0,
+		commitIndex: -1,
+
+		chain:     make([]*blk.BlockContainer, 0),
+		blocks:    make(map[string]*blk.BlockContainer),
+		committed: make(map[int]*blk.BlockContainer),
+	}
+	if nodeIndex == 0 {
+		n.becomeLeader()
+	}
+	return n
+}
+
+func (n *Node) participant() bool {
+	return n.nodeIndex < n.numParticipant
+}
+
+func (n *Node) quorum() int {
+	return n.numParticipant/2 + 1
+}
+
+// height returns the number of the next block to apply
+func (n *Node) height() int {
+	return n.base + len(n.chain)
+}
+
+// start launches the heartbeats and the election timer of a participant
+func (n *Node) start(g *gossip.Gossiper) {
+	if n.started || !n.participant() {
+		return
+	}
+	n.started = true
+	n.resetDeadline()
+	go n.run(g)
+}
+
+func (n *Node) run(g *gossip.Gossiper) {
+	ticker := time.NewTicker(n.timeout / heartbeatsPerTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.chanEnd:
+			return
+		case <-ticker.C:
+		}
+
+		var out []*extramessage.ExtraMessage
+		n.mutex.Lock()
+		if n.role == leader {
+			out = n.appendEntries()
+		} else if time.Now().After(n.deadline) {
+			out = n.startElection(g)
+		}
+		n.mutex.Unlock()
+		send(g, out)
+	}
+}
+
+func (n *Node) resetDeadline() {
+	n.deadline = time.Now().Add(n.timeout + time.Duration(rand.Int63n(int64(n.timeout))))
+}
+
+// Stop stops the heartbeats and the election timer
+func (n *Node) Stop() {
+	defer func() {
+		recover()
+	}()
+	close(n.chanEnd)
+}
+
+// Propose asks the leader to append a block with the given content
+func (n *Node) Propose(g *gossip.Gossiper, blockContent blk.BlockContent) {
+	n.mutex.Lock()
+	n.start(g)
+	out := n.propose(blockContent)
+	n.mutex.Unlock()
+	send(g, out)
+}
+
+func (n *Node) propose(blockContent blk.BlockContent) []*extramessage.ExtraMessage {
+	if n.pending == nil {
+		n.pending = blockContent
+	}
+	if n.role == leader {
+		return n.tryAppend()
+	} else if n.leader >= 0 {
+		return []*extramessage.ExtraMessage{n.forward()}
+	}
+	return nil
+}
+
+// GetBlocks returns the applied blocks, the first return is the hexadecimal
+// hash of the last block
+func (n *Node) GetBlocks() (string, map[string]*blk.BlockContainer) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	blocks := make(map[string]*blk.BlockContainer, len(n.blocks))
+	for hash, block := range n.blocks {
+		blocks[hash] = block
+	}
+	if len(n.chain) == 0 {
+		return hex.EncodeToString(make([]byte, 32)), blocks
+	}
+	return hex.EncodeToString(n.chain[len(n.chain)-1].Hash()), blocks
+}
+
+// HandleExtraMessage handles a Raft message and returns the next applied block, if any
+func (n *Node) HandleExtraMessage(g *gossip.Gossiper, msg *extramessage.ExtraMessage) *blk.BlockContainer {
+	n.mutex.Lock()
+	n.start(g)
+	block, out := n.handle(g, msg)
+	n.mutex.Unlock()
+
+	send(g, out)
+	return block
+}
+
+// handle returns the next applied block, if any, and the messages to send
+func (n *Node) handle(g *gossip.Gossiper, msg *extramessage.ExtraMessage) (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	var out []*extramessage.ExtraMessage
+	if msg.PaxosTLC != nil {
+		n.uponCommit(msg.PaxosTLC)
+	} else if n.participant() {
+		if msg.RaftRequestVote != nil {
+			out = n.uponRequestVote(msg.RaftRequestVote)
+		} else if msg.RaftVote != nil {
+			out = n.uponVote(g, msg.RaftVote)
+		} else if msg.RaftAppendEntries != nil {
+			out = n.uponAppendEntries(msg.RaftAppendEntries)
+		} else if msg.RaftAppendResponse != nil {
+			n.uponAppendResponse(msg.RaftAppendResponse)
+		} else if msg.RaftInstallSnapshot != nil {
+			out = n.uponInstallSnapshot(g, msg.RaftInstallSnapshot)
+		} else if msg.RaftForward != nil {
+			out = n.uponForward(msg.RaftForward)
+		}
+	}
+
+	block, applyOut := n.apply()
+	return block, append(out, applyOut...)
+}
+
+// --- Log ---
+
+func (n *Node) lastIndex() int {
+	return n.snapshotIndex + len(n.log)
+}
+
+func (n *Node) entryAt(index int) extramessage.RaftEntry {
+	return n.log[index-n.snapshotIndex-1]
+}
+
+// termAt returns the term of the entry at the given index, which must not be
+// compacted except for the last one
+func (n *Node) termAt(index int) int {
+	if index == n.snapshotIndex {
+		return n.snapshotTerm
+	}
+	return n.entryAt(index).Term
+}
+
+// newBlock returns the block following the last entry with the given content
+func (n *Node) newBlock(content blk.BlockContent) *blk.BlockContainer {
+	index := n.lastIndex() + 1
+	if index == 0 {
+		return n.blockFactory.NewGenesisBlock(content.BlockType(), index, content)
+	}
+	var previous *blk.BlockContainer
+	if index-1 == n.snapshotIndex {
+		previous = n.chain[n.snapshotIndex-n.base]
+	} else {
+		previous = n.entryAt(index - 1).Value
+	}
+	return n.blockFactory.NewBlock(content.BlockType(), index, previous.Hash(), content)
+}
+
+// --- Election ---
+
+// startElection votes for the node in a new term, the node being elected at
+// once if its vote is a majority
+func (n *Node) startElection(g *gossip.Gossiper) []*extramessage.ExtraMessage {
+	n.term++
+	n.role = candidate
+	n.leader = -1
+	n.votedFor = n.nodeIndex
+	n.votes = map[int]bool{n.nodeIndex: true}
+	n.resetDeadline()
+	log.Printf("%s Run for election in term %d", g.GetIdentifier(), n.term)
+
+	out := []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			RaftRequestVote: &extramessage.RaftRequestVote{
+				Term:      n.term,
+				Candidate: n.nodeIndex,
+				LastIndex: n.lastIndex(),
+				LastTerm:  n.termAt(n.lastIndex()),
+			},
+		},
+	}
+	return append(out, n.countVotes(g)...)
+}
+
+// stepDown makes the node follow the given term, the leader being -1 if unknown
+func (n *Node) stepDown(term int, leader int) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = -1
+	}
+	n.role = follower
+	n.leader = leader
+}
+
+func (n *Node) uponRequestVote(msg *extramessage.RaftRequestVote) []*extramessage.ExtraMessage {
+	if msg.Term < n.term {
+		return nil
+	}
+	if msg.Term > n.term {
+		n.stepDown(msg.Term, -1)
+	}
+
+	lastTerm := n.termAt(n.lastIndex())
+	upToDate := msg.LastTerm > lastTerm || msg.LastTerm == lastTerm && msg.LastIndex >= n.lastIndex()
+	granted := (n.votedFor == -1 || n.votedFor == msg.Candidate) && upToDate
+	if granted {
+		n.votedFor = msg.Candidate
+		n.resetDeadline()
+	}
+
+	return []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			RaftVote: &extramessage.RaftVote{
+				Term:      n.term,
+				Voter:     n.nodeIndex,
+				Candidate: msg.Candidate,
+				Granted:   granted,
+			},
+		},
+	}
+}
+
+func (n *Node) uponVote(g *gossip.Gossiper, msg *extramessage.RaftVote) []*extramessage.ExtraMessage {
+	if msg.Term > n.term {
+		n.stepDown(msg.Term, -1)
+		return nil
+	}
+	if n.role != candidate || msg.Term != n.term || msg.Candidate != n.nodeIndex || !msg.Granted {
+		return nil
+	}
+
+	n.votes[msg.Voter] = true
+	return n.countVotes(g)
+}
+
+// countVotes makes the candidate the leader once a majority voted for it
+func (n *Node) countVotes(g *gossip.Gossiper) []*extramessage.ExtraMessage {
+	if len(n.votes) < n.quorum() {
+		return nil
+	}
+	log.Printf("%s Elected in term %d", g.GetIdentifier(), n.term)
+	n.becomeLeader()
+	return append(n.appendEntries(), n.tryAppend()...)
+}
+
+func (n *Node) becomeLeader() {
+	n.role = leader
+	n.leader = n.nodeIndex
+	n.nextIndex = make(map[int]int)
+	n.matchIndex = make(map[int]int)
+	n.lastResponse = make(map[int]time.Time)
+	for i := 0; i < n.numParticipant; i++ {
+		if i != n.nodeIndex {
+			n.nextIndex[i] = n.lastIndex() + 1
+			n.matchIndex[i] = -1
+			n.lastResponse[i] = time.Now()
+		}
+	}
+}
+
+// --- Replication ---
+
+func (n *Node) forward() *extramessage.ExtraMessage {
+	return &extramessage.ExtraMessage{
+		RaftForward: &extramessage.RaftForward{
+			Value: n.newBlock(n.pending),
+		},
+	}
+}
+
+func (n *Node) uponForward(msg *extramessage.RaftForward) []*extramessage.ExtraMessage {
+	if n.role != leader {
+		return nil
+	}
+	if n.pending == nil {
+		n.pending = msg.Value.GetContent()
+	}
+	return n.tryAppend()
+}
+
+// tryAppend appends the pending proposal to the log, unless an entry of the
+// current term is still waiting to be committed
+func (n *Node) tryAppend() []*extramessage.ExtraMessage {
+	if n.role != leader || n.pending == nil {
+		return nil
+	}
+	for i := n.commitIndex + 1; i <= n.lastIndex(); i++ {
+		if i > n.snapshotIndex && n.termAt(i) == n.term {
+			return nil
+		}
+	}
+
+	n.log = append(n.log, extramessage.RaftEntry{
+		Term:  n.term,
+		Value: n.newBlock(n.pending),
+	})
+	n.advanceCommit()
+	return n.appendEntries()
+}
+
+// appendEntries returns the entries to replicate to the followers which
+// answered recently, starting from the lowest next index among them. The
+// followers lagging behind the compacted log get a snapshot.
+func (n *Node) appendEntries() []*extramessage.ExtraMessage {
+	from := n.lastIndex() + 1
+	for f, next := range n.nextIndex {
+		if time.Since(n.lastResponse[f]) < 2*n.timeout && next < from {
+			from = next
+		}
+	}
+
+	out := make([]*extramessage.ExtraMessage, 0, 2)
+	if from <= n.snapshotIndex {
+		out = append(out, &extramessage.ExtraMessage{
+			RaftInstallSnapshot: &extramessage.RaftInstallSnapshot{
+				Term:      n.term,
+				Leader:    n.nodeIndex,
+				LastIndex: n.snapshotIndex,
+				LastTerm:  n.snapshotTerm,
+				Blocks:    append([]*blk.BlockContainer{}, n.chain[:n.snapshotIndex-n.base+1]...),
+			},
+		})
+		from = n.snapshotIndex + 1
+	}
+
+	prevTerm := -1
+	if from > 0 {
+		prevTerm = n.termAt(from - 1)
+	}
+	out = append(out, &extramessage.ExtraMessage{
+		RaftAppendEntries: &extramessage.RaftAppendEntries{
+			Term:      n.term,
+			Leader:    n.nodeIndex,
+			PrevIndex: from - 1,
+			PrevTerm:  prevTerm,
+			Entries:   append([]extramessage.RaftEntry{}, n.log[from-n.snapshotIndex-1:]...),
+			Commit:    n.commitIndex,
+		},
+	})
+	return out
+}
+
+// follow makes the node follow the leader of the message term, and forwards
+// the pending proposal to a new leader
+func (n *Node) follow(term int, leader int) []*extramessage.ExtraMessage {
+	newLeader := n.leader != leader
+	n.stepDown(term, leader)
+	n.resetDeadline()
+
+	if newLeader && n.pending != nil {
+		return []*extramessage.ExtraMessage{n.forward()}
+	}
+	return nil
+}
+
+func (n *Node) uponAppendEntries(msg *extramessage.RaftAppendEntries) []*extramessage.ExtraMessage {
+	if msg.Term < n.term {
+		return nil
+	}
+	out := n.follow(msg.Term, msg.Leader)
+
+	response := &extramessage.RaftAppendResponse{
+		Term:     n.term,
+		Follower: n.nodeIndex,
+	}
+	out = append(out, &extramessage.ExtraMessage{
+		RaftAppendResponse: response,
+	})
+
+	if msg.PrevIndex > n.lastIndex() {
+		response.MatchIndex = n.lastIndex()
+		return out
+	}
+	if msg.PrevIndex >= n.snapshotIndex && msg.PrevIndex >= 0 && n.termAt(msg.PrevIndex) != msg.PrevTerm {
+		response.MatchIndex = msg.PrevIndex - 1
+		return out
+	}
+
+	for i, entry := range msg.Entries {
+		index := msg.PrevIndex + 1 + i
+		if index <= n.snapshotIndex {
+			// Committed entries match
+			continue
+		}
+		if index <= n.lastIndex() {
+			if n.termAt(index) == entry.Term {
+				continue
+			}
+			n.log = n.log[:index-n.snapshotIndex-1]
+		}
+		n.log = append(n.log, entry)
+	}
+
+	response.Success = true
+	response.MatchIndex = msg.PrevIndex + len(msg.Entries)
+	if msg.Commit > n.commitIndex {
+		n.commitIndex = msg.Commit
+		if response.MatchIndex < n.commitIndex {
+			n.commitIndex = response.MatchIndex
+		}
+	}
+	return out
+}
+
+func (n *Node) uponAppendResponse(msg *extramessage.RaftAppendResponse) {
+	if msg.Term > n.term {
+		n.stepDown(msg.Term, -1)
+		return
+	}
+	if n.role != leader || msg.Term != n.term {
+		return
+	}
+
+	f := msg.Follower
+	n.lastResponse[f] = time.Now()
+	if !msg.Success {
+		next := n.nextIndex[f] - 1
+		if msg.MatchIndex+1 < next {
+			next = msg.MatchIndex + 1
+		}
+		if next < 0 {
+			next = 0
+		}
+		n.nextIndex[f] = next
+		return
+	}
+
+	if msg.MatchIndex > n.matchIndex[f] {
+		n.matchIndex[f] = msg.MatchIndex
+	}
+	n.nextIndex[f] = n.matchIndex[f] + 1
+	n.advanceCommit()
+}
+
+// advanceCommit commits the last entry of the current term stored by a
+// majority, the leader included
+func (n *Node) advanceCommit() {
+	// An entry of the current term is committed once stored by a majority,
+	// committing the previous ones with it
+	for index := n.lastIndex(); index > n.commitIndex && index > n.snapshotIndex; index-- {
+		if n.termAt(index) != n.term {
+			break
+		}
+		count := 1
+		for _, match := range n.matchIndex {
+			if match >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			break
+		}
+	}
+}
+
+// --- State machine ---
+
+func (n *Node) uponCommit(msg *extramessage.PaxosTLC) {
+	index := msg.Value.BlockNumber()
+	if !n.participant() {
+		if index >= n.height() {
+			n.committed[index] = msg.Value
+		}
+		return
+	}
+	// The hash of a block covers the whole chain before it
+	if index > n.commitIndex && index > n.snapshotIndex && index <= n.lastIndex() &&
+		bytes.Equal(n.entryAt(index).Value.Hash(), msg.Value.Hash()) {
+		n.commitIndex = index
+	}
+}
+
+// apply returns the next committed block, if any. The leader announces it
+// and appends the next proposal.
+func (n *Node) apply() (*blk.BlockContainer, []*extramessage.ExtraMessage) {
+	index := n.height()
+	var block *blk.BlockContainer
+	if !n.participant() {
+		block = n.committed[index]
+		delete(n.committed, index)
+	} else if index <= n.commitIndex {
+		block = n.entryAt(index).Value
+	}
+	if block == nil {
+		return nil, nil
+	}
+
+	n.chain = append(n.chain, block)
+	n.blocks[hex.EncodeToString(block.Hash())] = block
+	// As with a new Paxos instance, the proposals are dropped once a block is committed
+	n.pending = nil
+
+	// The log is compacted up to each snapshot block, so that the chain
+	// always holds the last compacted entry
+	snapshot := block.Type == blk.BlockSnapshotStr
+	if snapshot {
+		n.prune()
+	}
+	if n.participant() && (snapshot || index-n.snapshotIndex >= n.snapshotThreshold) {
+		n.compact(index)
+	}
+
+	if n.role != leader {
+		return block, nil
+	}
+	out := []*extramessage.ExtraMessage{
+		&extramessage.ExtraMessage{
+			PaxosTLC: &extramessage.PaxosTLC{
+				Value: block,
+				Node:  n.nodeIndex,
+			},
+		},
+	}
+	return block, append(out, n.tryAppend()...)
+}
+
+// compact drops the entries of the log up to the given applied index
+func (n *Node) compact(index int) {
+	n.snapshotTerm = n.termAt(index)
+	n.log = append([]extramessage.RaftEntry{}, n.log[index-n.snapshotIndex:]...)
+	n.snapshotIndex = index
+}
+
+// prune drops the applied blocks preceding the last one, a snapshot block
+func (n *Node) prune() {
+	for _, block := range n.chain[:len(n.chain)-1] {
+		delete(n.blocks, hex.EncodeToString(block.Hash()))
+	}
+	n.chain = n.chain[len(n.chain)-1:]
+	n.base = n.chain[0].BlockNumber()
+	log.Printf("Pruned the blocks before snapshot %d", n.base)
+}
+
+func (n *Node) uponInstallSnapshot(g *gossip.Gossiper, msg *extramessage.RaftInstallSnapshot) []*extramessage.ExtraMessage {
+	if msg.Term < n.term {
+		return nil
+	}
+	out := n.follow(msg.Term, msg.Leader)
+	if err := verifySnapshot(msg); err != nil {
+		log.Printf("%s Invalid snapshot: %v", g.GetIdentifier(), err)
+		return out
+	}
+	if msg.LastIndex >= n.height() {
+		log.Printf("%s Install snapshot up to block %d", g.GetIdentifier(), msg.LastIndex)
+
+		if msg.LastIndex <= n.lastIndex() && msg.LastIndex > n.snapshotIndex && n.termAt(msg.LastIndex) == msg.LastTerm {
+			// Keep the entries following the snapshot
+			n.log = append([]extramessage.RaftEntry{}, n.log[msg.LastIndex-n.snapshotIndex:]...)
+		} else {
+			n.log = make([]extramessage.RaftEntry, 0)
+		}
+		n.snapshotIndex = msg.LastIndex
+		n.snapshotTerm = msg.LastTerm
+
+		// The blocks of the snapshot are applied without being returned
+		n.chain = append([]*blk.BlockContainer{}, msg.Blocks...)
+		n.base = n.chain[0].BlockNumber()
+		n.blocks = make(map[string]*blk.BlockContainer, len(n.chain))
+		for _, block := range n.chain {
+			n.blocks[hex.EncodeToString(block.Hash())] = block
+		}
+		if n.commitIndex < msg.LastIndex {
+			n.commitIndex = msg.LastIndex
+		}
+	}
+
+	return append(out, &extramessage.ExtraMessage{
+		RaftAppendResponse: &extramessage.RaftAppendResponse{
+			Term:       n.term,
+			Follower:   n.nodeIndex,
+			Success:    true,
+			MatchIndex: msg.LastIndex,
+		},
+	})
+}
+
+// verifySnapshot checks that the blocks of the snapshot form a chain, which
+// starts with the genesis block or a snapshot block and ends with its last
+// entry
+func verifySnapshot(msg *extramessage.RaftInstallSnapshot) error {
+	if len(msg.Blocks) == 0 {
+		return fmt.Errorf("no block")
+	}
+	if !msg.Blocks[0].StartsChain() {
+		return fmt.Errorf("block %d does not start a chain", msg.Blocks[0].BlockNumber())
+	}
+	for i := 1; i < len(msg.Blocks); i++ {
+		if err := msg.Blocks[i].Follows(msg.Blocks[i-1]); err != nil {
+			return err
+		}
+	}
+	last := msg.Blocks[len(msg.Blocks)-1].BlockNumber()
+	if last != msg.LastIndex {
+		return fmt.Errorf("block %d ends the snapshot of entry %d", last, msg.LastIndex)
+	}
+	return nil
+}
+
+// send broadcasts the messages, it must be called without holding the mutex
+// as the gossiper may be blocked on the messages being handled
+func send(g *gossip.Gossiper, out []*extramessage.ExtraMessage) {
+	for _, msg := range out {
+		g.AddExtraMessage(msg)
+	}
+}
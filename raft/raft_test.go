@@ -0,0 +1,234 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cs438/orbitalswarm/extramessage"
+	"go.dedis.ch/cs438/orbitalswarm/gossip"
+	"go.dedis.ch/cs438/orbitalswarm/paxos/blk"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+type message struct {
+	from int
+	msg  *extramessage.ExtraMessage
+}
+
+// network delivers every message to all the other nodes which are up, in order
+type network struct {
+	gossipers []*gossip.Gossiper
+	nodes     []*Node
+	down      map[int]bool
+	queue     []message
+}
+
+func newNetwork(t *testing.T, numParticipant int, numNodes int, firstPort int) *network {
+	net := &network{
+		gossipers: make([]*gossip.Gossiper, numNodes),
+		nodes:     make([]*Node, numNodes),
+		down:      make(map[int]bool),
+	}
+	for i := range net.nodes {
+		// The gossipers are not run, they only name the nodes
+		g, err := gossip.GetFactory().New(fmt.Sprintf("127.0.0.1:%d", firstPort+i), fmt.Sprintf("node%d", i), 10, 0, numNodes)
+		require.NoError(t, err)
+		net.gossipers[i] = g
+		net.nodes[i] = NewNode(numParticipant, i, 1, blk.NewGenericBlockFactory())
+	}
+	return net
+}
+
+func (net *network) send(from int, out []*extramessage.ExtraMessage) {
+	for _, msg := range out {
+		net.queue = append(net.queue, message{from: from, msg: msg})
+	}
+}
+
+func (net *network) propose(from int, patternID string) {
+	net.send(from, net.nodes[from].propose(&blk.MappingBlockContent{
+		PatternID: patternID,
+		Targets:   []r3.Vec{r3.Vec{X: 1, Y: 10, Z: 0}},
+	}))
+}
+
+// run delivers the messages until there is none left
+func (net *network) run() {
+	for len(net.queue) > 0 {
+		m := net.queue[0]
+		net.queue = net.queue[1:]
+		for i, n := range net.nodes {
+			if i != m.from && !net.down[i] && !net.down[m.from] {
+				_, out := n.handle(net.gossipers[i], m.msg)
+				net.send(i, out)
+			}
+		}
+	}
+	// Several blocks may be committed by a single message
+	for i, n := range net.nodes {
+		for !net.down[i] {
+			block, out := n.handle(net.gossipers[i], &extramessage.ExtraMessage{})
+			net.send(i, out)
+			if block == nil {
+				break
+			}
+		}
+	}
+	if len(net.queue) > 0 {
+		net.run()
+	}
+}
+
+func (net *network) requireSameChain(t *testing.T, length int, nodes ...int) {
+	chain := net.nodes[nodes[0]].chain
+	require.Len(t, chain, length)
+	for i, block := range chain {
+		if i > 0 {
+			require.Equal(t, chain[i-1].Hash(), block.PreviousHash())
+		}
+	}
+	for _, i := range nodes {
+		require.Equal(t, chain, net.nodes[i].chain, "node%d", i)
+	}
+}
+
+func TestNode_replication(t *testing.T) {
+	net := newNetwork(t, 3, 4, 7000)
+
+	// Proposals of followers are forwarded to the leader
+	net.propose(1, "pattern0")
+	net.run()
+	net.propose(0, "pattern1")
+	net.propose(2, "pattern1")
+	net.run()
+
+	net.requireSameChain(t, 2, 0, 1, 2, 3)
+	require.Equal(t, "pattern0", net.nodes[3].chain[0].GetContent().(*blk.MappingBlockContent).PatternID)
+}
+
+func TestNode_leaderElection(t *testing.T) {
+	net := newNetwork(t, 5, 5, 7100)
+
+	net.propose(0, "pattern0")
+	net.run()
+
+	// The leader is down and node 2 times out
+	net.down[0] = true
+	net.send(2, net.nodes[2].startElection(net.gossipers[2]))
+	net.run()
+	require.Equal(t, leader, net.nodes[2].role)
+
+	net.propose(4, "pattern1")
+	net.run()
+	net.requireSameChain(t, 2, 1, 2, 3, 4)
+
+	// The former leader follows the new one once back
+	net.down[0] = false
+	net.send(2, net.nodes[2].appendEntries())
+	net.run()
+	require.Equal(t, follower, net.nodes[0].role)
+	net.requireSameChain(t, 2, 0, 1, 2, 3, 4)
+}
+
+func TestNode_outdatedCandidate(t *testing.T) {
+	net := newNetwork(t, 3, 3, 7200)
+
+	net.down[2] = true
+	net.propose(0, "pattern0")
+	net.run()
+	net.down[2] = false
+
+	// Node 2 misses the block, node 1 refuses to vote for it
+	net.send(2, net.nodes[2].startElection(net.gossipers[2]))
+	net.run()
+	require.NotEqual(t, leader, net.nodes[2].role)
+}
+
+func TestNode_snapshot(t *testing.T) {
+	net := newNetwork(t, 3, 3, 7300)
+	for _, n := range net.nodes {
+		n.snapshotThreshold = 2
+	}
+
+	net.down[2] = true
+	for i := 0; i < 5; i++ {
+		net.propose(1, fmt.Sprintf("pattern%d", i))
+		net.run()
+	}
+	require.Equal(t, 3, net.nodes[0].snapshotIndex)
+	require.Len(t, net.nodes[0].log, 1)
+
+	// Node 2 lags behind the compacted log and gets a snapshot
+	net.down[2] = false
+	net.send(0, net.nodes[0].appendEntries())
+	net.run()
+	net.send(0, net.nodes[0].appendEntries())
+	net.run()
+
+	net.requireSameChain(t, 5, 0, 1, 2)
+	require.Equal(t, 4, net.nodes[0].matchIndex[2])
+}
+
+func TestNode_singleParticipant(t *testing.T) {
+	net := newNetwork(t, 1, 2, 7350)
+
+	// The participant elects itself and commits its entries alone
+	net.nodes[0].role = follower
+	net.send(0, net.nodes[0].startElection(net.gossipers[0]))
+	require.Equal(t, leader, net.nodes[0].role)
+
+	net.propose(0, "pattern0")
+	require.Equal(t, 0, net.nodes[0].commitIndex)
+	net.run()
+	net.propose(0, "pattern1")
+	net.run()
+	net.requireSameChain(t, 2, 0, 1)
+}
+
+func TestNode_snapshotBlock(t *testing.T) {
+	net := newNetwork(t, 3, 4, 7360)
+
+	net.down[2] = true
+	for i := 0; i < 3; i++ {
+		net.propose(1, fmt.Sprintf("pattern%d", i))
+		net.run()
+	}
+	net.send(1, net.nodes[1].propose(&blk.SnapshotBlockContent{
+		PatternID: "pattern2",
+		Positions: []r3.Vec{r3.Vec{X: 1, Y: 10, Z: 0}},
+	}))
+	net.run()
+	net.propose(1, "pattern3")
+	net.run()
+
+	// The chain and the log are pruned up to the snapshot block
+	for _, i := range []int{0, 1, 3} {
+		n := net.nodes[i]
+		require.Equal(t, 3, n.base, "node%d", i)
+		require.Len(t, n.blocks, 2, "node%d", i)
+		require.Equal(t, blk.BlockSnapshotStr, n.chain[0].Type)
+	}
+	require.Equal(t, 3, net.nodes[0].snapshotIndex)
+	net.requireSameChain(t, 2, 0, 1, 3)
+
+	// Node 2 only gets the blocks from the snapshot block
+	net.down[2] = false
+	net.send(0, net.nodes[0].appendEntries())
+	net.run()
+	net.send(0, net.nodes[0].appendEntries())
+	net.run()
+
+	net.requireSameChain(t, 2, 0, 1, 2, 3)
+	require.Equal(t, 3, net.nodes[2].base)
+	require.Equal(t, 4, net.nodes[0].matchIndex[2])
+
+	// A snapshot which does not start a chain is refused
+	install := &extramessage.RaftInstallSnapshot{
+		Term:      net.nodes[0].term,
+		Leader:    0,
+		LastIndex: 4,
+		Blocks:    net.nodes[0].chain[1:],
+	}
+	require.Error(t, verifySnapshot(install))
+}
@@ -0,0 +1,177 @@
+package trajectory
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	// TrapezoidalProfile accelerates at the maximal acceleration, cruises and decelerates
+	TrapezoidalProfile = "trapezoidal"
+	// MinimumJerkProfile follows the fifth order polynomial minimizing the jerk
+	MinimumJerkProfile = "minimum-jerk"
+
+	// Peak velocity and acceleration of the minimum jerk profile for a unit
+	// distance flown in a unit time
+	minimumJerkPeakVelocity     = 1.875
+	minimumJerkPeakAcceleration = 5.7735
+)
+
+// DefaultLimits are the dynamic limits of the simulated drones
+var DefaultLimits = Limits{
+	MaxVelocity:     2,
+	MaxAcceleration: 2,
+}
+
+// Limits bounds the dynamic of a drone, in units per second and units per second squared
+type Limits struct {
+	MaxVelocity     float64
+	MaxAcceleration float64
+}
+
+// Waypoint is a position reached by a drone at a given time, in seconds from
+// the beginning of the trajectory
+type Waypoint struct {
+	Time     float64
+	Position r3.Vec
+}
+
+// Trajectory describes the continuous motion of a drone through its
+// waypoints. The drone flies in straight line between two consecutive
+// waypoints, starting and stopping at rest, following the velocity profile.
+type Trajectory struct {
+	Profile   string
+	Limits    Limits
+	Waypoints []Waypoint
+}
+
+// Copy performs a deep copy of the trajectory
+func (t Trajectory) Copy() Trajectory {
+	return Trajectory{
+		Profile:   t.Profile,
+		Limits:    t.Limits,
+		Waypoints: append([]Waypoint{}, t.Waypoints...),
+	}
+}
+
+// Duration returns the time at which the last waypoint is reached
+func (t Trajectory) Duration() float64 {
+	if len(t.Waypoints) == 0 {
+		return 0
+	}
+	return t.Waypoints[len(t.Waypoints)-1].Time
+}
+
+// Sample returns the position of the drone at the given time
+func (t Trajectory) Sample(time float64) r3.Vec {
+	if len(t.Waypoints) == 0 {
+		return r3.Vec{}
+	}
+	if time <= t.Waypoints[0].Time {
+		return t.Waypoints[0].Position
+	}
+	for i := 1; i < len(t.Waypoints); i++ {
+		start, end := t.Waypoints[i-1], t.Waypoints[i]
+		if time < end.Time {
+			move := end.Position.Sub(start.Position)
+			progress := t.progress(r3.Norm(move), end.Time-start.Time, time-start.Time)
+			return start.Position.Add(move.Scale(progress))
+		}
+	}
+	return t.Waypoints[len(t.Waypoints)-1].Position
+}
+
+// progress returns the fraction of a segment of the given length and
+// duration that is flown after elapsed seconds
+func (t Trajectory) progress(length, duration, elapsed float64) float64 {
+	if length == 0 || duration <= 0 {
+		return 1
+	}
+	tau := math.Min(math.Max(elapsed/duration, 0), 1)
+
+	switch t.Profile {
+	case TrapezoidalProfile:
+		a := t.Limits.MaxAcceleration
+		// Cruise velocity such that the segment is flown in exactly duration
+		discriminant := a*a*duration*duration - 4*a*length
+		if a <= 0 || discriminant < 0 {
+			// Not feasible within the limits, fallback to a triangular profile
+			a = 4 * length / (duration * duration)
+			discriminant = 0
+		}
+		v := (a*duration - math.Sqrt(discriminant)) / 2
+		accelerationTime := v / a
+		switch {
+		case elapsed < accelerationTime:
+			return 0.5 * a * elapsed * elapsed / length
+		case elapsed < duration-accelerationTime:
+			return (0.5*a*accelerationTime*accelerationTime + v*(elapsed-accelerationTime)) / length
+		default:
+			remaining := duration - elapsed
+			return math.Min(1, 1-0.5*a*remaining*remaining/length)
+		}
+	case MinimumJerkProfile:
+		return tau * tau * tau * (10 - 15*tau + 6*tau*tau)
+	default:
+		return tau
+	}
+}
+
+// SegmentDuration returns the minimal time needed to fly the given distance
+// from rest to rest within the limits
+func SegmentDuration(distance float64, limits Limits, profile string) float64 {
+	v, a := limits.MaxVelocity, limits.MaxAcceleration
+	switch profile {
+	case TrapezoidalProfile:
+		if distance <= v*v/a {
+			// Triangular profile, the maximal velocity is never reached
+			return 2 * math.Sqrt(distance/a)
+		}
+		return distance/v + v/a
+	case MinimumJerkProfile:
+		return math.Max(minimumJerkPeakVelocity*distance/v, math.Sqrt(minimumJerkPeakAcceleration*distance/a))
+	default:
+		return distance / v
+	}
+}
+
+// StepDuration returns the duration of a round of unit moves such that every
+// drone of the swarm can follow it
+func StepDuration(limits []Limits, profile string) float64 {
+	duration := 0.0
+	for _, l := range limits {
+		duration = math.Max(duration, SegmentDuration(1, l, profile))
+	}
+	return duration
+}
+
+// FromMoves builds the trajectory of a drone following the given unit moves,
+// each move starting at a multiple of stepDuration. When merge is set,
+// consecutive moves in the same direction are flown as a single segment.
+func FromMoves(start r3.Vec, moves []r3.Vec, stepDuration float64, limits Limits, profile string, merge bool) Trajectory {
+	waypoints := []Waypoint{{Time: 0, Position: start}}
+	position := start
+	var direction r3.Vec
+	for i, move := range moves {
+		position = position.Add(move)
+		waypoint := Waypoint{Time: float64(i+1) * stepDuration, Position: position}
+
+		last := len(waypoints) - 1
+		if merge && last > 0 && move != (r3.Vec{}) && move == direction {
+			// Extend the current segment
+			waypoints[last] = waypoint
+		} else if move == (r3.Vec{}) && last > 0 && direction == (r3.Vec{}) {
+			// Extend the current hover
+			waypoints[last] = waypoint
+		} else {
+			waypoints = append(waypoints, waypoint)
+		}
+		direction = move
+	}
+	return Trajectory{
+		Profile:   profile,
+		Limits:    limits,
+		Waypoints: waypoints,
+	}
+}
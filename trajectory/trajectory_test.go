@@ -0,0 +1,65 @@
+package trajectory
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestSegmentDuration(t *testing.T) {
+	limits := Limits{MaxVelocity: 2, MaxAcceleration: 1}
+
+	// Triangular profile
+	require.InDelta(t, 2.0, SegmentDuration(1, limits, TrapezoidalProfile), 1e-9)
+	// Accelerate 2s, cruise 1s, decelerate 2s
+	require.InDelta(t, 5.0, SegmentDuration(6, limits, TrapezoidalProfile), 1e-9)
+	// Acceleration bound
+	require.InDelta(t, math.Sqrt(minimumJerkPeakAcceleration), SegmentDuration(1, limits, MinimumJerkProfile), 1e-9)
+}
+
+func TestFromMoves(t *testing.T) {
+	right := r3.Vec{X: 1, Y: 0, Z: 0}
+	up := r3.Vec{X: 0, Y: 1, Z: 0}
+	moves := []r3.Vec{up, up, r3.Vec{}, r3.Vec{}, right}
+
+	traj := FromMoves(r3.Vec{}, moves, 2, DefaultLimits, TrapezoidalProfile, true)
+	require.Equal(t, []Waypoint{
+		{Time: 0, Position: r3.Vec{}},
+		{Time: 4, Position: r3.Vec{X: 0, Y: 2, Z: 0}},
+		{Time: 8, Position: r3.Vec{X: 0, Y: 2, Z: 0}},
+		{Time: 10, Position: r3.Vec{X: 1, Y: 2, Z: 0}},
+	}, traj.Waypoints)
+
+	traj = FromMoves(r3.Vec{}, moves, 2, DefaultLimits, TrapezoidalProfile, false)
+	require.Equal(t, 5, len(traj.Waypoints))
+	require.Equal(t, 10.0, traj.Duration())
+}
+
+func TestSampleWithinLimits(t *testing.T) {
+	limits := Limits{MaxVelocity: 1.5, MaxAcceleration: 1}
+	moves := []r3.Vec{
+		r3.Vec{X: 1}, r3.Vec{X: 1}, r3.Vec{X: 1}, r3.Vec{Y: 1}, r3.Vec{}, r3.Vec{Z: -1},
+	}
+
+	for _, profile := range []string{TrapezoidalProfile, MinimumJerkProfile} {
+		step := StepDuration([]Limits{limits}, profile)
+		traj := FromMoves(r3.Vec{}, moves, step, limits, profile, true)
+
+		require.Equal(t, r3.Vec{}, traj.Sample(0))
+		require.Equal(t, r3.Vec{X: 3, Y: 1, Z: -1}, traj.Sample(traj.Duration()))
+		require.Equal(t, r3.Vec{X: 3, Y: 0, Z: 0}, traj.Sample(3*step))
+
+		dt := 0.001
+		previousVelocity := 0.0
+		for time := dt; time < traj.Duration(); time += dt {
+			velocity := r3.Norm(traj.Sample(time).Sub(traj.Sample(time-dt))) / dt
+			require.True(t, velocity <= limits.MaxVelocity+1e-6, "%s velocity %f at %f", profile, velocity, time)
+			acceleration := math.Abs(velocity-previousVelocity) / dt
+			require.True(t, acceleration <= limits.MaxAcceleration+0.01, "%s acceleration %f at %f", profile, acceleration, time)
+			previousVelocity = velocity
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Package wire implements the primitives of the binary wire format of the
+// gossip packets. Integers are varints and floats their IEEE 754 bits with
+// the bytes reversed, as varints, so that the integral coordinates of the
+// drones take few bytes, as in gob. Slices are prefixed by their length plus
+// one, 0 standing for a nil slice, so that a value decoded is the value a JSON
+// decoding gives. The packets, the extra messages and the blocks build their
+// encoding on top of it.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// Writer appends values to a buffer
+type Writer struct {
+	buf []byte
+}
+
+// NewWriter returns a writer with an empty buffer
+func NewWriter() *Writer {
+	return &Writer{buf: make([]byte, 0, 256)}
+}
+
+// Data returns the values written
+func (w *Writer) Data() []byte {
+	return w.buf
+}
+
+// Byte writes a single byte, such as a tag or a version
+func (w *Writer) Byte(v byte) {
+	w.buf = append(w.buf, v)
+}
+
+// Int writes a signed integer
+func (w *Writer) Int(v int) {
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+// Int64 writes a signed 64 bits integer
+func (w *Writer) Int64(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+// Uint writes an unsigned integer
+func (w *Writer) Uint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+// Bool writes a boolean, also used for the presence of a pointer
+func (w *Writer) Bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// Float writes a float
+func (w *Writer) Float(v float64) {
+	w.Uint(bits.ReverseBytes64(math.Float64bits(v)))
+}
+
+// String writes a string
+func (w *Writer) String(s string) {
+	w.Uint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// Slice writes the length of a slice, nil or not
+func (w *Writer) Slice(length int, isNil bool) {
+	if isNil {
+		w.Uint(0)
+		return
+	}
+	w.Uint(uint64(length) + 1)
+}
+
+// ByteSlice writes a slice of bytes
+func (w *Writer) ByteSlice(b []byte) {
+	w.Slice(len(b), b == nil)
+	w.buf = append(w.buf, b...)
+}
+
+// Vec writes a vector
+func (w *Writer) Vec(v r3.Vec) {
+	w.Float(v.X)
+	w.Float(v.Y)
+	w.Float(v.Z)
+}
+
+// Vecs writes a slice of vectors
+func (w *Writer) Vecs(vs []r3.Vec) {
+	w.Slice(len(vs), vs == nil)
+	for _, v := range vs {
+		w.Vec(v)
+	}
+}
+
+// errShort is the error of a reader reaching the end of its buffer
+var errShort = errors.New("unexpected end of data")
+
+// Reader reads the values written by a Writer. The first error is kept, the
+// next reads returning zero values, so that it is only checked once the
+// whole value is read.
+type Reader struct {
+	buf []byte
+	err error
+}
+
+// NewReader returns a reader of the data
+func NewReader(data []byte) *Reader {
+	return &Reader{buf: data}
+}
+
+// Err returns the first error met, if any
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Fail records the error, unless an error was already met
+func (r *Reader) Fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Remaining returns the number of bytes left
+func (r *Reader) Remaining() int {
+	return len(r.buf)
+}
+
+// Byte reads a single byte
+func (r *Reader) Byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) == 0 {
+		r.Fail(errShort)
+		return 0
+	}
+	v := r.buf[0]
+	r.buf = r.buf[1:]
+	return v
+}
+
+// Int reads a signed integer
+func (r *Reader) Int() int {
+	return int(r.Int64())
+}
+
+// Int64 reads a signed 64 bits integer
+func (r *Reader) Int64() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.Fail(fmt.Errorf("invalid integer"))
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// Uint reads an unsigned integer
+func (r *Reader) Uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.Fail(fmt.Errorf("invalid integer"))
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// Bool reads a boolean
+func (r *Reader) Bool() bool {
+	switch r.Byte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		r.Fail(fmt.Errorf("invalid boolean"))
+		return false
+	}
+}
+
+// Float reads a float
+func (r *Reader) Float() float64 {
+	return math.Float64frombits(bits.ReverseBytes64(r.Uint()))
+}
+
+// String reads a string
+func (r *Reader) String() string {
+	length := r.Uint()
+	if r.err != nil {
+		return ""
+	}
+	if length > uint64(len(r.buf)) {
+		r.Fail(errShort)
+		return ""
+	}
+	s := string(r.buf[:length])
+	r.buf = r.buf[length:]
+	return s
+}
+
+// Slice reads the length of a slice and whether it is nil. Each element
+// taking at least minSize bytes, a length exceeding the data left is an
+// error, so that a forged length does not allocate a huge slice.
+func (r *Reader) Slice(minSize int) (int, bool) {
+	length := r.Uint()
+	if r.err != nil || length == 0 {
+		return 0, true
+	}
+	length--
+	if minSize > 0 && length > uint64(len(r.buf)/minSize) {
+		r.Fail(fmt.Errorf("slice of %d elements exceeds the data", length))
+		return 0, true
+	}
+	return int(length), false
+}
+
+// ByteSlice reads a slice of bytes
+func (r *Reader) ByteSlice() []byte {
+	length, isNil := r.Slice(1)
+	if isNil {
+		return nil
+	}
+	b := append([]byte{}, r.buf[:length]...)
+	r.buf = r.buf[length:]
+	return b
+}
+
+// Vec reads a vector
+func (r *Reader) Vec() r3.Vec {
+	return r3.Vec{X: r.Float(), Y: r.Float(), Z: r.Float()}
+}
+
+// Vecs reads a slice of vectors
+func (r *Reader) Vecs() []r3.Vec {
+	length, isNil := r.Slice(3)
+	if isNil {
+		return nil
+	}
+	vs := make([]r3.Vec, length)
+	for i := range vs {
+		vs[i] = r.Vec()
+	}
+	return vs
+}